@@ -0,0 +1,293 @@
+package main
+
+/*
+	A minimal LDAP server for trying the directory sync locally.
+	It serves the entries of an LDIF file and supports simple
+	binds, checked against each entry's userPassword, and
+	searches with and, or, not, equality, presence and substring
+	filters. Edit the file and restart it to change the directory.
+
+		go run ./cmd/mockldap -ldif ./cmd/mockldap/directory.ldif
+
+	Point the API at it with
+
+		LDAP_URL='ldap://localhost:3389'
+		LDAP_BIND_DN='cn=sync,dc=school,dc=ca'
+		LDAP_BIND_PASSWORD='sync'
+		LDAP_BASE_DN='ou=staff,dc=school,dc=ca'
+		LDAP_TEACHER_FILTER='(employeeType=teacher)'
+		LDAP_ADMIN_FILTER='(employeeType=admin)'
+*/
+
+import (
+	"bufio"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+type entry struct {
+	DN         string
+	Attributes map[string][]string // keys are lower case
+}
+
+func (e entry) get(attribute string) []string {
+	return e.Attributes[strings.ToLower(attribute)]
+}
+
+func loadLDIF(path string) ([]entry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []entry
+	var current *entry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			current = nil
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, value, found := strings.Cut(line, ":")
+		if !found {
+			return nil, fmt.Errorf("invalid LDIF line %q", line)
+		}
+		if strings.HasPrefix(value, ":") {
+			decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value[1:]))
+			if err != nil {
+				return nil, err
+			}
+			value = string(decoded)
+		}
+		value = strings.TrimSpace(value)
+
+		if strings.EqualFold(name, "dn") {
+			entries = append(entries, entry{DN: value, Attributes: make(map[string][]string)})
+			current = &entries[len(entries)-1]
+			continue
+		}
+		if current == nil {
+			return nil, fmt.Errorf("attribute %q is not part of an entry", name)
+		}
+		key := strings.ToLower(name)
+		current.Attributes[key] = append(current.Attributes[key], value)
+	}
+	return entries, scanner.Err()
+}
+
+func packetString(p *ber.Packet) string {
+	if value, ok := p.Value.(string); ok {
+		return value
+	}
+	return p.Data.String()
+}
+
+// matches evaluates a search filter packet against an entry
+func matches(filter *ber.Packet, e entry) bool {
+	switch filter.Tag {
+	case ldap.FilterAnd:
+		for _, child := range filter.Children {
+			if !matches(child, e) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, child := range filter.Children {
+			if matches(child, e) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterNot:
+		return len(filter.Children) == 1 && !matches(filter.Children[0], e)
+	case ldap.FilterEqualityMatch, ldap.FilterApproxMatch:
+		want := packetString(filter.Children[1])
+		if strings.EqualFold(packetString(filter.Children[0]), "objectClass") && want == "*" {
+			return true
+		}
+		for _, value := range e.get(packetString(filter.Children[0])) {
+			if strings.EqualFold(value, want) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterPresent:
+		return strings.EqualFold(packetString(filter), "objectClass") || len(e.get(packetString(filter))) > 0
+	case ldap.FilterSubstrings:
+		for _, value := range e.get(packetString(filter.Children[0])) {
+			value = strings.ToLower(value)
+			ok := true
+			for _, part := range filter.Children[1].Children {
+				sub := strings.ToLower(packetString(part))
+				switch part.Tag {
+				case ldap.FilterSubstringsInitial:
+					ok = ok && strings.HasPrefix(value, sub)
+				case ldap.FilterSubstringsAny:
+					ok = ok && strings.Contains(value, sub)
+				case ldap.FilterSubstringsFinal:
+					ok = ok && strings.HasSuffix(value, sub)
+				}
+			}
+			if ok {
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
+
+func inScope(dn string, base string, scope int64) bool {
+	dn, base = strings.ToLower(dn), strings.ToLower(base)
+	if scope == ldap.ScopeBaseObject {
+		return dn == base
+	}
+	return base == "" || dn == base || strings.HasSuffix(dn, ","+base)
+}
+
+func result(messageID int64, application ber.Tag, code int, message string) *ber.Packet {
+	envelope := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "Message ID"))
+	response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, application, nil, "Response")
+	response.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "Result Code"))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, message, "Diagnostic Message"))
+	envelope.AppendChild(response)
+	return envelope
+}
+
+func searchEntry(messageID int64, e entry, attributes []string) *ber.Packet {
+	envelope := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "Message ID"))
+	response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.DN, "DN"))
+
+	list := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for _, name := range attributes {
+		values := e.get(name)
+		if len(values) == 0 || strings.EqualFold(name, "userPassword") {
+			continue
+		}
+		attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, value := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "Value"))
+		}
+		attribute.AppendChild(set)
+		list.AppendChild(attribute)
+	}
+	response.AppendChild(list)
+	envelope.AppendChild(response)
+	return envelope
+}
+
+func serve(conn net.Conn, path string) {
+	defer conn.Close()
+
+	for {
+		request, err := ber.ReadPacket(conn)
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("%s: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		if len(request.Children) < 2 {
+			return
+		}
+		messageID, _ := request.Children[0].Value.(int64)
+		op := request.Children[1]
+
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			dn, password := packetString(op.Children[1]), packetString(op.Children[2])
+			code, message := ldap.LDAPResultInvalidCredentials, "invalid credentials"
+
+			entries, err := loadLDIF(path)
+			if err != nil {
+				code, message = ldap.LDAPResultOther, err.Error()
+			}
+			for _, e := range entries {
+				if strings.EqualFold(e.DN, dn) && password != "" {
+					for _, stored := range e.get("userPassword") {
+						if stored == password {
+							code, message = ldap.LDAPResultSuccess, ""
+						}
+					}
+				}
+			}
+			conn.Write(result(messageID, ldap.ApplicationBindResponse, int(code), message).Bytes())
+
+		case ldap.ApplicationSearchRequest:
+			base := packetString(op.Children[0])
+			scope, _ := op.Children[1].Value.(int64)
+			filter := op.Children[6]
+			var attributes []string
+			for _, attribute := range op.Children[7].Children {
+				attributes = append(attributes, packetString(attribute))
+			}
+
+			entries, err := loadLDIF(path)
+			if err != nil {
+				conn.Write(result(messageID, ldap.ApplicationSearchResultDone, int(ldap.LDAPResultOther), err.Error()).Bytes())
+				continue
+			}
+			for _, e := range entries {
+				if inScope(e.DN, base, scope) && matches(filter, e) {
+					conn.Write(searchEntry(messageID, e, attributes).Bytes())
+				}
+			}
+			conn.Write(result(messageID, ldap.ApplicationSearchResultDone, int(ldap.LDAPResultSuccess), "").Bytes())
+
+		case ldap.ApplicationUnbindRequest:
+			return
+
+		case ldap.ApplicationAbandonRequest:
+			continue
+
+		default:
+			conn.Write(result(messageID, ber.Tag(op.Tag+1), int(ldap.LDAPResultUnwillingToPerform), "operation not supported").Bytes())
+		}
+	}
+}
+
+func main() {
+	port := flag.Int("port", 3389, "port to listen on")
+	path := flag.String("ldif", "./cmd/mockldap/directory.ldif", "LDIF file of the directory entries")
+	flag.Parse()
+
+	if _, err := loadLDIF(*path); err != nil {
+		log.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", *port))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Mock LDAP server running at ldap://localhost:%d serving %s", *port, *path)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Fatal(err)
+		}
+		go serve(conn, *path)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+/*
+	A minimal OpenID Connect provider for trying single sign-on
+	locally. It signs in whoever types an email, so it must never
+	be used in production. Point the API at it with
+
+		OIDC_ISSUER=http://localhost:9096
+		OIDC_CLIENT_ID=school-management-api
+
+	and run it with go run ./cmd/mockoidc
+*/
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html/template"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const keyID = "mock-key"
+
+type authorization struct {
+	ClientID    string
+	RedirectURI string
+	Challenge   string
+	Nonce       string
+	Email       string
+	Expires     time.Time
+}
+
+type provider struct {
+	issuer string
+	key    *rsa.PrivateKey
+
+	lock  sync.Mutex
+	codes map[string]authorization
+}
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif;">
+	<h3>Mock OIDC Provider</h3>
+	<form method="POST">
+		<input type="email" name="email" placeholder="school email" value="{{ .hint }}" autofocus>
+		<input type="submit" value="Sign In">
+	</form>
+</body>
+</html>`))
+
+func randomString(size int) string {
+	b := make([]byte, size)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (p *provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                p.issuer,
+		"authorization_endpoint":                p.issuer + "/authorize",
+		"token_endpoint":                        p.issuer + "/token",
+		"jwks_uri":                              p.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (p *provider) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": keyID,
+			"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+		}},
+	})
+}
+
+// authorize shows a login form, then sends the browser back to the client with a code
+func (p *provider) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("response_type") != "code" || query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		http.Error(w, "only the authorization code flow with S256 PKCE is supported", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		loginPage.Execute(w, map[string]string{"hint": query.Get("login_hint")})
+		return
+	}
+
+	r.ParseForm()
+	code := randomString(24)
+	p.lock.Lock()
+	p.codes[code] = authorization{
+		ClientID:    query.Get("client_id"),
+		RedirectURI: query.Get("redirect_uri"),
+		Challenge:   query.Get("code_challenge"),
+		Nonce:       query.Get("nonce"),
+		Email:       r.PostForm.Get("email"),
+		Expires:     time.Now().Add(time.Minute),
+	}
+	p.lock.Unlock()
+
+	redirect := query.Get("redirect_uri") + "?" + url.Values{"code": {code}, "state": {query.Get("state")}}.Encode()
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
+
+// token checks the code and PKCE verifier then issues a signed id token
+func (p *provider) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	p.lock.Lock()
+	auth, ok := p.codes[r.PostForm.Get("code")]
+	delete(p.codes, r.PostForm.Get("code"))
+	p.lock.Unlock()
+
+	challenge := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || auth.Expires.Before(time.Now()) ||
+		auth.ClientID != r.PostForm.Get("client_id") ||
+		auth.RedirectURI != r.PostForm.Get("redirect_uri") ||
+		auth.Challenge != base64.RawURLEncoding.EncodeToString(challenge[:]) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            p.issuer,
+		"sub":            auth.Email,
+		"aud":            auth.ClientID,
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"iat":            time.Now().Unix(),
+		"nonce":          auth.Nonce,
+		"email":          auth.Email,
+		"email_verified": true,
+	})
+	idToken.Header["kid"] = keyID
+	signed, err := idToken.SignedString(p.key)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(24),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     signed,
+	})
+}
+
+func main() {
+	port := flag.Int("port", 9096, "port to listen on")
+	flag.Parse()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	p := &provider{
+		issuer: fmt.Sprintf("http://localhost:%d", *port),
+		key:    key,
+		codes:  make(map[string]authorization),
+	}
+
+	http.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	http.HandleFunc("/jwks", p.jwks)
+	http.HandleFunc("/authorize", p.authorize)
+	http.HandleFunc("/token", p.token)
+
+	log.Printf("Mock OIDC provider running at %s", p.issuer)
+	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", *port), nil))
+}
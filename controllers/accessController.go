@@ -0,0 +1,287 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/SowinskiBraeden/school-management-api/models"
+	"github.com/SowinskiBraeden/school-management-api/repository"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+	Every read of a student's personal information is recorded
+	in the access log, alongside the audit log of writes. Any
+	handler that returns student records, like a roster or a
+	report, calls RecordAccess with the students it returned.
+
+	A student can see which staff have viewed their record, and
+	an admin can look it up for a guardian who asks. When one
+	account reads more than ACCESS_ALERT_THRESHOLD students in
+	an hour an alert is raised and emailed to every admin.
+*/
+
+const (
+	defaultAccessAlertThreshold = 100
+	accessAlertWindow           = time.Hour
+)
+
+func accessAlertThreshold() int {
+	if threshold, err := strconv.Atoi(os.Getenv("ACCESS_ALERT_THRESHOLD")); err == nil && threshold > 0 {
+		return threshold
+	}
+	return defaultAccessAlertThreshold
+}
+
+// RecordAccess logs that the requester read the given parts of each student's record
+func RecordAccess(c *fiber.Ctx, fields []string, sids ...string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	accessor, accessorType, impersonation := auditActor(c)
+	created_at, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+	var events []interface{}
+	for _, sid := range sids {
+		// A student reading their own record isn't worth recording
+		if accessorType == 1 && accessor == sid && impersonation == "" {
+			continue
+		}
+
+		var event models.AccessEvent
+		event.ID = primitive.NewObjectID()
+		event.SID = sid
+		event.Accessor = accessor
+		event.AccessorType = accessorType
+		event.Impersonation = impersonation
+		event.Action = c.Method() + " " + c.Route().Path
+		event.Fields = fields
+		event.IP = c.IP()
+		event.Created_at = created_at
+		events = append(events, event)
+	}
+	if len(events) == 0 {
+		return
+	}
+
+	if err := Repos(c).Accesses.InsertMany(ctx, events); err != nil {
+		log.Printf("Failed to record access by %s: %v\n", accessor, err)
+		return
+	}
+
+	go checkAccessVolume(Repos(c), accessor, accessorType)
+}
+
+// checkAccessVolume raises an alert if the accessor has read too many students in the last hour
+func checkAccessVolume(repos *repository.Repositories, accessor string, accessorType int) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	windowStart := time.Now().Add(-accessAlertWindow)
+	var events []models.AccessEvent
+	err := repos.Accesses.Find(ctx, bson.M{
+		"accessor":   accessor,
+		"created_at": bson.M{"$gte": windowStart},
+	}, nil, 0, &events)
+	if err != nil {
+		return
+	}
+	sids := map[string]bool{}
+	for _, event := range events {
+		sids[event.SID] = true
+	}
+	if len(sids) < accessAlertThreshold() {
+		return
+	}
+
+	// Only alert once per window, not on every read after the threshold
+	count, err := repos.AccessAlerts.Count(ctx, bson.M{
+		"accessor":   accessor,
+		"created_at": bson.M{"$gte": windowStart},
+	})
+	if err != nil || count > 0 {
+		return
+	}
+
+	var alert models.AccessAlert
+	alert.ID = primitive.NewObjectID()
+	alert.Accessor = accessor
+	alert.AccessorType = accessorType
+	alert.Records = len(sids)
+	alert.Window_start, _ = time.Parse(time.RFC3339, windowStart.Format(time.RFC3339))
+	alert.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+	if err := repos.AccessAlerts.Insert(ctx, alert); err != nil {
+		log.Printf("Failed to insert access alert for %s: %v\n", accessor, err)
+		return
+	}
+
+	admins, err := repos.Admins.Find(ctx, repository.AccountFilter{})
+	if err != nil {
+		log.Printf("Failed to find admins for the access alert: %v\n", err)
+		return
+	}
+
+	for _, admin := range admins {
+		r := NewRequest([]string{admin.Email}, "Unusual Student Record Access")
+		r.Send("./templates/accessAlert.html", map[string]interface{}{
+			"username": admin.FirstName,
+			"accessor": accessorName(ctx, repos, accessor, accessorType),
+			"records":  alert.Records,
+			"minutes":  int(accessAlertWindow.Minutes()),
+		})
+	}
+}
+
+// accessorName finds the name of whoever read a record
+func accessorName(ctx context.Context, repos *repository.Repositories, accessor string, accessorType int) string {
+	switch accessorType {
+	case 1:
+		student, _ := repos.Students.Get(ctx, accessor)
+		return student.Personal.FirstName + " " + student.Personal.LastName
+	case 2:
+		teacher, _ := repos.Teachers.Get(ctx, accessor)
+		return teacher.Personal.FirstName + " " + teacher.Personal.LastName
+	case 3:
+		admin, _ := repos.Admins.Get(ctx, accessor)
+		return admin.FirstName + " " + admin.LastName
+	}
+	return "Integration " + accessor
+}
+
+func StudentAccesses(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// An admin can look up any student for a guardian, a student only themselves
+	var sid string
+	if verified, _ := AuthenticateUser(c, 3); verified {
+		sid = c.Query("uid")
+		if sid == "" {
+			return NewError(fiber.StatusBadRequest, CodeInvalidRequest, "missing required fields")
+		}
+	} else {
+		token, err := jwt.ParseWithClaims(c.Cookies("jwt"), &jwt.StandardClaims{}, func(token *jwt.Token) (interface{}, error) {
+			return []byte(SecretKey), nil
+		})
+		if err != nil {
+			return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "not authorized")
+		}
+		sid = token.Claims.(*jwt.StandardClaims).Issuer
+	}
+
+	var events []models.AccessEvent
+	if err := Repos(c).Accesses.Find(ctx, bson.M{"sid": sid}, nil, 0, &events); err != nil {
+		return InternalError("failed to find accesses", err)
+	}
+
+	// Each accessor is summarized by how often and when they last viewed the record
+	type accessKey struct {
+		accessor     string
+		accessorType int
+	}
+	summaries := map[accessKey]*models.AccessSummary{}
+	for _, event := range events {
+		key := accessKey{event.Accessor, event.AccessorType}
+		summary, ok := summaries[key]
+		if !ok {
+			summary = &models.AccessSummary{Accessor: event.Accessor, AccessorType: event.AccessorType}
+			summaries[key] = summary
+		}
+		summary.Views++
+		if event.Created_at.After(summary.Last_viewed) {
+			summary.Last_viewed = event.Created_at
+		}
+	}
+
+	accesses := []models.AccessSummary{}
+	for _, summary := range summaries {
+		summary.Name = accessorName(ctx, Repos(c), summary.Accessor, summary.AccessorType)
+		accesses = append(accesses, *summary)
+	}
+	sort.Slice(accesses, func(i, j int) bool { return accesses[i].Last_viewed.After(accesses[j].Last_viewed) })
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "successfully retrieved accesses",
+		"result":  accesses,
+	})
+}
+
+func Accesses(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Ensure Authenticated admin sent request
+	if verified, _ := AuthenticateUser(c, 3); !verified {
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
+	}
+
+	filter := bson.M{}
+	if sid := c.Query("uid"); sid != "" {
+		filter["sid"] = sid
+	}
+	if accessor := c.Query("accessor"); accessor != "" {
+		filter["accessor"] = accessor
+	}
+
+	created := bson.M{}
+	for param, op := range map[string]string{"from": "$gte", "to": "$lte"} {
+		if c.Query(param) == "" {
+			continue
+		}
+		t, err := parseAuditDate(c.Query(param), param == "to")
+		if err != nil {
+			return NewError(fiber.StatusBadRequest, CodeInvalidRequest, err.Error())
+		}
+		created[op] = t
+	}
+	if len(created) > 0 {
+		filter["created_at"] = created
+	}
+
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	if limit < 1 || limit > 1000 {
+		limit = 100
+	}
+
+	events := []models.AccessEvent{}
+	if err := Repos(c).Accesses.Find(ctx, filter, bson.D{{Key: "created_at", Value: -1}}, int64(limit), &events); err != nil {
+		return InternalError("failed to find accesses", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "successfully retrieved accesses",
+		"result":  events,
+	})
+}
+
+func AccessAlerts(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Ensure Authenticated admin sent request
+	if verified, _ := AuthenticateUser(c, 3); !verified {
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
+	}
+
+	alerts := []models.AccessAlert{}
+	if err := Repos(c).AccessAlerts.Find(ctx, bson.M{}, bson.D{{Key: "created_at", Value: -1}}, 100, &alerts); err != nil {
+		return InternalError("failed to find access alerts", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "successfully retrieved access alerts",
+		"result":  alerts,
+	})
+}
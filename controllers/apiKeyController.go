@@ -0,0 +1,224 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/SowinskiBraeden/school-management-api/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+	API keys let other services, such as the library system,
+	read data without logging in as an admin. A key is sent in
+	the X-API-Key header and is only accepted on the routes in
+	APIKeyRoutes, when it holds the scope that route needs.
+	Other scopes decide which parts of a response it can see.
+*/
+
+// APIKeyRoutes are the only routes an API key is accepted on, and the scope each one needs
+var APIKeyRoutes = map[string]string{
+	"GET /api/v1/student":                "students:read",
+	"GET /api/v2/students":               "students:read",
+	"GET /api/v2/students/:sid":          "students:read",
+	"GET /api/v2/students/:sid/contacts": "contacts:read",
+	"GET /api/v2/contacts":               "contacts:read",
+	"GET /api/v2/lockers":                "lockers:read",
+	"GET /api/v2/lockers/:number":        "lockers:read",
+}
+
+const (
+	defaultAPIKeyDays = 90
+	maxAPIKeyDays     = 365
+)
+
+// AuthenticateAPIKey checks a key is valid for the route and returns an id for the integration
+func AuthenticateAPIKey(c *fiber.Ctx, key string) (bool, string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var apiKey models.APIKey
+	findErr := Repos(c).APIKeys.FindOne(ctx, bson.M{"hash": HashToken(key), "revoked": false}, nil, &apiKey)
+	if findErr != nil {
+		return false, ""
+	}
+
+	if apiKey.Expires_at.Before(time.Now()) || !apiKey.AllowsIP(c.IP()) {
+		return false, ""
+	}
+
+	scope, ok := APIKeyRoutes[c.Method()+" "+c.Route().Path]
+	if !ok || !apiKey.HasScope(scope) {
+		return false, ""
+	}
+
+	update_time, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	Repos(c).APIKeys.UpdateOne(ctx, bson.M{"_id": apiKey.ID}, bson.M{"$set": bson.M{"lastused_at": update_time}}, false)
+
+	c.Locals("apikey", apiKey)
+	return true, "apikey:" + apiKey.Prefix
+}
+
+// APIKeyAllows reports whether the request may see data under the scope, requests without a key always can
+func APIKeyAllows(c *fiber.Ctx, scope string) bool {
+	apiKey, ok := c.Locals("apikey").(models.APIKey)
+	return !ok || apiKey.HasScope(scope)
+}
+
+// APIKeyRequest is the body of CreateAPIKey
+type APIKeyRequest struct {
+	Name          string   `json:"name" validate:"required"`
+	Scopes        []string `json:"scopes" validate:"min=1"`
+	AllowedIPs    []string `json:"allowedips"`
+	ExpiresInDays int      `json:"expiresindays"` // defaults to defaultAPIKeyDays
+}
+
+func CreateAPIKey(c *fiber.Ctx) error {
+	var data APIKeyRequest
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
+		cancel()
+		return InvalidRequest(fieldErrs)
+	}
+
+	// Ensure Authenticated admin sent request
+	verified, aid := AuthenticateUser(c, 3)
+	if !verified {
+		cancel()
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
+	}
+
+	for _, scope := range data.Scopes {
+		if !models.ValidAPIKeyScope(scope) {
+			cancel()
+			return NewError(fiber.StatusBadRequest, CodeInvalidRequest, "invalid scope "+scope+", use one of: "+strings.Join(models.APIKeyScopes, ", "))
+		}
+	}
+
+	for _, ip := range data.AllowedIPs {
+		_, _, cidrErr := net.ParseCIDR(ip)
+		if net.ParseIP(ip) == nil && cidrErr != nil {
+			cancel()
+			return NewError(fiber.StatusBadRequest, CodeInvalidRequest, "invalid IP address "+ip)
+		}
+	}
+
+	if data.ExpiresInDays == 0 {
+		data.ExpiresInDays = defaultAPIKeyDays
+	}
+	if data.ExpiresInDays < 1 || data.ExpiresInDays > maxAPIKeyDays {
+		cancel()
+		return NewError(fiber.StatusBadRequest, CodeInvalidRequest, "expiresindays must be between 1 and 365")
+	}
+
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		cancel()
+		return InternalError("the API key could not be generated", err)
+	}
+	key := "sms_" + hex.EncodeToString(b)
+
+	var apiKey models.APIKey
+	apiKey.ID = primitive.NewObjectID()
+	apiKey.Name = data.Name
+	apiKey.Prefix = key[:12]
+	apiKey.Hash = HashToken(key)
+	apiKey.Scopes = data.Scopes
+	apiKey.AllowedIPs = data.AllowedIPs
+	if apiKey.AllowedIPs == nil {
+		apiKey.AllowedIPs = []string{}
+	}
+	apiKey.CreatedBy = aid
+	apiKey.Revoked = false
+	apiKey.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	apiKey.Expires_at = apiKey.Created_at.AddDate(0, 0, data.ExpiresInDays)
+
+	insertErr := AuditedInsertOne(c, ctx, Repos(c).APIKeys, apiKey)
+	if insertErr != nil {
+		cancel()
+		return InternalError("the API key could not be inserted", insertErr)
+	}
+	defer cancel()
+
+	// This is the only time the key is shown
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "successfully created API key, it will not be shown again",
+		"key":     key,
+		"result":  apiKey,
+	})
+}
+
+func APIKeys(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Ensure Authenticated admin sent request
+	if verified, _ := AuthenticateUser(c, 3); !verified {
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
+	}
+
+	apiKeys := []models.APIKey{}
+	if err := Repos(c).APIKeys.Find(ctx, bson.M{}, nil, 0, &apiKeys); err != nil {
+		return InternalError("failed to find API keys", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "successfully retrieved API keys",
+		"result":  apiKeys,
+	})
+}
+
+// RevokeAPIKeyRequest is the body of RevokeAPIKey, the prefix isn't unique so keys are revoked by their _id
+type RevokeAPIKeyRequest struct {
+	ID string `json:"id" validate:"required,objectid"`
+}
+
+func RevokeAPIKey(c *fiber.Ctx) error {
+	var data RevokeAPIKeyRequest
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
+		cancel()
+		return InvalidRequest(fieldErrs)
+	}
+
+	// Ensure Authenticated admin sent request
+	if verified, _ := AuthenticateUser(c, 3); !verified {
+		cancel()
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
+	}
+
+	id, _ := primitive.ObjectIDFromHex(data.ID)
+
+	update_time, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	matched, updateErr := AuditedUpdateOne(
+		c, ctx, Repos(c).APIKeys,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"revoked": true, "revoked_at": update_time}},
+		false,
+	)
+	if updateErr != nil {
+		cancel()
+		return InternalError("the API key could not be revoked", updateErr)
+	}
+	defer cancel()
+
+	if matched == 0 {
+		return NotFound("API key")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "successfully revoked API key",
+	})
+}
@@ -0,0 +1,518 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SowinskiBraeden/school-management-api/models"
+	"github.com/SowinskiBraeden/school-management-api/repository"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+	Every write made through the API is recorded in the audit
+	log with who made it, the route, the document written and
+	the fields that changed. Writes go through AuditedInsertOne,
+	AuditedUpdateOne and AuditedDeleteOne for the records, or
+	AuditedUpdate and AuditedDelete for the accounts and the rest
+	of the stores, which read the document before and after the
+	write to find the changes. The write and its entry are made
+	as one, see repository.Atomic, so if the entry can't be
+	recorded the write is undone and its error returned, and no
+	change is kept that isn't in the log.
+
+	The log is append-only, there is no route to change or remove
+	an entry. Each entry holds the hash of the entry before it,
+	so editing or deleting one breaks the chain from that point,
+	which VerifyAuditLog reports.
+*/
+
+const (
+	redacted  = "[redacted]"
+	encrypted = "[encrypted]"
+)
+
+var auditLock sync.Mutex // entries are chained one at a time, across servers the unique seq index made by the first migration stops two appending to the same point
+
+// redactedField reports whether a field's values are left out of the log
+func redactedField(field string) bool {
+	field = strings.ToLower(field)
+	return strings.Contains(field, "password") || strings.Contains(field, "hashhistory") ||
+		strings.Contains(field, "base64") || field == "hash" || field == "token"
+}
+
+// flatten turns a document into dotted field paths and their JSON encoded values
+func flatten(prefix string, value interface{}, fields map[string]string) {
+	switch v := value.(type) {
+	case bson.M:
+		// A sealed value is compared by its ciphertext but never shown
+		if ciphertext, ok := v["c"].(primitive.Binary); ok && v["k"] != nil {
+			fields[strings.TrimSuffix(prefix, ".")] = encrypted + HashToken(string(ciphertext.Data))
+			return
+		}
+		for key, child := range v {
+			flatten(prefix+key+".", child, fields)
+		}
+	case map[string]interface{}:
+		flatten(prefix, bson.M(v), fields)
+	case bson.D:
+		for _, element := range v {
+			flatten(prefix+element.Key+".", element.Value, fields)
+		}
+	default:
+		encoded, _ := json.Marshal(v)
+		fields[strings.TrimSuffix(prefix, ".")] = string(encoded)
+	}
+}
+
+// diff lists the fields that differ between two versions of a document, either may be nil
+func diff(before bson.M, after bson.M) []models.AuditChange {
+	beforeFields, afterFields := map[string]string{}, map[string]string{}
+	if before != nil {
+		flatten("", before, beforeFields)
+	}
+	if after != nil {
+		flatten("", after, afterFields)
+	}
+
+	changes := []models.AuditChange{}
+	seen := map[string]bool{}
+	for _, fields := range []map[string]string{beforeFields, afterFields} {
+		for field := range fields {
+			if seen[field] || beforeFields[field] == afterFields[field] {
+				continue
+			}
+			seen[field] = true
+
+			change := models.AuditChange{Field: field, Before: beforeFields[field], After: afterFields[field]}
+			if redactedField(field) {
+				change.Before, change.After = redacted, redacted
+			}
+			if strings.HasPrefix(change.Before, encrypted) {
+				change.Before = encrypted
+			}
+			if strings.HasPrefix(change.After, encrypted) {
+				change.After = encrypted
+			}
+			changes = append(changes, change)
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
+
+// auditTarget finds the id a document is known by
+func auditTarget(doc bson.M) string {
+	if school, ok := doc["school"].(bson.M); ok {
+		for _, key := range []string{"sid", "tid"} {
+			if id, ok := school[key].(string); ok && id != "" {
+				return id
+			}
+		}
+	}
+	for _, key := range []string{"sid", "aid", "cid", "lockernumber", "role", "prefix", "name"} {
+		if id, ok := doc[key].(string); ok && id != "" {
+			return id
+		}
+	}
+	if id, ok := doc["_id"].(primitive.ObjectID); ok {
+		return id.Hex()
+	}
+	return ""
+}
+
+// auditActor finds who made the request
+func auditActor(c *fiber.Ctx) (string, int, string) {
+	if apiKey, ok := c.Locals("apikey").(models.APIKey); ok {
+		return "apikey:" + apiKey.Prefix, 0, ""
+	}
+	if session := impersonationSession(c); session != nil {
+		return session.AID, 3, session.ID.Hex()
+	}
+
+	token, err := jwt.ParseWithClaims(c.Cookies("jwt"), &jwt.StandardClaims{}, func(token *jwt.Token) (interface{}, error) {
+		return []byte(SecretKey), nil
+	})
+	if err != nil {
+		return "", 0, ""
+	}
+	claims := token.Claims.(*jwt.StandardClaims)
+
+	userType, _ := UserTypeOf(context.TODO(), Repos(c), claims.Issuer)
+	return claims.Issuer, userType, ""
+}
+
+// RecordAudit appends an entry to the end of the chain
+func RecordAudit(ctx context.Context, repos *repository.Repositories, entry models.AuditEntry) error {
+	// The entry is appended in the transaction of the write, where there is one, taken before the lock so
+	// a write waiting for the database never holds the lock another needs to finish
+	return repos.Atomic(ctx, repository.Step{
+		Do: func(ctx context.Context) error { return appendAudit(ctx, repos, entry) },
+	})
+}
+
+func appendAudit(ctx context.Context, repos *repository.Repositories, entry models.AuditEntry) error {
+	auditLock.Lock()
+	defer auditLock.Unlock()
+
+	entry.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	if entry.Changes == nil {
+		entry.Changes = []models.AuditChange{}
+	}
+
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		var last models.AuditEntry
+		findErr := repos.Audit.FindOne(ctx, bson.M{}, bson.D{{Key: "seq", Value: -1}}, &last)
+		if findErr != nil && findErr != repository.ErrNotFound {
+			return findErr
+		}
+
+		entry.ID = primitive.NewObjectID()
+		entry.Seq = last.Seq + 1
+		entry.PrevHash = last.Hash
+		entry.Hash = entry.ComputeHash()
+
+		if err = repos.Audit.Insert(ctx, entry); err != repository.ErrDuplicateKey {
+			return err
+		}
+	}
+	return err
+}
+
+var ErrNoAuditLog = errors.New("there is no audit log to record the write in")
+
+// recordWrite appends the entry for a write to the audit log
+func recordWrite(c *fiber.Ctx, ctx context.Context, collection string, before bson.M, after bson.M) error {
+	doc := after
+	if doc == nil {
+		doc = before
+	}
+
+	var entry models.AuditEntry
+	entry.TargetType = collection
+	entry.Target = auditTarget(doc)
+	entry.Changes = diff(before, after)
+
+	// Writes made outside of a request, like the directory sync, are made by the system
+	if c == nil {
+		entry.Actor, entry.Action = "system", "system"
+	} else {
+		entry.Actor, entry.ActorType, entry.Impersonation = auditActor(c)
+		entry.Action = c.Method() + " " + c.Route().Path
+		entry.IP = c.IP()
+	}
+
+	repos := reposOf(c, ctx)
+	if repos == nil {
+		return ErrNoAuditLog
+	}
+	return RecordAudit(ctx, repos, entry)
+}
+
+// documentID finds the _id of a record about to be written
+func documentID(record interface{}) interface{} {
+	data, err := bson.Marshal(record)
+	if err != nil {
+		return nil
+	}
+	var doc bson.M
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		return nil
+	}
+	return doc["_id"]
+}
+
+// auditedWrite makes a write and records its entry as one, the write is undone if the entry can't be recorded
+func auditedWrite(c *fiber.Ctx, ctx context.Context, write repository.Step, audit func(ctx context.Context) error) error {
+	repos := reposOf(c, ctx)
+	if repos == nil {
+		return ErrNoAuditLog
+	}
+	return repos.Atomic(ctx, write, repository.Step{Do: audit})
+}
+
+// restoreRecord puts a record back as it was before a write, or removes it if the write inserted it
+func restoreRecord(ctx context.Context, records repository.RecordRepository, before bson.M, after bson.M) error {
+	if before == nil {
+		if after == nil {
+			return nil
+		}
+		_, err := records.DeleteMany(ctx, bson.M{"_id": after["_id"]})
+		return err
+	}
+	if _, err := records.DeleteMany(ctx, bson.M{"_id": before["_id"]}); err != nil {
+		return err
+	}
+	return records.Insert(ctx, before)
+}
+
+func AuditedInsertOne(c *fiber.Ctx, ctx context.Context, records repository.RecordRepository, record interface{}) error {
+	id := documentID(record)
+	return auditedWrite(c, ctx,
+		repository.Step{
+			Do: func(ctx context.Context) error { return records.Insert(ctx, record) },
+			Undo: func(ctx context.Context) error {
+				_, err := records.DeleteMany(ctx, bson.M{"_id": id})
+				return err
+			},
+		},
+		func(ctx context.Context) error {
+			var after bson.M
+			if err := records.FindOne(ctx, bson.M{"_id": id}, nil, &after); err != nil {
+				return err
+			}
+			return recordWrite(c, ctx, records.Name(), nil, after)
+		},
+	)
+}
+
+// AuditedUpdateOne updates the first record matching the filter and returns how many matched
+func AuditedUpdateOne(c *fiber.Ctx, ctx context.Context, records repository.RecordRepository, filter bson.M, update bson.M, upsert bool) (int64, error) {
+	var before bson.M
+	if err := records.FindOne(ctx, filter, nil, &before); err != nil && err != repository.ErrNotFound {
+		return 0, err
+	}
+
+	var matched int64
+	var after bson.M
+	err := auditedWrite(c, ctx,
+		repository.Step{
+			Do: func(ctx context.Context) (err error) {
+				matched, err = records.UpdateOne(ctx, filter, update, upsert)
+				return err
+			},
+			Undo: func(ctx context.Context) error { return restoreRecord(ctx, records, before, after) },
+		},
+		func(ctx context.Context) error {
+			if matched == 0 {
+				return nil
+			}
+			var err error
+			if before != nil {
+				err = records.FindOne(ctx, bson.M{"_id": before["_id"]}, nil, &after)
+			} else {
+				err = records.FindOne(ctx, filter, nil, &after)
+			}
+			if err != nil || len(diff(before, after)) == 0 {
+				return err
+			}
+			return recordWrite(c, ctx, records.Name(), before, after)
+		},
+	)
+	return matched, err
+}
+
+// AuditedDeleteOne deletes the first record matching the filter and returns how many were deleted
+func AuditedDeleteOne(c *fiber.Ctx, ctx context.Context, records repository.RecordRepository, filter bson.M) (int64, error) {
+	var before bson.M
+	if err := records.FindOne(ctx, filter, nil, &before); err != nil {
+		if err == repository.ErrNotFound {
+			err = nil
+		}
+		return 0, err
+	}
+
+	var deleted int64
+	err := auditedWrite(c, ctx,
+		repository.Step{
+			Do: func(ctx context.Context) (err error) {
+				deleted, err = records.DeleteMany(ctx, bson.M{"_id": before["_id"]})
+				return err
+			},
+			Undo: func(ctx context.Context) error { return restoreRecord(ctx, records, before, nil) },
+		},
+		func(ctx context.Context) error {
+			if deleted == 0 {
+				return nil
+			}
+			return recordWrite(c, ctx, records.Name(), before, nil)
+		},
+	)
+	return deleted, err
+}
+
+// AuditInsert records a record just inserted into a repository
+func AuditInsert(c *fiber.Ctx, ctx context.Context, store repository.Store, key string) error {
+	after, err := store.Document(ctx, key)
+	if err != nil {
+		return err
+	}
+	return recordWrite(c, ctx, store.Name(), nil, after)
+}
+
+func AuditedUpdate(c *fiber.Ctx, ctx context.Context, store repository.Store, key string, update bson.M) error {
+	before, err := store.Document(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	return auditedWrite(c, ctx,
+		repository.Step{
+			Do:   func(ctx context.Context) error { return store.Update(ctx, key, update) },
+			Undo: func(ctx context.Context) error { return store.Restore(ctx, key, before) },
+		},
+		func(ctx context.Context) error {
+			after, err := store.Document(ctx, key)
+			if err != nil || len(diff(before, after)) == 0 {
+				return err
+			}
+			return recordWrite(c, ctx, store.Name(), before, after)
+		},
+	)
+}
+
+func AuditedDelete(c *fiber.Ctx, ctx context.Context, store repository.Store, key string) error {
+	before, err := store.Document(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	return auditedWrite(c, ctx,
+		repository.Step{
+			Do:   func(ctx context.Context) error { return store.Delete(ctx, key) },
+			Undo: func(ctx context.Context) error { return store.Restore(ctx, key, before) },
+		},
+		func(ctx context.Context) error { return recordWrite(c, ctx, store.Name(), before, nil) },
+	)
+}
+
+// parseAuditDate accepts a date or an RFC3339 time
+func parseAuditDate(value string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return t, errors.New("invalid date " + value + ", use YYYY-MM-DD or RFC3339")
+	}
+	if endOfDay {
+		t = t.Add(24*time.Hour - time.Second)
+	}
+	return t, nil
+}
+
+func AuditLog(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Ensure Authenticated admin sent request
+	if verified, _ := AuthenticateUser(c, 3); !verified {
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
+	}
+
+	filter := bson.M{}
+	if target := c.Query("target"); target != "" {
+		filter["target"] = target
+	}
+	if actor := c.Query("actor"); actor != "" {
+		filter["actor"] = actor
+	}
+
+	created := bson.M{}
+	for param, op := range map[string]string{"from": "$gte", "to": "$lte"} {
+		if c.Query(param) == "" {
+			continue
+		}
+		t, err := parseAuditDate(c.Query(param), param == "to")
+		if err != nil {
+			return NewError(fiber.StatusBadRequest, CodeInvalidRequest, err.Error())
+		}
+		created[op] = t
+	}
+	if len(created) > 0 {
+		filter["created_at"] = created
+	}
+
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	if limit < 1 || limit > 1000 {
+		limit = 100
+	}
+
+	entries := []models.AuditEntry{}
+	if err := Repos(c).Audit.Find(ctx, filter, bson.D{{Key: "seq", Value: -1}}, int64(limit), &entries); err != nil {
+		return InternalError("failed to find audit entries", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "successfully retrieved audit entries",
+		"result":  entries,
+	})
+}
+
+// VerifyAuditLog walks the chain and returns the seq of the first entry that doesn't match, 0 if it is intact
+func VerifyAuditLog(ctx context.Context, repos *repository.Repositories) (int64, int64, error) {
+	var seq, count int64
+	var prevHash string
+	for {
+		// The log is read a page at a time so a long one is never held in memory at once
+		entries := []models.AuditEntry{}
+		filter := bson.M{"seq": bson.M{"$gt": seq}}
+		if err := repos.Audit.Find(ctx, filter, bson.D{{Key: "seq", Value: 1}}, 1000, &entries); err != nil {
+			return 0, count, err
+		}
+		if len(entries) == 0 {
+			return 0, count, nil
+		}
+
+		for _, entry := range entries {
+			// Once the oldest entries are removed by retention the log chains on from the last one removed
+			if count == 0 && entry.Seq > 1 {
+				anchor, ok := auditAnchor(ctx, repos, entry.Seq-1)
+				if !ok {
+					return entry.Seq, count, nil
+				}
+				seq, prevHash = entry.Seq-1, anchor
+			}
+
+			seq++
+			count++
+			if entry.Seq != seq || entry.PrevHash != prevHash || entry.Hash != entry.ComputeHash() {
+				return seq, count, nil
+			}
+			prevHash = entry.Hash
+		}
+	}
+}
+
+func VerifyAudit(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	// Ensure Authenticated admin sent request
+	if verified, _ := AuthenticateUser(c, 3); !verified {
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
+	}
+
+	brokenAt, checked, err := VerifyAuditLog(ctx, Repos(c))
+	if err != nil {
+		return InternalError("failed to verify the audit log", err)
+	}
+
+	if brokenAt != 0 {
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success":  true,
+			"message":  "the audit log has been tampered with",
+			"valid":    false,
+			"brokenat": brokenAt,
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "the audit log is intact",
+		"valid":   true,
+		"entries": checked,
+	})
+}
@@ -0,0 +1,189 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/SowinskiBraeden/school-management-api/models"
+	"github.com/SowinskiBraeden/school-management-api/repository"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var errAuditDown = errors.New("the audit log is down")
+
+// failingAudit is an audit log no entry can be appended to
+type failingAudit struct {
+	repository.RecordRepository
+}
+
+func (failingAudit) Insert(ctx context.Context, record interface{}) error {
+	return errAuditDown
+}
+
+func auditStudent(t *testing.T, ctx context.Context, repos *repository.Repositories, sid string) {
+	var student models.Student
+	student.ID = primitive.NewObjectID()
+	student.School.SID = sid
+	student.School.Homeroom = "A1"
+	if err := repos.Students.Insert(ctx, student); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuditedWriteUndone(t *testing.T) {
+	repos := repository.NewMemory()
+	ctx, cancel := context.WithTimeout(SystemContext(context.Background(), repos), time.Minute)
+	defer cancel()
+
+	auditStudent(t, ctx, repos, "1000001")
+	repos.Audit = failingAudit{repos.Audit}
+
+	err := AuditedUpdate(nil, ctx, repos.Students, "1000001", bson.M{"$set": bson.M{"school.homeroom": "B2"}})
+	if err != errAuditDown {
+		t.Fatalf("update: got %v, want %v", err, errAuditDown)
+	}
+	if student, _ := repos.Students.Get(ctx, "1000001"); student.School.Homeroom != "A1" {
+		t.Errorf("the update was kept without its entry, homeroom is %q", student.School.Homeroom)
+	}
+
+	if err := AuditedDelete(nil, ctx, repos.Students, "1000001"); err != errAuditDown {
+		t.Fatalf("delete: got %v, want %v", err, errAuditDown)
+	}
+	if _, err := repos.Students.Get(ctx, "1000001"); err != nil {
+		t.Errorf("the delete was kept without its entry: %v", err)
+	}
+
+	var hold models.LegalHold
+	hold.ID = primitive.NewObjectID()
+	if err := AuditedInsertOne(nil, ctx, repos.LegalHolds, hold); err != errAuditDown {
+		t.Fatalf("insert: got %v, want %v", err, errAuditDown)
+	}
+	if count, _ := repos.LegalHolds.Count(ctx, bson.M{}); count != 0 {
+		t.Errorf("the insert was kept without its entry")
+	}
+}
+
+// auditChain appends entries to the log, one for each time, chained as RecordAudit would
+func auditChain(t *testing.T, ctx context.Context, repos *repository.Repositories, times ...time.Time) {
+	var last models.AuditEntry
+	if err := repos.Audit.FindOne(ctx, bson.M{}, bson.D{{Key: "seq", Value: -1}}, &last); err != nil && err != repository.ErrNotFound {
+		t.Fatal(err)
+	}
+	for _, created := range times {
+		entry := models.AuditEntry{
+			ID:         primitive.NewObjectID(),
+			Seq:        last.Seq + 1,
+			Actor:      "system",
+			Action:     "system",
+			TargetType: "students",
+			Target:     "1234566",
+			Changes:    []models.AuditChange{{Field: "school.homeroom", Before: `"A1"`, After: `"B2"`}},
+			Created_at: created.UTC().Truncate(time.Second),
+			PrevHash:   last.Hash,
+		}
+		entry.Hash = entry.ComputeHash()
+		if err := repos.Audit.Insert(ctx, entry); err != nil {
+			t.Fatal(err)
+		}
+		last = entry
+	}
+}
+
+func setAuditSeq(t *testing.T, ctx context.Context, repos *repository.Repositories, from int64, to int64) {
+	if _, err := repos.Audit.UpdateOne(ctx, bson.M{"seq": from}, bson.M{"$set": bson.M{"seq": to}}, false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyAuditLog(t *testing.T) {
+	now := time.Now()
+	for _, test := range []struct {
+		name     string
+		tamper   func(t *testing.T, ctx context.Context, repos *repository.Repositories)
+		brokenAt int64
+	}{
+		{"intact", func(t *testing.T, ctx context.Context, repos *repository.Repositories) {}, 0},
+		{"entry edited", func(t *testing.T, ctx context.Context, repos *repository.Repositories) {
+			repos.Audit.UpdateOne(ctx, bson.M{"seq": 3}, bson.M{"$set": bson.M{"target": "9876541"}}, false)
+		}, 3},
+		{"entry edited and hashed again", func(t *testing.T, ctx context.Context, repos *repository.Repositories) {
+			var entry models.AuditEntry
+			repos.Audit.FindOne(ctx, bson.M{"seq": 3}, nil, &entry)
+			entry.Target = "9876541"
+			repos.Audit.UpdateOne(ctx, bson.M{"seq": 3}, bson.M{"$set": bson.M{"target": entry.Target, "hash": entry.ComputeHash()}}, false)
+		}, 4},
+		{"entry deleted", func(t *testing.T, ctx context.Context, repos *repository.Repositories) {
+			repos.Audit.DeleteMany(ctx, bson.M{"seq": 3})
+		}, 3},
+		{"first entry deleted", func(t *testing.T, ctx context.Context, repos *repository.Repositories) {
+			repos.Audit.DeleteMany(ctx, bson.M{"seq": 1})
+		}, 2},
+		{"entries reordered", func(t *testing.T, ctx context.Context, repos *repository.Repositories) {
+			setAuditSeq(t, ctx, repos, 2, 100)
+			setAuditSeq(t, ctx, repos, 3, 2)
+			setAuditSeq(t, ctx, repos, 100, 3)
+		}, 2},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			repos := repository.NewMemory()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+
+			auditChain(t, ctx, repos, now, now, now, now, now)
+			test.tamper(t, ctx, repos)
+
+			brokenAt, _, err := VerifyAuditLog(ctx, repos)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if brokenAt != test.brokenAt {
+				t.Errorf("broken at %d, want %d", brokenAt, test.brokenAt)
+			}
+		})
+	}
+}
+
+func TestVerifyAuditLogAfterRetention(t *testing.T) {
+	repos := repository.NewMemory()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	old := time.Now().Add(-400 * 24 * time.Hour)
+	auditChain(t, ctx, repos, old, old, old, time.Now(), time.Now())
+
+	var rule models.RetentionRule
+	rule.ID = primitive.NewObjectID()
+	rule.DataType = "audit_log"
+	rule.Days = 30
+	rule.Action = "purge"
+	if err := repos.RetentionRules.Insert(ctx, rule); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := ApplyRetention(repos, false)
+	if err != nil || len(report.Errors) > 0 {
+		t.Fatalf("retention: %v %v", err, report.Errors)
+	}
+	if report.AuditAnchor == nil || report.AuditAnchor.Seq != 3 {
+		t.Fatalf("the anchor is %+v, want the third entry", report.AuditAnchor)
+	}
+
+	// The log now starts at the fourth entry and chains on from the anchor
+	brokenAt, checked, err := VerifyAuditLog(ctx, repos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if brokenAt != 0 || checked != 2 {
+		t.Errorf("got broken at %d after checking %d, want intact after checking 2", brokenAt, checked)
+	}
+
+	// Entries removed without a retention run leave no anchor to chain on from
+	repos.Audit.DeleteMany(ctx, bson.M{"seq": 4})
+	if brokenAt, _, _ := VerifyAuditLog(ctx, repos); brokenAt != 5 {
+		t.Errorf("broken at %d once the oldest kept entry is deleted, want 5", brokenAt)
+	}
+}
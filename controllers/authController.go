@@ -10,8 +10,8 @@ import (
 	"strings"
 	"time"
 
-	"github.com/SowinskiBraeden/school-management-api/database"
 	"github.com/SowinskiBraeden/school-management-api/models"
+	"github.com/SowinskiBraeden/school-management-api/repository"
 	"github.com/howeyc/gopass"
 
 	"github.com/gofiber/fiber/v2"
@@ -19,7 +19,6 @@ import (
 	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 /*
@@ -31,14 +30,7 @@ import (
 		- removeing users
 */
 
-var TeacherCollection *mongo.Collection = database.OpenCollection(database.Client, "teachers")
-var StudentCollection *mongo.Collection = database.OpenCollection(database.Client, "students")
-var ContactCollection *mongo.Collection = database.OpenCollection(database.Client, "contacts")
-var AdminCollection *mongo.Collection = database.OpenCollection(database.Client, "admins")
-var ImageCollection *mongo.Collection = database.OpenCollection(database.Client, "images")
-var LockerCollection *mongo.Collection = database.OpenCollection(database.Client, "lockers")
-
-func validMailAddress(address string) (string, bool) {
+func ValidMailAddress(address string) (string, bool) {
 	addr, err := mail.ParseAddress(address)
 	if err != nil {
 		return "", false
@@ -58,7 +50,7 @@ func confirm(s string) bool {
 	return strings.ToLower(strings.TrimSpace(res))[0] == 'y'
 }
 
-func CreateDefaultAdmin() models.Admin {
+func CreateDefaultAdmin(repos *repository.Repositories) models.Admin {
 	fmt.Println()
 	reader := bufio.NewReader(os.Stdin)
 	fmt.Print("First Name: ")
@@ -87,7 +79,7 @@ func CreateDefaultAdmin() models.Admin {
 	offset := 0
 	for {
 		schoolEmail = admin.GenerateSchoolEmail(offset, schoolEmail)
-		if !admin.EmailExists(schoolEmail) {
+		if _, err := repos.Admins.FindBySchoolEmail(context.TODO(), schoolEmail); err != nil {
 			break
 		}
 		offset++
@@ -95,17 +87,14 @@ func CreateDefaultAdmin() models.Admin {
 	admin.SchoolEmail = schoolEmail
 
 	pass := strings.TrimSuffix(string(password), "\n")
-	admin.Password = admin.HashPassword(pass)
-	admin.TempPassword = false
-
-	var aid string
-	for {
-		aid = GenerateID(6)
-		if ValidateID(aid, 3) {
-			break
-		}
+	hash, err := admin.HashPassword(pass)
+	if err != nil {
+		log.Fatal(err)
 	}
-	admin.AID = aid
+	admin.Password = hash
+	admin.HashHistory = []string{admin.Password}
+	admin.PasswordChanged_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	admin.TempPassword = false
 
 	admin.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 	admin.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
@@ -114,8 +103,8 @@ func CreateDefaultAdmin() models.Admin {
 	return admin
 }
 
-func NewSystem() {
-	count, err := AdminCollection.CountDocuments(context.Background(), bson.D{})
+func NewSystem(repos *repository.Repositories) {
+	count, err := repos.Admins.Count(context.Background(), repository.AccountFilter{State: repository.AllAccounts})
 	if err != nil {
 		fmt.Println("Unable to detect new system")
 	}
@@ -124,10 +113,14 @@ func NewSystem() {
 		fmt.Println("Admin account setup...")
 
 		for {
-			defaultAdmin := CreateDefaultAdmin()
+			defaultAdmin := CreateDefaultAdmin(repos)
 
 			if confirm("Are the above credentials correct?") {
-				_, insertErr := AdminCollection.InsertOne(context.Background(), defaultAdmin)
+				aid, insertErr := AllocateID(context.Background(), repos, GenerateUID, func(aid string) error {
+					defaultAdmin.AID = aid
+					return repos.Admins.Insert(context.Background(), defaultAdmin)
+				})
+				defaultAdmin.AID = aid
 				if insertErr != nil {
 					log.Printf("Failed to create an admin\n")
 				}
@@ -140,13 +133,21 @@ func NewSystem() {
 	}
 }
 
-var SecretKey = os.Getenv("secret")
+var SecretKey string // signs the session tokens, set by main from the secret in the .env
 
 func AuthenticateUser(c *fiber.Ctx, userType int) (bool, string) {
 	if userType < 1 || userType > 3 {
 		log.Fatal("Invalid userType")
 	}
 
+	// Integrations authenticate with an API key in place of an admin cookie
+	if key := c.Get("X-API-Key"); key != "" {
+		if userType != 3 {
+			return false, ""
+		}
+		return AuthenticateAPIKey(c, key)
+	}
+
 	cookie := c.Cookies("jwt")
 
 	token, err := jwt.ParseWithClaims(cookie, &jwt.StandardClaims{}, func(token *jwt.Token) (interface{}, error) {
@@ -158,92 +159,109 @@ func AuthenticateUser(c *fiber.Ctx, userType int) (bool, string) {
 
 	claims := token.Claims.(*jwt.StandardClaims)
 
-	var userID models.Id
-	findErr := IdCollection.FindOne(context.TODO(), bson.M{"cid": claims.Issuer}).Decode(&userID)
-	if findErr != nil {
+	user, findErr := accountOf(context.TODO(), Repos(c), userType, claims.Issuer)
+	if findErr != nil || user.Removed != nil {
 		return false, ""
 	}
 
-	if userID.ParentType != userType {
-		return false, ""
+	return true, claims.Issuer
+}
+
+// insertStep is a step of Atomic inserting a record, undone by deleting the record with key
+func insertStep(store repository.Store, key string, insert func(ctx context.Context) error) repository.Step {
+	return repository.Step{
+		Do:   insert,
+		Undo: func(ctx context.Context) error { return store.Delete(ctx, key) },
+	}
+}
+
+// auditInsertStep records an insert made by an earlier step, the insert is undone if it can't be
+func auditInsertStep(c *fiber.Ctx, store repository.Store, key string) repository.Step {
+	return repository.Step{
+		Do: func(ctx context.Context) error { return AuditInsert(c, ctx, store, key) },
+	}
+}
+
+// sendRegistered emails a new account its ID, with a link to verify the personal email of students and teachers.
+// It is only called once the account is committed, so no email goes out for an account that doesn't exist
+func sendRegistered(repos *repository.Repositories, uid string, userType int, username string, email string) bool {
+	items := map[string]string{"username": username, "id": uid, "userType": userTypeNames[userType]}
+	if userType != 3 {
+		link, err := NewVerification(repos, uid, userType, email)
+		if err != nil {
+			return false
+		}
+		items["link"] = link
 	}
+	return NewRequest([]string{email}, "Account Registered").Send("./templates/accountRegistered.html", items)
+}
 
-	return true, userID.CID
+// EnrollRequest is the body of Enroll
+type EnrollRequest struct {
+	FirstName  string   `json:"firstname" validate:"required"`
+	MiddleName string   `json:"middlename"`
+	LastName   string   `json:"lastname" validate:"required"`
+	Age        *float64 `json:"age" validate:"required"`
+	GradeLevel *float64 `json:"gradelevel" validate:"required,min=0,max=12"`
+	DOB        string   `json:"dob" validate:"required"`
+	Email      string   `json:"email" validate:"required,mailaddress"`
+	Province   string   `json:"province" validate:"required"`
+	City       string   `json:"city" validate:"required"`
+	Address    string   `json:"address" validate:"required"`
+	Postal     string   `json:"postal" validate:"required"`
+	PEN        string   `json:"pen" validate:"omitempty,pen"` // a PEN the ministry already assigned the student
+	Password1  string   `json:"password1" validate:"required"`
+	Password2  string   `json:"password2" validate:"required"`
 }
 
 func Enroll(c *fiber.Ctx) error {
-	var data map[string]interface{}
+	var data EnrollRequest
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 
-	if err := c.BodyParser(&data); err != nil {
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to parse body",
-			"error":   err,
-		})
+		return InvalidRequest(fieldErrs)
 	}
 
 	// Ensure Authenticated admin sent request
 	if verified, _ := AuthenticateUser(c, 3); !verified {
 		cancel()
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"success": false,
-			"message": "Unauthorized: only an admin can perform this action",
-		})
-	}
-
-	// Check minimum enroll field requirements are met
-	if data["firstname"] == nil || data["lastname"] == nil || data["age"] == nil ||
-		data["gradelevel"] == nil || data["dob"] == nil || data["email"] == nil ||
-		data["province"] == nil || data["city"] == nil || data["address"] == nil ||
-		data["postal"] == nil || data["password1"] == nil || data["password2"] == nil {
-		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "missing required fields",
-		})
-	}
-
-	if _, validEmail := validMailAddress(data["email"].(string)); !validEmail {
-		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "invalid email address",
-		})
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
 	}
 
-	if data["password1"] != data["password2"] {
+	if data.Password1 != data.Password2 {
 		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "the passwords chosen must match",
-		})
+		return NewError(fiber.StatusBadRequest, CodePasswordMismatch, "the passwords chosen must match")
 	}
 
+	repos := Repos(c)
 	var student models.Student
 
-	if !student.CheckPasswordStrength(data["password1"].(string)) {
-		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "your password isnt strong enough",
-		})
+	// A PEN already assigned by the ministry is imported, otherwise one is generated
+	importedPEN := data.PEN
+	if importedPEN != "" {
+		if _, err := repos.Students.FindByPEN(ctx, importedPEN); err == nil {
+			cancel()
+			return NewError(fiber.StatusConflict, CodePENTaken, "the pen is already assigned to another student")
+		}
 	}
 
-	student.Personal.FirstName = data["firstname"].(string)
-	if middle, ok := data["middlename"]; ok {
-		student.Personal.MiddleName = middle.(string)
-	}
-	student.Personal.LastName = data["lastname"].(string)
-	student.Personal.Age = data["age"].(float64)
-	student.School.GradeLevel = data["gradelevel"].(float64)
-	student.Personal.DOB = data["dob"].(string)
-	student.Personal.Email = data["email"].(string)
-	student.Personal.Province = data["province"].(string)
-	student.Personal.City = data["city"].(string)
-	student.Personal.Address = data["address"].(string)
-	student.Personal.Postal = data["postal"].(string)
+	if policyErrs := student.CheckPassword(GetPasswordPolicy(ctx, Repos(c), "student"), data.Password1); len(policyErrs) > 0 {
+		cancel()
+		return PasswordPolicyError("your password does not meet the password policy", "password1", policyErrs)
+	}
+
+	student.Personal.FirstName = data.FirstName
+	student.Personal.MiddleName = data.MiddleName
+	student.Personal.LastName = data.LastName
+	student.Personal.Age = *data.Age
+	student.School.GradeLevel = *data.GradeLevel
+	student.Personal.DOB = models.Encrypted(data.DOB)
+	student.Personal.Email = models.Searchable(data.Email)
+	student.Personal.Province = data.Province
+	student.Personal.City = data.City
+	student.Personal.Address = models.Encrypted(data.Address)
+	student.Personal.Postal = models.Encrypted(data.Postal)
 	student.Personal.Contacts = []string{}
 	student.School.YOG = ((12 - int(student.School.GradeLevel)) + time.Now().Year()) + 1
 
@@ -262,77 +280,65 @@ func Enroll(c *fiber.Ctx) error {
 	offset := 0
 	for {
 		schoolEmail = student.GenerateSchoolEmail(offset, schoolEmail)
-		if !student.EmailExists(schoolEmail) {
+		if _, err := repos.Students.FindBySchoolEmail(ctx, schoolEmail); err != nil {
 			break
 		}
 		offset++
 	}
 	student.Account.SchoolEmail = schoolEmail
-	student.Account.HashHistory = []string{}
 
 	// Disable login block
 	student.Account.AccountDisabled = false
 	student.Account.Alerted = false
 	student.Account.Attempts = 0
 
-	student.Account.Password = student.HashPassword(data["password1"].(string))
-	student.Account.TempPassword = false
-
-	var sid string
-	for {
-		sid = GenerateID(6)
-		if ValidateID(sid, 1) {
-			break
-		}
-	}
-	student.School.SID = sid
-
-	// Send student personal email student ID
-	subject := "Account Registered"
-	receiver := student.Personal.Email
-	r := NewRequest([]string{receiver}, subject)
-
-	if sent := r.Send("./templates/accountRegistered.html", map[string]string{"username": student.Personal.FirstName, "id": sid, "userType": "student"}); !sent {
+	hash, hashErr := student.HashPassword(data.Password1)
+	if hashErr != nil {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Could not send ID to students email",
-		})
-	}
-
-	var pen string
-	for {
-		pen = GenerateID(9)
-		if ValidatePEN(pen) {
-			break
-		}
+		return InternalError("the password could not be hashed", hashErr)
 	}
-	student.School.PEN = pen
+	student.Account.Password = hash
+	student.Account.HashHistory = []string{student.Account.Password}
+	student.Account.PasswordChanged_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	student.Account.TempPassword = false
 
 	student.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 	student.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 	student.ID = primitive.NewObjectID()
 
-	_, insertErr := StudentCollection.InsertOne(ctx, student)
+	// Inserting the student is what reserves its SID and PEN, the student and its photo are kept together or not at all
+	sid, insertErr := AllocateID(ctx, repos, GenerateUID, func(sid string) error {
+		student.School.SID = sid
+		student.School.PEN = importedPEN
+		if importedPEN == "" {
+			student.School.PEN = GeneratePEN()
+		}
+		return repos.Atomic(ctx,
+			insertStep(repos.Students, sid, func(ctx context.Context) error { return repos.Students.Insert(ctx, student) }),
+			insertStep(repos.Photos, photo.Name, func(ctx context.Context) error { return repos.Photos.Insert(ctx, photo) }),
+			auditInsertStep(c, repos.Students, sid),
+			auditInsertStep(c, repos.Photos, photo.Name),
+		)
+	})
+	if insertErr != nil && importedPEN != "" {
+		// Only an imported PEN taken by a student enrolled at the same time keeps every attempt failing
+		if _, err := repos.Students.FindByPEN(ctx, importedPEN); err == nil {
+			cancel()
+			return NewError(fiber.StatusConflict, CodePENTaken, "the pen is already assigned to another student")
+		}
+	}
 	if insertErr != nil {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "the student could not be inserted",
-			"error":   insertErr,
-		})
+		return InternalError("the student could not be inserted", insertErr)
 	}
+	defer cancel()
 
-	_, insertErr = ImageCollection.InsertOne(ctx, photo)
-	if insertErr != nil {
-		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "the student default photo could not be inserted",
-			"error":   insertErr,
+	if sent := sendRegistered(Repos(c), sid, 1, student.Personal.FirstName, string(student.Personal.Email)); !sent {
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success": true,
+			"message": "successfully inserted student, but the student's ID could not be emailed to them",
 		})
 	}
-	defer cancel()
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"success": true,
@@ -340,82 +346,56 @@ func Enroll(c *fiber.Ctx) error {
 	})
 }
 
+// RegisterTeacherRequest is the body of RegisterTeacher
+type RegisterTeacherRequest struct {
+	FirstName  string `json:"firstname" validate:"required"`
+	MiddleName string `json:"middlename"`
+	LastName   string `json:"lastname" validate:"required"`
+	DOB        string `json:"dob" validate:"required"`
+	Email      string `json:"email" validate:"required,mailaddress"`
+	Province   string `json:"province"`
+	City       string `json:"city"`
+	Postal     string `json:"postal"`
+	Password1  string `json:"password1" validate:"required"`
+	Password2  string `json:"password2" validate:"required"`
+}
+
 func RegisterTeacher(c *fiber.Ctx) error {
-	var data map[string]interface{}
+	var data RegisterTeacherRequest
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 
-	if err := c.BodyParser(&data); err != nil {
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to parse body",
-			"error":   err,
-		})
+		return InvalidRequest(fieldErrs)
 	}
 
 	// Ensure Authenticated admin sent request
 	if verified, _ := AuthenticateUser(c, 3); !verified {
 		cancel()
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"success": false,
-			"message": "Unauthorized: only an admin can perform this action",
-		})
-	}
-
-	// Check minimum register teacher field requirements are met
-	if data["firstname"] == nil || data["lastname"] == nil || data["dob"] == nil || data["email"] == nil ||
-		data["password1"] == nil || data["password2"] == nil {
-		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "missing required fields",
-		})
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
 	}
 
-	if _, validEmail := validMailAddress(data["email"].(string)); !validEmail {
+	if data.Password1 != data.Password2 {
 		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "invalid email address",
-		})
-	}
-
-	if data["password1"] != data["password2"] {
-		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "the passwords chosen must match",
-		})
+		return NewError(fiber.StatusBadRequest, CodePasswordMismatch, "the passwords chosen must match")
 	}
 
+	repos := Repos(c)
 	var teacher models.Teacher
 
-	if !teacher.CheckPasswordStrength(data["password1"].(string)) {
+	if policyErrs := teacher.CheckPassword(GetPasswordPolicy(ctx, Repos(c), "teacher"), data.Password1); len(policyErrs) > 0 {
 		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "your password isnt strong enough",
-		})
+		return PasswordPolicyError("your password does not meet the password policy", "password1", policyErrs)
 	}
 
-	teacher.Personal.FirstName = data["firstname"].(string)
-	if middle, ok := data["middlename"]; ok {
-		teacher.Personal.MiddleName = middle.(string)
-	}
-	teacher.Personal.LastName = data["lastname"].(string)
-	teacher.Personal.Email = data["email"].(string)
-	if province, ok := data["province"]; ok {
-		teacher.Personal.Province = province.(string)
-	}
-	if city, ok := data["city"]; ok {
-		teacher.Personal.City = city.(string)
-	}
-	if postal, ok := data["postal"]; ok {
-		teacher.Personal.Postal = postal.(string)
-	}
-	if dob, ok := data["dob"]; ok {
-		teacher.Personal.DOB = dob.(string)
-	}
+	teacher.Personal.FirstName = data.FirstName
+	teacher.Personal.MiddleName = data.MiddleName
+	teacher.Personal.LastName = data.LastName
+	teacher.Personal.Email = data.Email
+	teacher.Personal.Province = data.Province
+	teacher.Personal.City = data.City
+	teacher.Personal.Postal = data.Postal
+	teacher.Personal.DOB = data.DOB
 
 	var photo models.Photo
 	photo.Name = uuid.New().String()
@@ -432,278 +412,218 @@ func RegisterTeacher(c *fiber.Ctx) error {
 	offset := 0
 	for {
 		schoolEmail = teacher.GenerateSchoolEmail(offset, schoolEmail)
-		if !teacher.EmailExists(schoolEmail) {
+		if _, err := repos.Teachers.FindBySchoolEmail(ctx, schoolEmail); err != nil {
 			break
 		}
 		offset++
 	}
 	teacher.Account.SchoolEmail = schoolEmail
-	teacher.Account.HashHistory = []string{}
 
 	// Disable login block
 	teacher.Account.AccountDisabled = false
 	teacher.Account.Attempts = 0
 
-	teacher.Account.Password = teacher.HashPassword(data["password1"].(string))
-	teacher.Account.TempPassword = false
-
-	var tid string
-	// For the unlikely event that an ID is already in use this will simply try again till it gets a id not in use
-	for {
-		tid = GenerateID(6)
-		if ValidateID(tid, 2) {
-			break
-		}
-	}
-	teacher.School.TID = tid
-
-	// Send teacher personal email student ID
-	subject := "Account Registered"
-	receiver := teacher.Personal.Email
-	r := NewRequest([]string{receiver}, subject)
-
-	if sent := r.Send("./templates/accountRegistered.html", map[string]string{"username": teacher.Personal.FirstName, "id": tid, "userType": "teacher"}); !sent {
+	hash, hashErr := teacher.HashPassword(data.Password1)
+	if hashErr != nil {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Could not send ID to teachers email",
-		})
+		return InternalError("the password could not be hashed", hashErr)
 	}
+	teacher.Account.Password = hash
+	teacher.Account.HashHistory = []string{teacher.Account.Password}
+	teacher.Account.PasswordChanged_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	teacher.Account.TempPassword = false
 
 	teacher.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 	teacher.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 	teacher.ID = primitive.NewObjectID()
 
-	_, insertErr := TeacherCollection.InsertOne(ctx, teacher)
+	// Inserting the teacher is what reserves its TID, the teacher and its photo are kept together or not at all
+	tid, insertErr := AllocateID(ctx, repos, GenerateUID, func(tid string) error {
+		teacher.School.TID = tid
+		return repos.Atomic(ctx,
+			insertStep(repos.Teachers, tid, func(ctx context.Context) error { return repos.Teachers.Insert(ctx, teacher) }),
+			insertStep(repos.Photos, photo.Name, func(ctx context.Context) error { return repos.Photos.Insert(ctx, photo) }),
+			auditInsertStep(c, repos.Teachers, tid),
+			auditInsertStep(c, repos.Photos, photo.Name),
+		)
+	})
 	if insertErr != nil {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "the teacher could not be inserted",
-			"error":   insertErr,
-		})
+		return InternalError("the teacher could not be inserted", insertErr)
 	}
 	defer cancel()
 
+	if sent := sendRegistered(Repos(c), tid, 2, teacher.Personal.FirstName, teacher.Personal.Email); !sent {
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success": true,
+			"message": "successfully inserted teacher, but the teacher's ID could not be emailed to them",
+		})
+	}
+
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"success": true,
 		"message": "successfully inserted teacher",
 	})
 }
 
+// CreateAdminRequest is the body of CreateAdmin
+type CreateAdminRequest struct {
+	FirstName string `json:"firstname" validate:"required"`
+	LastName  string `json:"lastname" validate:"required"`
+	DOB       string `json:"dob" validate:"required"`
+	Email     string `json:"email" validate:"required,mailaddress"`
+	Password1 string `json:"password1" validate:"required"`
+	Password2 string `json:"password2" validate:"required"`
+}
+
 func CreateAdmin(c *fiber.Ctx) error {
-	var data map[string]interface{}
+	var data CreateAdminRequest
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 
-	if err := c.BodyParser(&data); err != nil {
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to parse body",
-			"error":   err,
-		})
+		return InvalidRequest(fieldErrs)
 	}
 
 	// Ensure Authenticated admin sent request
 	if verified, _ := AuthenticateUser(c, 3); !verified {
 		cancel()
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"success": false,
-			"message": "Unauthorized: only an admin can perform this action",
-		})
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
 	}
 
-	// Check minimum register teacher field requirements are met
-	if data["firstname"] == nil || data["lastname"] == nil || data["dob"] == nil || data["email"] == nil ||
-		data["password1"] == nil || data["password2"] == nil {
+	if data.Password1 != data.Password2 {
 		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "missing required fields",
-		})
-	}
-
-	if _, validEmail := validMailAddress(data["email"].(string)); !validEmail {
-		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "invalid email address",
-		})
-	}
-
-	if data["password1"] != data["password2"] {
-		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "the passwords chosen must match",
-		})
+		return NewError(fiber.StatusBadRequest, CodePasswordMismatch, "the passwords chosen must match")
 	}
 
+	repos := Repos(c)
 	var admin models.Admin
 
-	if !admin.CheckPasswordStrength(data["password1"].(string)) {
+	if policyErrs := admin.CheckPassword(GetPasswordPolicy(ctx, Repos(c), "admin"), data.Password1); len(policyErrs) > 0 {
 		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "your password isnt strong enough",
-		})
+		return PasswordPolicyError("your password does not meet the password policy", "password1", policyErrs)
 	}
 
-	admin.FirstName = data["firstname"].(string)
-	admin.LastName = data["lastname"].(string)
-	admin.Email = data["email"].(string)
+	admin.FirstName = data.FirstName
+	admin.LastName = data.LastName
+	admin.Email = data.Email
 
 	var schoolEmail string = ""
 	offset := 0
 	for {
 		schoolEmail = admin.GenerateSchoolEmail(offset, schoolEmail)
-		if !admin.EmailExists(schoolEmail) {
+		if _, err := repos.Admins.FindBySchoolEmail(context.TODO(), schoolEmail); err != nil {
 			break
 		}
 		offset++
 	}
 	admin.SchoolEmail = schoolEmail
 
-	admin.Password = admin.HashPassword(data["password1"].(string))
-	admin.TempPassword = false
-
-	var aid string
-	for {
-		aid = GenerateID(6)
-		if ValidateID(aid, 3) {
-			break
-		}
-	}
-	admin.AID = aid
-
-	// Send student personal email student ID
-	subject := "Account Registered"
-	receiver := admin.Email
-	r := NewRequest([]string{receiver}, subject)
-
-	if sent := r.Send("./templates/accountRegistered.html", map[string]string{"username": admin.FirstName, "id": aid, "userType": "admin"}); !sent {
+	hash, hashErr := admin.HashPassword(data.Password1)
+	if hashErr != nil {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Could not send ID to admins email",
-		})
+		return InternalError("the password could not be hashed", hashErr)
 	}
+	admin.Password = hash
+	admin.HashHistory = []string{admin.Password}
+	admin.PasswordChanged_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	admin.TempPassword = false
 
 	admin.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 	admin.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 	admin.ID = primitive.NewObjectID()
 
-	_, insertErr := AdminCollection.InsertOne(ctx, admin)
+	// Inserting the admin is what reserves its AID
+	aid, insertErr := AllocateID(ctx, repos, GenerateUID, func(aid string) error {
+		admin.AID = aid
+		return repos.Atomic(ctx,
+			insertStep(repos.Admins, aid, func(ctx context.Context) error { return repos.Admins.Insert(ctx, admin) }),
+			auditInsertStep(c, repos.Admins, aid),
+		)
+	})
 	if insertErr != nil {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "the admin could not be inserted",
-			"error":   insertErr,
-		})
+		return InternalError("the admin could not be inserted", insertErr)
 	}
 	defer cancel()
 
+	if sent := sendRegistered(Repos(c), aid, 3, admin.FirstName, admin.Email); !sent {
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success": true,
+			"message": "successfully inserted admin, but the admin's ID could not be emailed to them",
+		})
+	}
+
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"success": true,
 		"message": "successfully inserted admin",
 	})
 }
 
+// LoginRequest is the body of StudentLogin, TeacherLogin and AdminLogin
+type LoginRequest struct {
+	UID      string `json:"uid" validate:"required,uid"`
+	Password string `json:"password" validate:"required"`
+}
+
 func StudentLogin(c *fiber.Ctx) error {
-	var data map[string]string
+	var data LoginRequest
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 
-	if err := c.BodyParser(&data); err != nil {
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to parse body",
-			"error":   err,
-		})
+		return InvalidRequest(fieldErrs)
 	}
 
-	// Check required fields are included
-	if data["uid"] == "" || data["password"] == "" {
+	if PasswordLoginDisabled("student") {
 		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "missing required fields",
-		})
+		return NewError(fiber.StatusForbidden, CodePasswordLoginDisabled, "password login is disabled, sign in with your school account")
 	}
 
-	var student models.Student
-	err := StudentCollection.FindOne(ctx, bson.M{"school.sid": data["uid"]}).Decode(&student)
+	// A device the student has logged in from before isn't held back by others failing to log in
+	trusted := TrustedDevice(Repos(c), data.UID, c.Cookies(deviceCookie))
 
-	if err != nil {
+	// Throttle an IP guessing passwords before it can lock out any accounts
+	if !trusted && IPThrottled(Repos(c), c.IP()) {
 		cancel()
-		return c.Status(fiber.StatusOK).JSON(fiber.Map{
-			"success": false,
-			"message": "student not found",
-			"error":   err,
-		})
+		return NewError(fiber.StatusTooManyRequests, CodeTooManyAttempts, "too many failed login attempts, try again later")
 	}
 
-	var verified bool = student.ComparePasswords(data["password"])
-	var localAccountDisabled bool = false
-
-	if !verified {
-		student.Account.Attempts += 1
+	repos := Repos(c)
+	student, err := repos.Students.Get(ctx, data.UID)
+	if err == nil && student.Removed != nil {
+		err = repository.ErrNotFound
 	}
 
-	if student.Account.Attempts >= 5 {
-		localAccountDisabled = true // Catches newly disbaled account before student obj is updated
-		update_time, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-		update := bson.M{
-			"$set": bson.M{
-				"account.accountdisabled": true,
-				"account.alerted":         true,
-				"account.attempts":        0,
-				"updated_at":              update_time,
-			},
-		}
-
-		_, updateErr := StudentCollection.UpdateOne(
-			ctx,
-			bson.M{"school.sid": data["uid"]},
-			update,
-		)
-		if updateErr != nil {
-			cancel()
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"success": false,
-				"message": "the student could not be updated",
-				"error":   updateErr,
-			})
-		}
+	if err != nil {
+		cancel()
+		RecordLoginAttempt(Repos(c), data.UID, 1, c.IP(), "", false)
+		return FindError("student", err)
 	}
 
-	if localAccountDisabled || student.Account.AccountDisabled {
-
-		if !student.Account.Alerted {
-			// Send student email warning of disabled account
-			subject := "Account Disabled"
-			receiver := student.Personal.Email
-			r := NewRequest([]string{receiver}, subject)
+	if student.Account.AccountDisabled {
+		cancel()
+		return NewError(fiber.StatusForbidden, CodeAccountDisabled, "Account is Disabled, contact an Admin")
+	}
 
-			if sent := r.Send("./templates/accountDisabled.html", map[string]string{"username": student.Personal.FirstName}); !sent {
-				cancel()
-				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-					"success": false,
-					"message": "Could not send password to students email",
-					"error":   err,
-				})
-			}
-		}
+	// A locked account still accepts logins from a device the student has used before
+	if student.Locked() && !trusted {
 		cancel()
+		return NewError(fiber.StatusLocked, CodeAccountLocked, LockedMessage(student.Account.LockedUntil))
+	}
 
-		return c.Status(fiber.StatusOK).JSON(fiber.Map{
-			"success": false,
-			"message": "Account is Disabled, contact an Admin",
-		})
+	var verified bool = student.ComparePasswords(data.Password)
+	var passwordExpired bool = verified && student.PasswordExpired(GetPasswordPolicy(ctx, Repos(c), "student"))
+	var device string
+	if verified {
+		device = TrustDevice(c)
 	}
+	RecordLoginAttempt(Repos(c), student.School.SID, 1, c.IP(), device, verified)
 
 	if !verified {
+		var locked bool = student.Account.Attempts+1 >= MaxLoginAttempts
+		lockedUntil := time.Now().Add(LockoutDuration(student.Account.Lockouts))
+
 		update_time, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		update := bson.M{
 			"$set": bson.M{
@@ -711,46 +631,64 @@ func StudentLogin(c *fiber.Ctx) error {
 				"updated_at":       update_time,
 			},
 		}
+		if locked {
+			update = bson.M{
+				"$set": bson.M{
+					"account.attempts":    0,
+					"account.lockeduntil": lockedUntil,
+					"account.lockouts":    (student.Account.Lockouts + 1),
+					"updated_at":          update_time,
+				},
+			}
+		}
 
-		_, updateErr := StudentCollection.UpdateOne(
-			ctx,
-			bson.M{"school.sid": data["uid"]},
-			update,
-		)
+		updateErr := repos.Students.Update(ctx, student.School.SID, update)
 		cancel()
 		if updateErr != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"success": false,
-				"message": "the student could not be updated",
-				"error":   updateErr,
-			})
+			return UpdateError("student", updateErr)
 		}
 
-		return c.Status(fiber.StatusOK).JSON(fiber.Map{
-			"success": false,
-			"message": "incorrect password",
-		})
+		if locked {
+			// Send student email warning of locked account
+			if student.Account.VerifiedEmail {
+				subject := "Account Locked"
+				receiver := string(student.Personal.Email)
+				r := NewRequest([]string{receiver}, subject)
+				r.Send("./templates/accountLocked.html", map[string]string{"username": student.Personal.FirstName, "until": lockedUntil.Format(time.RFC1123)})
+			}
+
+			return NewError(fiber.StatusLocked, CodeAccountLocked, LockedMessage(lockedUntil))
+		}
+
+		return NewError(fiber.StatusUnauthorized, CodeIncorrectPassword, "incorrect password")
 	} else {
 		update_time, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		update := bson.M{
 			"$set": bson.M{
-				"account.attempts": 0,
-				"updated_at":       update_time,
+				"account.attempts":    0,
+				"account.lockeduntil": time.Time{},
+				"account.lockouts":    0,
+				"updated_at":          update_time,
 			},
 		}
+		// An expired password is treated like a temp password that must be changed
+		if passwordExpired {
+			update["$set"].(bson.M)["account.temppassword"] = true
+		}
+		// Re-encode the hash if it was made with an outdated algorithm or cost
+		if models.Hashing.NeedsRehash(student.Account.Password) {
+			// If it fails the old hash is kept, it still verifies and is re-encoded at the next login
+			if hash, hashErr := student.HashPassword(data.Password); hashErr == nil {
+				update["$set"].(bson.M)["account.password"] = hash
+			} else {
+				log.Printf("Failed to re-encode the password hash: %v", hashErr)
+			}
+		}
 
-		_, updateErr := StudentCollection.UpdateOne(
-			ctx,
-			bson.M{"school.sid": data["uid"]},
-			update,
-		)
+		updateErr := repos.Students.Update(ctx, student.School.SID, update)
 		if updateErr != nil {
 			cancel()
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"success": false,
-				"message": "the student could not be updated",
-				"error":   updateErr,
-			})
+			return UpdateError("student", updateErr)
 		}
 	}
 	defer cancel()
@@ -761,10 +699,7 @@ func StudentLogin(c *fiber.Ctx) error {
 	})
 	token, err := claims.SignedString([]byte(SecretKey))
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "could not log in",
-		})
+		return InternalError("could not log in", nil)
 	}
 
 	cookie := fiber.Cookie{
@@ -776,53 +711,138 @@ func StudentLogin(c *fiber.Ctx) error {
 	c.Cookie(&cookie)
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"success": true,
-		"message": "correct password",
+		"success":         true,
+		"message":         "correct password",
+		"passwordexpired": passwordExpired,
 	})
 }
 
 func TeacherLogin(c *fiber.Ctx) error {
-	var data map[string]string
+	var data LoginRequest
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 
-	if err := c.BodyParser(&data); err != nil {
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to parse body",
-			"error":   err,
-		})
+		return InvalidRequest(fieldErrs)
 	}
 
-	// Check required fields are included
-	if data["uid"] == "" || data["password"] == "" {
+	if PasswordLoginDisabled("teacher") {
 		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "missing required fields",
-		})
+		return NewError(fiber.StatusForbidden, CodePasswordLoginDisabled, "password login is disabled, sign in with your school account")
 	}
 
-	var teacher models.Teacher
-	err := TeacherCollection.FindOne(ctx, bson.M{"school.tid": data["uid"]}).Decode(&teacher)
-	defer cancel()
+	// A device the teacher has logged in from before isn't held back by others failing to log in
+	trusted := TrustedDevice(Repos(c), data.UID, c.Cookies(deviceCookie))
 
-	if err != nil {
+	// Throttle an IP guessing passwords before it can lock out any accounts
+	if !trusted && IPThrottled(Repos(c), c.IP()) {
 		cancel()
-		return c.Status(fiber.StatusOK).JSON(fiber.Map{
-			"success": false,
-			"message": "teacher not found",
-			"error":   err,
-		})
+		return NewError(fiber.StatusTooManyRequests, CodeTooManyAttempts, "too many failed login attempts, try again later")
+	}
+
+	repos := Repos(c)
+	teacher, err := repos.Teachers.Get(ctx, data.UID)
+	if err == nil && teacher.Removed != nil {
+		err = repository.ErrNotFound
 	}
 	defer cancel()
 
-	var verified bool = teacher.ComparePasswords(data["password"])
+	if err != nil {
+		RecordLoginAttempt(Repos(c), data.UID, 2, c.IP(), "", false)
+		return FindError("teacher", err)
+	}
+
+	if teacher.Account.AccountDisabled {
+		return NewError(fiber.StatusForbidden, CodeAccountDisabled, "Account is Disabled, contact an Admin")
+	}
+
+	// A locked account still accepts logins from a device the teacher has used before
+	if teacher.Locked() && !trusted {
+		return NewError(fiber.StatusLocked, CodeAccountLocked, LockedMessage(teacher.Account.LockedUntil))
+	}
+
+	// Synced staff use their directory password when LDAP_AUTH is on
+	var directoryLogin bool = DirectoryAuthEnabled() && teacher.Account.DirectoryDN != ""
+	var verified bool
+	if directoryLogin {
+		verified = DirectoryBind(teacher.Account.DirectoryDN, data.Password)
+	} else {
+		verified = teacher.ComparePasswords(data.Password)
+	}
+	var passwordExpired bool = verified && !directoryLogin && teacher.PasswordExpired(GetPasswordPolicy(ctx, Repos(c), "teacher"))
+	var device string
+	if verified {
+		device = TrustDevice(c)
+	}
+	RecordLoginAttempt(Repos(c), teacher.School.TID, 2, c.IP(), device, verified)
+
+	update_time, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 	if !verified {
-		return c.Status(fiber.StatusOK).JSON(fiber.Map{
-			"success": false,
-			"message": "incorrect password",
-		})
+		var locked bool = teacher.Account.Attempts+1 >= MaxLoginAttempts
+		lockedUntil := time.Now().Add(LockoutDuration(teacher.Account.Lockouts))
+
+		update := bson.M{
+			"$set": bson.M{
+				"account.attempts": (teacher.Account.Attempts + 1),
+				"updated_at":       update_time,
+			},
+		}
+		if locked {
+			update = bson.M{
+				"$set": bson.M{
+					"account.attempts":    0,
+					"account.lockeduntil": lockedUntil,
+					"account.lockouts":    (teacher.Account.Lockouts + 1),
+					"updated_at":          update_time,
+				},
+			}
+		}
+
+		updateErr := repos.Teachers.Update(ctx, teacher.School.TID, update)
+		if updateErr != nil {
+			return UpdateError("teacher", updateErr)
+		}
+
+		if locked {
+			// Send teacher email warning of locked account
+			if teacher.Account.VerifiedEmail {
+				subject := "Account Locked"
+				receiver := teacher.Personal.Email
+				r := NewRequest([]string{receiver}, subject)
+				r.Send("./templates/accountLocked.html", map[string]string{"username": teacher.Personal.FirstName, "until": lockedUntil.Format(time.RFC1123)})
+			}
+
+			return NewError(fiber.StatusLocked, CodeAccountLocked, LockedMessage(lockedUntil))
+		}
+
+		return NewError(fiber.StatusUnauthorized, CodeIncorrectPassword, "incorrect password")
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"account.attempts":    0,
+			"account.lockeduntil": time.Time{},
+			"account.lockouts":    0,
+			"updated_at":          update_time,
+		},
+	}
+	// An expired password is treated like a temp password that must be changed
+	if passwordExpired {
+		update["$set"].(bson.M)["account.temppassword"] = true
+	}
+	// Re-encode the hash if it was made with an outdated algorithm or cost
+	if !directoryLogin && models.Hashing.NeedsRehash(teacher.Account.Password) {
+		// If it fails the old hash is kept, it still verifies and is re-encoded at the next login
+		if hash, hashErr := teacher.HashPassword(data.Password); hashErr == nil {
+			update["$set"].(bson.M)["account.password"] = hash
+		} else {
+			log.Printf("Failed to re-encode the password hash: %v", hashErr)
+		}
+	}
+
+	updateErr := repos.Teachers.Update(ctx, teacher.School.TID, update)
+	if updateErr != nil {
+		return UpdateError("teacher", updateErr)
 	}
 
 	claims := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{
@@ -831,10 +851,7 @@ func TeacherLogin(c *fiber.Ctx) error {
 	})
 	token, err := claims.SignedString([]byte(SecretKey))
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "could not log in",
-		})
+		return InternalError("could not log in", nil)
 	}
 
 	cookie := fiber.Cookie{
@@ -846,53 +863,136 @@ func TeacherLogin(c *fiber.Ctx) error {
 	c.Cookie(&cookie)
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"success": true,
-		"message": "correct password",
+		"success":         true,
+		"message":         "correct password",
+		"passwordexpired": passwordExpired,
 	})
 }
 
 func AdminLogin(c *fiber.Ctx) error {
-	var data map[string]string
+	var data LoginRequest
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 
-	if err := c.BodyParser(&data); err != nil {
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to parse body",
-			"error":   err,
-		})
+		return InvalidRequest(fieldErrs)
 	}
 
-	// Check required fields are included
-	if data["uid"] == "" || data["password"] == "" {
+	if PasswordLoginDisabled("admin") {
 		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "missing required fields",
-		})
+		return NewError(fiber.StatusForbidden, CodePasswordLoginDisabled, "password login is disabled, sign in with your school account")
 	}
 
-	var admin models.Admin
-	err := AdminCollection.FindOne(ctx, bson.M{"aid": data["uid"]}).Decode(&admin)
-	defer cancel()
+	// A device the admin has logged in from before isn't held back by others failing to log in
+	trusted := TrustedDevice(Repos(c), data.UID, c.Cookies(deviceCookie))
 
-	if err != nil {
+	// Throttle an IP guessing passwords before it can lock out any accounts
+	if !trusted && IPThrottled(Repos(c), c.IP()) {
 		cancel()
-		return c.Status(fiber.StatusOK).JSON(fiber.Map{
-			"success": false,
-			"message": "admin not found",
-			"error":   err,
-		})
+		return NewError(fiber.StatusTooManyRequests, CodeTooManyAttempts, "too many failed login attempts, try again later")
+	}
+
+	repos := Repos(c)
+	admin, err := repos.Admins.Get(ctx, data.UID)
+	if err == nil && admin.Removed != nil {
+		err = repository.ErrNotFound
 	}
 	defer cancel()
 
-	var verified bool = admin.ComparePasswords(data["password"])
+	if err != nil {
+		RecordLoginAttempt(Repos(c), data.UID, 3, c.IP(), "", false)
+		return FindError("admin", err)
+	}
+
+	if admin.AccountDisabled {
+		return NewError(fiber.StatusForbidden, CodeAccountDisabled, "Account is Disabled, contact an Admin")
+	}
+
+	// A locked account still accepts logins from a device the admin has used before
+	if admin.Locked() && !trusted {
+		return NewError(fiber.StatusLocked, CodeAccountLocked, LockedMessage(admin.LockedUntil))
+	}
+
+	// Synced staff use their directory password when LDAP_AUTH is on
+	var directoryLogin bool = DirectoryAuthEnabled() && admin.DirectoryDN != ""
+	var verified bool
+	if directoryLogin {
+		verified = DirectoryBind(admin.DirectoryDN, data.Password)
+	} else {
+		verified = admin.ComparePasswords(data.Password)
+	}
+	var passwordExpired bool = verified && !directoryLogin && admin.PasswordExpired(GetPasswordPolicy(ctx, Repos(c), "admin"))
+	var device string
+	if verified {
+		device = TrustDevice(c)
+	}
+	RecordLoginAttempt(Repos(c), admin.AID, 3, c.IP(), device, verified)
+
+	update_time, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 	if !verified {
-		return c.Status(fiber.StatusOK).JSON(fiber.Map{
-			"success": false,
-			"message": "incorrect password",
-		})
+		var locked bool = admin.Attempts+1 >= MaxLoginAttempts
+		lockedUntil := time.Now().Add(LockoutDuration(admin.Lockouts))
+
+		update := bson.M{
+			"$set": bson.M{
+				"attempts":   (admin.Attempts + 1),
+				"updated_at": update_time,
+			},
+		}
+		if locked {
+			update = bson.M{
+				"$set": bson.M{
+					"attempts":    0,
+					"lockeduntil": lockedUntil,
+					"lockouts":    (admin.Lockouts + 1),
+					"updated_at":  update_time,
+				},
+			}
+		}
+
+		updateErr := repos.Admins.Update(ctx, admin.AID, update)
+		if updateErr != nil {
+			return UpdateError("admin", updateErr)
+		}
+
+		if locked {
+			// Send admin email warning of locked account
+			subject := "Account Locked"
+			receiver := admin.Email
+			r := NewRequest([]string{receiver}, subject)
+			r.Send("./templates/accountLocked.html", map[string]string{"username": admin.FirstName, "until": lockedUntil.Format(time.RFC1123)})
+
+			return NewError(fiber.StatusLocked, CodeAccountLocked, LockedMessage(lockedUntil))
+		}
+
+		return NewError(fiber.StatusUnauthorized, CodeIncorrectPassword, "incorrect password")
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"attempts":    0,
+			"lockeduntil": time.Time{},
+			"lockouts":    0,
+			"updated_at":  update_time,
+		},
+	}
+	// An expired password is treated like a temp password that must be changed
+	if passwordExpired {
+		update["$set"].(bson.M)["temppassword"] = true
+	}
+	// Re-encode the hash if it was made with an outdated algorithm or cost
+	if !directoryLogin && models.Hashing.NeedsRehash(admin.Password) {
+		// If it fails the old hash is kept, it still verifies and is re-encoded at the next login
+		if hash, hashErr := admin.HashPassword(data.Password); hashErr == nil {
+			update["$set"].(bson.M)["password"] = hash
+		} else {
+			log.Printf("Failed to re-encode the password hash: %v", hashErr)
+		}
+	}
+
+	updateErr := repos.Admins.Update(ctx, admin.AID, update)
+	if updateErr != nil {
+		return UpdateError("admin", updateErr)
 	}
 
 	claims := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{
@@ -901,10 +1001,7 @@ func AdminLogin(c *fiber.Ctx) error {
 	})
 	token, err := claims.SignedString([]byte(SecretKey))
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "could not log in",
-		})
+		return InternalError("could not log in", nil)
 	}
 
 	cookie := fiber.Cookie{
@@ -916,32 +1013,21 @@ func AdminLogin(c *fiber.Ctx) error {
 	c.Cookie(&cookie)
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"success": true,
-		"message": "correct password",
+		"success":         true,
+		"message":         "correct password",
+		"passwordexpired": passwordExpired,
 	})
 }
 
 func Student(c *fiber.Ctx) error {
 	var sid string
 	if verified, _ := AuthenticateUser(c, 3); verified {
-		var data map[string]string
-
-		if err := c.BodyParser(&data); err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"success": false,
-				"message": "Failed to parse body",
-				"error":   err,
-			})
-		}
+		var data UIDRequest
 
-		// Check required fields are included
-		if data["uid"] == "" {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"success": false,
-				"message": "missing required fields",
-			})
+		if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
+			return InvalidRequest(fieldErrs)
 		}
-		sid = data["uid"]
+		sid = data.UID
 	} else {
 		cookie := c.Cookies("jwt")
 
@@ -950,50 +1036,58 @@ func Student(c *fiber.Ctx) error {
 		})
 		// This returns not authorized for both admin and student
 		if err != nil {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"success": false,
-				"message": "not authorized",
-			})
+			return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "not authorized")
 		}
 
 		claims := token.Claims.(*jwt.StandardClaims)
 		sid = claims.Issuer
 	}
 
-	responseData := make(map[string]interface{})
+	responseData, err := StudentRecord(c, sid)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"success":  true,
+		"response": responseData,
+	})
+}
+
+// StudentRecord gathers a student with their locker, contacts and photo, and records the access
+func StudentRecord(c *fiber.Ctx, sid string) (fiber.Map, error) {
+	responseData := fiber.Map{}
 	responseData["student"] = nil
 	responseData["locker"] = nil
 	responseData["contacts"] = nil
 	responseData["photo"] = nil
 
-	var student models.Student
-	findErr := StudentCollection.FindOne(context.TODO(), bson.M{"school.sid": sid}).Decode(&student)
+	repos := Repos(c)
+	student, findErr := repos.Students.Get(context.TODO(), sid)
+	if findErr == nil && student.Removed != nil {
+		findErr = repository.ErrNotFound
+	}
 	if findErr != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "student not found",
-		})
+		return nil, FindError("student", findErr)
 	}
 
 	if student.Account.AccountDisabled {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "Student Accound Disabled, Contact and Admin",
-		})
+		return nil, NewError(fiber.StatusForbidden, CodeAccountDisabled, "Account is Disabled, contact an Admin")
 	}
 
 	responseData["student"] = student
 
-	var locker models.Locker
-	if student.School.Locker != "" {
-		LockerCollection.FindOne(context.TODO(), bson.M{"ID": student.School.Locker}).Decode(&locker)
+	if student.School.Locker != "" && APIKeyAllows(c, "lockers:read") {
+		locker, _ := repos.Lockers.Get(context.TODO(), student.School.Locker)
 		responseData["locker"] = locker
 	}
 
 	var contacts []models.Contact
-	var contact models.Contact
 	for i := range student.Personal.Contacts {
-		findErr := ContactCollection.FindOne(context.TODO(), bson.M{"_id": student.Personal.Contacts[i]}).Decode(&contact)
+		if !APIKeyAllows(c, "contacts:read") {
+			break
+		}
+		contact, findErr := repos.Contacts.Get(context.TODO(), student.Personal.Contacts[i])
 		if findErr != nil {
 			responseData["error"] = "Error! There was an error finding some contacts"
 		}
@@ -1003,17 +1097,22 @@ func Student(c *fiber.Ctx) error {
 		responseData["contacts"] = contacts
 	}
 
-	var photo models.Photo
-	findErr = ImageCollection.FindOne(context.TODO(), bson.M{"name": student.School.PhotoName}).Decode(&photo)
+	photo, findErr := repos.Photos.Get(context.TODO(), student.School.PhotoName)
 	if findErr != nil {
 		responseData["error"] = "Error! There was an error finding the student photo"
 	}
 	responseData["photo"] = photo
 
-	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
-		"success":  true,
-		"response": responseData,
-	})
+	fields := []string{"student", "photo"}
+	if responseData["locker"] != nil {
+		fields = append(fields, "locker")
+	}
+	if responseData["contacts"] != nil {
+		fields = append(fields, "contacts")
+	}
+	RecordAccess(c, fields, student.School.SID)
+
+	return responseData, nil
 }
 
 func Teacher(c *fiber.Ctx) error {
@@ -1023,29 +1122,22 @@ func Teacher(c *fiber.Ctx) error {
 		return []byte(SecretKey), nil
 	})
 	if err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"success": false,
-			"message": "not authorized",
-		})
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "not authorized")
 	}
 
 	claims := token.Claims.(*jwt.StandardClaims)
 
 	responseData := make(map[string]interface{})
 
-	var teacher models.Teacher
-	findErr := TeacherCollection.FindOne(context.TODO(), bson.M{"school.tid": claims.Issuer}).Decode(&teacher)
-	if findErr != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "teacher not found",
-		})
+	repos := Repos(c)
+	teacher, findErr := repos.Teachers.Get(context.TODO(), claims.Issuer)
+	if findErr != nil || teacher.Removed != nil {
+		return NotFound("teacher")
 	}
 
 	responseData["teacher"] = teacher
 
-	var photo models.Photo
-	findErr = ImageCollection.FindOne(context.TODO(), bson.M{"name": teacher.School.PhotoName}).Decode(&photo)
+	photo, findErr := repos.Photos.Get(context.TODO(), teacher.School.PhotoName)
 	if findErr != nil {
 		responseData["error"] = "Error! There was an error finding the student photo"
 	}
@@ -1065,21 +1157,14 @@ func Admin(c *fiber.Ctx) error {
 		return []byte(SecretKey), nil
 	})
 	if err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"success": false,
-			"message": "not authorized",
-		})
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "not authorized")
 	}
 
 	claims := token.Claims.(*jwt.StandardClaims)
 
-	var admin models.Admin
-	findErr := AdminCollection.FindOne(context.TODO(), bson.M{"aid": claims.Issuer}).Decode(&admin)
-	if findErr != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "admin not found",
-		})
+	admin, findErr := Repos(c).Admins.Get(context.TODO(), claims.Issuer)
+	if findErr != nil || admin.Removed != nil {
+		return NotFound("admin")
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
@@ -1091,6 +1176,11 @@ func Admin(c *fiber.Ctx) error {
 
 // Should work for both teacher and student ends
 func Logout(c *fiber.Ctx) error {
+	// Logging out of an impersonation also logs out the admin behind it
+	if session := impersonationSession(c); session != nil {
+		endImpersonation(c, session)
+	}
+
 	cookie := fiber.Cookie{
 		Name:     "jwt",
 		Value:    "",
@@ -1105,130 +1195,116 @@ func Logout(c *fiber.Ctx) error {
 	})
 }
 
+// CreateContactRequest is the body of CreateContact
+type CreateContactRequest struct {
+	UID        string   `json:"uid" validate:"required,uid"` // the student the contact is for
+	FirstName  string   `json:"firstname" validate:"required"`
+	MiddleName string   `json:"middlename"`
+	LastName   string   `json:"lastname" validate:"required"`
+	HomePhone  *float64 `json:"homephone" validate:"required"`
+	WorkPhone  float64  `json:"workphone"`
+	Email      string   `json:"email" validate:"required"`
+	Province   string   `json:"province"`
+	City       string   `json:"city"`
+	Address    string   `json:"address"`
+	Postal     string   `json:"postal"`
+	Relation   string   `json:"relation" validate:"required"`
+	Priority   *float64 `json:"priority" validate:"required"`
+}
+
 func CreateContact(c *fiber.Ctx) error {
-	var data map[string]interface{}
+	var data CreateContactRequest
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 
-	if err := c.BodyParser(&data); err != nil {
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to parse body",
-			"error":   err,
-		})
+		return InvalidRequest(fieldErrs)
 	}
 
 	// Ensure Authenticated admin sent request
 	if verified, _ := AuthenticateUser(c, 3); !verified {
 		cancel()
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"success": false,
-			"message": "Unauthorized: only an admin can perform this action",
-		})
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
 	}
 
-	// Check required fields are included
-	if data["uid"] == nil || data["firstname"] == nil || data["lastname"] == nil || data["homephone"] == nil || data["email"] == nil || data["priority"] == nil || data["relation"] == nil {
+	if _, err := InsertContact(c, ctx, data); err != nil {
 		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "missing required fields",
-		})
+		return err
 	}
+	defer cancel()
 
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "successfully inserted contact to student",
+	})
+}
+
+// InsertContact inserts a contact for the student data.UID, and adds it to the student in the same transaction
+func InsertContact(c *fiber.Ctx, ctx context.Context, data CreateContactRequest) (models.Contact, error) {
 	var contact models.Contact
-	contact.FirstName = data["firstname"].(string)
-	contact.MiddleName = data["middlename"].(string)
-	contact.LastName = data["lastname"].(string)
-	contact.HomePhone = data["homephone"].(float64)
-	contact.WorkPhone = data["workphone"].(float64)
-	contact.Email = data["email"].(string)
-	contact.Province = data["province"].(string)
-	contact.City = data["city"].(string)
-	contact.Address = data["address"].(string)
-	contact.Postal = data["postal"].(string)
-	contact.Relation = data["relation"].(string)
-	contact.Priotrity, _ = data["priority"].(float64)
+	contact.FirstName = data.FirstName
+	contact.MiddleName = data.MiddleName
+	contact.LastName = data.LastName
+	contact.HomePhone = models.EncryptedNumber(*data.HomePhone)
+	contact.WorkPhone = models.EncryptedNumber(data.WorkPhone)
+	contact.Email = models.Searchable(data.Email)
+	contact.Province = data.Province
+	contact.City = data.City
+	contact.Address = models.Encrypted(data.Address)
+	contact.Postal = models.Encrypted(data.Postal)
+	contact.Relation = data.Relation
+	contact.Priotrity = *data.Priority
 
 	contact.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 	contact.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 	contact.ID = primitive.NewObjectID()
 
-	_, insertErr := ContactCollection.InsertOne(ctx, contact)
-	if insertErr != nil {
-		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "could not insert contact",
-			"error":   insertErr,
-		})
-	}
-
-	update := bson.M{
-		"$push": bson.M{
-			"contacts": contact.ID,
+	// The contact is only kept if it could be added to the student
+	repos := Repos(c)
+	insertErr := repos.Atomic(ctx,
+		insertStep(repos.Contacts, contact.ID.Hex(), func(ctx context.Context) error { return repos.Contacts.Insert(ctx, contact) }),
+		repository.Step{
+			Do: func(ctx context.Context) error {
+				return AuditedUpdate(c, ctx, repos.Students, data.UID, bson.M{
+					"$push": bson.M{
+						"personal.contacts": contact.ID.Hex(),
+					},
+				})
+			},
+			Undo: func(ctx context.Context) error {
+				return repos.Students.Update(ctx, data.UID, bson.M{"$pull": bson.M{"personal.contacts": contact.ID.Hex()}})
+			},
 		},
-	}
-	_, updateErr := StudentCollection.UpdateOne(
-		ctx,
-		bson.M{"sid": data["uid"]},
-		update,
+		auditInsertStep(c, repos.Contacts, contact.ID.Hex()),
 	)
-	if updateErr != nil {
-		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "the student could not be updated",
-			"error":   updateErr,
-		})
+	if insertErr == repository.ErrNotFound {
+		return contact, NotFound("student")
 	}
-	defer cancel()
-
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"success": true,
-		"message": "successfully inserted contact to student",
-	})
+	if insertErr != nil {
+		return contact, InternalError("could not insert contact", insertErr)
+	}
+	return contact, nil
 }
 
 func DeleteContact(c *fiber.Ctx) error {
-	var data map[string]string
+	var data ContactIDRequest
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 
-	if err := c.BodyParser(&data); err != nil {
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to parse body",
-			"error":   err,
-		})
+		return InvalidRequest(fieldErrs)
 	}
 
 	// Ensure Authenticated admin sent request
 	if verified, _ := AuthenticateUser(c, 3); !verified {
 		cancel()
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"success": false,
-			"message": "Unauthorized: only an admin can perform this action",
-		})
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
 	}
 
-	// Check required fields are included
-	if data["_id"] == "" {
-		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "missing required fields",
-		})
-	}
-
-	_, err := ContactCollection.DeleteOne(ctx, bson.M{"_id": data["_id"]})
+	err := AuditedDelete(c, ctx, Repos(c).Contacts, data.ID)
 	if err != nil {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to delete object",
-			"error":   err,
-		})
+		return InternalError("Failed to delete object", err)
 	}
 	defer cancel()
 
@@ -1239,175 +1315,13 @@ func DeleteContact(c *fiber.Ctx) error {
 }
 
 func RemoveStudent(c *fiber.Ctx) error {
-	var data map[string]string
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-
-	if err := c.BodyParser(&data); err != nil {
-		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to parse body",
-			"error":   err,
-		})
-	}
-
-	// Ensure Authenticated admin sent request
-	if verified, _ := AuthenticateUser(c, 3); !verified {
-		cancel()
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"success": false,
-			"message": "Unauthorized: only an admin can perform this action",
-		})
-	}
-
-	// Check student id is included
-	if data["uid"] == "" {
-		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "missing required fields",
-		})
-	}
-
-	_, deleteErr := IdCollection.DeleteOne(ctx, bson.M{"cid": data["uid"]})
-	if deleteErr != nil {
-		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "the identification number could not be deleted",
-			"error":   deleteErr,
-		})
-	}
-
-	_, deleteErr = StudentCollection.DeleteOne(ctx, bson.M{"school.sid": data["uid"]})
-	if deleteErr != nil {
-		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "the student could not be deleted",
-			"error":   deleteErr,
-		})
-	}
-	defer cancel()
-
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"success": true,
-		"message": "successfully deleted student",
-	})
+	return removeUser(c, 1)
 }
 
 func RemoveTeacher(c *fiber.Ctx) error {
-	var data map[string]string
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-
-	if err := c.BodyParser(&data); err != nil {
-		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to parse body",
-			"error":   err,
-		})
-	}
-
-	// Ensure Authenticated admin sent request
-	if verified, _ := AuthenticateUser(c, 3); !verified {
-		cancel()
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"success": false,
-			"message": "Unauthorized: only an admin can perform this action",
-		})
-	}
-
-	// Check student id is included
-	if data["uid"] == "" {
-		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "missing required fields",
-		})
-	}
-
-	_, deleteErr := IdCollection.DeleteOne(ctx, bson.M{"cid": data["uid"]})
-	if deleteErr != nil {
-		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "the identification number could not be deleted",
-			"error":   deleteErr,
-		})
-	}
-
-	_, deleteErr = TeacherCollection.DeleteOne(ctx, bson.M{"school.tid": data["uid"]})
-	if deleteErr != nil {
-		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "the teacher could not be deleted",
-			"error":   deleteErr,
-		})
-	}
-	defer cancel()
-
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"success": true,
-		"message": "successfully deleted teacher",
-	})
+	return removeUser(c, 2)
 }
 
 func RemoveAdmin(c *fiber.Ctx) error {
-	var data map[string]string
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-
-	if err := c.BodyParser(&data); err != nil {
-		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to parse body",
-			"error":   err,
-		})
-	}
-
-	// Ensure Authenticated admin sent request
-	if verified, _ := AuthenticateUser(c, 3); !verified {
-		cancel()
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"success": false,
-			"message": "Unauthorized: only an admin can perform this action",
-		})
-	}
-
-	// Check student id is included
-	if data["uid"] == "" {
-		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "missing required fields",
-		})
-	}
-
-	_, deleteErr := IdCollection.DeleteOne(ctx, bson.M{"cid": data["uid"]})
-	if deleteErr != nil {
-		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "the identification number could not be deleted",
-			"error":   deleteErr,
-		})
-	}
-
-	_, deleteErr = AdminCollection.DeleteOne(ctx, bson.M{"aid": data["uid"]})
-	if deleteErr != nil {
-		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "the admin could not be deleted",
-			"error":   deleteErr,
-		})
-	}
-	defer cancel()
-
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"success": true,
-		"message": "successfully deleted admin",
-	})
+	return removeUser(c, 3)
 }
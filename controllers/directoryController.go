@@ -0,0 +1,458 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SowinskiBraeden/school-management-api/models"
+	"github.com/SowinskiBraeden/school-management-api/repository"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+	The directory controller keeps teacher and admin accounts in
+	sync with the district's LDAP directory. Each sync searches
+	LDAP_BASE_DN with the teacher and admin filters, then:
+		- creates accounts for new entries
+		- updates names and school emails that changed
+		- disables synced accounts whose entry was removed
+
+	An existing account is linked to its entry by school email
+	the first time they match. Accounts created here and never
+	linked to an entry are left alone. Every run, including dry
+	runs which change nothing, stores a diff report.
+
+	With LDAP_AUTH=true, synced accounts log in by binding to the
+	directory with their own DN and password.
+*/
+
+type directoryEntry struct {
+	DN        string
+	FirstName string
+	LastName  string
+	Email     string
+}
+
+func DirectoryEnabled() bool {
+	return os.Getenv("LDAP_URL") != "" && os.Getenv("LDAP_BASE_DN") != ""
+}
+
+func DirectoryAuthEnabled() bool {
+	return DirectoryEnabled() && os.Getenv("LDAP_AUTH") == "true"
+}
+
+func envDefault(key string, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// dialDirectory connects and binds with the sync's service account
+func dialDirectory() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(os.Getenv("LDAP_URL"))
+	if err != nil {
+		return nil, err
+	}
+	if os.Getenv("LDAP_BIND_DN") != "" {
+		if err := conn.Bind(os.Getenv("LDAP_BIND_DN"), os.Getenv("LDAP_BIND_PASSWORD")); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func searchDirectory(conn *ldap.Conn, filter string) ([]directoryEntry, error) {
+	emailAttribute := envDefault("LDAP_EMAIL_ATTRIBUTE", "mail")
+	request := ldap.NewSearchRequest(
+		os.Getenv("LDAP_BASE_DN"),
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		filter,
+		[]string{"givenName", "sn", emailAttribute},
+		nil,
+	)
+	result, err := conn.SearchWithPaging(request, 500)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []directoryEntry
+	for _, entry := range result.Entries {
+		entries = append(entries, directoryEntry{
+			DN:        entry.DN,
+			FirstName: entry.GetAttributeValue("givenName"),
+			LastName:  entry.GetAttributeValue("sn"),
+			Email:     strings.ToLower(entry.GetAttributeValue(emailAttribute)),
+		})
+	}
+	return entries, nil
+}
+
+// DirectoryBind checks a password by binding to the directory as the account's entry
+func DirectoryBind(dn string, password string) bool {
+	// An empty password would be an unauthenticated bind, which always succeeds
+	if dn == "" || password == "" {
+		return false
+	}
+	conn, err := ldap.DialURL(os.Getenv("LDAP_URL"))
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	return conn.Bind(dn, password) == nil
+}
+
+func sameDN(a string, b string) bool {
+	return strings.EqualFold(a, b)
+}
+
+// syncedAccount is the part of a teacher or admin the sync reads
+type syncedAccount struct {
+	UID         string
+	FirstName   string
+	LastName    string
+	SchoolEmail string
+	DirectoryDN string
+	Disabled    bool
+}
+
+// syncRole diffs the accounts of a role against its directory entries and applies the changes unless dryRun
+func syncRole(ctx context.Context, repos *repository.Repositories, report *models.DirectorySync, userType int, accounts []syncedAccount, entries []directoryEntry, dryRun bool) {
+	store := repos.Account(userType)
+	prefix := "account."
+	if userType == 3 {
+		prefix = ""
+	}
+
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.Email == "" {
+			report.Errors = append(report.Errors, entry.DN+" has no email")
+			continue
+		}
+
+		var account *syncedAccount
+		for i := range accounts {
+			if sameDN(accounts[i].DirectoryDN, entry.DN) {
+				account = &accounts[i]
+				break
+			}
+		}
+		if account == nil {
+			for i := range accounts {
+				if accounts[i].DirectoryDN == "" && accounts[i].SchoolEmail == entry.Email {
+					account = &accounts[i]
+					break
+				}
+			}
+		}
+
+		change := models.DirectoryChange{UserType: userType, DN: entry.DN, Email: entry.Email, Fields: []string{}}
+
+		if account == nil {
+			if !dryRun {
+				uid, err := createDirectoryAccount(ctx, repos, userType, entry)
+				if err != nil {
+					report.Errors = append(report.Errors, entry.DN+": "+err.Error())
+					continue
+				}
+				change.UID = uid
+			}
+			report.Created = append(report.Created, change)
+			continue
+		}
+		seen[account.UID] = true
+
+		firstnameField, lastnameField := "personal.firstname", "personal.lastname"
+		if userType == 3 {
+			firstnameField, lastnameField = "firstname", "lastname"
+		}
+
+		set := bson.M{}
+		if account.FirstName != entry.FirstName && entry.FirstName != "" {
+			set[firstnameField] = entry.FirstName
+		}
+		if account.LastName != entry.LastName && entry.LastName != "" {
+			set[lastnameField] = entry.LastName
+		}
+		if account.SchoolEmail != entry.Email {
+			set[prefix+"schoolemail"] = entry.Email
+		}
+		if account.DirectoryDN != entry.DN {
+			set[prefix+"directorydn"] = entry.DN
+		}
+		if len(set) == 0 {
+			continue
+		}
+
+		for field := range set {
+			change.Fields = append(change.Fields, field)
+		}
+		sort.Strings(change.Fields)
+		change.UID = account.UID
+
+		if !dryRun {
+			set["updated_at"], _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+			if err := AuditedUpdate(nil, ctx, store, account.UID, bson.M{"$set": set}); err != nil {
+				report.Errors = append(report.Errors, entry.DN+": "+err.Error())
+				continue
+			}
+		}
+		report.Updated = append(report.Updated, change)
+	}
+
+	// A filter that matches nothing is far more likely a mistake than every account leaving
+	if len(entries) == 0 {
+		report.Errors = append(report.Errors, fmt.Sprintf("the directory returned no entries for user type %d, no accounts were disabled", userType))
+		return
+	}
+
+	for _, account := range accounts {
+		if account.DirectoryDN == "" || account.Disabled || seen[account.UID] {
+			continue
+		}
+
+		if !dryRun {
+			update_time, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+			update := bson.M{"$set": bson.M{prefix + "accountdisabled": true, "updated_at": update_time}}
+			if err := AuditedUpdate(nil, ctx, store, account.UID, update); err != nil {
+				report.Errors = append(report.Errors, account.DirectoryDN+": "+err.Error())
+				continue
+			}
+		}
+		report.Disabled = append(report.Disabled, models.DirectoryChange{
+			UserType: userType,
+			UID:      account.UID,
+			DN:       account.DirectoryDN,
+			Email:    account.SchoolEmail,
+			Fields:   []string{prefix + "accountdisabled"},
+		})
+	}
+}
+
+// createDirectoryAccount inserts a teacher or admin for a new entry and emails them their ID
+func createDirectoryAccount(ctx context.Context, repos *repository.Repositories, userType int, entry directoryEntry) (string, error) {
+	var uid string
+	var err error
+	now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+	if userType == 3 {
+		var admin models.Admin
+		admin.ID = primitive.NewObjectID()
+		admin.FirstName = entry.FirstName
+		admin.LastName = entry.LastName
+		admin.Email = entry.Email
+		admin.SchoolEmail = entry.Email
+		admin.DirectoryDN = entry.DN
+		admin.Password, err = admin.HashPassword(admin.GeneratePassword(12, 1, 1, 1))
+		if err != nil {
+			return "", err
+		}
+		admin.TempPassword = true
+		admin.HashHistory = []string{}
+		admin.Created_at = now
+		admin.Updated_at = now
+
+		uid, err = AllocateID(ctx, repos, GenerateUID, func(aid string) error {
+			admin.AID = aid
+			return repos.Atomic(ctx,
+				insertStep(repos.Admins, aid, func(ctx context.Context) error { return repos.Admins.Insert(ctx, admin) }),
+				auditInsertStep(nil, repos.Admins, aid),
+			)
+		})
+		if err != nil {
+			return "", err
+		}
+	} else {
+		var teacher models.Teacher
+		teacher.ID = primitive.NewObjectID()
+		teacher.Personal.FirstName = entry.FirstName
+		teacher.Personal.LastName = entry.LastName
+		teacher.Personal.Email = entry.Email
+		teacher.Account.SchoolEmail = entry.Email
+		teacher.Account.VerifiedEmail = true // the directory owns the address
+		teacher.Account.DirectoryDN = entry.DN
+		teacher.Account.Password, err = teacher.HashPassword(teacher.GeneratePassword(12, 1, 1, 1))
+		if err != nil {
+			return "", err
+		}
+		teacher.Account.TempPassword = true
+		teacher.Account.HashHistory = []string{}
+		teacher.Created_at = now
+		teacher.Updated_at = now
+
+		var photo models.Photo
+		photo.Name = uuid.New().String()
+		photo.Created_at = now
+		photo.Updated_at = now
+		photo.ID = primitive.NewObjectID()
+		defaultImage, _ := os.ReadFile("./database/defaultImage.txt")
+		photo.Base64 = string(defaultImage)
+		teacher.School.PhotoName = photo.Name
+
+		uid, err = AllocateID(ctx, repos, GenerateUID, func(tid string) error {
+			teacher.School.TID = tid
+			return repos.Atomic(ctx,
+				insertStep(repos.Teachers, tid, func(ctx context.Context) error { return repos.Teachers.Insert(ctx, teacher) }),
+				insertStep(repos.Photos, photo.Name, func(ctx context.Context) error { return repos.Photos.Insert(ctx, photo) }),
+				auditInsertStep(nil, repos.Teachers, tid),
+				auditInsertStep(nil, repos.Photos, photo.Name),
+			)
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	userTypeName := map[int]string{2: "teacher", 3: "admin"}[userType]
+	r := NewRequest([]string{entry.Email}, "Account Registered")
+	r.Send("./templates/accountRegistered.html", map[string]string{"username": entry.FirstName, "id": uid, "userType": userTypeName})
+
+	return uid, nil
+}
+
+// SyncDirectory runs one sync of teachers and admins against the directory
+func SyncDirectory(repos *repository.Repositories, dryRun bool) (models.DirectorySync, error) {
+	ctx, cancel := context.WithTimeout(SystemContext(context.Background(), repos), 5*time.Minute)
+	defer cancel()
+
+	var report models.DirectorySync
+	report.ID = primitive.NewObjectID()
+	report.DryRun = dryRun
+	report.Created = []models.DirectoryChange{}
+	report.Updated = []models.DirectoryChange{}
+	report.Disabled = []models.DirectoryChange{}
+	report.Errors = []string{}
+	report.Started_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+	if !DirectoryEnabled() {
+		return report, errors.New("LDAP_URL and LDAP_BASE_DN must be set")
+	}
+
+	conn, err := dialDirectory()
+	if err != nil {
+		return report, err
+	}
+	defer conn.Close()
+
+	if filter := os.Getenv("LDAP_TEACHER_FILTER"); filter != "" {
+		entries, err := searchDirectory(conn, filter)
+		if err != nil {
+			return report, err
+		}
+
+		teachers, err := repos.Teachers.Find(ctx, repository.AccountFilter{State: repository.AllAccounts})
+		if err != nil {
+			return report, err
+		}
+
+		var accounts []syncedAccount
+		for _, t := range teachers {
+			accounts = append(accounts, syncedAccount{t.School.TID, t.Personal.FirstName, t.Personal.LastName, t.Account.SchoolEmail, t.Account.DirectoryDN, t.Account.AccountDisabled})
+		}
+		syncRole(ctx, repos, &report, 2, accounts, entries, dryRun)
+	}
+
+	if filter := os.Getenv("LDAP_ADMIN_FILTER"); filter != "" {
+		entries, err := searchDirectory(conn, filter)
+		if err != nil {
+			return report, err
+		}
+
+		admins, err := repos.Admins.Find(ctx, repository.AccountFilter{State: repository.AllAccounts})
+		if err != nil {
+			return report, err
+		}
+
+		var accounts []syncedAccount
+		for _, a := range admins {
+			accounts = append(accounts, syncedAccount{a.AID, a.FirstName, a.LastName, a.SchoolEmail, a.DirectoryDN, a.AccountDisabled})
+		}
+		syncRole(ctx, repos, &report, 3, accounts, entries, dryRun)
+	}
+
+	report.Finished_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	if insertErr := repos.DirectorySyncs.Insert(ctx, report); insertErr != nil {
+		return report, insertErr
+	}
+
+	return report, nil
+}
+
+// StartDirectorySync runs the sync every LDAP_SYNC_HOURS, if set
+func StartDirectorySync(repos *repository.Repositories) {
+	hours, _ := strconv.Atoi(os.Getenv("LDAP_SYNC_HOURS"))
+	if !DirectoryEnabled() || hours <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(hours) * time.Hour)
+		for range ticker.C {
+			report, err := SyncDirectory(repos, false)
+			if err != nil {
+				log.Printf("Directory sync failed: %v", err)
+				continue
+			}
+			log.Printf("Directory sync: %d created, %d updated, %d disabled", len(report.Created), len(report.Updated), len(report.Disabled))
+		}
+	}()
+}
+
+func RunDirectorySync(c *fiber.Ctx) error {
+	var data DryRunRequest
+
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
+		return InvalidRequest(fieldErrs)
+	}
+
+	// Ensure Authenticated admin sent request
+	if verified, _ := AuthenticateUser(c, 3); !verified {
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
+	}
+
+	report, err := SyncDirectory(Repos(c), data.DryRun)
+	if err != nil {
+		return InternalError("the directory sync failed", err).WithResult(report)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "successfully synced directory",
+		"result":  report,
+	})
+}
+
+func DirectorySyncs(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Ensure Authenticated admin sent request
+	if verified, _ := AuthenticateUser(c, 3); !verified {
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
+	}
+
+	reports := []models.DirectorySync{}
+	if err := Repos(c).DirectorySyncs.Find(ctx, bson.M{}, bson.D{{Key: "started_at", Value: -1}}, 50, &reports); err != nil {
+		return InternalError("failed to find directory syncs", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "successfully retrieved directory syncs",
+		"result":  reports,
+	})
+}
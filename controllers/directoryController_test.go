@@ -0,0 +1,320 @@
+package controllers
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SowinskiBraeden/school-management-api/models"
+	"github.com/SowinskiBraeden/school-management-api/repository"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+	The directory sync tests run against a directory served on a
+	local port. Like cmd/mockldap it answers binds and searches,
+	but keeps its entries in memory so a test can change them
+	between syncs, and only understands the equality, presence
+	and and filters the sync is configured with here.
+*/
+
+const directoryTestBase = "ou=staff,dc=school,dc=ca"
+
+type directoryTestEntry struct {
+	dn         string
+	attributes map[string]string // keys are lower case
+}
+
+type directoryTestServer struct {
+	lock    sync.Mutex
+	entries []directoryTestEntry
+}
+
+func staffEntry(uid string, firstName string, lastName string, employeeType string) directoryTestEntry {
+	return directoryTestEntry{"uid=" + uid + "," + directoryTestBase, map[string]string{
+		"givenname":    firstName,
+		"sn":           lastName,
+		"mail":         strings.ToLower(firstName[:1] + "." + lastName + "@school.ca"),
+		"employeetype": employeeType,
+	}}
+}
+
+func (d *directoryTestServer) setEntries(entries ...directoryTestEntry) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.entries = entries
+}
+
+func (d *directoryTestServer) matches(filter *ber.Packet, entry directoryTestEntry) bool {
+	switch filter.Tag {
+	case ldap.FilterAnd:
+		for _, child := range filter.Children {
+			if !d.matches(child, entry) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterEqualityMatch:
+		value, ok := entry.attributes[strings.ToLower(packetValue(filter.Children[0]))]
+		return ok && strings.EqualFold(value, packetValue(filter.Children[1]))
+	case ldap.FilterPresent:
+		_, ok := entry.attributes[strings.ToLower(packetValue(filter))]
+		return ok
+	}
+	return false
+}
+
+func packetValue(p *ber.Packet) string {
+	if value, ok := p.Value.(string); ok {
+		return value
+	}
+	return p.Data.String()
+}
+
+// ldapMessage wraps a finished response, a packet's children must be complete before it is appended
+func ldapMessage(messageID int64, response *ber.Packet) []byte {
+	envelope := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "Message ID"))
+	envelope.AppendChild(response)
+	return envelope.Bytes()
+}
+
+func ldapResult(application ber.Tag, code int) *ber.Packet {
+	response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, application, nil, "Response")
+	response.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "Result Code"))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+	return response
+}
+
+func (d *directoryTestServer) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		request, err := ber.ReadPacket(conn)
+		if err != nil || len(request.Children) < 2 {
+			return
+		}
+		messageID, _ := request.Children[0].Value.(int64)
+		op := request.Children[1]
+
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			code := ldap.LDAPResultInvalidCredentials
+			if packetValue(op.Children[1]) == "cn=sync,dc=school,dc=ca" && packetValue(op.Children[2]) == "sync" {
+				code = ldap.LDAPResultSuccess
+			}
+			conn.Write(ldapMessage(messageID, ldapResult(ldap.ApplicationBindResponse, int(code))))
+
+		case ldap.ApplicationSearchRequest:
+			d.lock.Lock()
+			for _, entry := range d.entries {
+				if !d.matches(op.Children[6], entry) {
+					continue
+				}
+				found := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+				found.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.dn, "DN"))
+				list := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+				for _, requested := range op.Children[7].Children {
+					name := packetValue(requested)
+					value, ok := entry.attributes[strings.ToLower(name)]
+					if !ok {
+						continue
+					}
+					attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+					attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+					values := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+					values.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "Value"))
+					attribute.AppendChild(values)
+					list.AppendChild(attribute)
+				}
+				found.AppendChild(list)
+				conn.Write(ldapMessage(messageID, found))
+			}
+			d.lock.Unlock()
+			conn.Write(ldapMessage(messageID, ldapResult(ldap.ApplicationSearchResultDone, int(ldap.LDAPResultSuccess))))
+
+		case ldap.ApplicationUnbindRequest:
+			return
+		}
+	}
+}
+
+// newDirectoryTestServer serves a directory for the sync, and runs the test from the root where the sync finds its templates
+func newDirectoryTestServer(t *testing.T) *directoryTestServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	d := &directoryTestServer{}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go d.serve(conn)
+		}
+	}()
+
+	t.Setenv("LDAP_URL", "ldap://"+listener.Addr().String())
+	t.Setenv("LDAP_BASE_DN", directoryTestBase)
+	t.Setenv("LDAP_BIND_DN", "cn=sync,dc=school,dc=ca")
+	t.Setenv("LDAP_BIND_PASSWORD", "sync")
+	t.Setenv("LDAP_TEACHER_FILTER", "(employeeType=teacher)")
+	t.Setenv("LDAP_ADMIN_FILTER", "(&(employeeType=admin)(mail=*))")
+
+	// New accounts are emailed their ID, a port nothing listens on refuses the email at once
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+	mailConfig := config
+	config = Config{Server: "127.0.0.1", Port: closed.Addr().(*net.TCPAddr).Port}
+	t.Cleanup(func() { config = mailConfig })
+
+	dir, _ := os.Getwd()
+	if err := os.Chdir(".."); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(dir) })
+	return d
+}
+
+func directoryTeacher(t *testing.T, ctx context.Context, repos *repository.Repositories, tid string, firstName string, lastName string, schoolEmail string, dn string) {
+	var teacher models.Teacher
+	teacher.ID = primitive.NewObjectID()
+	teacher.School.TID = tid
+	teacher.Personal.FirstName = firstName
+	teacher.Personal.LastName = lastName
+	teacher.Account.SchoolEmail = schoolEmail
+	teacher.Account.DirectoryDN = dn
+	if err := repos.Teachers.Insert(ctx, teacher); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// syncChanges lists the changes of a report as "dn fields" for comparing
+func syncChanges(changes []models.DirectoryChange) []string {
+	listed := []string{}
+	for _, change := range changes {
+		listed = append(listed, strings.TrimSpace(change.DN+" "+strings.Join(change.Fields, ",")))
+	}
+	sort.Strings(listed)
+	return listed
+}
+
+func checkChanges(t *testing.T, kind string, got []models.DirectoryChange, want ...string) {
+	t.Helper()
+	if want == nil {
+		want = []string{}
+	}
+	if listed := syncChanges(got); strings.Join(listed, "; ") != strings.Join(want, "; ") {
+		t.Errorf("%s: got %q, want %q", kind, listed, want)
+	}
+}
+
+func TestSyncDirectory(t *testing.T) {
+	directory := newDirectoryTestServer(t)
+	repos := repository.NewMemory()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	jdoe := staffEntry("jdoe", "Jane", "Doe", "teacher")
+	rsmith := staffEntry("rsmith", "Robert", "Smith", "teacher")
+	akhan := staffEntry("akhan", "Amira", "Khan", "admin")
+	directory.setEntries(jdoe, rsmith, akhan)
+
+	// Jane's account was made here before the sync and is linked by her email, under her old name
+	directoryTeacher(t, ctx, repos, "1234566", "Jane", "Dow", "j.doe@school.ca", "")
+	// Tom left the district, his entry was removed
+	directoryTeacher(t, ctx, repos, "9876541", "Tom", "Lee", "t.lee@school.ca", "uid=tlee,"+directoryTestBase)
+	// Sam's account was never in the directory, the sync leaves it alone
+	directoryTeacher(t, ctx, repos, "1111117", "Sam", "Roe", "s.roe@school.ca", "")
+
+	report, err := SyncDirectory(repos, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Errors) > 0 {
+		t.Fatalf("dry run: %v", report.Errors)
+	}
+	checkChanges(t, "dry run created", report.Created, akhan.dn, rsmith.dn)
+	checkChanges(t, "dry run updated", report.Updated, jdoe.dn+" account.directorydn,personal.lastname")
+	checkChanges(t, "dry run disabled", report.Disabled, "uid=tlee,"+directoryTestBase+" account.accountdisabled")
+
+	if count, _ := repos.Teachers.Count(ctx, repository.AccountFilter{State: repository.AllAccounts}); count != 3 {
+		t.Errorf("the dry run left %d teachers, want 3", count)
+	}
+	if jane, _ := repos.Teachers.Get(ctx, "1234566"); jane.Personal.LastName != "Dow" || jane.Account.DirectoryDN != "" {
+		t.Errorf("the dry run changed %s %s", jane.Personal.LastName, jane.Account.DirectoryDN)
+	}
+	if tom, _ := repos.Teachers.Get(ctx, "9876541"); tom.Account.AccountDisabled {
+		t.Errorf("the dry run disabled an account")
+	}
+
+	report, err = SyncDirectory(repos, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Errors) > 0 {
+		t.Fatalf("sync: %v", report.Errors)
+	}
+	checkChanges(t, "created", report.Created, akhan.dn, rsmith.dn)
+	checkChanges(t, "updated", report.Updated, jdoe.dn+" account.directorydn,personal.lastname")
+	checkChanges(t, "disabled", report.Disabled, "uid=tlee,"+directoryTestBase+" account.accountdisabled")
+
+	robert, err := repos.Teachers.FindBySchoolEmail(ctx, "r.smith@school.ca")
+	if err != nil || robert.Account.DirectoryDN != rsmith.dn || robert.Personal.FirstName != "Robert" {
+		t.Errorf("Robert's account wasn't created from his entry: %+v %v", robert.Account, err)
+	}
+	if amira, err := repos.Admins.FindBySchoolEmail(ctx, "a.khan@school.ca"); err != nil || amira.DirectoryDN != akhan.dn {
+		t.Errorf("Amira's admin account wasn't created from her entry: %v", err)
+	}
+	if jane, _ := repos.Teachers.Get(ctx, "1234566"); jane.Personal.LastName != "Doe" || jane.Account.DirectoryDN != jdoe.dn {
+		t.Errorf("Jane's account is %s %s, want it renamed and linked", jane.Personal.LastName, jane.Account.DirectoryDN)
+	}
+	if tom, _ := repos.Teachers.Get(ctx, "9876541"); !tom.Account.AccountDisabled {
+		t.Errorf("Tom's account wasn't disabled")
+	}
+	if sam, _ := repos.Teachers.Get(ctx, "1111117"); sam.Account.AccountDisabled {
+		t.Errorf("an account that was never synced was disabled")
+	}
+	if count, _ := repos.DirectorySyncs.Count(ctx, nil); count != 2 {
+		t.Errorf("%d reports were stored, want one for each run", count)
+	}
+
+	// Once in sync there is nothing left to do
+	report, err = SyncDirectory(repos, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkChanges(t, "second sync created", report.Created)
+	checkChanges(t, "second sync updated", report.Updated)
+	checkChanges(t, "second sync disabled", report.Disabled)
+
+	// A directory that returns nobody for a role is reported, not taken as everyone leaving
+	directory.setEntries(jdoe, rsmith)
+	report, err = SyncDirectory(repos, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkChanges(t, "empty role disabled", report.Disabled)
+	if len(report.Errors) != 1 {
+		t.Errorf("got errors %q, want one for the admins", report.Errors)
+	}
+}
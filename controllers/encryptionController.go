@@ -0,0 +1,186 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SowinskiBraeden/school-management-api/models"
+	"github.com/SowinskiBraeden/school-management-api/repository"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+	Records are re-encrypted onto the current key in the
+	background. A pass runs when the server starts, which
+	picks up a new current key or encryption being turned on,
+	and can be started by an admin. Each record is only
+	written if it hasn't changed since it was read, so a pass
+	never overwrites an update made while it runs.
+*/
+
+var rotating sync.Mutex
+
+// rotateCollection reseals every stale record of a collection
+func rotateCollection(ctx context.Context, keyring *models.Keyring, records repository.RecordRepository, report *models.KeyRotation) {
+	name := records.Name()
+	fields := models.EncryptedFields[name]
+
+	var stale []bson.Raw
+	if err := records.Find(ctx, keyring.StaleFilter(name), nil, 0, &stale); err != nil {
+		report.Errors = append(report.Errors, name+": "+err.Error())
+		return
+	}
+
+	for _, record := range stale {
+		id := record.Lookup("_id").ObjectID()
+		filter := bson.M{"_id": id}
+		set := bson.M{}
+
+		for _, field := range fields {
+			value, err := record.LookupErr(strings.Split(field.Name, ".")...)
+			if err != nil {
+				continue
+			}
+			resealed, changed, err := keyring.Reseal(field, value)
+			if err != nil {
+				report.Errors = append(report.Errors, name+" "+id.Hex()+" "+field.Name+": "+err.Error())
+				continue
+			}
+			if changed {
+				filter[field.Name] = value
+				set[field.Name] = resealed
+			}
+		}
+		if len(set) == 0 {
+			continue
+		}
+
+		matched, err := records.UpdateOne(ctx, filter, bson.M{"$set": set}, false)
+		if err != nil {
+			report.Errors = append(report.Errors, name+" "+id.Hex()+": "+err.Error())
+			continue
+		}
+		report.Resealed[name] += int(matched)
+	}
+}
+
+// RotateEncryption re-encrypts every record in plain text or sealed with an old key
+func RotateEncryption(repos *repository.Repositories) (models.KeyRotation, error) {
+	var report models.KeyRotation
+	report.ID = primitive.NewObjectID()
+	report.Resealed = map[string]int{}
+	report.Errors = []string{}
+	report.Started_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+	keyring, err := models.Encryption()
+	if err != nil {
+		return report, err
+	}
+	if keyring == nil {
+		return report, errors.New("encryption is not configured, set ENCRYPTION_KEY_FILE or ENCRYPTION_PROVIDER")
+	}
+	if !rotating.TryLock() {
+		return report, errors.New("a key rotation is already running")
+	}
+	defer rotating.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Hour)
+	defer cancel()
+
+	report.KeyID = keyring.CurrentKeyID()
+	// Sealed values are rewritten in place, so this works on the stored documents rather than the models
+	rotateCollection(ctx, keyring, repos.Records("students"), &report)
+	rotateCollection(ctx, keyring, repos.Records("contacts"), &report)
+
+	report.Finished_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	if insertErr := repos.KeyRotations.Insert(ctx, report); insertErr != nil {
+		return report, insertErr
+	}
+	return report, nil
+}
+
+// StartKeyRotation checks the encryption settings and runs a rotation pass in the background
+func StartKeyRotation(repos *repository.Repositories) {
+	keyring, err := models.Encryption()
+	if err != nil {
+		log.Fatalf("Failed to load the encryption keys: %v", err)
+	}
+	if keyring == nil {
+		return
+	}
+
+	go func() {
+		report, err := RotateEncryption(repos)
+		if err != nil {
+			log.Printf("Key rotation failed: %v", err)
+			return
+		}
+		log.Printf("Key rotation onto %s: %d students, %d contacts re-encrypted", report.KeyID, report.Resealed["students"], report.Resealed["contacts"])
+	}()
+}
+
+func RunKeyRotation(c *fiber.Ctx) error {
+	// Ensure Authenticated admin sent request
+	if verified, _ := AuthenticateUser(c, 3); !verified {
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
+	}
+
+	if keyring, err := models.Encryption(); keyring == nil || err != nil {
+		return NewError(fiber.StatusNotImplemented, CodeNotConfigured, "encryption is not configured")
+	}
+
+	// A pass over every record can take a while, its report is saved when it finishes.
+	// The request's context is reused once the handler returns, so nothing is read from it after
+	repos := Repos(c)
+	go func() {
+		if _, err := RotateEncryption(repos); err != nil {
+			log.Printf("Key rotation failed: %v", err)
+		}
+	}()
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"success": true,
+		"message": "started key rotation",
+	})
+}
+
+func KeyRotations(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Ensure Authenticated admin sent request
+	if verified, _ := AuthenticateUser(c, 3); !verified {
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
+	}
+
+	keyring, _ := models.Encryption()
+	if keyring == nil {
+		return NewError(fiber.StatusNotImplemented, CodeNotConfigured, "encryption is not configured")
+	}
+
+	// Records still waiting to be re-encrypted
+	stale := map[string]int64{}
+	for collectionName := range models.EncryptedFields {
+		stale[collectionName], _ = Repos(c).Records(collectionName).Count(ctx, keyring.StaleFilter(collectionName))
+	}
+
+	reports := []models.KeyRotation{}
+	if err := Repos(c).KeyRotations.Find(ctx, bson.M{}, bson.D{{Key: "started_at", Value: -1}}, 50, &reports); err != nil {
+		return InternalError("failed to find key rotations", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "successfully retrieved key rotations",
+		"keyid":   keyring.CurrentKeyID(),
+		"stale":   stale,
+		"result":  reports,
+	})
+}
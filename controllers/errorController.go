@@ -0,0 +1,186 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/SowinskiBraeden/school-management-api/models"
+	"github.com/SowinskiBraeden/school-management-api/repository"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+/*
+	Handlers return an APIError instead of writing a failure
+	themselves, and ErrorHandler renders every one the same way:
+	the HTTP status, a code the front end can branch on, which
+	never changes once given, a message for people to read and
+	the ID of the request. The error that caused a failure is
+	logged with the request ID and never sent, so database and
+	driver errors can't leak to clients. Errors that aren't an
+	APIError, from Fiber or a handler, are rendered too.
+*/
+
+// Codes for failures that aren't a record not being found, those are the record's name and _NOT_FOUND, like STUDENT_NOT_FOUND
+const (
+	CodeNotFound              = "NOT_FOUND"
+	CodeInvalidRequest        = "INVALID_REQUEST"
+	CodeInvalidUID            = "INVALID_UID"
+	CodeUnauthorized          = "UNAUTHORIZED"
+	CodeForbidden             = "FORBIDDEN"
+	CodeIncorrectPassword     = "INCORRECT_PASSWORD"
+	CodeIncorrectEmail        = "INCORRECT_EMAIL"
+	CodeEmailNotVerified      = "EMAIL_NOT_VERIFIED"
+	CodeAccountDisabled       = "ACCOUNT_DISABLED"
+	CodeAccountLocked         = "ACCOUNT_LOCKED"
+	CodeTooManyAttempts       = "TOO_MANY_ATTEMPTS"
+	CodePasswordPolicy        = "PASSWORD_POLICY"
+	CodePasswordMismatch      = "PASSWORD_MISMATCH"
+	CodePasswordLoginDisabled = "PASSWORD_LOGIN_DISABLED"
+	CodeLoginExpired          = "LOGIN_EXPIRED"
+	CodeLoginRefused          = "LOGIN_REFUSED"
+	CodeSessionEnded          = "SESSION_ENDED"
+	CodeReadOnlySession       = "READ_ONLY_SESSION"
+	CodeNotImpersonating      = "NOT_IMPERSONATING"
+	CodeCannotRemoveSelf      = "CANNOT_REMOVE_SELF"
+	CodePENTaken              = "PEN_TAKEN"
+	CodeLockerTaken           = "LOCKER_TAKEN"
+	CodeVerificationInvalid   = "VERIFICATION_INVALID"
+	CodeNotConfigured         = "NOT_CONFIGURED"
+	CodeIdentityProvider      = "IDENTITY_PROVIDER_UNAVAILABLE"
+	CodeEmailNotSent          = "EMAIL_NOT_SENT"
+	CodeInternal              = "INTERNAL_ERROR"
+)
+
+// APIError is a failure returned by a handler, rendered by ErrorHandler
+type APIError struct {
+	Status  int
+	Code    string
+	Message string
+	Fields  []FieldError // the fields of the body that are wrong
+	Result  interface{}  // what was done before the failure, for runs that stop part way
+	cause   error
+}
+
+func (e *APIError) Error() string {
+	if e.cause != nil {
+		return e.Message + ": " + e.cause.Error()
+	}
+	return e.Message
+}
+
+func (e *APIError) Unwrap() error {
+	return e.cause
+}
+
+// Because records the error that caused the failure, it is logged and not sent
+func (e *APIError) Because(err error) *APIError {
+	e.cause = err
+	return e
+}
+
+// WithResult sends result with the failure
+func (e *APIError) WithResult(result interface{}) *APIError {
+	e.Result = result
+	return e
+}
+
+func NewError(status int, code string, message string) *APIError {
+	return &APIError{Status: status, Code: code, Message: message}
+}
+
+// InvalidRequest is the failure for a body with fields that are wrong
+func InvalidRequest(fieldErrs []FieldError) *APIError {
+	return &APIError{Status: fiber.StatusBadRequest, Code: CodeInvalidRequest, Message: "the request is invalid", Fields: fieldErrs}
+}
+
+// NotFound is the failure for a record that doesn't exist, record is its name, like student or legal hold
+func NotFound(record string) *APIError {
+	return NewError(fiber.StatusNotFound, notFoundCode(record), record+" not found")
+}
+
+func notFoundCode(record string) string {
+	return strings.ToUpper(strings.ReplaceAll(record, " ", "_")) + "_NOT_FOUND"
+}
+
+// FindError is the failure for a record that couldn't be found, NotFound when it doesn't exist
+func FindError(record string, err error) *APIError {
+	if errors.Is(err, repository.ErrNotFound) || errors.Is(err, mongo.ErrNoDocuments) {
+		return NotFound(record).Because(err)
+	}
+	return InternalError("the "+record+" could not be found", err)
+}
+
+// UpdateError is the failure for a record that couldn't be updated, NotFound when it doesn't exist
+func UpdateError(record string, err error) *APIError {
+	if errors.Is(err, repository.ErrNotFound) {
+		return NotFound(record).Because(err)
+	}
+	return InternalError("the "+record+" could not be updated", err)
+}
+
+// InternalError is the failure for something that went wrong on the server, err is logged
+func InternalError(message string, err error) *APIError {
+	return NewError(fiber.StatusInternalServerError, CodeInternal, message).Because(err)
+}
+
+// PasswordPolicyError is the failure for a password that breaks the policy, field is the one that holds it
+func PasswordPolicyError(message string, field string, policyErrs []models.PolicyError) *APIError {
+	apiErr := NewError(fiber.StatusBadRequest, CodePasswordPolicy, message)
+	for _, policyErr := range policyErrs {
+		apiErr.Fields = append(apiErr.Fields, FieldError{Field: field, Rule: policyErr.Rule, Message: policyErr.Message})
+	}
+	return apiErr
+}
+
+// RequestID returns the ID given to the request, it is also sent back in the X-Request-ID header
+func RequestID(c *fiber.Ctx) string {
+	id, _ := c.Locals("requestid").(string)
+	return id
+}
+
+// ErrorHandler renders every failure as JSON with its code and the request ID
+func ErrorHandler(c *fiber.Ctx, err error) error {
+	var apiErr *APIError
+	var fiberErr *fiber.Error
+	switch {
+	case errors.As(err, &apiErr):
+	case errors.As(err, &fiberErr):
+		apiErr = NewError(fiberErr.Code, statusCode(fiberErr.Code), fiberErr.Message)
+	default:
+		apiErr = InternalError("something went wrong", err)
+	}
+
+	// Failures of the client aren't logged, they are answered
+	if apiErr.Status >= fiber.StatusInternalServerError {
+		log.Printf("request %s, %s %s: %v", RequestID(c), c.Method(), c.Path(), apiErr)
+	}
+
+	response := fiber.Map{
+		"success":    false,
+		"code":       apiErr.Code,
+		"message":    apiErr.Message,
+		"request_id": RequestID(c),
+	}
+	if len(apiErr.Fields) > 0 {
+		response["errors"] = apiErr.Fields
+	}
+	if apiErr.Result != nil {
+		response["result"] = apiErr.Result
+	}
+	return c.Status(apiErr.Status).JSON(response)
+}
+
+// statusCode names an HTTP status as a code, 405 is METHOD_NOT_ALLOWED
+func statusCode(status int) string {
+	if status == fiber.StatusBadRequest {
+		return CodeInvalidRequest
+	}
+	if status >= fiber.StatusInternalServerError {
+		return CodeInternal
+	}
+	return strings.ToUpper(strings.ReplaceAll(utils.StatusMessage(status), " ", "_"))
+}
@@ -0,0 +1,263 @@
+package controllers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"html/template"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SowinskiBraeden/school-management-api/models"
+	"github.com/SowinskiBraeden/school-management-api/repository"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+	When a family asks for everything held about a student, an
+	admin logs the request, which must be answered within
+	EXPORT_DEADLINE_DAYS (30 by default). Downloading the export
+	gathers every record linked to the student's SID into a zip
+	of a JSON bundle and an HTML summary, which prints to PDF, and
+	marks the request as completed.
+*/
+
+const defaultExportDeadlineDays = 30
+
+func exportDeadline() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("EXPORT_DEADLINE_DAYS"))
+	if err != nil || days < 1 {
+		days = defaultExportDeadlineDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// BuildStudentExport gathers every record linked to a student
+func BuildStudentExport(ctx context.Context, repos *repository.Repositories, request models.ExportRequest) (models.StudentExport, error) {
+	export := models.StudentExport{
+		Request:        request,
+		Contacts:       []models.Contact{},
+		LoginAttempts:  []models.LoginAttempt{},
+		Verifications:  []models.Verification{},
+		Impersonations: []models.Impersonation{},
+		AuditEntries:   []models.AuditEntry{},
+		AccessEvents:   []models.AccessEvent{},
+	}
+	export.Generated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+	var err error
+	if export.Student, err = repos.Students.Get(ctx, request.SID); err != nil {
+		return export, err
+	}
+	export.Photo, _ = repos.Photos.Get(ctx, export.Student.School.PhotoName)
+
+	// The audit log refers to contacts by their document id
+	targets := bson.A{request.SID}
+	for _, id := range export.Student.Personal.Contacts {
+		if contact, err := repos.Contacts.Get(ctx, id); err == nil {
+			export.Contacts = append(export.Contacts, contact)
+			targets = append(targets, id)
+		}
+	}
+
+	if locker, err := repos.Lockers.Get(ctx, export.Student.School.Locker); err == nil {
+		export.Locker = &locker
+	}
+
+	// Every part is listed oldest first
+	for _, part := range []struct {
+		records repository.RecordRepository
+		filter  bson.M
+		results interface{}
+	}{
+		{repos.LoginAttempts, bson.M{"uid": request.SID, "usertype": 1}, &export.LoginAttempts},
+		{repos.Verifications, bson.M{"uid": request.SID, "usertype": 1}, &export.Verifications},
+		{repos.Impersonations, bson.M{"uid": request.SID, "usertype": 1}, &export.Impersonations},
+		{repos.Audit, bson.M{"target": bson.M{"$in": targets}}, &export.AuditEntries},
+		{repos.Accesses, bson.M{"sid": request.SID}, &export.AccessEvents},
+	} {
+		if err := part.records.Find(ctx, part.filter, bson.D{{Key: "created_at", Value: 1}}, 0, part.results); err != nil {
+			return export, err
+		}
+	}
+
+	return export, nil
+}
+
+var exportFuncs = template.FuncMap{
+	"date": func(t time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return t.Format("2006-01-02 15:04 MST")
+	},
+	"join": strings.Join,
+	"photo": func(base64 string) template.URL {
+		return template.URL("data:image/jpeg;base64," + base64)
+	},
+}
+
+// renderExportSummary renders the human readable summary of an export
+func renderExportSummary(export models.StudentExport) ([]byte, error) {
+	t, err := template.New("exportSummary.html").Funcs(exportFuncs).ParseFiles("./templates/exportSummary.html")
+	if err != nil {
+		return nil, err
+	}
+	buffer := new(bytes.Buffer)
+	if err := t.Execute(buffer, export); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// ExportRequestRequest is the body of CreateExportRequest
+type ExportRequestRequest struct {
+	UID       string `json:"uid" validate:"required,uid"`
+	Requester string `json:"requester" validate:"notblank"`
+	Notes     string `json:"notes"`
+}
+
+func CreateExportRequest(c *fiber.Ctx) error {
+	var data ExportRequestRequest
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
+		return InvalidRequest(fieldErrs)
+	}
+
+	// Ensure Authenticated admin sent request
+	verified, aid := AuthenticateUser(c, 3)
+	if !verified {
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
+	}
+
+	if _, err := Repos(c).Students.Get(ctx, data.UID); err != nil {
+		return NotFound("student")
+	}
+
+	var request models.ExportRequest
+	request.ID = primitive.NewObjectID()
+	request.SID = data.UID
+	request.Requester = strings.TrimSpace(data.Requester)
+	request.Notes = data.Notes
+	request.Created_by = aid
+	request.Received_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	request.Due_at = request.Received_at.Add(exportDeadline())
+
+	if insertErr := AuditedInsertOne(c, ctx, Repos(c).Exports, request); insertErr != nil {
+		return InternalError("the export request could not be inserted", insertErr)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "successfully created export request",
+		"result":  request,
+	})
+}
+
+func ExportRequests(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Ensure Authenticated admin sent request
+	if verified, _ := AuthenticateUser(c, 3); !verified {
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
+	}
+
+	filter := bson.M{}
+	if uid := c.Query("uid"); uid != "" {
+		filter["sid"] = uid
+	}
+	switch c.Query("status") {
+	case "open":
+		filter["completed_at"] = time.Time{}
+	case "overdue":
+		filter["completed_at"] = time.Time{}
+		filter["due_at"] = bson.M{"$lt": time.Now()}
+	case "completed":
+		filter["completed_at"] = bson.M{"$gt": time.Time{}}
+	}
+
+	requests := []models.ExportRequest{}
+	if err := Repos(c).Exports.Find(ctx, filter, bson.D{{Key: "due_at", Value: 1}}, 100, &requests); err != nil {
+		return InternalError("failed to find export requests", err)
+	}
+
+	result := []fiber.Map{}
+	for i := range requests {
+		result = append(result, fiber.Map{"request": requests[i], "overdue": requests[i].Overdue()})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "successfully retrieved export requests",
+		"result":  result,
+	})
+}
+
+func DownloadExport(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	// Ensure Authenticated admin sent request
+	verified, aid := AuthenticateUser(c, 3)
+	if !verified {
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
+	}
+
+	id, err := primitive.ObjectIDFromHex(c.Query("id"))
+	if err != nil {
+		return NewError(fiber.StatusBadRequest, CodeInvalidRequest, "missing required fields")
+	}
+
+	var request models.ExportRequest
+	if findErr := Repos(c).Exports.FindOne(ctx, bson.M{"_id": id}, nil, &request); findErr != nil {
+		return NotFound("export request")
+	}
+
+	export, err := BuildStudentExport(ctx, Repos(c), request)
+	if err != nil {
+		return InternalError("failed to gather the student's records", err)
+	}
+
+	bundle, _ := json.MarshalIndent(export, "", "  ")
+	summary, err := renderExportSummary(export)
+	if err != nil {
+		return InternalError("failed to render the export summary", err)
+	}
+
+	archive := new(bytes.Buffer)
+	writer := zip.NewWriter(archive)
+	for name, contents := range map[string][]byte{"bundle.json": bundle, "summary.html": summary} {
+		file, err := writer.Create(name)
+		if err == nil {
+			_, err = file.Write(contents)
+		}
+		if err != nil {
+			return InternalError("failed to write the export", err)
+		}
+	}
+	writer.Close()
+
+	if request.Completed_at.IsZero() {
+		completed_at, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		_, updateErr := AuditedUpdateOne(c, ctx, Repos(c).Exports, bson.M{"_id": request.ID}, bson.M{
+			"$set": bson.M{"completed_at": completed_at, "completed_by": aid},
+		}, false)
+		if updateErr != nil {
+			return InternalError("failed to record the export as completed", updateErr)
+		}
+	}
+	RecordAccess(c, []string{"export"}, request.SID)
+
+	c.Set(fiber.HeaderContentType, "application/zip")
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="student-`+request.SID+`-export.zip"`)
+	return c.Status(fiber.StatusOK).Send(archive.Bytes())
+}
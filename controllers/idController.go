@@ -3,43 +3,88 @@ package controllers
 import (
 	"context"
 	"crypto/rand"
+	"encoding/json"
+	"errors"
 	"io"
+	"strings"
 	"time"
 
-	"github.com/SowinskiBraeden/school-management-api/database"
 	"github.com/SowinskiBraeden/school-management-api/models"
+	"github.com/SowinskiBraeden/school-management-api/repository"
 
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
+	"github.com/gofiber/fiber/v2"
 )
 
-var IdCollection *mongo.Collection = database.OpenCollection(database.Client, "cids")
+/*
+	IDs are allocated by reserving a new ID in the ledger of
+	every ID ever given, then inserting the account with it,
+	and trying again with another if either is taken. The
+	ledger is one collection for students, teachers and admins
+	with the ID as its unique _id, so the insert into it is the
+	check, and two accounts of any type created at the same time
+	can never end up with the same ID. The unique indexes on the
+	sid, tid, aid and pen still stop a clash with an account
+	created before the ledger.
+
+	Student, teacher and admin IDs end in a Luhn check digit, so
+	a mistyped digit, or two neighbouring digits swapped, is
+	caught before it can match the wrong account. IDs given
+	before check digits were added are six digits long and are
+	still accepted. PENs follow the BC Ministry of Education
+	format: eight digits and a mod 10 check digit, which is the
+	same as Luhn for nine digits.
+*/
 
 var table = [...]byte{'1', '2', '3', '4', '5', '6', '7', '8', '9', '0'}
 
-func ValidateID(id string, userType int) bool { // true: valid id, false: id already in use
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	var foundID models.Id
-	err := IdCollection.FindOne(ctx, bson.M{"cid": id}).Decode(&foundID)
-	cancel()
-	if err != nil { // If there is no id found create new ID object to be stored and return true (unless insert error then try again)
-		var newID models.Id
-		newID.CID = id
-		newID.ParentType = userType
-		newID.ID = primitive.NewObjectID()
-		_, insertErr := IdCollection.InsertOne(context.Background(), newID)
-		return insertErr == nil
+// allocationAttempts is how many IDs are tried before giving up, a full ID space would otherwise loop forever
+const allocationAttempts = 50
+
+var ErrNoFreeID = errors.New("no free ID could be found")
+
+// AllocateID calls insert with new IDs from generate until one isn't taken, and returns the ID inserted
+func AllocateID(ctx context.Context, repos *repository.Repositories, generate func() string, insert func(id string) error) (string, error) {
+	for attempt := 0; attempt < allocationAttempts; attempt++ {
+		id := generate()
+
+		// Accounts created before the ledger are only found in their own collection
+		if userType, err := UserTypeOf(ctx, repos, id); err != nil {
+			return "", err
+		} else if userType != 0 {
+			continue
+		}
+
+		// IDs are also unique across students, teachers and admins, so a uid names one account
+		var allocated models.AllocatedID
+		allocated.ID = id
+		allocated.Allocated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		if err := repos.IDs.Insert(ctx, allocated); err == repository.ErrDuplicateKey {
+			continue
+		} else if err != nil {
+			return "", err
+		}
+
+		err := insert(id)
+		if err == repository.ErrDuplicateKey {
+			continue
+		}
+		return id, err
 	}
-	return false
+	return "", ErrNoFreeID
 }
 
-func ValidatePEN(pen string) bool { // true: valid pen, false: pen already in use
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	var foundID models.Id
-	err := StudentCollection.FindOne(ctx, bson.M{"school.pen": pen}).Decode(&foundID)
-	cancel()
-	return err != nil
+// UserTypeOf returns the type of the account with the ID (1: student, 2: teacher, 3: admin), or 0 if there is none
+func UserTypeOf(ctx context.Context, repos *repository.Repositories, uid string) (int, error) {
+	for _, userType := range []int{1, 2, 3} {
+		_, err := repos.Account(userType).Document(ctx, uid)
+		if err == nil {
+			return userType, nil
+		}
+		if err != repository.ErrNotFound {
+			return 0, err
+		}
+	}
+	return 0, nil
 }
 
 func GenerateID(length int) string {
@@ -53,3 +98,83 @@ func GenerateID(length int) string {
 	}
 	return string(b)
 }
+
+// checkDigit returns the Luhn check digit for a string of digits
+func checkDigit(digits string) byte {
+	sum := 0
+	for i := len(digits) - 1; i >= 0; i-- {
+		digit := int(digits[i] - '0')
+		// Every other digit, starting with the last, is doubled
+		if (len(digits)-i)%2 == 1 {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+func isDigits(value string) bool {
+	for _, char := range value {
+		if char < '0' || char > '9' {
+			return false
+		}
+	}
+	return value != ""
+}
+
+// validCheckDigit reports whether the last digit of value is the check digit of the rest
+func validCheckDigit(value string) bool {
+	return isDigits(value) && len(value) > 1 && checkDigit(value[:len(value)-1]) == value[len(value)-1]
+}
+
+// GenerateUID returns a new student, teacher or admin ID: six random digits and a check digit
+func GenerateUID() string {
+	id := GenerateID(6)
+	return id + string(checkDigit(id))
+}
+
+// ValidUID reports whether uid could be an account ID, one with a check digit or one given before they were added
+func ValidUID(uid string) bool {
+	if len(uid) == 6 {
+		return isDigits(uid)
+	}
+	return len(uid) == 7 && validCheckDigit(uid)
+}
+
+// GeneratePEN returns a new Personal Education Number in the BC format
+func GeneratePEN() string {
+	pen := GenerateID(8)
+	return pen + string(checkDigit(pen))
+}
+
+// ValidPEN reports whether pen is a Personal Education Number in the BC format, with a valid check digit
+func ValidPEN(pen string) bool {
+	return len(pen) == 9 && validCheckDigit(pen)
+}
+
+// CheckUID rejects requests with a uid, in the query or the body, that isn't a valid account ID
+func CheckUID(c *fiber.Ctx) error {
+	uids := []string{c.Query("uid")}
+
+	contentType := strings.ToLower(c.Get(fiber.HeaderContentType))
+	if strings.HasPrefix(contentType, fiber.MIMEApplicationJSON) {
+		var body map[string]interface{}
+		if json.Unmarshal(c.Body(), &body) == nil {
+			if uid, ok := body["uid"].(string); ok {
+				uids = append(uids, uid)
+			}
+		}
+	} else if strings.HasPrefix(contentType, fiber.MIMEApplicationForm) || strings.HasPrefix(contentType, fiber.MIMEMultipartForm) {
+		uids = append(uids, c.FormValue("uid"))
+	}
+
+	for _, uid := range uids {
+		if uid != "" && !ValidUID(uid) {
+			return NewError(fiber.StatusBadRequest, CodeInvalidUID, "the uid isn't a valid ID, check it was typed correctly")
+		}
+	}
+	return c.Next()
+}
@@ -0,0 +1,255 @@
+package controllers
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SowinskiBraeden/school-management-api/database"
+	"github.com/SowinskiBraeden/school-management-api/models"
+	"github.com/SowinskiBraeden/school-management-api/repository"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+/*
+	Students and teachers enrolled at the same time draw their IDs
+	from the same space, TestConcurrentEnrollment enrols both at
+	once from a small pool of IDs so that they clash, and checks
+	no ID is given to two accounts. It runs on SQLite, and on
+	MongoDB when mongoURI is set, in a <dbo>_enrollment database
+	migrated for the run and dropped after it.
+*/
+
+// enrollmentBackends opens each backend to enrol on, MongoDB only when mongoURI is set
+var enrollmentBackends = []struct {
+	name string
+	open func(t *testing.T) *repository.Repositories
+}{
+	{"sqlite", func(t *testing.T) *repository.Repositories {
+		repos, err := repository.NewSQLite(filepath.Join(t.TempDir(), "enrollment.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return repos
+	}},
+	{"mongodb", func(t *testing.T) *repository.Repositories {
+		if os.Getenv("mongoURI") == "" {
+			t.Skip("mongoURI is not set")
+		}
+		if err := database.Connect(); err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		db := database.Client.Database(os.Getenv("dbo") + "_enrollment")
+		if err := db.Drop(ctx); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Drop(context.Background()) })
+		if _, err := database.MigrateUp(ctx, db); err != nil {
+			t.Fatal(err)
+		}
+		return repository.NewMongo(db)
+	}},
+}
+
+func TestConcurrentEnrollment(t *testing.T) {
+	for _, backend := range enrollmentBackends {
+		backend := backend
+		t.Run(backend.name, func(t *testing.T) {
+			repos := backend.open(t)
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+
+			pool := make([]string, 5)
+			for i := range pool {
+				pool[i] = GenerateUID()
+			}
+
+			type enrollment struct {
+				userType int
+				id       string
+				err      error
+			}
+			enrollments := make([]enrollment, 20)
+
+			var wg sync.WaitGroup
+			for i := range enrollments {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+
+					// Each enrollment tries every ID in the pool, starting at a different one
+					next := i
+					generate := func() string {
+						next++
+						return pool[next%len(pool)]
+					}
+
+					userType := 1 + i%2
+					id, err := AllocateID(ctx, repos, generate, func(id string) error {
+						// A slow insert, as one with a photo is, leaves the others time to pick the same ID
+						time.Sleep(20 * time.Millisecond)
+						if userType == 1 {
+							var student models.Student
+							student.School.SID = id
+							student.School.PEN = GeneratePEN()
+							return repos.Students.Insert(ctx, student)
+						}
+						var teacher models.Teacher
+						teacher.School.TID = id
+						return repos.Teachers.Insert(ctx, teacher)
+					})
+					enrollments[i] = enrollment{userType, id, err}
+				}(i)
+			}
+			wg.Wait()
+
+			given := map[string]int{}
+			for _, enrolled := range enrollments {
+				if enrolled.err == ErrNoFreeID {
+					continue
+				}
+				if enrolled.err != nil {
+					t.Fatalf("enrolling: %v", enrolled.err)
+				}
+				if userType, seen := given[enrolled.id]; seen {
+					t.Fatalf("%s was given to a %d and a %d", enrolled.id, userType, enrolled.userType)
+				}
+				given[enrolled.id] = enrolled.userType
+
+				userType, err := UserTypeOf(ctx, repos, enrolled.id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if userType != enrolled.userType {
+					t.Errorf("%s belongs to a %d, not the %d it was given to", enrolled.id, userType, enrolled.userType)
+				}
+			}
+			if len(given) != len(pool) {
+				t.Errorf("%d of the %d IDs were given", len(given), len(pool))
+			}
+		})
+	}
+}
+
+func TestCheckDigit(t *testing.T) {
+	for _, test := range []struct {
+		digits string
+		want   byte
+	}{
+		{"7992739871", '3'}, // the usual worked example of Luhn
+		{"123456", '6'},
+		{"987654", '1'},
+		{"000000", '0'},
+		{"12345678", '2'},
+	} {
+		if got := checkDigit(test.digits); got != test.want {
+			t.Errorf("checkDigit(%q) = %c, want %c", test.digits, got, test.want)
+		}
+	}
+}
+
+func TestValidUID(t *testing.T) {
+	for _, test := range []struct {
+		uid  string
+		want bool
+	}{
+		{"1234566", true},
+		{"9876541", true},
+		{"0000000", true},
+		{"1234567", false}, // wrong check digit
+		{"1234656", false}, // neighbouring digits swapped
+		{"2234566", false}, // one digit mistyped
+		{"123456", true},   // given before check digits, any six digits
+		{"000000", true},
+		{"12345", false},
+		{"12345662", false},
+		{"", false},
+		{"12a4566", false},
+		{"12345a", false},
+		{" 123456", false},
+		{"-123456", false},
+	} {
+		if got := ValidUID(test.uid); got != test.want {
+			t.Errorf("ValidUID(%q) = %v, want %v", test.uid, got, test.want)
+		}
+	}
+}
+
+func TestValidPEN(t *testing.T) {
+	for _, test := range []struct {
+		pen  string
+		want bool
+	}{
+		{"123456782", true},
+		{"000000000", true},
+		{"123456781", false}, // wrong check digit
+		{"213456782", false}, // neighbouring digits swapped
+		{"12345678", false},
+		{"1234567822", false},
+		{"1234566", false}, // a UID isn't a PEN
+		{"", false},
+		{"12345678a", false},
+		{"1234 5678", false},
+	} {
+		if got := ValidPEN(test.pen); got != test.want {
+			t.Errorf("ValidPEN(%q) = %v, want %v", test.pen, got, test.want)
+		}
+	}
+}
+
+func TestGeneratedIDsAreValid(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		if uid := GenerateUID(); !ValidUID(uid) || len(uid) != 7 {
+			t.Fatalf("GenerateUID() = %q isn't a valid ID", uid)
+		}
+		if pen := GeneratePEN(); !ValidPEN(pen) {
+			t.Fatalf("GeneratePEN() = %q isn't a valid PEN", pen)
+		}
+	}
+}
+
+func TestCheckUID(t *testing.T) {
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	app.Post("/", CheckUID, func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	for _, test := range []struct {
+		query       string
+		contentType string
+		body        string
+		want        int
+	}{
+		{"", "", "", fiber.StatusOK},
+		{"?uid=1234566", "", "", fiber.StatusOK},
+		{"?uid=123456", "", "", fiber.StatusOK},
+		{"?uid=1234567", "", "", fiber.StatusBadRequest},
+		{"", fiber.MIMEApplicationJSON, `{"uid": "1234566"}`, fiber.StatusOK},
+		{"", fiber.MIMEApplicationJSON, `{"uid": "1234656"}`, fiber.StatusBadRequest},
+		{"", fiber.MIMEApplicationForm, "uid=12345", fiber.StatusBadRequest},
+		{"", fiber.MIMEApplicationForm, "uid=9876541", fiber.StatusOK},
+	} {
+		req := httptest.NewRequest("POST", "/"+test.query, strings.NewReader(test.body))
+		if test.contentType != "" {
+			req.Header.Set(fiber.HeaderContentType, test.contentType)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != test.want {
+			t.Errorf("%s %s: got %d, want %d", test.query, test.body, resp.StatusCode, test.want)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/SowinskiBraeden/school-management-api/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+	An admin can impersonate a student or teacher to see what
+	they see. The admin's jwt cookie is swapped for one issued to
+	the user, with the session's id in the token, and the admin's
+	own token is kept in the admin_jwt cookie until the session
+	ends. While it lasts every response carries the
+	X-Impersonation header and an "impersonation" field, and a
+	read-only session refuses anything but GET requests.
+
+	Sessions are never deleted, the impersonations collection is
+	the audit trail of who impersonated whom, why and for how long.
+*/
+
+const (
+	impersonationPrefix     = "imp_" // start of the jwt id of an impersonation token
+	defaultImpersonationMin = 15
+	maxImpersonationMin     = 60
+)
+
+// impersonationSession returns the session the request's jwt was issued for, if any
+func impersonationSession(c *fiber.Ctx) *models.Impersonation {
+	token, err := jwt.ParseWithClaims(c.Cookies("jwt"), &jwt.StandardClaims{}, func(token *jwt.Token) (interface{}, error) {
+		return []byte(SecretKey), nil
+	})
+	// An expired session is still returned so its end can be recorded
+	if ve, ok := err.(*jwt.ValidationError); err != nil && (!ok || ve.Errors != jwt.ValidationErrorExpired) {
+		return nil
+	}
+
+	claims := token.Claims.(*jwt.StandardClaims)
+	if !strings.HasPrefix(claims.Id, impersonationPrefix) {
+		return nil
+	}
+	id, err := primitive.ObjectIDFromHex(strings.TrimPrefix(claims.Id, impersonationPrefix))
+	if err != nil {
+		return nil
+	}
+
+	var session models.Impersonation
+	if findErr := Repos(c).Impersonations.FindOne(context.TODO(), bson.M{"_id": id}, nil, &session); findErr != nil {
+		return nil
+	}
+	return &session
+}
+
+// ImpersonationGuard marks responses made during an impersonation and blocks writes in read-only sessions
+func ImpersonationGuard(c *fiber.Ctx) error {
+	if c.Cookies("jwt") == "" {
+		return c.Next()
+	}
+
+	session := impersonationSession(c)
+	if session == nil {
+		return c.Next()
+	}
+
+	if !session.Active() {
+		endImpersonation(c, session)
+		return NewError(fiber.StatusUnauthorized, CodeSessionEnded, "the impersonation session has ended")
+	}
+
+	c.Set("X-Impersonation", session.ID.Hex())
+
+	ending := strings.HasSuffix(c.Path(), "/impersonation/end") || strings.HasSuffix(c.Path(), "/logout")
+	var err error
+	if session.ReadOnly && c.Method() != fiber.MethodGet && !ending {
+		err = NewError(fiber.StatusForbidden, CodeReadOnlySession, "the impersonation session is read-only")
+	} else {
+		err = c.Next()
+	}
+	// Failures are rendered here rather than after, so they are marked too
+	if err != nil {
+		if err := c.App().Config().ErrorHandler(c, err); err != nil {
+			return err
+		}
+	}
+
+	// Add the session to JSON responses so a client can't miss it
+	if strings.HasPrefix(string(c.Response().Header.ContentType()), fiber.MIMEApplicationJSON) && !ending {
+		var body map[string]interface{}
+		if json.Unmarshal(c.Response().Body(), &body) == nil {
+			body["impersonation"] = session
+			if marked, err := json.Marshal(body); err == nil {
+				c.Response().SetBody(marked)
+			}
+		}
+	}
+	return nil
+}
+
+// endImpersonation records the end of the session and gives the admin their own cookie back
+func endImpersonation(c *fiber.Ctx, session *models.Impersonation) {
+	ended := session.Expires_at
+	if session.Active() {
+		ended, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	}
+	if session.Ended_at.IsZero() {
+		Repos(c).Impersonations.UpdateOne(context.TODO(), bson.M{"_id": session.ID}, bson.M{"$set": bson.M{"ended_at": ended}}, false)
+		session.Ended_at = ended
+	}
+
+	c.Cookie(&fiber.Cookie{
+		Name:     "jwt",
+		Value:    c.Cookies("admin_jwt"),
+		Expires:  time.Now().Add(time.Hour * 24),
+		HTTPOnly: true,
+	})
+	c.Cookie(&fiber.Cookie{
+		Name:     "admin_jwt",
+		Value:    "",
+		Expires:  time.Now().Add(-time.Hour),
+		HTTPOnly: true,
+	})
+}
+
+// ImpersonationRequest is the body of StartImpersonation
+type ImpersonationRequest struct {
+	UID      string `json:"uid" validate:"required,uid"`
+	UserType string `json:"usertype" validate:"oneof=student teacher"`
+	Reason   string `json:"reason" validate:"notblank"`
+	Minutes  int    `json:"minutes"`  // defaults to defaultImpersonationMin
+	ReadOnly *bool  `json:"readonly"` // defaults to true
+}
+
+func StartImpersonation(c *fiber.Ctx) error {
+	var data ImpersonationRequest
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
+		return InvalidRequest(fieldErrs)
+	}
+
+	// Ensure Authenticated admin sent request, an API key can't impersonate anyone
+	verified, aid := AuthenticateUser(c, 3)
+	if !verified || c.Get("X-API-Key") != "" {
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
+	}
+
+	if data.Minutes == 0 {
+		data.Minutes = defaultImpersonationMin
+	}
+	if data.Minutes < 1 || data.Minutes > maxImpersonationMin {
+		return NewError(fiber.StatusBadRequest, CodeInvalidRequest, "minutes must be between 1 and 60")
+	}
+
+	userType := oidcUserTypes[data.UserType]
+	if user, err := accountOf(ctx, Repos(c), userType, data.UID); err != nil || user.Removed != nil {
+		return NotFound(data.UserType)
+	}
+
+	var session models.Impersonation
+	session.ID = primitive.NewObjectID()
+	session.AID = aid
+	session.UID = data.UID
+	session.UserType = userType
+	session.Reason = strings.TrimSpace(data.Reason)
+	session.ReadOnly = data.ReadOnly == nil || *data.ReadOnly
+	session.IP = c.IP()
+	session.Started_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	session.Expires_at = session.Started_at.Add(time.Duration(data.Minutes) * time.Minute)
+
+	claims := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{
+		Id:        impersonationPrefix + session.ID.Hex(),
+		Issuer:    session.UID,
+		ExpiresAt: session.Expires_at.Unix(),
+	})
+	token, err := claims.SignedString([]byte(SecretKey))
+	if err != nil {
+		return InternalError("could not start impersonation", nil)
+	}
+
+	if insertErr := Repos(c).Impersonations.Insert(ctx, session); insertErr != nil {
+		return InternalError("the impersonation could not be inserted", insertErr)
+	}
+
+	c.Cookie(&fiber.Cookie{
+		Name:     "admin_jwt",
+		Value:    c.Cookies("jwt"),
+		Expires:  time.Now().Add(time.Hour * 24),
+		HTTPOnly: true,
+	})
+	// The cookie outlives the token so the admin's own cookie can be restored once it expires
+	c.Cookie(&fiber.Cookie{
+		Name:     "jwt",
+		Value:    token,
+		Expires:  time.Now().Add(time.Hour * 24),
+		HTTPOnly: true,
+	})
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success":       true,
+		"message":       "successfully started impersonation",
+		"impersonation": session,
+	})
+}
+
+func EndImpersonation(c *fiber.Ctx) error {
+	session := impersonationSession(c)
+	if session == nil {
+		return NewError(fiber.StatusBadRequest, CodeNotImpersonating, "not impersonating anyone")
+	}
+
+	endImpersonation(c, session)
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success":       true,
+		"message":       "successfully ended impersonation",
+		"impersonation": session,
+	})
+}
+
+func Impersonations(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Ensure Authenticated admin sent request
+	if verified, _ := AuthenticateUser(c, 3); !verified {
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
+	}
+
+	filter := bson.M{}
+	if uid := c.Query("uid"); uid != "" {
+		filter["uid"] = uid
+	}
+	if aid := c.Query("aid"); aid != "" {
+		filter["aid"] = aid
+	}
+
+	sessions := []models.Impersonation{}
+	if err := Repos(c).Impersonations.Find(ctx, filter, bson.D{{Key: "started_at", Value: -1}}, 100, &sessions); err != nil {
+		return InternalError("failed to find impersonations", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "successfully retrieved impersonations",
+		"result":  sessions,
+	})
+}
@@ -0,0 +1,213 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/SowinskiBraeden/school-management-api/models"
+	"github.com/SowinskiBraeden/school-management-api/repository"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+	The lockout controller handles the following:
+		- recording every login attempt
+		- throttling failed attempts per IP address
+		- escalating the cooldown of locked accounts
+		- the daily digest of locked accounts sent to admins
+
+	An account is locked for a cooldown after too many failed
+	attempts rather than being disabled, so anyone who knows a
+	SID can only delay a login rather than block it until an
+	admin steps in.
+
+	A successful login marks the browser with a random device
+	cookie, and only its hash is kept with the attempt. A device
+	that has logged into the account before is let past both the
+	IP throttle and a lockout, so someone failing logins from the
+	same school network can't keep its users out. Trust is never
+	given by IP, any device behind the same address shares it.
+	When the attempts can't be read the throttle fails closed.
+*/
+
+const (
+	MaxLoginAttempts    = 5                   // failed attempts before an account is locked
+	baseLockout         = 5 * time.Minute     // cooldown of the first lockout
+	maxLockout          = 24 * time.Hour      // the cooldown never grows past this
+	ipWindow            = 15 * time.Minute    // window failed attempts per IP are counted in
+	maxIPFailures       = 20                  // failed attempts an IP may make per window
+	trustedDeviceWindow = 30 * 24 * time.Hour // how long a successful login trusts a device for that account
+
+	deviceCookie = "device"
+)
+
+// LockoutDuration doubles the base cooldown for every previous consecutive lockout
+func LockoutDuration(lockouts int) time.Duration {
+	if lockouts < 0 {
+		lockouts = 0
+	}
+	duration := float64(baseLockout) * math.Pow(2, float64(lockouts))
+	if duration > float64(maxLockout) {
+		return maxLockout
+	}
+	return time.Duration(duration)
+}
+
+func LockedMessage(until time.Time) string {
+	minutes := int(math.Ceil(time.Until(until).Minutes()))
+	if minutes < 1 {
+		minutes = 1
+	}
+	return fmt.Sprintf("Account is locked due to too many failed login attempts, try again in %d minute(s)", minutes)
+}
+
+// RecordLoginAttempt records a login attempt, device is the hash TrustDevice returned for a successful one
+func RecordLoginAttempt(repos *repository.Repositories, uid string, userType int, ip string, device string, success bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var attempt models.LoginAttempt
+	attempt.ID = primitive.NewObjectID()
+	attempt.UID = uid
+	attempt.UserType = userType
+	attempt.IP = ip
+	attempt.Device = device
+	attempt.Success = success
+	attempt.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+	if insertErr := repos.LoginAttempts.Insert(ctx, attempt); insertErr != nil {
+		log.Printf("Failed to record login attempt for %s: %v\n", uid, insertErr)
+	}
+}
+
+// IPThrottled reports whether an IP has failed too many logins, for any account, recently
+func IPThrottled(repos *repository.Repositories, ip string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	count, err := repos.LoginAttempts.Count(ctx, bson.M{
+		"ip":         ip,
+		"success":    false,
+		"created_at": bson.M{"$gte": time.Now().Add(-ipWindow)},
+	})
+	if err != nil {
+		log.Printf("Failed to count the failed logins of %s, throttling it: %v\n", ip, err)
+		return true
+	}
+	return count >= maxIPFailures
+}
+
+// TrustDevice gives the browser a device cookie, or renews the one it has, and returns the hash to record with the login
+func TrustDevice(c *fiber.Ctx) string {
+	token := c.Cookies(deviceCookie)
+	if token == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return ""
+		}
+		token = hex.EncodeToString(b)
+	}
+
+	c.Cookie(&fiber.Cookie{
+		Name:     deviceCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  time.Now().Add(trustedDeviceWindow),
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+	return HashToken(token)
+}
+
+// TrustedDevice reports whether the browser's device cookie has successfully logged into the account recently
+func TrustedDevice(repos *repository.Repositories, uid string, token string) bool {
+	if token == "" {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	count, err := repos.LoginAttempts.Count(ctx, bson.M{
+		"uid":        uid,
+		"device":     HashToken(token),
+		"success":    true,
+		"created_at": bson.M{"$gte": time.Now().Add(-trustedDeviceWindow)},
+	})
+	if err != nil {
+		log.Printf("Failed to check the device logging into %s, not trusting it: %v\n", uid, err)
+		return false
+	}
+	return count > 0
+}
+
+func StartLockoutDigest(repos *repository.Repositories) {
+	go func() {
+		ticker := time.NewTicker(24 * time.Hour)
+		for range ticker.C {
+			SendLockoutDigest(repos)
+		}
+	}()
+}
+
+// SendLockoutDigest emails every admin the accounts that were locked in the last day
+func SendLockoutDigest(repos *repository.Repositories) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	filter := repository.AccountFilter{LockedSince: time.Now().Add(-24 * time.Hour)}
+	var accounts []map[string]string
+
+	students, _ := repos.Students.Find(ctx, filter)
+	for _, student := range students {
+		accounts = append(accounts, map[string]string{
+			"type":  "student",
+			"uid":   student.School.SID,
+			"name":  student.Personal.FirstName + " " + student.Personal.LastName,
+			"until": student.Account.LockedUntil.Format(time.RFC1123),
+		})
+	}
+
+	teachers, _ := repos.Teachers.Find(ctx, filter)
+	for _, teacher := range teachers {
+		accounts = append(accounts, map[string]string{
+			"type":  "teacher",
+			"uid":   teacher.School.TID,
+			"name":  teacher.Personal.FirstName + " " + teacher.Personal.LastName,
+			"until": teacher.Account.LockedUntil.Format(time.RFC1123),
+		})
+	}
+
+	lockedAdmins, _ := repos.Admins.Find(ctx, filter)
+	for _, admin := range lockedAdmins {
+		accounts = append(accounts, map[string]string{
+			"type":  "admin",
+			"uid":   admin.AID,
+			"name":  admin.FirstName + " " + admin.LastName,
+			"until": admin.LockedUntil.Format(time.RFC1123),
+		})
+	}
+
+	if len(accounts) == 0 {
+		return
+	}
+
+	admins, err := repos.Admins.Find(ctx, repository.AccountFilter{})
+	if err != nil {
+		log.Printf("Failed to find admins for the lockout digest: %v\n", err)
+		return
+	}
+
+	for _, admin := range admins {
+		r := NewRequest([]string{admin.Email}, "Locked Accounts Digest")
+		r.Send("./templates/lockoutDigest.html", map[string]interface{}{"username": admin.FirstName, "accounts": accounts})
+	}
+}
@@ -0,0 +1,385 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SowinskiBraeden/school-management-api/models"
+	"github.com/SowinskiBraeden/school-management-api/repository"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+	Users can sign in with their school email account through
+	any OpenID Connect provider, using the authorization code
+	flow with PKCE. The provider is set by OIDC_ISSUER in the
+	.env, and the email in the returned id token is matched to
+	the school email of an existing student, teacher or admin.
+
+	Password login can be turned off per role with
+	OIDC_DISABLE_PASSWORD_LOGIN, e.g. "teacher,admin".
+*/
+
+const oidcStateLifetime = 10 * time.Minute
+
+// oidcStateCookie holds the hash of the state in the browser that started the login, only it can finish the login
+const oidcStateCookie = "oidc_state"
+
+var oidcUserTypes = map[string]int{"student": 1, "teacher": 2, "admin": 3}
+
+type oidcProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+var (
+	discovered     *oidcProvider
+	discoveredLock sync.Mutex
+	oidcClient     = &http.Client{Timeout: 10 * time.Second}
+)
+
+func OIDCEnabled() bool {
+	return os.Getenv("OIDC_ISSUER") != "" && os.Getenv("OIDC_CLIENT_ID") != ""
+}
+
+// PasswordLoginDisabled reports whether a role (student, teacher or admin) must sign in through OIDC
+func PasswordLoginDisabled(role string) bool {
+	if !OIDCEnabled() {
+		return false
+	}
+	for _, r := range strings.Split(os.Getenv("OIDC_DISABLE_PASSWORD_LOGIN"), ",") {
+		if strings.TrimSpace(r) == role {
+			return true
+		}
+	}
+	return false
+}
+
+func oidcRedirectURL() string {
+	if redirect := os.Getenv("OIDC_REDIRECT_URL"); redirect != "" {
+		return redirect
+	}
+	return os.Getenv("SYSTEM_URL") + "/api/v1/oidc/callback"
+}
+
+func getJSON(endpoint string, v interface{}) error {
+	res, err := oidcClient.Get(endpoint)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", endpoint, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// discover fetches the provider's endpoints once they are first needed
+func discover() (*oidcProvider, error) {
+	discoveredLock.Lock()
+	defer discoveredLock.Unlock()
+
+	if discovered != nil {
+		return discovered, nil
+	}
+
+	issuer := strings.TrimSuffix(os.Getenv("OIDC_ISSUER"), "/")
+	var provider oidcProvider
+	if err := getJSON(issuer+"/.well-known/openid-configuration", &provider); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(provider.Issuer, "/") != issuer {
+		return nil, errors.New("the provider's issuer does not match OIDC_ISSUER")
+	}
+
+	discovered = &provider
+	return discovered, nil
+}
+
+func randomString(size int) string {
+	b := make([]byte, size)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// jwksKey finds the RSA public key the provider signed an id token with
+func jwksKey(provider *oidcProvider, kid string) (*rsa.PublicKey, error) {
+	var jwks struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := getJSON(provider.JWKSURI, &jwks); err != nil {
+		return nil, err
+	}
+
+	for _, key := range jwks.Keys {
+		if key.Kty != "RSA" || (kid != "" && key.Kid != kid) {
+			continue
+		}
+		n, nErr := base64.RawURLEncoding.DecodeString(key.N)
+		e, eErr := base64.RawURLEncoding.DecodeString(key.E)
+		if nErr != nil || eErr != nil {
+			return nil, errors.New("invalid key in the provider's JWKS")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	}
+	return nil, errors.New("the id token was signed with an unknown key")
+}
+
+// exchangeCode trades the authorization code for the id token and returns its verified email
+func exchangeCode(provider *oidcProvider, code string, state models.OIDCState) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {oidcRedirectURL()},
+		"client_id":     {os.Getenv("OIDC_CLIENT_ID")},
+		"code_verifier": {state.Verifier},
+	}
+	if secret := os.Getenv("OIDC_CLIENT_SECRET"); secret != "" {
+		form.Set("client_secret", secret)
+	}
+
+	res, err := oidcClient.PostForm(provider.TokenEndpoint, form)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&tokens); err != nil {
+		return "", err
+	}
+	if res.StatusCode != http.StatusOK || tokens.IDToken == "" {
+		return "", fmt.Errorf("the provider refused the code: %s", tokens.Error)
+	}
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(tokens.IDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		kid, _ := token.Header["kid"].(string)
+		return jwksKey(provider, kid)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if !claims.VerifyIssuer(provider.Issuer, true) {
+		return "", errors.New("the id token has the wrong issuer")
+	}
+	if !claims.VerifyAudience(os.Getenv("OIDC_CLIENT_ID"), true) {
+		return "", errors.New("the id token was not issued for this system")
+	}
+	if nonce, _ := claims["nonce"].(string); nonce != state.Nonce {
+		return "", errors.New("the id token has the wrong nonce")
+	}
+	if verified, _ := claims["email_verified"].(bool); !verified {
+		return "", errors.New("the provider has not verified the email")
+	}
+
+	email, _ := claims["email"].(string)
+	if email == "" {
+		return "", errors.New("the id token has no email")
+	}
+	return strings.ToLower(email), nil
+}
+
+// findBySchoolEmail returns the uid of the user of the type with the school email
+func findBySchoolEmail(ctx context.Context, repos *repository.Repositories, userType int, email string) (string, bool, error) {
+	var uid string
+	var disabled bool
+	var removed *models.Removal
+	var err error
+	switch userType {
+	case 1:
+		var student models.Student
+		student, err = repos.Students.FindBySchoolEmail(ctx, email)
+		uid, disabled, removed = student.School.SID, student.Account.AccountDisabled, student.Removed
+	case 2:
+		var teacher models.Teacher
+		teacher, err = repos.Teachers.FindBySchoolEmail(ctx, email)
+		uid, disabled, removed = teacher.School.TID, teacher.Account.AccountDisabled, teacher.Removed
+	default:
+		var admin models.Admin
+		admin, err = repos.Admins.FindBySchoolEmail(ctx, email)
+		uid, disabled, removed = admin.AID, admin.AccountDisabled, admin.Removed
+	}
+	if err == nil && removed != nil {
+		err = repository.ErrNotFound
+	}
+	return uid, disabled, err
+}
+
+func OIDCLogin(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if !OIDCEnabled() {
+		return NewError(fiber.StatusNotImplemented, CodeNotConfigured, "single sign-on is not configured")
+	}
+
+	userType, ok := oidcUserTypes[c.Query("usertype")]
+	if !ok {
+		return NewError(fiber.StatusBadRequest, CodeInvalidRequest, "missing required fields")
+	}
+
+	provider, err := discover()
+	if err != nil {
+		return NewError(fiber.StatusBadGateway, CodeIdentityProvider, "could not reach the identity provider").Because(err)
+	}
+
+	token := randomString(32)
+
+	var state models.OIDCState
+	state.ID = primitive.NewObjectID()
+	state.State = HashToken(token)
+	state.Nonce = randomString(32)
+	state.Verifier = randomString(48)
+	state.UserType = userType
+	state.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	state.Expires_at = state.Created_at.Add(oidcStateLifetime)
+
+	if insertErr := Repos(c).OIDCStates.Insert(ctx, state); insertErr != nil {
+		return InternalError("could not start single sign-on", insertErr)
+	}
+
+	c.Cookie(&fiber.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state.State,
+		Path:     "/api/v1/oidc",
+		Expires:  state.Expires_at,
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteLaxMode, // sent on the redirect back from the provider
+	})
+
+	challenge := sha256.Sum256([]byte(state.Verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {os.Getenv("OIDC_CLIENT_ID")},
+		"redirect_uri":          {oidcRedirectURL()},
+		"scope":                 {"openid email profile"},
+		"state":                 {token},
+		"nonce":                 {state.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	if hint := c.Query("email"); hint != "" {
+		query.Set("login_hint", hint)
+	}
+
+	return c.Redirect(provider.AuthorizationEndpoint+"?"+query.Encode(), fiber.StatusFound)
+}
+
+func OIDCCallback(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if c.Query("error") != "" {
+		return NewError(fiber.StatusUnauthorized, CodeLoginRefused, "the identity provider refused the login: "+c.Query("error"))
+	}
+
+	if c.Query("code") == "" || c.Query("state") == "" {
+		return NewError(fiber.StatusBadRequest, CodeInvalidRequest, "missing required fields")
+	}
+
+	// The state must come back to the browser it was sent from, so a login started elsewhere can't be finished here
+	hash := HashToken(c.Query("state"))
+	c.Cookie(&fiber.Cookie{Name: oidcStateCookie, Path: "/api/v1/oidc", Expires: time.Unix(0, 0), HTTPOnly: true})
+	if subtle.ConstantTimeCompare([]byte(c.Cookies(oidcStateCookie)), []byte(hash)) != 1 {
+		return NewError(fiber.StatusBadRequest, CodeLoginExpired, "the login was started in another browser, try again")
+	}
+
+	// A state can only be used once, only the request that deletes it may finish the login
+	var state models.OIDCState
+	findErr := Repos(c).OIDCStates.FindOne(ctx, bson.M{
+		"state":      hash,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}, nil, &state)
+	if findErr == nil {
+		var deleted int64
+		if deleted, findErr = Repos(c).OIDCStates.DeleteMany(ctx, bson.M{"_id": state.ID}); findErr == nil && deleted != 1 {
+			findErr = repository.ErrNotFound
+		}
+	}
+	if findErr != nil {
+		return NewError(fiber.StatusBadRequest, CodeLoginExpired, "the login has expired, try again")
+	}
+
+	provider, err := discover()
+	if err != nil {
+		return NewError(fiber.StatusBadGateway, CodeIdentityProvider, "could not reach the identity provider").Because(err)
+	}
+
+	email, err := exchangeCode(provider, c.Query("code"), state)
+	if err != nil {
+		return NewError(fiber.StatusUnauthorized, CodeLoginRefused, "could not verify the login").Because(err)
+	}
+
+	uid, disabled, findErr := findBySchoolEmail(ctx, Repos(c), state.UserType, email)
+	if findErr != nil {
+		return NewError(fiber.StatusNotFound, notFoundCode("account"), "no account uses the school email "+email)
+	}
+
+	if disabled {
+		return NewError(fiber.StatusForbidden, CodeAccountDisabled, "Account is Disabled, contact an Admin")
+	}
+
+	RecordLoginAttempt(Repos(c), uid, state.UserType, c.IP(), TrustDevice(c), true)
+
+	claims := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{
+		Issuer:    uid,
+		ExpiresAt: time.Now().Add(time.Hour * 24).Unix(), // 1 Day
+	})
+	token, err := claims.SignedString([]byte(SecretKey))
+	if err != nil {
+		return InternalError("could not log in", nil)
+	}
+
+	cookie := fiber.Cookie{
+		Name:     "jwt",
+		Value:    token,
+		Expires:  time.Now().Add(time.Hour * 24),
+		HTTPOnly: true,
+	}
+	c.Cookie(&cookie)
+
+	// Send the browser back to the front end if it has a page for it
+	if success := os.Getenv("OIDC_SUCCESS_URL"); success != "" {
+		return c.Redirect(success, fiber.StatusFound)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "successfully logged in",
+		"uid":     uid,
+	})
+}
@@ -0,0 +1,256 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SowinskiBraeden/school-management-api/models"
+	"github.com/SowinskiBraeden/school-management-api/repository"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+	The single sign-on tests log in through a provider served by
+	httptest. Like cmd/mockoidc it signs in whoever it is told
+	to, but without the login form, and it only gives an id
+	token for a code when the PKCE verifier matches the challenge
+	the code was issued for.
+*/
+
+const oidcTestRedirect = "http://api.test/api/v1/oidc/callback"
+
+type oidcTestAuthorization struct {
+	challenge string
+	nonce     string
+	email     string
+}
+
+type oidcTestProvider struct {
+	*httptest.Server
+
+	lock  sync.Mutex
+	email string // signed in by the next authorization
+	codes map[string]oidcTestAuthorization
+}
+
+func newOIDCTestProvider(t *testing.T) *oidcTestProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &oidcTestProvider{codes: map[string]oidcTestAuthorization{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcProvider{
+			Issuer:                p.URL,
+			AuthorizationEndpoint: p.URL + "/authorize",
+			TokenEndpoint:         p.URL + "/token",
+			JWKSURI:               p.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		code := randomString(24)
+		p.lock.Lock()
+		p.codes[code] = oidcTestAuthorization{query.Get("code_challenge"), query.Get("nonce"), p.email}
+		p.lock.Unlock()
+		http.Redirect(w, r, query.Get("redirect_uri")+"?"+url.Values{"code": {code}, "state": {query.Get("state")}}.Encode(), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		p.lock.Lock()
+		auth, ok := p.codes[r.PostForm.Get("code")]
+		delete(p.codes, r.PostForm.Get("code"))
+		p.lock.Unlock()
+
+		challenge := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if !ok || auth.challenge != base64.RawURLEncoding.EncodeToString(challenge[:]) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss":            p.URL,
+			"aud":            r.PostForm.Get("client_id"),
+			"exp":            time.Now().Add(time.Hour).Unix(),
+			"nonce":          auth.nonce,
+			"email":          auth.email,
+			"email_verified": true,
+		})
+		idToken.Header["kid"] = "test"
+		signed, _ := idToken.SignedString(key)
+		json.NewEncoder(w).Encode(map[string]string{"id_token": signed})
+	})
+
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+
+	t.Setenv("OIDC_ISSUER", p.URL)
+	t.Setenv("OIDC_CLIENT_ID", "school-management-api")
+	t.Setenv("OIDC_REDIRECT_URL", oidcTestRedirect)
+	t.Setenv("OIDC_SUCCESS_URL", "")
+	discovered = nil
+	t.Cleanup(func() { discovered = nil })
+	return p
+}
+
+// oidcTestLogin is a login started by a browser and sent back from the provider
+type oidcTestLogin struct {
+	cookie string // the state cookie set in the browser
+	code   string
+	state  string
+}
+
+// startLogin starts a login for the user type and has the provider sign in email
+func (p *oidcTestProvider) startLogin(t *testing.T, app *fiber.App, userType string, email string) oidcTestLogin {
+	res, err := app.Test(httptest.NewRequest("GET", "/api/v1/oidc/login?usertype="+userType, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != fiber.StatusFound {
+		t.Fatalf("starting the login: got %d, want %d", res.StatusCode, fiber.StatusFound)
+	}
+	var login oidcTestLogin
+	for _, cookie := range res.Cookies() {
+		if cookie.Name == oidcStateCookie {
+			login.cookie = cookie.Value
+		}
+	}
+
+	p.lock.Lock()
+	p.email = email
+	p.lock.Unlock()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	authorized, err := client.Get(res.Header.Get(fiber.HeaderLocation))
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorized.Body.Close()
+
+	callback, err := url.Parse(authorized.Header.Get(fiber.HeaderLocation))
+	if err != nil {
+		t.Fatal(err)
+	}
+	login.code = callback.Query().Get("code")
+	login.state = callback.Query().Get("state")
+	return login
+}
+
+// finish returns the status of the callback for a login
+func (login oidcTestLogin) finish(t *testing.T, app *fiber.App) int {
+	req := httptest.NewRequest("GET", "/api/v1/oidc/callback?"+url.Values{"code": {login.code}, "state": {login.state}}.Encode(), nil)
+	req.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: login.cookie})
+	res, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.StatusCode
+}
+
+func oidcTestApp(t *testing.T) (*fiber.App, *repository.Repositories) {
+	repos := repository.NewMemory()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	var teacher models.Teacher
+	teacher.ID = primitive.NewObjectID()
+	teacher.School.TID = "1234566"
+	teacher.Account.SchoolEmail = "doe_j@school.test"
+	if err := repos.Teachers.Insert(ctx, teacher); err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	app.Use(Inject(repos))
+	app.Get("/api/v1/oidc/login", OIDCLogin)
+	app.Get("/api/v1/oidc/callback", OIDCCallback)
+	return app, repos
+}
+
+func TestOIDCLogin(t *testing.T) {
+	provider := newOIDCTestProvider(t)
+	app, _ := oidcTestApp(t)
+
+	login := provider.startLogin(t, app, "teacher", "Doe_J@school.test")
+	if login.cookie == "" || login.code == "" || login.state == "" {
+		t.Fatalf("the login wasn't sent back from the provider: %+v", login)
+	}
+	if status := login.finish(t, app); status != fiber.StatusOK {
+		t.Fatalf("got %d, want %d", status, fiber.StatusOK)
+	}
+
+	// The state was used up by the login, sending it again fails
+	if status := login.finish(t, app); status != fiber.StatusBadRequest {
+		t.Errorf("reusing the state: got %d, want %d", status, fiber.StatusBadRequest)
+	}
+}
+
+func TestOIDCLoginRefused(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		userType string
+		email    string
+		tamper   func(t *testing.T, app *fiber.App, provider *oidcTestProvider, repos *repository.Repositories, login *oidcTestLogin)
+		want     int
+	}{
+		{"unknown email", "teacher", "nobody@school.test", nil, fiber.StatusNotFound},
+		{"another role's email", "student", "doe_j@school.test", nil, fiber.StatusNotFound},
+		{"state expired", "teacher", "doe_j@school.test", func(t *testing.T, app *fiber.App, provider *oidcTestProvider, repos *repository.Repositories, login *oidcTestLogin) {
+			repos.OIDCStates.UpdateOne(context.Background(), bson.M{}, bson.M{"$set": bson.M{"expires_at": time.Now().Add(-time.Minute)}}, false)
+		}, fiber.StatusBadRequest},
+		{"state from another browser", "teacher", "doe_j@school.test", func(t *testing.T, app *fiber.App, provider *oidcTestProvider, repos *repository.Repositories, login *oidcTestLogin) {
+			login.cookie = provider.startLogin(t, app, "teacher", "doe_j@school.test").cookie
+		}, fiber.StatusBadRequest},
+		{"state tampered", "teacher", "doe_j@school.test", func(t *testing.T, app *fiber.App, provider *oidcTestProvider, repos *repository.Repositories, login *oidcTestLogin) {
+			login.state += "x"
+		}, fiber.StatusBadRequest},
+		{"code of another login", "teacher", "doe_j@school.test", func(t *testing.T, app *fiber.App, provider *oidcTestProvider, repos *repository.Repositories, login *oidcTestLogin) {
+			// Its challenge was made from the other login's verifier, so the provider won't exchange it
+			login.code = provider.startLogin(t, app, "teacher", "doe_j@school.test").code
+		}, fiber.StatusUnauthorized},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			provider := newOIDCTestProvider(t)
+			app, repos := oidcTestApp(t)
+
+			login := provider.startLogin(t, app, test.userType, test.email)
+			if test.tamper != nil {
+				test.tamper(t, app, provider, repos, &login)
+			}
+			if status := login.finish(t, app); status != test.want {
+				t.Errorf("got %d, want %d", status, test.want)
+			}
+		})
+	}
+
+	newOIDCTestProvider(t)
+	app, _ := oidcTestApp(t)
+	if res, _ := app.Test(httptest.NewRequest("GET", "/api/v1/oidc/login?usertype=parent", nil)); res.StatusCode != fiber.StatusBadRequest {
+		t.Errorf("unknown role: got %d, want %d", res.StatusCode, fiber.StatusBadRequest)
+	}
+}
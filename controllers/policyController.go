@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/SowinskiBraeden/school-management-api/models"
+	"github.com/SowinskiBraeden/school-management-api/repository"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GetPasswordPolicy returns the password policy for a role, or the default until one is configured
+func GetPasswordPolicy(ctx context.Context, repos *repository.Repositories, role string) models.PasswordPolicy {
+	var policy models.PasswordPolicy
+	if err := repos.PasswordPolicies.FindOne(ctx, bson.M{"role": role}, nil, &policy); err != nil {
+		return models.DefaultPasswordPolicy(role)
+	}
+	return policy
+}
+
+func PasswordPolicies(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Ensure Authenticated admin sent request
+	if verified, _ := AuthenticateUser(c, 3); !verified {
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
+	}
+
+	var policies []models.PasswordPolicy
+	for _, role := range models.PasswordPolicyRoles {
+		policies = append(policies, GetPasswordPolicy(ctx, Repos(c), role))
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success":  true,
+		"message":  "successfully retrieved password policies",
+		"policies": policies,
+	})
+}
+
+func UpdatePasswordPolicy(c *fiber.Ctx) error {
+	var policy models.PasswordPolicy
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+
+	if fieldErrs := ParseRequest(c, &policy); len(fieldErrs) > 0 {
+		cancel()
+		return InvalidRequest(fieldErrs)
+	}
+
+	// Ensure Authenticated admin sent request
+	if verified, _ := AuthenticateUser(c, 3); !verified {
+		cancel()
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
+	}
+
+	if !models.ValidPasswordPolicyRole(policy.Role) {
+		cancel()
+		return NewError(fiber.StatusBadRequest, CodeInvalidRequest, "role must be one of student, teacher or admin")
+	}
+
+	if policy.MinLength < 1 || policy.HistoryDepth < 0 || policy.MaxAgeDays < 0 {
+		cancel()
+		return NewError(fiber.StatusBadRequest, CodeInvalidRequest, "invalid password policy")
+	}
+
+	update_time, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	update := bson.M{
+		"$set": bson.M{
+			"minlength":      policy.MinLength,
+			"requireupper":   policy.RequireUpper,
+			"requirelower":   policy.RequireLower,
+			"requirenumber":  policy.RequireNumber,
+			"requirespecial": policy.RequireSpecial,
+			"historydepth":   policy.HistoryDepth,
+			"blockcommon":    policy.BlockCommon,
+			"maxagedays":     policy.MaxAgeDays,
+			"updated_at":     update_time,
+		},
+		"$setOnInsert": bson.M{
+			"_id": primitive.NewObjectID(),
+		},
+	}
+
+	_, updateErr := AuditedUpdateOne(
+		c, ctx, Repos(c).PasswordPolicies,
+		bson.M{"role": policy.Role},
+		update,
+		true,
+	)
+	if updateErr != nil {
+		cancel()
+		return InternalError("the password policy could not be updated", updateErr)
+	}
+	defer cancel()
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "successfully updated password policy",
+	})
+}
@@ -0,0 +1,406 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SowinskiBraeden/school-management-api/models"
+	"github.com/SowinskiBraeden/school-management-api/repository"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+/*
+	Removing a user is a soft delete. The account is marked as
+	removed with a reason and a retention period, after which
+	it can't log in or be found, and can be restored until the
+	period ends. The purge job then deletes it for good along
+	with what hangs off it: the photo and the student's
+	contacts. Its ID stays in the ledger of IDs given, so it is
+	never given to another account. A student's locker is
+	released with the student document, lockers don't record
+	who holds them. An account under a legal hold isn't purged
+	until it's released.
+
+	Removing, restoring and purging are made as steps, see
+	repository.Atomic, in a transaction where the database has
+	them and as a saga on a standalone MongoDB, so either every
+	change is made or none. A purge step that deletes a record
+	is undone by putting it back as it was.
+*/
+
+const (
+	defaultRetentionDays = 30
+	maxRetentionDays     = 365
+)
+
+var userTypeNames = map[int]string{1: "student", 2: "teacher", 3: "admin"}
+
+func retentionDays() int {
+	days, err := strconv.Atoi(os.Getenv("REMOVAL_RETENTION_DAYS"))
+	if err != nil || days < 1 {
+		return defaultRetentionDays
+	}
+	return days
+}
+
+// account is what removing, restoring and purging need to know about any type of user
+type account struct {
+	Removed   *models.Removal
+	PhotoName string
+	Contacts  []string
+}
+
+func accountOf(ctx context.Context, repos *repository.Repositories, userType int, uid string) (account, error) {
+	switch userType {
+	case 3:
+		admin, err := repos.Admins.Get(ctx, uid)
+		return account{Removed: admin.Removed}, err
+	case 2:
+		teacher, err := repos.Teachers.Get(ctx, uid)
+		return account{Removed: teacher.Removed, PhotoName: teacher.School.PhotoName}, err
+	}
+	student, err := repos.Students.Get(ctx, uid)
+	return account{Removed: student.Removed, PhotoName: student.School.PhotoName, Contacts: student.Personal.Contacts}, err
+}
+
+// accountKeys returns the sid, tid or aid of every account the filter picks
+func accountKeys(ctx context.Context, repos *repository.Repositories, userType int, filter repository.AccountFilter) ([]string, error) {
+	keys := []string{}
+	switch userType {
+	case 3:
+		admins, err := repos.Admins.Find(ctx, filter)
+		for _, admin := range admins {
+			keys = append(keys, admin.AID)
+		}
+		return keys, err
+	case 2:
+		teachers, err := repos.Teachers.Find(ctx, filter)
+		for _, teacher := range teachers {
+			keys = append(keys, teacher.School.TID)
+		}
+		return keys, err
+	}
+	students, err := repos.Students.Find(ctx, filter)
+	for _, student := range students {
+		keys = append(keys, student.School.SID)
+	}
+	return keys, err
+}
+
+func ignoreNotFound(err error) error {
+	if err == repository.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// softRemove marks an account as removed, c is nil when the system removes it
+func softRemove(c *fiber.Ctx, ctx context.Context, repos *repository.Repositories, userType int, uid string, removal models.Removal) error {
+	// The account is one write, AuditedUpdate undoes it if it can't be audited
+	return repos.Atomic(ctx, repository.Step{
+		Do: func(ctx context.Context) error {
+			user, err := accountOf(ctx, repos, userType, uid)
+			if err == nil && user.Removed != nil {
+				err = repository.ErrNotFound
+			}
+			if err != nil {
+				return err
+			}
+
+			return AuditedUpdate(c, ctx, repos.Account(userType), uid,
+				bson.M{"$set": bson.M{"removed": removal, "updated_at": removal.Removed_at}},
+			)
+		},
+	})
+}
+
+// RemovalRequest is the body of RemoveStudent, RemoveTeacher and RemoveAdmin
+type RemovalRequest struct {
+	UID    string `json:"uid" validate:"required,uid"`
+	Reason string `json:"reason" validate:"notblank"`
+	Days   int    `json:"days"` // until the account is purged, defaults to REMOVAL_RETENTION_DAYS
+}
+
+// RemoveAccount soft removes the account for days, or REMOVAL_RETENTION_DAYS when days is 0, on behalf of the admin aid
+func RemoveAccount(c *fiber.Ctx, ctx context.Context, userType int, uid string, aid string, reason string, days int) (models.Removal, error) {
+	if days == 0 {
+		days = retentionDays()
+	}
+	if days < 1 || days > maxRetentionDays {
+		return models.Removal{}, NewError(fiber.StatusBadRequest, CodeInvalidRequest, "days must be between 1 and 365")
+	}
+
+	if userType == 3 && uid == aid {
+		return models.Removal{}, NewError(fiber.StatusBadRequest, CodeCannotRemoveSelf, "an admin can't remove themselves")
+	}
+
+	var removal models.Removal
+	removal.Reason = strings.TrimSpace(reason)
+	removal.Removed_by = aid
+	removal.Removed_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	removal.Purge_at = removal.Removed_at.Add(time.Duration(days) * 24 * time.Hour)
+
+	err := softRemove(c, ctx, Repos(c), userType, uid, removal)
+	if err == repository.ErrNotFound {
+		return removal, NotFound(userTypeNames[userType])
+	}
+	if err != nil {
+		return removal, InternalError("the "+userTypeNames[userType]+" could not be removed", err)
+	}
+	return removal, nil
+}
+
+func removeUser(c *fiber.Ctx, userType int) error {
+	var data RemovalRequest
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
+		return InvalidRequest(fieldErrs)
+	}
+
+	// Ensure Authenticated admin sent request
+	verified, aid := AuthenticateUser(c, 3)
+	if !verified {
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
+	}
+
+	removal, err := RemoveAccount(c, ctx, userType, data.UID, aid, data.Reason, data.Days)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "successfully removed " + userTypeNames[userType],
+		"removed": removal,
+	})
+}
+
+func restoreUser(c *fiber.Ctx, userType int) error {
+	var data UIDRequest
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
+		return InvalidRequest(fieldErrs)
+	}
+
+	// Ensure Authenticated admin sent request
+	if verified, _ := AuthenticateUser(c, 3); !verified {
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
+	}
+
+	update_time, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	repos := Repos(c)
+	err := repos.Atomic(ctx, repository.Step{
+		Do: func(ctx context.Context) error {
+			user, err := accountOf(ctx, repos, userType, data.UID)
+			if err == nil && (user.Removed == nil || !user.Removed.Purge_at.After(time.Now())) {
+				err = repository.ErrNotFound
+			}
+			if err != nil {
+				return err
+			}
+
+			return AuditedUpdate(c, ctx, repos.Account(userType), data.UID,
+				bson.M{"$set": bson.M{"removed": nil, "updated_at": update_time}},
+			)
+		},
+	})
+	if err == repository.ErrNotFound {
+		return NewError(fiber.StatusNotFound, notFoundCode(userTypeNames[userType]), "no removed "+userTypeNames[userType]+" to restore, it may have been purged")
+	}
+	if err != nil {
+		return InternalError("the "+userTypeNames[userType]+" could not be restored", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "successfully restored " + userTypeNames[userType],
+	})
+}
+
+func RestoreStudent(c *fiber.Ctx) error {
+	return restoreUser(c, 1)
+}
+
+func RestoreTeacher(c *fiber.Ctx) error {
+	return restoreUser(c, 2)
+}
+
+func RestoreAdmin(c *fiber.Ctx) error {
+	return restoreUser(c, 3)
+}
+
+// purgeStep deletes a record, the undo puts it back as it was. A record already gone is skipped when missingOK
+func purgeStep(store repository.Store, key string, missingOK bool) repository.Step {
+	var before bson.M
+	return repository.Step{
+		Do: func(ctx context.Context) error {
+			var err error
+			if before, err = store.Document(ctx, key); err != nil {
+				if missingOK && err == repository.ErrNotFound {
+					return nil
+				}
+				return err
+			}
+			return AuditedDelete(nil, ctx, store, key)
+		},
+		Undo: func(ctx context.Context) error {
+			if before == nil {
+				return nil
+			}
+			return store.Restore(ctx, key, before)
+		},
+	}
+}
+
+// purgeUser deletes a removed account and everything that hangs off it, either all of it or none
+func purgeUser(ctx context.Context, repos *repository.Repositories, userType int, uid string) error {
+	user, err := accountOf(ctx, repos, userType, uid)
+	if err != nil {
+		return err
+	}
+
+	// The account is checked again in the transaction, where there is one, so a restore made meanwhile isn't undone
+	steps := []repository.Step{{
+		Do: func(ctx context.Context) error {
+			user, err := accountOf(ctx, repos, userType, uid)
+			if err != nil {
+				return err
+			}
+			if user.Removed == nil || user.Removed.Purge_at.After(time.Now()) {
+				return errors.New("the account changed while it was being purged")
+			}
+			return nil
+		},
+	}}
+	if user.PhotoName != "" {
+		steps = append(steps, purgeStep(repos.Photos, user.PhotoName, true))
+	}
+	for _, id := range user.Contacts {
+		steps = append(steps, purgeStep(repos.Contacts, id, true))
+	}
+	steps = append(steps, purgeStep(repos.Account(userType), uid, false))
+	return repos.Atomic(ctx, steps...)
+}
+
+// PurgeRemoved deletes every removed account whose retention period has ended, unless it is under a legal hold
+func PurgeRemoved(repos *repository.Repositories) (map[string][]string, []string) {
+	ctx, cancel := context.WithTimeout(SystemContext(context.Background(), repos), 30*time.Minute)
+	defer cancel()
+
+	purged := map[string][]string{}
+	failures := []string{}
+
+	held, err := heldUIDs(ctx, repos)
+	if err != nil {
+		return purged, []string{"legal holds: " + err.Error()}
+	}
+
+	for userType := 1; userType <= 3; userType++ {
+		uids, err := accountKeys(ctx, repos, userType, repository.AccountFilter{
+			State:       repository.RemovedAccounts,
+			PurgeDue:    time.Now(),
+			ExcludeKeys: held,
+		})
+		if err != nil {
+			failures = append(failures, userTypeNames[userType]+"s: "+err.Error())
+			continue
+		}
+
+		purged[userTypeNames[userType]] = []string{}
+		for _, uid := range uids {
+			if err := purgeUser(ctx, repos, userType, uid); err != nil {
+				failures = append(failures, userTypeNames[userType]+" "+uid+": "+err.Error())
+				continue
+			}
+			purged[userTypeNames[userType]] = append(purged[userTypeNames[userType]], uid)
+		}
+	}
+	return purged, failures
+}
+
+func StartRemovalPurge(repos *repository.Repositories) {
+	go func() {
+		ticker := time.NewTicker(24 * time.Hour)
+		for range ticker.C {
+			purged, failures := PurgeRemoved(repos)
+			log.Printf("Purged %d students, %d teachers, %d admins", len(purged["student"]), len(purged["teacher"]), len(purged["admin"]))
+			for _, failure := range failures {
+				log.Printf("Failed to purge %s", failure)
+			}
+		}
+	}()
+}
+
+func RemovedUsers(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Ensure Authenticated admin sent request
+	if verified, _ := AuthenticateUser(c, 3); !verified {
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
+	}
+
+	repos := Repos(c)
+	filter := repository.AccountFilter{State: repository.RemovedAccounts}
+	accounts := []fiber.Map{}
+
+	students, err := repos.Students.Find(ctx, filter)
+	for _, student := range students {
+		accounts = append(accounts, fiber.Map{
+			"usertype":  "student",
+			"uid":       student.School.SID,
+			"firstname": student.Personal.FirstName,
+			"lastname":  student.Personal.LastName,
+			"removed":   student.Removed,
+		})
+	}
+
+	var teachers []models.Teacher
+	if err == nil {
+		teachers, err = repos.Teachers.Find(ctx, filter)
+	}
+	for _, teacher := range teachers {
+		accounts = append(accounts, fiber.Map{
+			"usertype":  "teacher",
+			"uid":       teacher.School.TID,
+			"firstname": teacher.Personal.FirstName,
+			"lastname":  teacher.Personal.LastName,
+			"removed":   teacher.Removed,
+		})
+	}
+
+	var admins []models.Admin
+	if err == nil {
+		admins, err = repos.Admins.Find(ctx, filter)
+	}
+	for _, admin := range admins {
+		accounts = append(accounts, fiber.Map{
+			"usertype":  "admin",
+			"uid":       admin.AID,
+			"firstname": admin.FirstName,
+			"lastname":  admin.LastName,
+			"removed":   admin.Removed,
+		})
+	}
+
+	if err != nil {
+		return InternalError("failed to find removed accounts", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "successfully retrieved removed accounts",
+		"result":  accounts,
+	})
+}
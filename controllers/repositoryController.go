@@ -0,0 +1,49 @@
+package controllers
+
+import (
+	"context"
+
+	"github.com/SowinskiBraeden/school-management-api/repository"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+/*
+	Handlers reach every record the API keeps, from students to
+	the audit log, through the repositories given to the request
+	by Inject. The server injects the repositories for STORAGE,
+	tests can inject repository.NewMemory() to run an endpoint
+	without a database.
+
+	Work done outside of a request, like the directory sync, is
+	given the repositories through its context by SystemContext.
+*/
+
+type systemReposKey struct{}
+
+// Inject gives every request the repositories, it must be the first handler
+func Inject(repos *repository.Repositories) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Locals("repositories", repos)
+		return c.Next()
+	}
+}
+
+// Repos returns the repositories given to the request by Inject
+func Repos(c *fiber.Ctx) *repository.Repositories {
+	return c.Locals("repositories").(*repository.Repositories)
+}
+
+// SystemContext gives writes made outside of a request the repositories to audit them in
+func SystemContext(ctx context.Context, repos *repository.Repositories) context.Context {
+	return context.WithValue(ctx, systemReposKey{}, repos)
+}
+
+// reposOf returns the repositories of the request, or of the context for writes made by the system
+func reposOf(c *fiber.Ctx, ctx context.Context) *repository.Repositories {
+	if c != nil {
+		return Repos(c)
+	}
+	repos, _ := ctx.Value(systemReposKey{}).(*repository.Repositories)
+	return repos
+}
@@ -0,0 +1,266 @@
+package rest
+
+import (
+	"context"
+	"time"
+
+	. "github.com/SowinskiBraeden/school-management-api/controllers"
+	"github.com/SowinskiBraeden/school-management-api/models"
+	"github.com/SowinskiBraeden/school-management-api/repository"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// studentContact returns the student's contact with the id in the path, a contact of another student is not found
+func studentContact(c *fiber.Ctx, ctx context.Context, sid string) (models.Contact, error) {
+	repos := Repos(c)
+	student, findErr := repos.Students.Get(ctx, sid)
+	if findErr == nil && student.Removed != nil {
+		findErr = repository.ErrNotFound
+	}
+	if findErr != nil {
+		return models.Contact{}, FindError("student", findErr)
+	}
+
+	id := c.Params("id")
+	for _, contactID := range student.Personal.Contacts {
+		if contactID != id {
+			continue
+		}
+		contact, findErr := repos.Contacts.Get(ctx, id)
+		if findErr != nil {
+			return contact, FindError("contact", findErr)
+		}
+		return contact, nil
+	}
+	return models.Contact{}, NotFound("contact")
+}
+
+func StudentContacts(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	sid, err := pathUID(c, "sid")
+	if err != nil {
+		return err
+	}
+
+	// Admins can see the contacts of every student, a student only their own
+	if verified, _ := AuthenticateUser(c, 3); !verified {
+		verified, own := AuthenticateUser(c, 1)
+		if !verified {
+			return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin or the student can perform this action")
+		}
+		if own != sid {
+			return NewError(fiber.StatusForbidden, CodeForbidden, "a student can only see their own contacts")
+		}
+	}
+
+	repos := Repos(c)
+	student, findErr := repos.Students.Get(ctx, sid)
+	if findErr == nil && student.Removed != nil {
+		findErr = repository.ErrNotFound
+	}
+	if findErr != nil {
+		return FindError("student", findErr)
+	}
+
+	contacts := []models.Contact{}
+	for _, id := range student.Personal.Contacts {
+		contact, findErr := repos.Contacts.Get(ctx, id)
+		if findErr == repository.ErrNotFound {
+			continue
+		}
+		if findErr != nil {
+			return FindError("contact", findErr)
+		}
+		contacts = append(contacts, contact)
+	}
+	RecordAccess(c, []string{"contacts"}, sid)
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"result":  contacts,
+	})
+}
+
+func AddStudentContact(c *fiber.Ctx) error {
+	var data CreateContactRequest
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	sid, err := pathUID(c, "sid")
+	if err != nil {
+		return err
+	}
+
+	// The student is the one in the path, a uid in the body is ignored
+	fieldErrs := ReadRequest(c, &data)
+	data.UID = sid
+	if len(fieldErrs) == 0 {
+		fieldErrs = ValidateRequest(&data)
+	}
+	if len(fieldErrs) > 0 {
+		return InvalidRequest(fieldErrs)
+	}
+
+	// Ensure Authenticated admin sent request
+	if verified, _ := AuthenticateUser(c, 3); !verified {
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
+	}
+
+	contact, err := InsertContact(c, ctx, data)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"success": true,
+		"message": "successfully inserted contact to student",
+		"result":  contact,
+	})
+}
+
+// ContactPatch is the body of PatchStudentContact, only the fields sent are changed
+type ContactPatch struct {
+	FirstName  *string  `json:"firstname" validate:"omitempty,notblank"`
+	MiddleName *string  `json:"middlename"`
+	LastName   *string  `json:"lastname" validate:"omitempty,notblank"`
+	HomePhone  *float64 `json:"homephone"`
+	WorkPhone  *float64 `json:"workphone"`
+	Email      *string  `json:"email" validate:"omitempty,mailaddress"`
+	Address    *string  `json:"address" validate:"omitempty,notblank"`
+	City       *string  `json:"city"`
+	Province   *string  `json:"province"`
+	Postal     *string  `json:"postal"`
+	Relation   *string  `json:"relation" validate:"omitempty,notblank"`
+	Priority   *float64 `json:"priority"`
+}
+
+func PatchStudentContact(c *fiber.Ctx) error {
+	var data ContactPatch
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	sid, err := pathUID(c, "sid")
+	if err != nil {
+		return err
+	}
+
+	if fieldErrs := ParsePatch(c, &data); len(fieldErrs) > 0 {
+		return InvalidRequest(fieldErrs)
+	}
+
+	// Ensure Authenticated admin sent request
+	if verified, _ := AuthenticateUser(c, 3); !verified {
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
+	}
+
+	contact, err := studentContact(c, ctx, sid)
+	if err != nil {
+		return err
+	}
+
+	update_time, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	set := bson.M{"updated_at": update_time}
+	if data.FirstName != nil {
+		set["firstname"] = *data.FirstName
+	}
+	if data.MiddleName != nil {
+		set["middlename"] = *data.MiddleName
+	}
+	if data.LastName != nil {
+		set["lastname"] = *data.LastName
+	}
+	if data.HomePhone != nil {
+		set["homephone"] = models.EncryptedNumber(*data.HomePhone)
+	}
+	if data.WorkPhone != nil {
+		set["workphone"] = models.EncryptedNumber(*data.WorkPhone)
+	}
+	if data.Email != nil {
+		set["email"] = models.Searchable(*data.Email)
+	}
+	if data.Address != nil {
+		set["address"] = models.Encrypted(*data.Address)
+	}
+	if data.City != nil {
+		set["city"] = *data.City
+	}
+	if data.Province != nil {
+		set["province"] = *data.Province
+	}
+	if data.Postal != nil {
+		set["postal"] = models.Encrypted(*data.Postal)
+	}
+	if data.Relation != nil {
+		set["relation"] = *data.Relation
+	}
+	if data.Priority != nil {
+		set["priotrity"] = *data.Priority // stored under the name of the model's field
+	}
+
+	repos := Repos(c)
+	if updateErr := AuditedUpdate(c, ctx, repos.Contacts, contact.ID.Hex(), bson.M{"$set": set}); updateErr != nil {
+		return UpdateError("contact", updateErr)
+	}
+
+	contact, findErr := repos.Contacts.Get(ctx, contact.ID.Hex())
+	if findErr != nil {
+		return FindError("contact", findErr)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "successfully updated contact",
+		"result":  contact,
+	})
+}
+
+func DeleteStudentContact(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	sid, err := pathUID(c, "sid")
+	if err != nil {
+		return err
+	}
+
+	// Ensure Authenticated admin sent request
+	if verified, _ := AuthenticateUser(c, 3); !verified {
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
+	}
+
+	contact, err := studentContact(c, ctx, sid)
+	if err != nil {
+		return err
+	}
+
+	// The contact is taken off the student and deleted together, so the student never lists a contact that is gone
+	id := contact.ID.Hex()
+	repos := Repos(c)
+	deleteErr := repos.Atomic(ctx,
+		repository.Step{
+			Do: func(ctx context.Context) error {
+				return AuditedUpdate(c, ctx, repos.Students, sid, bson.M{"$pull": bson.M{"personal.contacts": id}})
+			},
+			Undo: func(ctx context.Context) error {
+				return repos.Students.Update(ctx, sid, bson.M{"$push": bson.M{"personal.contacts": id}})
+			},
+		},
+		repository.Step{
+			Do: func(ctx context.Context) error {
+				return AuditedDelete(c, ctx, repos.Contacts, id)
+			},
+		},
+	)
+	if deleteErr != nil {
+		return InternalError("the contact could not be deleted", deleteErr)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "successfully deleted contact",
+	})
+}
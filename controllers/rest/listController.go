@@ -0,0 +1,577 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/SowinskiBraeden/school-management-api/controllers"
+	"github.com/SowinskiBraeden/school-management-api/models"
+	"github.com/SowinskiBraeden/school-management-api/repository"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+/*
+	The list routes return records a page at a time. Each page
+	ends with next, the cursor to send for the page after it,
+	which is null on the last page. Records are sorted by the
+	field named in sort, "-" in front sorts them descending,
+	and fields picks the fields of each record to return, by
+	their dotted JSON names. Photos are large, so students and
+	teachers only come with theirs when a photo field is picked.
+*/
+
+// defaultListLimit is the records in a page when the query doesn't say, it can ask for up to 200
+const defaultListLimit = 50
+
+// ListRequest is the query every list route takes
+type ListRequest struct {
+	Sort   string `json:"sort" query:"sort"`     // field to sort by, "-" in front sorts descending
+	Cursor string `json:"cursor" query:"cursor"` // next of the page before
+	Limit  int    `json:"limit" query:"limit" validate:"omitempty,min=1,max=200"`
+	Fields string `json:"fields" query:"fields"` // comma separated, every field when empty
+}
+
+// StudentFilter is the query of ListStudents
+type StudentFilter struct {
+	Search    string `json:"search" query:"search"` // start of a first or last name
+	Email     string `json:"email" query:"email"`   // start of the school email
+	Grade     string `json:"grade" query:"grade" validate:"omitempty,numeric"`
+	Homeroom  string `json:"homeroom" query:"homeroom"`
+	YOG       string `json:"yog" query:"yog" validate:"omitempty,numeric"`
+	Disabled  string `json:"disabled" query:"disabled" validate:"omitempty,oneof=true false"`
+	HasLocker string `json:"haslocker" query:"haslocker" validate:"omitempty,oneof=true false"`
+	Locker    string `json:"locker" query:"locker"` // number of the locker
+}
+
+// TeacherFilter is the query of ListTeachers
+type TeacherFilter struct {
+	Search   string `json:"search" query:"search"`
+	Email    string `json:"email" query:"email"`
+	Homeroom string `json:"homeroom" query:"homeroom"`
+	Disabled string `json:"disabled" query:"disabled" validate:"omitempty,oneof=true false"`
+}
+
+// AdminFilter is the query of ListAdmins
+type AdminFilter struct {
+	Search   string `json:"search" query:"search"`
+	Email    string `json:"email" query:"email"`
+	Disabled string `json:"disabled" query:"disabled" validate:"omitempty,oneof=true false"`
+}
+
+// ContactFilter is the query of ListContacts
+type ContactFilter struct {
+	Search   string `json:"search" query:"search"`
+	SID      string `json:"sid" query:"sid" validate:"omitempty,uid"` // only the contacts of this student
+	Relation string `json:"relation" query:"relation"`
+}
+
+// LockerFilter is the query of ListLockers
+type LockerFilter struct {
+	Search   string `json:"search" query:"search"` // start of the locker number
+	Type     string `json:"type" query:"type"`
+	Assigned string `json:"assigned" query:"assigned" validate:"omitempty,oneof=true false"`
+}
+
+// The fields each list can be sorted by, and their path in the stored record
+var (
+	studentSorts = map[string]string{"sid": "school.sid", "firstname": "personal.firstname", "lastname": "personal.lastname", "gradelevel": "school.gradelevel", "yog": "school.yog", "created_at": "created_at"}
+	teacherSorts = map[string]string{"tid": "school.tid", "firstname": "personal.firstname", "lastname": "personal.lastname", "homeroom": "school.homeroom", "created_at": "created_at"}
+	adminSorts   = map[string]string{"aid": "aid", "firstname": "firstname", "lastname": "lastname", "created_at": "created_at"}
+	contactSorts = map[string]string{"firstname": "firstname", "lastname": "lastname", "priority": "priotrity", "created_at": "created_at"}
+	lockerSorts  = map[string]string{"number": "lockernumber", "type": "lockertype", "created_at": "created_at"}
+)
+
+// projection is the fields of each record a list returns, every field when empty
+type projection []string
+
+// wants reports whether the field, or a field inside it, is picked
+func (p projection) wants(field string) bool {
+	if len(p) == 0 {
+		return false
+	}
+	for _, path := range p {
+		if path == field || strings.HasPrefix(path, field+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// toMap returns a record as the JSON object it is sent as
+func toMap(record interface{}) fiber.Map {
+	data, _ := json.Marshal(record)
+	var object fiber.Map
+	json.Unmarshal(data, &object)
+	return object
+}
+
+// jsonLookup finds the value at a dotted path of a JSON object
+func jsonLookup(object fiber.Map, path string) (interface{}, bool) {
+	var value interface{} = object
+	for _, key := range strings.Split(path, ".") {
+		var parent map[string]interface{}
+		switch object := value.(type) {
+		case fiber.Map:
+			parent = object
+		case map[string]interface{}:
+			parent = object
+		default:
+			return nil, false
+		}
+		var ok bool
+		if value, ok = parent[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// check returns an error for each field that isn't one of the sample record's
+func (p projection) check(sample fiber.Map) []FieldError {
+	fieldErrs := []FieldError{}
+	for _, path := range p {
+		if _, ok := jsonLookup(sample, path); !ok {
+			fieldErrs = append(fieldErrs, FieldError{Field: "fields", Rule: "unknown", Message: path + " isn't a field of the records"})
+		}
+	}
+	return fieldErrs
+}
+
+// apply returns the picked fields of a record
+func (p projection) apply(record fiber.Map) fiber.Map {
+	if len(p) == 0 {
+		return record
+	}
+	picked := fiber.Map{}
+	for _, path := range p {
+		value, ok := jsonLookup(record, path)
+		if !ok {
+			continue
+		}
+		keys := strings.Split(path, ".")
+		parent := picked
+		for _, key := range keys[:len(keys)-1] {
+			child, ok := parent[key].(fiber.Map)
+			if !ok {
+				child = fiber.Map{}
+				parent[key] = child
+			}
+			parent = child
+		}
+		parent[keys[len(keys)-1]] = value
+	}
+	return picked
+}
+
+// listPage reads the query of a list route into filter and a repository query for the page, sample is a
+// record with every field that can be picked
+func listPage(c *fiber.Ctx, filter interface{}, sorts map[string]string, sample fiber.Map) (repository.ListQuery, projection, error) {
+	var page ListRequest
+	query := repository.ListQuery{Limit: defaultListLimit}
+
+	if err := c.QueryParser(&page); err != nil {
+		return query, nil, InvalidRequest([]FieldError{{Field: "limit", Rule: "type", Message: "limit must be a whole number"}})
+	}
+	if err := c.QueryParser(filter); err != nil {
+		return query, nil, InvalidRequest([]FieldError{{Field: "", Rule: "query", Message: "the query couldn't be read"}})
+	}
+	fieldErrs := append(ValidateRequest(&page), ValidateRequest(filter)...)
+
+	if page.Sort != "" {
+		name := strings.TrimPrefix(page.Sort, "-")
+		path, ok := sorts[name]
+		if !ok {
+			names := []string{}
+			for name := range sorts {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			fieldErrs = append(fieldErrs, FieldError{Field: "sort", Rule: "oneof", Message: "sort must be one of: " + strings.Join(names, ", ")})
+		}
+		query.Sort, query.Descending = path, strings.HasPrefix(page.Sort, "-")
+	}
+
+	fields := projection{}
+	for _, field := range strings.Split(page.Fields, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields = append(fields, field)
+		}
+	}
+	fieldErrs = append(fieldErrs, fields.check(sample)...)
+
+	if len(fieldErrs) > 0 {
+		return query, nil, InvalidRequest(fieldErrs)
+	}
+	if page.Limit > 0 {
+		query.Limit = page.Limit
+	}
+	query.After = page.Cursor
+	query.Equal, query.NotEqual = bson.M{}, bson.M{}
+	return query, fields, nil
+}
+
+// nameSearch matches records where every word searched for starts the first or last name
+func nameSearch(search string, firstName string, lastName string) []repository.Prefix {
+	prefixes := []repository.Prefix{}
+	for _, word := range strings.Fields(search) {
+		prefixes = append(prefixes, repository.Prefix{Fields: []string{firstName, lastName}, Text: word})
+	}
+	return prefixes
+}
+
+// filterDisabled adds to the query's filters to pick accounts by whether they are disabled, an account
+// without the field isn't
+func filterDisabled(query repository.ListQuery, disabled string, path string) {
+	switch disabled {
+	case "true":
+		query.Equal[path] = true
+	case "false":
+		query.NotEqual[path] = true
+	}
+}
+
+// listed sends a page of records
+func listed(c *fiber.Ctx, records []fiber.Map, next string) error {
+	var cursor interface{}
+	if next != "" {
+		cursor = next
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"result":  records,
+		"next":    cursor,
+	})
+}
+
+// listError returns the error for a list that couldn't be read
+func listError(records string, err error) error {
+	if err == repository.ErrInvalidCursor {
+		return InvalidRequest([]FieldError{{Field: "cursor", Rule: "cursor", Message: "cursor must be the next of a page of this list"}})
+	}
+	return InternalError("the "+records+" could not be listed", err)
+}
+
+func ListStudents(c *fiber.Ctx) error {
+	var filter StudentFilter
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	sample := toMap(models.Student{})
+	sample["photo"] = toMap(models.Photo{})
+	query, fields, err := listPage(c, &filter, studentSorts, sample)
+	if err != nil {
+		return err
+	}
+
+	// Ensure Authenticated admin sent request
+	if verified, _ := AuthenticateUser(c, 3); !verified {
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
+	}
+
+	repos := Repos(c)
+	query.Equal["removed"] = nil
+	query.Prefixes = nameSearch(filter.Search, "personal.firstname", "personal.lastname")
+	if filter.Email != "" {
+		query.Prefixes = append(query.Prefixes, repository.Prefix{Fields: []string{"account.schoolemail"}, Text: filter.Email})
+	}
+	if filter.Grade != "" {
+		grade, _ := strconv.ParseFloat(filter.Grade, 64)
+		query.Equal["school.gradelevel"] = grade
+	}
+	if filter.Homeroom != "" {
+		query.Equal["school.homeroom"] = filter.Homeroom
+	}
+	if filter.YOG != "" {
+		yog, _ := strconv.Atoi(filter.YOG)
+		query.Equal["school.yog"] = yog
+	}
+	filterDisabled(query, filter.Disabled, "account.accountdisabled")
+	switch filter.HasLocker {
+	case "true":
+		query.NotEqual["school.locker"] = ""
+	case "false":
+		query.Equal["school.locker"] = ""
+	}
+	if filter.Locker != "" {
+		locker, findErr := repos.Lockers.FindByNumber(ctx, filter.Locker)
+		if findErr != nil && findErr != repository.ErrNotFound {
+			return FindError("locker", findErr)
+		}
+		// No student has a locker that doesn't exist
+		query.Equal["school.locker"] = locker.ID.Hex()
+		if findErr != nil {
+			query.Keys = []string{}
+		}
+	}
+
+	students, next, listErr := repos.Students.List(ctx, query)
+	if listErr != nil {
+		return listError("students", listErr)
+	}
+
+	records := []fiber.Map{}
+	sids := []string{}
+	for _, student := range students {
+		record := toMap(student)
+		if fields.wants("photo") {
+			record["photo"] = nil
+			if photo, findErr := repos.Photos.Get(ctx, student.School.PhotoName); findErr == nil {
+				record["photo"] = toMap(photo)
+			}
+		}
+		records = append(records, fields.apply(record))
+		sids = append(sids, student.School.SID)
+	}
+
+	parts := []string{"student"}
+	if fields.wants("photo") {
+		parts = append(parts, "photo")
+	}
+	RecordAccess(c, parts, sids...)
+
+	return listed(c, records, next)
+}
+
+func ListTeachers(c *fiber.Ctx) error {
+	var filter TeacherFilter
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	sample := toMap(models.Teacher{})
+	sample["photo"] = toMap(models.Photo{})
+	query, fields, err := listPage(c, &filter, teacherSorts, sample)
+	if err != nil {
+		return err
+	}
+
+	// Ensure Authenticated admin sent request
+	if verified, _ := AuthenticateUser(c, 3); !verified {
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
+	}
+
+	repos := Repos(c)
+	query.Equal["removed"] = nil
+	query.Prefixes = nameSearch(filter.Search, "personal.firstname", "personal.lastname")
+	if filter.Email != "" {
+		query.Prefixes = append(query.Prefixes, repository.Prefix{Fields: []string{"account.schoolemail"}, Text: filter.Email})
+	}
+	if filter.Homeroom != "" {
+		query.Equal["school.homeroom"] = filter.Homeroom
+	}
+	filterDisabled(query, filter.Disabled, "account.accountdisabled")
+
+	teachers, next, listErr := repos.Teachers.List(ctx, query)
+	if listErr != nil {
+		return listError("teachers", listErr)
+	}
+
+	records := []fiber.Map{}
+	for _, teacher := range teachers {
+		record := toMap(teacher)
+		if fields.wants("photo") {
+			record["photo"] = nil
+			if photo, findErr := repos.Photos.Get(ctx, teacher.School.PhotoName); findErr == nil {
+				record["photo"] = toMap(photo)
+			}
+		}
+		records = append(records, fields.apply(record))
+	}
+
+	return listed(c, records, next)
+}
+
+func ListAdmins(c *fiber.Ctx) error {
+	var filter AdminFilter
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	query, fields, err := listPage(c, &filter, adminSorts, toMap(models.Admin{}))
+	if err != nil {
+		return err
+	}
+
+	// Ensure Authenticated admin sent request
+	if verified, _ := AuthenticateUser(c, 3); !verified {
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
+	}
+
+	query.Equal["removed"] = nil
+	query.Prefixes = nameSearch(filter.Search, "firstname", "lastname")
+	if filter.Email != "" {
+		query.Prefixes = append(query.Prefixes, repository.Prefix{Fields: []string{"schoolemail"}, Text: filter.Email})
+	}
+	filterDisabled(query, filter.Disabled, "accountdisabled")
+
+	admins, next, listErr := Repos(c).Admins.List(ctx, query)
+	if listErr != nil {
+		return listError("admins", listErr)
+	}
+
+	records := []fiber.Map{}
+	for _, admin := range admins {
+		records = append(records, fields.apply(toMap(admin)))
+	}
+
+	return listed(c, records, next)
+}
+
+func ListContacts(c *fiber.Ctx) error {
+	var filter ContactFilter
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	sample := toMap(models.Contact{})
+	sample["sid"] = ""
+	query, fields, err := listPage(c, &filter, contactSorts, sample)
+	if err != nil {
+		return err
+	}
+
+	// Ensure Authenticated admin sent request
+	if verified, _ := AuthenticateUser(c, 3); !verified {
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
+	}
+
+	// Contacts are linked from their student, the contacts of removed students are left out with them
+	repos := Repos(c)
+	removed, findErr := repos.Students.Find(ctx, repository.AccountFilter{State: repository.RemovedAccounts})
+	if findErr != nil {
+		return FindError("student", findErr)
+	}
+	for _, student := range removed {
+		query.ExcludeKeys = append(query.ExcludeKeys, student.Personal.Contacts...)
+	}
+
+	query.Prefixes = nameSearch(filter.Search, "firstname", "lastname")
+	if filter.Relation != "" {
+		query.Equal["relation"] = filter.Relation
+	}
+	if filter.SID != "" {
+		query.Keys = []string{}
+		student, findErr := repos.Students.Get(ctx, filter.SID)
+		if findErr != nil && findErr != repository.ErrNotFound {
+			return FindError("student", findErr)
+		}
+		if findErr == nil && student.Removed == nil {
+			query.Keys = append(query.Keys, student.Personal.Contacts...)
+		}
+	}
+
+	contacts, next, listErr := repos.Contacts.List(ctx, query)
+	if listErr != nil {
+		return listError("contacts", listErr)
+	}
+
+	// Each contact is listed with the sid of the student it belongs to
+	ids := []string{}
+	for _, contact := range contacts {
+		ids = append(ids, contact.ID.Hex())
+	}
+	owners := map[string]string{}
+	students, findErr := repos.Students.Find(ctx, repository.AccountFilter{Contacts: ids})
+	if findErr != nil {
+		return FindError("student", findErr)
+	}
+	for _, student := range students {
+		for _, id := range student.Personal.Contacts {
+			owners[id] = student.School.SID
+		}
+	}
+
+	records := []fiber.Map{}
+	sids := []string{}
+	for _, contact := range contacts {
+		record := toMap(contact)
+		record["sid"] = owners[contact.ID.Hex()]
+		records = append(records, fields.apply(record))
+		if sid := owners[contact.ID.Hex()]; sid != "" {
+			sids = append(sids, sid)
+		}
+	}
+	RecordAccess(c, []string{"contacts"}, sids...)
+
+	return listed(c, records, next)
+}
+
+func ListLockers(c *fiber.Ctx) error {
+	var filter LockerFilter
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	sample := toMap(models.Locker{})
+	sample["sid"] = ""
+	query, fields, err := listPage(c, &filter, lockerSorts, sample)
+	if err != nil {
+		return err
+	}
+
+	// Ensure Authenticated admin sent request
+	if verified, _ := AuthenticateUser(c, 3); !verified {
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
+	}
+
+	if filter.Search != "" {
+		query.Prefixes = []repository.Prefix{{Fields: []string{"lockernumber"}, Text: filter.Search}}
+	}
+	if filter.Type != "" {
+		query.Equal["lockertype"] = filter.Type
+	}
+
+	// A locker is assigned when a student has it
+	repos := Repos(c)
+	if filter.Assigned != "" {
+		holding, findErr := repos.Students.Find(ctx, repository.AccountFilter{HoldsLocker: true})
+		if findErr != nil {
+			return FindError("student", findErr)
+		}
+		assigned := []string{}
+		for _, student := range holding {
+			assigned = append(assigned, student.School.Locker)
+		}
+		switch filter.Assigned {
+		case "true":
+			query.Keys = assigned
+		case "false":
+			query.ExcludeKeys = assigned
+		}
+	}
+
+	lockers, next, listErr := repos.Lockers.List(ctx, query)
+	if listErr != nil {
+		return listError("lockers", listErr)
+	}
+
+	// Each locker is listed with the sid of the student holding it
+	ids := []string{}
+	for _, locker := range lockers {
+		ids = append(ids, locker.ID.Hex())
+	}
+	holders := map[string]string{}
+	students, findErr := repos.Students.Find(ctx, repository.AccountFilter{Lockers: ids})
+	if findErr != nil {
+		return FindError("student", findErr)
+	}
+	for _, student := range students {
+		holders[student.School.Locker] = student.School.SID
+	}
+
+	records := []fiber.Map{}
+	sids := []string{}
+	for _, locker := range lockers {
+		record := toMap(locker)
+		record["sid"] = holders[locker.ID.Hex()]
+		records = append(records, fields.apply(record))
+		if sid := holders[locker.ID.Hex()]; sid != "" {
+			sids = append(sids, sid)
+		}
+	}
+	RecordAccess(c, []string{"locker"}, sids...)
+
+	return listed(c, records, next)
+}
@@ -0,0 +1,95 @@
+package rest
+
+import (
+	"context"
+	"time"
+
+	. "github.com/SowinskiBraeden/school-management-api/controllers"
+	"github.com/SowinskiBraeden/school-management-api/repository"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func GetLocker(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Ensure Authenticated admin sent request
+	if verified, _ := AuthenticateUser(c, 3); !verified {
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
+	}
+
+	repos := Repos(c)
+	locker, findErr := repos.Lockers.FindByNumber(ctx, c.Params("number"))
+	if findErr != nil {
+		return FindError("locker", findErr)
+	}
+
+	// Reading a locker reads the record of the student holding it
+	holders, findErr := repos.Students.Find(ctx, repository.AccountFilter{Lockers: []string{locker.ID.Hex()}})
+	if findErr != nil {
+		return FindError("student", findErr)
+	}
+	sids := []string{}
+	for _, holder := range holders {
+		sids = append(sids, holder.School.SID)
+	}
+	RecordAccess(c, []string{"locker"}, sids...)
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"result":  locker,
+	})
+}
+
+// LockerPatch is the body of PatchLocker, only the fields sent are changed
+type LockerPatch struct {
+	LockerCombo *string `json:"lockercombo" validate:"omitempty,notblank"`
+	LockerType  *string `json:"lockertype" validate:"omitempty,notblank"`
+}
+
+func PatchLocker(c *fiber.Ctx) error {
+	var data LockerPatch
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if fieldErrs := ParsePatch(c, &data); len(fieldErrs) > 0 {
+		return InvalidRequest(fieldErrs)
+	}
+
+	// Ensure Authenticated admin sent request
+	if verified, _ := AuthenticateUser(c, 3); !verified {
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
+	}
+
+	repos := Repos(c)
+	locker, findErr := repos.Lockers.FindByNumber(ctx, c.Params("number"))
+	if findErr != nil {
+		return FindError("locker", findErr)
+	}
+
+	update_time, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	set := bson.M{"updated_at": update_time}
+	if data.LockerCombo != nil {
+		set["lockercombo"] = *data.LockerCombo
+	}
+	if data.LockerType != nil {
+		set["lockertype"] = *data.LockerType
+	}
+
+	if updateErr := AuditedUpdate(c, ctx, repos.Lockers, locker.ID.Hex(), bson.M{"$set": set}); updateErr != nil {
+		return UpdateError("locker", updateErr)
+	}
+
+	locker, findErr = repos.Lockers.Get(ctx, locker.ID.Hex())
+	if findErr != nil {
+		return FindError("locker", findErr)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "successfully updated locker",
+		"result":  locker,
+	})
+}
@@ -0,0 +1,250 @@
+package rest
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/SowinskiBraeden/school-management-api/controllers"
+	"github.com/SowinskiBraeden/school-management-api/models"
+	"github.com/SowinskiBraeden/school-management-api/repository"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+/*
+	Version 2 of the API names records by their path, like
+	/students/{sid}, and the method says what is done to them:
+	GET reads a record, PATCH changes it and DELETE removes it.
+	A PATCH body holds only the fields to change, any number of
+	them, which are all validated before one update sets them
+	together, so either every field changes or none do. The
+	version 1 routes keep working alongside these.
+*/
+
+// pathUID returns the account ID in the path parameter, or an error when it isn't a valid one
+func pathUID(c *fiber.Ctx, param string) (string, error) {
+	uid := c.Params(param)
+	if !ValidUID(uid) {
+		return "", NewError(fiber.StatusBadRequest, CodeInvalidUID, "the "+param+" isn't a valid ID, check it was typed correctly")
+	}
+	return uid, nil
+}
+
+// RemovalQuery is the query of the DELETE routes, which remove an account until it is purged
+type RemovalQuery struct {
+	Reason string `json:"reason" query:"reason" validate:"notblank"`
+	Days   int    `json:"days" query:"days"` // until the account is purged, defaults to REMOVAL_RETENTION_DAYS
+}
+
+// removeAccount soft removes the account named by the path parameter
+func removeAccount(c *fiber.Ctx, userType int, param string, name string) error {
+	var query RemovalQuery
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	uid, err := pathUID(c, param)
+	if err != nil {
+		return err
+	}
+
+	if err := c.QueryParser(&query); err != nil {
+		return InvalidRequest([]FieldError{{Field: "days", Rule: "type", Message: "days must be a whole number"}})
+	}
+	if fieldErrs := ValidateRequest(&query); len(fieldErrs) > 0 {
+		return InvalidRequest(fieldErrs)
+	}
+
+	// Ensure Authenticated admin sent request
+	verified, aid := AuthenticateUser(c, 3)
+	if !verified {
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
+	}
+
+	removal, err := RemoveAccount(c, ctx, userType, uid, aid, query.Reason, query.Days)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "successfully removed " + name,
+		"result":  removal,
+	})
+}
+
+func GetStudent(c *fiber.Ctx) error {
+	sid, err := pathUID(c, "sid")
+	if err != nil {
+		return err
+	}
+
+	// Admins can see every student, a student only themselves
+	if verified, _ := AuthenticateUser(c, 3); !verified {
+		verified, own := AuthenticateUser(c, 1)
+		if !verified {
+			return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin or the student can perform this action")
+		}
+		if own != sid {
+			return NewError(fiber.StatusForbidden, CodeForbidden, "a student can only see their own record")
+		}
+	}
+
+	record, err := StudentRecord(c, sid)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"result":  record,
+	})
+}
+
+// errLockerTaken is returned inside the update of PatchStudent when another student holds the locker
+var errLockerTaken = errors.New("the locker is already assigned to another student")
+
+// StudentPatch is the body of PatchStudent, only the fields sent are changed
+type StudentPatch struct {
+	FirstName    *string  `json:"firstname" validate:"omitempty,notblank"`
+	MiddleName   *string  `json:"middlename"`
+	LastName     *string  `json:"lastname" validate:"omitempty,notblank"`
+	GradeLevel   *float64 `json:"gradelevel" validate:"omitempty,min=0,max=12"`
+	Homeroom     *string  `json:"homeroom" validate:"omitempty,notblank"`
+	LockerNumber *string  `json:"lockernumber" validate:"omitempty,notblank"`
+	YOG          *int     `json:"yog" validate:"omitempty,min=1900,max=2100"`
+	PEN          *string  `json:"pen" validate:"omitempty,pen"`
+	Address      *string  `json:"address" validate:"omitempty,notblank"`
+	City         *string  `json:"city" validate:"omitempty,notblank"`
+	Province     *string  `json:"province" validate:"omitempty,notblank"`
+	Postal       *string  `json:"postal" validate:"omitempty,notblank"`
+	Email        *string  `json:"email" validate:"omitempty,mailaddress"` // used once it has been verified
+}
+
+func PatchStudent(c *fiber.Ctx) error {
+	var data StudentPatch
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	sid, err := pathUID(c, "sid")
+	if err != nil {
+		return err
+	}
+
+	if fieldErrs := ParsePatch(c, &data); len(fieldErrs) > 0 {
+		return InvalidRequest(fieldErrs)
+	}
+
+	// Ensure Authenticated admin sent request
+	if verified, _ := AuthenticateUser(c, 3); !verified {
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
+	}
+
+	repos := Repos(c)
+	student, findErr := repos.Students.Get(ctx, sid)
+	if findErr == nil && student.Removed != nil {
+		findErr = repository.ErrNotFound
+	}
+	if findErr != nil {
+		return FindError("student", findErr)
+	}
+
+	update_time, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	set := bson.M{"updated_at": update_time}
+	if data.FirstName != nil {
+		set["personal.firstname"] = *data.FirstName
+	}
+	if data.MiddleName != nil {
+		set["personal.middlename"] = *data.MiddleName
+	}
+	if data.LastName != nil {
+		set["personal.lastname"] = *data.LastName
+	}
+	if data.GradeLevel != nil {
+		set["school.gradelevel"] = *data.GradeLevel
+	}
+	if data.Homeroom != nil {
+		set["school.homeroom"] = *data.Homeroom
+	}
+	var lockerID string
+	if data.LockerNumber != nil {
+		locker, findErr := repos.Lockers.FindByNumber(ctx, *data.LockerNumber)
+		if findErr != nil {
+			return FindError("locker", findErr)
+		}
+		lockerID = locker.ID.Hex()
+		set["school.locker"] = lockerID
+	}
+	if data.YOG != nil {
+		set["school.yog"] = *data.YOG
+	}
+	if data.PEN != nil {
+		set["school.pen"] = *data.PEN
+	}
+	if data.Address != nil {
+		set["personal.address"] = models.Encrypted(*data.Address)
+	}
+	if data.City != nil {
+		set["personal.city"] = *data.City
+	}
+	if data.Province != nil {
+		set["personal.province"] = *data.Province
+	}
+	if data.Postal != nil {
+		set["personal.postal"] = models.Encrypted(*data.Postal)
+	}
+	if data.Email != nil {
+		set["account.pendingemail"] = models.Encrypted(*data.Email)
+	}
+
+	// A locker can only be held by one student, it is checked in the same transaction as the update
+	steps := []repository.Step{}
+	if lockerID != "" && lockerID != student.School.Locker {
+		steps = append(steps, repository.Step{Do: func(ctx context.Context) error {
+			holders, err := repos.Students.Find(ctx, repository.AccountFilter{State: repository.AllAccounts, Lockers: []string{lockerID}})
+			if err != nil {
+				return err
+			}
+			if len(holders) > 0 {
+				return errLockerTaken
+			}
+			return nil
+		}})
+	}
+	steps = append(steps, repository.Step{Do: func(ctx context.Context) error {
+		return AuditedUpdate(c, ctx, repos.Students, sid, bson.M{"$set": set})
+	}})
+
+	updateErr := repos.Atomic(ctx, steps...)
+	if updateErr == errLockerTaken {
+		return NewError(fiber.StatusConflict, CodeLockerTaken, "the locker is already assigned to another student")
+	}
+	if updateErr == repository.ErrDuplicateKey {
+		return NewError(fiber.StatusConflict, CodePENTaken, "the pen is already assigned to another student")
+	}
+	if updateErr != nil {
+		return UpdateError("student", updateErr)
+	}
+
+	if data.Email != nil {
+		if sent := SendVerification(Repos(c), sid, 1, student.Personal.FirstName, *data.Email); !sent {
+			return NewError(fiber.StatusBadGateway, CodeEmailNotSent, "the student was updated, but the verification email could not be sent")
+		}
+	}
+
+	student, findErr = repos.Students.Get(ctx, sid)
+	if findErr != nil {
+		return FindError("student", findErr)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "successfully updated student",
+		"result":  student,
+	})
+}
+
+func DeleteStudent(c *fiber.Ctx) error {
+	return removeAccount(c, 1, "sid", "student")
+}
@@ -0,0 +1,149 @@
+package rest
+
+import (
+	"context"
+	"time"
+
+	. "github.com/SowinskiBraeden/school-management-api/controllers"
+	"github.com/SowinskiBraeden/school-management-api/repository"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func GetTeacher(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tid, err := pathUID(c, "tid")
+	if err != nil {
+		return err
+	}
+
+	// Admins can see every teacher, a teacher only themselves
+	if verified, _ := AuthenticateUser(c, 3); !verified {
+		verified, own := AuthenticateUser(c, 2)
+		if !verified {
+			return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin or the teacher can perform this action")
+		}
+		if own != tid {
+			return NewError(fiber.StatusForbidden, CodeForbidden, "a teacher can only see their own record")
+		}
+	}
+
+	repos := Repos(c)
+	teacher, findErr := repos.Teachers.Get(ctx, tid)
+	if findErr == nil && teacher.Removed != nil {
+		findErr = repository.ErrNotFound
+	}
+	if findErr != nil {
+		return FindError("teacher", findErr)
+	}
+
+	record := fiber.Map{"teacher": teacher, "photo": nil}
+	if photo, findErr := repos.Photos.Get(ctx, teacher.School.PhotoName); findErr == nil {
+		record["photo"] = photo
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"result":  record,
+	})
+}
+
+// TeacherPatch is the body of PatchTeacher, only the fields sent are changed
+type TeacherPatch struct {
+	FirstName  *string `json:"firstname" validate:"omitempty,notblank"`
+	MiddleName *string `json:"middlename"`
+	LastName   *string `json:"lastname" validate:"omitempty,notblank"`
+	Homeroom   *string `json:"homeroom" validate:"omitempty,notblank"`
+	Address    *string `json:"address" validate:"omitempty,notblank"`
+	City       *string `json:"city" validate:"omitempty,notblank"`
+	Province   *string `json:"province" validate:"omitempty,notblank"`
+	Postal     *string `json:"postal" validate:"omitempty,notblank"`
+	Email      *string `json:"email" validate:"omitempty,mailaddress"` // used once it has been verified
+}
+
+func PatchTeacher(c *fiber.Ctx) error {
+	var data TeacherPatch
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tid, err := pathUID(c, "tid")
+	if err != nil {
+		return err
+	}
+
+	if fieldErrs := ParsePatch(c, &data); len(fieldErrs) > 0 {
+		return InvalidRequest(fieldErrs)
+	}
+
+	// Ensure Authenticated admin sent request
+	if verified, _ := AuthenticateUser(c, 3); !verified {
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
+	}
+
+	repos := Repos(c)
+	teacher, findErr := repos.Teachers.Get(ctx, tid)
+	if findErr == nil && teacher.Removed != nil {
+		findErr = repository.ErrNotFound
+	}
+	if findErr != nil {
+		return FindError("teacher", findErr)
+	}
+
+	update_time, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	set := bson.M{"updated_at": update_time}
+	if data.FirstName != nil {
+		set["personal.firstname"] = *data.FirstName
+	}
+	if data.MiddleName != nil {
+		set["personal.middlename"] = *data.MiddleName
+	}
+	if data.LastName != nil {
+		set["personal.lastname"] = *data.LastName
+	}
+	if data.Homeroom != nil {
+		set["school.homeroom"] = *data.Homeroom
+	}
+	if data.Address != nil {
+		set["personal.address"] = *data.Address
+	}
+	if data.City != nil {
+		set["personal.city"] = *data.City
+	}
+	if data.Province != nil {
+		set["personal.province"] = *data.Province
+	}
+	if data.Postal != nil {
+		set["personal.postal"] = *data.Postal
+	}
+	if data.Email != nil {
+		set["account.pendingemail"] = *data.Email
+	}
+
+	if updateErr := AuditedUpdate(c, ctx, repos.Teachers, tid, bson.M{"$set": set}); updateErr != nil {
+		return UpdateError("teacher", updateErr)
+	}
+
+	if data.Email != nil {
+		if sent := SendVerification(Repos(c), tid, 2, teacher.Personal.FirstName, *data.Email); !sent {
+			return NewError(fiber.StatusBadGateway, CodeEmailNotSent, "the teacher was updated, but the verification email could not be sent")
+		}
+	}
+
+	teacher, findErr = repos.Teachers.Get(ctx, tid)
+	if findErr != nil {
+		return FindError("teacher", findErr)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "successfully updated teacher",
+		"result":  teacher,
+	})
+}
+
+func DeleteTeacher(c *fiber.Ctx) error {
+	return removeAccount(c, 2, "tid", "teacher")
+}
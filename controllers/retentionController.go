@@ -0,0 +1,592 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SowinskiBraeden/school-management-api/models"
+	"github.com/SowinskiBraeden/school-management-api/repository"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+	The retention job runs daily and removes records that are
+	past the retention rule for their type. Records are either
+	purged or archived, which moves them to <collection>_archive
+	in the same transaction they are deleted in. Anything linked
+	to a user under a legal hold is kept until the hold is
+	released. Each run is saved as a report of what it removed.
+
+	Graduated students are removed through the soft delete, so
+	they can be restored and are purged with their contacts and
+	photo by the removal purge. The audit log is only ever cut
+	from its oldest end, so the entries left still form an
+	unbroken chain, and the last entry removed is kept in the
+	report as the anchor the chain is verified from.
+*/
+
+const retentionBatchSize = 500
+
+var retaining sync.Mutex
+
+// retentionTarget is where a type of record is kept and how its age and owners are found
+type retentionTarget struct {
+	collection string
+	dateField  string
+	uidFields  []string // fields holding the sid, tid or aid a legal hold applies to
+	filter     bson.M   // only records matching this are ever removed
+}
+
+var retentionTargets = map[string]retentionTarget{
+	"attendance":      {"attendance", "created_at", []string{"sid"}, nil},
+	"login_attempts":  {"loginattempts", "created_at", []string{"uid"}, nil},
+	"access_log":      {"accesslog", "created_at", []string{"sid", "accessor"}, nil},
+	"access_alerts":   {"accessalerts", "created_at", []string{"accessor"}, nil},
+	"impersonations":  {"impersonations", "expires_at", []string{"uid", "aid"}, nil},
+	"export_requests": {"exports", "completed_at", []string{"sid"}, bson.M{"completed_at": bson.M{"$gt": time.Time{}}}},
+	"directory_syncs": {"directorysyncs", "started_at", nil, nil},
+	"key_rotations":   {"keyrotations", "started_at", nil, nil},
+}
+
+// heldUIDs lists every user under a legal hold
+func heldUIDs(ctx context.Context, repos *repository.Repositories) ([]string, error) {
+	var holds []models.LegalHold
+	if err := repos.LegalHolds.Find(ctx, bson.M{"released_at": time.Time{}}, nil, 0, &holds); err != nil {
+		return nil, err
+	}
+	held := []string{}
+	seen := map[string]bool{}
+	for _, hold := range holds {
+		if !seen[hold.UID] {
+			seen[hold.UID] = true
+			held = append(held, hold.UID)
+		}
+	}
+	return held, nil
+}
+
+// heldFilter matches records linked to a held user through any of the fields
+func heldFilter(fields []string, held []string) bson.M {
+	conditions := bson.A{}
+	for _, field := range fields {
+		conditions = append(conditions, bson.M{field: bson.M{"$in": held}})
+	}
+	if len(conditions) == 0 {
+		// Matches nothing, the records aren't linked to a user
+		return bson.M{"_id": bson.M{"$exists": false}}
+	}
+	return bson.M{"$or": conditions}
+}
+
+// archiveBatch moves up to a batch of matching records to the archive collection
+func archiveBatch(ctx context.Context, repos *repository.Repositories, records repository.RecordRepository, filter bson.M) (int64, error) {
+	archive := repos.Records(records.Name() + "_archive")
+	if archive == nil {
+		return 0, errors.New(records.Name() + " has no archive")
+	}
+
+	// The records are copied and then deleted, if deleting them fails the copies are deleted from the archive
+	var moved int64
+	ids := bson.A{}
+	err := repos.Atomic(ctx,
+		repository.Step{
+			Do: func(ctx context.Context) error {
+				var batch []bson.M
+				if err := records.Find(ctx, filter, nil, retentionBatchSize, &batch); err != nil || len(batch) == 0 {
+					return err
+				}
+
+				docs := make([]interface{}, len(batch))
+				for i, record := range batch {
+					docs[i] = record
+					ids = append(ids, record["_id"])
+				}
+				// A copy left by a run that stopped before deleting the records is replaced
+				if _, err := archive.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+					return err
+				}
+				return archive.InsertMany(ctx, docs)
+			},
+			Undo: func(ctx context.Context) error {
+				_, err := archive.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+				return err
+			},
+		},
+		repository.Step{
+			Do: func(ctx context.Context) error {
+				if len(ids) == 0 {
+					return nil
+				}
+				var err error
+				moved, err = records.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+				return err
+			},
+		},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return moved, nil
+}
+
+// removeRecords purges or archives every record matching the filter
+func removeRecords(ctx context.Context, repos *repository.Repositories, records repository.RecordRepository, filter bson.M, action string) (int64, error) {
+	if action != "archive" {
+		return records.DeleteMany(ctx, filter)
+	}
+
+	var total int64
+	for {
+		moved, err := archiveBatch(ctx, repos, records, filter)
+		total += moved
+		if err != nil || moved == 0 {
+			return total, err
+		}
+	}
+}
+
+func retainRecords(ctx context.Context, repos *repository.Repositories, target retentionTarget, result *models.RetentionResult, held []string, dryRun bool) error {
+	records := repos.Records(target.collection)
+
+	expired := bson.M{target.dateField: bson.M{"$lt": result.Cutoff}}
+	if target.filter != nil {
+		expired = bson.M{"$and": bson.A{expired, target.filter}}
+	}
+	holds := heldFilter(target.uidFields, held)
+
+	var err error
+	result.Held, err = records.Count(ctx, bson.M{"$and": bson.A{expired, holds}})
+	if err != nil {
+		return err
+	}
+
+	removable := bson.M{"$and": bson.A{expired, bson.M{"$nor": bson.A{holds}}}}
+	if dryRun {
+		result.Removed, err = records.Count(ctx, removable)
+		return err
+	}
+	result.Removed, err = removeRecords(ctx, repos, records, removable, result.Action)
+	return err
+}
+
+// retainAuditLog removes the oldest audit entries, stopping at the first one that must be kept
+func retainAuditLog(ctx context.Context, repos *repository.Repositories, result *models.RetentionResult, held []string, dryRun bool) (*models.AuditAnchor, error) {
+	var last models.AuditEntry
+	if err := repos.Audit.FindOne(ctx, bson.M{}, bson.D{{Key: "seq", Value: -1}}, &last); err != nil {
+		if err == repository.ErrNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	// The newest entry is always kept so the next one has something to chain onto
+	keepFrom := last.Seq
+	var first models.AuditEntry
+	err := repos.Audit.FindOne(ctx, bson.M{"$or": bson.A{
+		bson.M{"created_at": bson.M{"$gte": result.Cutoff}},
+		heldFilter([]string{"target", "actor"}, held),
+	}}, bson.D{{Key: "seq", Value: 1}}, &first)
+	if err == nil && first.Seq < keepFrom {
+		keepFrom = first.Seq
+	} else if err != nil && err != repository.ErrNotFound {
+		return nil, err
+	}
+
+	removable := bson.M{"seq": bson.M{"$lt": keepFrom}}
+	result.Held, err = repos.Audit.Count(ctx, bson.M{
+		"seq":        bson.M{"$gte": keepFrom},
+		"created_at": bson.M{"$lt": result.Cutoff},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if dryRun {
+		result.Removed, err = repos.Audit.Count(ctx, removable)
+		return nil, err
+	}
+
+	var anchor models.AuditEntry
+	if err := repos.Audit.FindOne(ctx, bson.M{"seq": keepFrom - 1}, nil, &anchor); err != nil {
+		if err == repository.ErrNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	result.Removed, err = removeRecords(ctx, repos, repos.Audit, removable, result.Action)
+	return &models.AuditAnchor{Seq: anchor.Seq, Hash: anchor.Hash}, err
+}
+
+// auditAnchor finds the hash the audit log chains on from when its oldest entries were removed
+func auditAnchor(ctx context.Context, repos *repository.Repositories, seq int64) (string, bool) {
+	var run models.RetentionRun
+	if err := repos.RetentionRuns.FindOne(ctx, bson.M{"auditanchor.seq": seq}, bson.D{{Key: "started_at", Value: -1}}, &run); err != nil {
+		return "", false
+	}
+	return run.AuditAnchor.Hash, true
+}
+
+// retainGraduatedStudents removes students whose graduation is past retention
+func retainGraduatedStudents(ctx context.Context, repos *repository.Repositories, result *models.RetentionResult, held []string, dryRun bool) error {
+	// Students graduate at the end of June of their year of graduation
+	lastYOG := result.Cutoff.Year()
+	if result.Cutoff.Before(time.Date(lastYOG, time.July, 1, 0, 0, 0, 0, time.UTC)) {
+		lastYOG--
+	}
+
+	var err error
+	result.Held, err = repos.Students.Count(ctx, repository.AccountFilter{GraduatedBy: lastYOG, Keys: held})
+	if err != nil {
+		return err
+	}
+
+	sids, err := accountKeys(ctx, repos, 1, repository.AccountFilter{GraduatedBy: lastYOG, ExcludeKeys: held})
+	if err != nil {
+		return err
+	}
+
+	var removal models.Removal
+	removal.Reason = "retention period ended"
+	removal.Removed_by = "system"
+	removal.Removed_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	removal.Purge_at = removal.Removed_at.Add(time.Duration(retentionDays()) * 24 * time.Hour)
+
+	result.UIDs = []string{}
+	for _, sid := range sids {
+		if !dryRun {
+			if err := softRemove(nil, ctx, repos, 1, sid, removal); err != nil {
+				return err
+			}
+		}
+		result.UIDs = append(result.UIDs, sid)
+		result.Removed++
+	}
+	return nil
+}
+
+// GetRetentionRule returns the retention rule for a type of record, or the default until one is configured
+func GetRetentionRule(ctx context.Context, repos *repository.Repositories, dataType string) models.RetentionRule {
+	var rule models.RetentionRule
+	if err := repos.RetentionRules.FindOne(ctx, bson.M{"datatype": dataType}, nil, &rule); err != nil {
+		return models.DefaultRetentionRule(dataType)
+	}
+	return rule
+}
+
+// ApplyRetention removes every record past the retention rule for its type, a dry run only reports them
+func ApplyRetention(repos *repository.Repositories, dryRun bool) (models.RetentionRun, error) {
+	var report models.RetentionRun
+	report.ID = primitive.NewObjectID()
+	report.DryRun = dryRun
+	report.Results = []models.RetentionResult{}
+	report.Errors = []string{}
+	report.Started_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+	if !retaining.TryLock() {
+		return report, errors.New("a retention run is already running")
+	}
+	defer retaining.Unlock()
+
+	ctx, cancel := context.WithTimeout(SystemContext(context.Background(), repos), 2*time.Hour)
+	defer cancel()
+
+	held, err := heldUIDs(ctx, repos)
+	if err != nil {
+		return report, err
+	}
+
+	for _, dataType := range models.RetentionDataTypes {
+		rule := GetRetentionRule(ctx, repos, dataType)
+		if rule.Days <= 0 {
+			continue
+		}
+
+		result := models.RetentionResult{
+			DataType: dataType,
+			Action:   rule.Action,
+			Cutoff:   report.Started_at.Add(-time.Duration(rule.Days) * 24 * time.Hour),
+		}
+
+		switch dataType {
+		case "graduated_students":
+			err = retainGraduatedStudents(ctx, repos, &result, held, dryRun)
+		case "audit_log":
+			report.AuditAnchor, err = retainAuditLog(ctx, repos, &result, held, dryRun)
+		default:
+			err = retainRecords(ctx, repos, retentionTargets[dataType], &result, held, dryRun)
+		}
+		if err != nil {
+			report.Errors = append(report.Errors, dataType+": "+err.Error())
+		}
+		report.Results = append(report.Results, result)
+	}
+
+	report.Finished_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	if dryRun {
+		return report, nil
+	}
+	if insertErr := repos.RetentionRuns.Insert(ctx, report); insertErr != nil {
+		return report, insertErr
+	}
+	return report, nil
+}
+
+func StartRetention(repos *repository.Repositories) {
+	go func() {
+		ticker := time.NewTicker(24 * time.Hour)
+		for range ticker.C {
+			report, err := ApplyRetention(repos, false)
+			if err != nil {
+				log.Printf("Retention run failed: %v", err)
+				continue
+			}
+			for _, result := range report.Results {
+				log.Printf("Retention %s: %d %sd, %d kept for legal holds", result.DataType, result.Removed, result.Action, result.Held)
+			}
+			for _, failure := range report.Errors {
+				log.Printf("Retention failed for %s", failure)
+			}
+		}
+	}()
+}
+
+func RetentionRules(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Ensure Authenticated admin sent request
+	if verified, _ := AuthenticateUser(c, 3); !verified {
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
+	}
+
+	var rules []models.RetentionRule
+	for _, dataType := range models.RetentionDataTypes {
+		rules = append(rules, GetRetentionRule(ctx, Repos(c), dataType))
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "successfully retrieved retention rules",
+		"result":  rules,
+	})
+}
+
+func UpdateRetentionRule(c *fiber.Ctx) error {
+	var rule models.RetentionRule
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if fieldErrs := ParseRequest(c, &rule); len(fieldErrs) > 0 {
+		return InvalidRequest(fieldErrs)
+	}
+
+	// Ensure Authenticated admin sent request
+	verified, aid := AuthenticateUser(c, 3)
+	if !verified {
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
+	}
+
+	if !models.ValidRetentionDataType(rule.DataType) {
+		return NewError(fiber.StatusBadRequest, CodeInvalidRequest, "datatype must be one of "+strings.Join(models.RetentionDataTypes, ", "))
+	}
+
+	if rule.Action == "" {
+		rule.Action = "purge"
+	}
+	if rule.Days < 0 || (rule.Action != "purge" && rule.Action != "archive") {
+		return NewError(fiber.StatusBadRequest, CodeInvalidRequest, "invalid retention rule")
+	}
+	if rule.DataType == "graduated_students" && rule.Action == "archive" {
+		return NewError(fiber.StatusBadRequest, CodeInvalidRequest, "graduated students can only be purged")
+	}
+
+	update_time, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	_, updateErr := AuditedUpdateOne(
+		c, ctx, Repos(c).RetentionRules,
+		bson.M{"datatype": rule.DataType},
+		bson.M{
+			"$set": bson.M{
+				"days":       rule.Days,
+				"action":     rule.Action,
+				"updated_by": aid,
+				"updated_at": update_time,
+			},
+			"$setOnInsert": bson.M{
+				"_id": primitive.NewObjectID(),
+			},
+		},
+		true,
+	)
+	if updateErr != nil {
+		return InternalError("the retention rule could not be updated", updateErr)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "successfully updated retention rule",
+	})
+}
+
+func RunRetention(c *fiber.Ctx) error {
+	var data DryRunRequest
+
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
+		return InvalidRequest(fieldErrs)
+	}
+
+	// Ensure Authenticated admin sent request
+	if verified, _ := AuthenticateUser(c, 3); !verified {
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
+	}
+
+	report, err := ApplyRetention(Repos(c), data.DryRun)
+	if err != nil {
+		return InternalError("the retention run failed", err).WithResult(report)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "successfully applied retention rules",
+		"result":  report,
+	})
+}
+
+func RetentionRuns(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Ensure Authenticated admin sent request
+	if verified, _ := AuthenticateUser(c, 3); !verified {
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
+	}
+
+	reports := []models.RetentionRun{}
+	if err := Repos(c).RetentionRuns.Find(ctx, bson.M{}, bson.D{{Key: "started_at", Value: -1}}, 50, &reports); err != nil {
+		return InternalError("failed to find retention runs", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "successfully retrieved retention runs",
+		"result":  reports,
+	})
+}
+
+// LegalHoldRequest is the body of PlaceLegalHold
+type LegalHoldRequest struct {
+	UID    string `json:"uid" validate:"required,uid"`
+	Reason string `json:"reason" validate:"notblank"`
+}
+
+func PlaceLegalHold(c *fiber.Ctx) error {
+	var data LegalHoldRequest
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
+		return InvalidRequest(fieldErrs)
+	}
+
+	// Ensure Authenticated admin sent request
+	verified, aid := AuthenticateUser(c, 3)
+	if !verified {
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
+	}
+
+	if userType, err := UserTypeOf(ctx, Repos(c), data.UID); err != nil || userType == 0 {
+		return NotFound("account")
+	}
+
+	var hold models.LegalHold
+	hold.ID = primitive.NewObjectID()
+	hold.UID = data.UID
+	hold.Reason = strings.TrimSpace(data.Reason)
+	hold.Placed_by = aid
+	hold.Placed_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+	if insertErr := AuditedInsertOne(c, ctx, Repos(c).LegalHolds, hold); insertErr != nil {
+		return InternalError("the legal hold could not be inserted", insertErr)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "successfully placed legal hold",
+		"result":  hold,
+	})
+}
+
+// ReleaseLegalHoldRequest is the body of ReleaseLegalHold
+type ReleaseLegalHoldRequest struct {
+	ID string `json:"id" validate:"required,objectid"`
+}
+
+func ReleaseLegalHold(c *fiber.Ctx) error {
+	var data ReleaseLegalHoldRequest
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
+		return InvalidRequest(fieldErrs)
+	}
+
+	// Ensure Authenticated admin sent request
+	verified, aid := AuthenticateUser(c, 3)
+	if !verified {
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
+	}
+
+	id, _ := primitive.ObjectIDFromHex(data.ID)
+
+	released_at, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	matched, updateErr := AuditedUpdateOne(c, ctx, Repos(c).LegalHolds,
+		bson.M{"_id": id, "released_at": time.Time{}},
+		bson.M{"$set": bson.M{"released_at": released_at, "released_by": aid}},
+		false,
+	)
+	if updateErr != nil {
+		return InternalError("the legal hold could not be released", updateErr)
+	}
+	if matched == 0 {
+		return NewError(fiber.StatusNotFound, notFoundCode("legal hold"), "no legal hold in place with that id")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "successfully released legal hold",
+	})
+}
+
+func LegalHolds(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Ensure Authenticated admin sent request
+	if verified, _ := AuthenticateUser(c, 3); !verified {
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
+	}
+
+	filter := bson.M{}
+	if uid := c.Query("uid"); uid != "" {
+		filter["uid"] = uid
+	}
+	if c.Query("active") == "true" {
+		filter["released_at"] = time.Time{}
+	}
+
+	holds := []models.LegalHold{}
+	if err := Repos(c).LegalHolds.Find(ctx, filter, bson.D{{Key: "placed_at", Value: -1}}, 0, &holds); err != nil {
+		return InternalError("failed to find legal holds", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "successfully retrieved legal holds",
+		"result":  holds,
+	})
+}
@@ -5,7 +5,6 @@ import (
 	"time"
 
 	. "github.com/SowinskiBraeden/school-management-api/controllers"
-	"github.com/SowinskiBraeden/school-management-api/models"
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt"
 	"go.mongodb.org/mongo-driver/bson"
@@ -18,57 +17,46 @@ import (
 	disbaled, etc.
 */
 
+// LockerComboRequest is the body of UpdateLockerCombo
+type LockerComboRequest struct {
+	LockerNumber   string `json:"lockernumber" validate:"required"`
+	NewLockerCombo string `json:"newlockercombo" validate:"required"`
+}
+
 func UpdateLockerCombo(c *fiber.Ctx) error {
-	var data map[string]string
+	var data LockerComboRequest
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 
-	if err := c.BodyParser(&data); err != nil {
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to parse body",
-			"error":   err,
-		})
+		return InvalidRequest(fieldErrs)
 	}
 
 	// Ensure Authenticated admin sent request
 	if verified, _ := AuthenticateUser(c, 3); !verified {
 		cancel()
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"success": false,
-			"message": "Unauthorized: only an admin can perform this action",
-		})
-	}
-
-	// Check locker number is included
-	if data["lockernumber"] == "" || data["newlockercombo"] == "" {
-		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "missing required fields",
-		})
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
 	}
 
 	update_time, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 	update := bson.M{
 		"$set": bson.M{
-			"lockercombo": data["newlockercombo"],
+			"lockercombo": data.NewLockerCombo,
 			"updated_at":  update_time,
 		},
 	}
 
-	_, updateErr := LockerCollection.UpdateOne(
-		ctx,
-		bson.M{"lockernumber": data["lockernumber"]},
-		update,
-	)
+	repos := Repos(c)
+	locker, findErr := repos.Lockers.FindByNumber(ctx, data.LockerNumber)
+	if findErr != nil {
+		cancel()
+		return FindError("locker", findErr)
+	}
+
+	updateErr := AuditedUpdate(c, ctx, repos.Lockers, locker.ID.Hex(), update)
 	if updateErr != nil {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "the locker could not be updated",
-			"error":   updateErr,
-		})
+		return UpdateError("locker", updateErr)
 	}
 	defer cancel()
 
@@ -78,17 +66,19 @@ func UpdateLockerCombo(c *fiber.Ctx) error {
 	})
 }
 
+// AdminNameRequest is the body of UpdateAdminName
+type AdminNameRequest struct {
+	FirstName string `json:"firstname" validate:"required"`
+	LastName  string `json:"lastname" validate:"required"`
+}
+
 func UpdateAdminName(c *fiber.Ctx) error {
-	var data map[string]string
+	var data AdminNameRequest
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 
-	if err := c.BodyParser(&data); err != nil {
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to parse body",
-			"error":   err,
-		})
+		return InvalidRequest(fieldErrs)
 	}
 
 	cookie := c.Cookies("jwt")
@@ -98,54 +88,30 @@ func UpdateAdminName(c *fiber.Ctx) error {
 	})
 	if err != nil {
 		cancel()
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"success": false,
-			"message": "not authorized",
-		})
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "not authorized")
 	}
 
 	claims := token.Claims.(*jwt.StandardClaims)
 
-	var admin models.Admin
-	findErr := AdminCollection.FindOne(ctx, bson.M{"aid": claims.Issuer}).Decode(&admin)
+	admin, findErr := Repos(c).Admins.Get(ctx, claims.Issuer)
 	if findErr != nil {
 		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "admin not found",
-		})
-	}
-
-	// Check required fields are included
-	if data["firstname"] == "" || data["lastname"] == "" {
-		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "missing required fields",
-		})
+		return FindError("admin", findErr)
 	}
 
 	update_time, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 	update := bson.M{
 		"$set": bson.M{
-			"firstname":  data["firstname"],
-			"lastname":   data["lastname"],
+			"firstname":  data.FirstName,
+			"lastname":   data.LastName,
 			"updated_at": update_time,
 		},
 	}
 
-	_, updateErr := TeacherCollection.UpdateOne(
-		ctx,
-		bson.M{"aid": claims.Issuer},
-		update,
-	)
+	updateErr := AuditedUpdate(c, ctx, Repos(c).Admins, admin.AID, update)
 	if updateErr != nil {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "the admin could not be updated",
-			"error":   updateErr,
-		})
+		return UpdateError("admin", updateErr)
 	}
 	defer cancel()
 
@@ -155,17 +121,18 @@ func UpdateAdminName(c *fiber.Ctx) error {
 	})
 }
 
+// AdminEmailRequest is the body of UpdateAdminEmail
+type AdminEmailRequest struct {
+	Email string `json:"email" validate:"required,mailaddress"`
+}
+
 func UpdateAdminEmail(c *fiber.Ctx) error {
-	var data map[string]string
+	var data AdminEmailRequest
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 
-	if err := c.BodyParser(&data); err != nil {
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to parse body",
-			"error":   err,
-		})
+		return InvalidRequest(fieldErrs)
 	}
 
 	cookie := c.Cookies("jwt")
@@ -175,53 +142,29 @@ func UpdateAdminEmail(c *fiber.Ctx) error {
 	})
 	if err != nil {
 		cancel()
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"success": false,
-			"message": "not authorized",
-		})
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "not authorized")
 	}
 
 	claims := token.Claims.(*jwt.StandardClaims)
 
-	var admin models.Admin
-	findErr := AdminCollection.FindOne(ctx, bson.M{"aid": claims.Issuer}).Decode(&admin)
+	admin, findErr := Repos(c).Admins.Get(ctx, claims.Issuer)
 	if findErr != nil {
 		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "admin not found",
-		})
-	}
-
-	// Check required fields are included
-	if data["email"] == "" {
-		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "missing required fields",
-		})
+		return FindError("admin", findErr)
 	}
 
 	update_time, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 	update := bson.M{
 		"$set": bson.M{
-			"email":      data["email"],
+			"email":      data.Email,
 			"updated_at": update_time,
 		},
 	}
 
-	_, updateErr := TeacherCollection.UpdateOne(
-		ctx,
-		bson.M{"aid": claims.Issuer},
-		update,
-	)
+	updateErr := AuditedUpdate(c, ctx, Repos(c).Admins, admin.AID, update)
 	if updateErr != nil {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "the admin could not be updated",
-			"error":   updateErr,
-		})
+		return UpdateError("admin", updateErr)
 	}
 	defer cancel()
 
@@ -232,16 +175,12 @@ func UpdateAdminEmail(c *fiber.Ctx) error {
 }
 
 func UpdateAdminPassword(c *fiber.Ctx) error {
-	var data map[string]string
+	var data PasswordRequest
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 
-	if err := c.BodyParser(&data); err != nil {
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to parse body",
-			"error":   err,
-		})
+		return InvalidRequest(fieldErrs)
 	}
 
 	cookie := c.Cookies("jwt")
@@ -251,70 +190,56 @@ func UpdateAdminPassword(c *fiber.Ctx) error {
 	})
 	if err != nil {
 		cancel()
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"success": false,
-			"message": "not authorized",
-		})
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "not authorized")
 	}
 
 	claims := token.Claims.(*jwt.StandardClaims)
 
-	var admin models.Admin
-	findErr := AdminCollection.FindOne(ctx, bson.M{"aid": claims.Issuer}).Decode(&admin)
+	admin, findErr := Repos(c).Admins.Get(ctx, claims.Issuer)
 	if findErr != nil {
 		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "admin not found",
-		})
+		return FindError("admin", findErr)
 	}
 
-	// Check required fields are included
-	if data["password"] == "" || data["newpassword1"] == "" || data["newpassword2"] == "" {
+	if !admin.ComparePasswords(data.Password) {
 		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "missing required fields",
-		})
+		return NewError(fiber.StatusUnauthorized, CodeIncorrectPassword, "Your password is incorrect")
 	}
 
-	if !admin.ComparePasswords(data["password"]) {
+	if data.NewPassword1 != data.NewPassword2 {
 		cancel()
-		return c.Status(fiber.StatusOK).JSON(fiber.Map{
-			"success": false,
-			"message": "Your password is incorrect",
-		})
+		return NewError(fiber.StatusBadRequest, CodePasswordMismatch, "Your new passwords must match")
 	}
 
-	if data["newpassword1"] != data["newpassword2"] {
+	policy := GetPasswordPolicy(ctx, Repos(c), "admin")
+	if policyErrs := admin.CheckPassword(policy, data.NewPassword1); len(policyErrs) > 0 {
 		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "Your new passwords must match",
-		})
+		return PasswordPolicyError("Your new password does not meet the password policy", "newpassword1", policyErrs)
 	}
 
+	newHash, hashErr := admin.HashPassword(data.NewPassword1)
+	if hashErr != nil {
+		cancel()
+		return InternalError("the password could not be hashed", hashErr)
+	}
 	update_time, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 	update := bson.M{
 		"$set": bson.M{
-			"password":     admin.HashPassword(data["newpassword1"]),
-			"temppassword": false, // If it were a temp password, its not now
-			"updated_at":   update_time,
+			"password":           newHash,
+			"temppassword":       false, // If it were a temp password, its not now
+			"passwordchanged_at": update_time,
+			"updated_at":         update_time,
+		},
+		// Only as many passwords as the policy checks are kept
+		"$push": bson.M{
+			"hashhistory": bson.M{"$each": []string{newHash}, "$slice": -policy.HistoryDepth},
 		},
 	}
 
-	_, updateErr := TeacherCollection.UpdateOne(
-		ctx,
-		bson.M{"aid": claims.Issuer},
-		update,
-	)
+	updateErr := AuditedUpdate(c, ctx, Repos(c).Admins, claims.Issuer, update)
 	if updateErr != nil {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "the admin password could not be updated",
-			"error":   updateErr,
-		})
+		return InternalError("the admin password could not be updated", updateErr)
 	}
 	defer cancel()
 
@@ -323,10 +248,7 @@ func UpdateAdminPassword(c *fiber.Ctx) error {
 	r := NewRequest([]string{receiver}, subject)
 
 	if sent := r.Send("./templates/selfPasswordChanged.html", map[string]string{"username": admin.FirstName}); !sent {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Could not send password to admins email",
-		})
+		return NewError(fiber.StatusBadGateway, CodeEmailNotSent, "Could not send password to admins email")
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
@@ -336,34 +258,18 @@ func UpdateAdminPassword(c *fiber.Ctx) error {
 }
 
 func RemoveStudentsDisabled(c *fiber.Ctx) error {
-	var data map[string]string
+	var data UIDRequest
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 
-	if err := c.BodyParser(&data); err != nil {
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to parse body",
-			"error":   err,
-		})
+		return InvalidRequest(fieldErrs)
 	}
 
 	// Ensure Authorized admin sent request
 	if verified, _ := AuthenticateUser(c, 3); !verified {
 		cancel()
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"success": false,
-			"message": "Unauthorized: only an admin can perform this action",
-		})
-	}
-
-	// Check required fields are included
-	if data["uid"] == "" {
-		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "missing required fields",
-		})
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
 	}
 
 	update_time, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
@@ -372,90 +278,61 @@ func RemoveStudentsDisabled(c *fiber.Ctx) error {
 			"account.accountdisabled": false,
 			"account.alerted":         false,
 			"account.attempts":        0,
+			"account.lockeduntil":     time.Time{},
+			"account.lockouts":        0,
 			"updated_at":              update_time,
 		},
 	}
 
-	result, updateErr := StudentCollection.UpdateOne(
-		ctx,
-		bson.M{"school.sid": data["uid"]},
-		update,
-	)
+	updateErr := AuditedUpdate(c, ctx, Repos(c).Students, data.UID, update)
 	if updateErr != nil {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "the student account could not be enabled",
-			"error":   updateErr,
-		})
+		return InternalError("the student account could not be enabled", updateErr)
 	}
 	defer cancel()
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"success": true,
 		"message": "successfully enabled student account",
-		"result":  result,
 	})
 }
 
 func RemoveTeachersDisabled(c *fiber.Ctx) error {
-	var data map[string]string
+	var data UIDRequest
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 
-	if err := c.BodyParser(&data); err != nil {
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to parse body",
-			"error":   err,
-		})
+		return InvalidRequest(fieldErrs)
 	}
 
 	// Ensure Authorized admin sent request
 	if verified, _ := AuthenticateUser(c, 3); !verified {
 		cancel()
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"success": false,
-			"message": "Unauthorized: only an admin can perform this action",
-		})
-	}
-
-	// Check required fields are included
-	if data["uid"] == "" {
-		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "missing required fields",
-		})
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
 	}
 
 	update_time, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 	update := bson.M{
 		"$set": bson.M{
 			"account.accountdisabled": false,
+			"account.alerted":         false,
 			"account.attempts":        0,
+			"account.lockeduntil":     time.Time{},
+			"account.lockouts":        0,
 			"updated_at":              update_time,
 		},
 	}
 
-	result, updateErr := TeacherCollection.UpdateOne(
-		ctx,
-		bson.M{"school.tid": data["uid"]},
-		update,
-	)
+	updateErr := AuditedUpdate(c, ctx, Repos(c).Teachers, data.UID, update)
 	if updateErr != nil {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "the teacher account could not be enabled",
-			"error":   updateErr,
-		})
+		return InternalError("the teacher account could not be enabled", updateErr)
 	}
 	defer cancel()
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"success": true,
 		"message": "successfully enabled teacher account",
-		"result":  result,
 	})
 }
@@ -5,70 +5,55 @@ import (
 	"time"
 
 	. "github.com/SowinskiBraeden/school-management-api/controllers"
+	"github.com/SowinskiBraeden/school-management-api/models"
 
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/bson"
 )
 
+// ContactNameRequest is the body of UpdateContactName
+type ContactNameRequest struct {
+	ID         string `json:"_id" validate:"required,objectid"`
+	FirstName  string `json:"firstname" validate:"required"`
+	MiddleName string `json:"middlename"`
+	LastName   string `json:"lastname" validate:"required"`
+}
+
 func UpdateContactName(c *fiber.Ctx) error {
-	var data map[string]string
+	var data ContactNameRequest
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 
-	if err := c.BodyParser(&data); err != nil {
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to parse body",
-			"error":   err,
-		})
+		return InvalidRequest(fieldErrs)
 	}
 
 	// Ensure Authenticated admin sent request
 	if verified, _ := AuthenticateUser(c, 3); !verified {
 		cancel()
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"success": false,
-			"message": "Unauthorized: only an admin can perform this action",
-		})
-	}
-
-	// Check required fields are included
-	if data["_id"] == "" || data["firstname"] == "" || data["lastname"] == "" {
-		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "missing required fields",
-		})
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
 	}
 
 	var middlename string = ""
 
-	if data["middlename"] != "" {
-		middlename = data["middlename"]
+	if data.MiddleName != "" {
+		middlename = data.MiddleName
 	}
 
 	update_time, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 	update := bson.M{
 		"$set": bson.M{
-			"firstname":  data["firstname"],
+			"firstname":  data.FirstName,
 			"middlename": middlename,
-			"lastname":   data["lastname"],
+			"lastname":   data.LastName,
 			"updated_at": update_time,
 		},
 	}
 
-	_, updateErr := ContactCollection.UpdateOne(
-		ctx,
-		bson.M{"_id": data["_id"]},
-		update,
-	)
+	updateErr := AuditedUpdate(c, ctx, Repos(c).Contacts, data.ID, update)
 	if updateErr != nil {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "the contact could not be updated",
-			"error":   updateErr,
-		})
+		return UpdateError("contact", updateErr)
 	}
 	defer cancel()
 
@@ -78,60 +63,45 @@ func UpdateContactName(c *fiber.Ctx) error {
 	})
 }
 
+// ContactAddressRequest is the body of UpdateContactAddress
+type ContactAddressRequest struct {
+	ID       string `json:"_id" validate:"required,objectid"`
+	Address  string `json:"address" validate:"required"`
+	City     string `json:"city" validate:"required"`
+	Province string `json:"province" validate:"required"`
+	Postal   string `json:"postal" validate:"required"`
+}
+
 func UpdateContactAddress(c *fiber.Ctx) error {
-	var data map[string]string
+	var data ContactAddressRequest
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 
-	if err := c.BodyParser(&data); err != nil {
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to parse body",
-			"error":   err,
-		})
+		return InvalidRequest(fieldErrs)
 	}
 
 	// Ensure Authenticated admin sent request
 	if verified, _ := AuthenticateUser(c, 3); !verified {
 		cancel()
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"success": false,
-			"message": "Unauthorized: only an admin can perform this action",
-		})
-	}
-
-	// Check required fields are included
-	if data["_id"] == "" || data["address"] == "" || data["city"] == "" || data["province"] == "" || data["postal"] == "" {
-		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "missing required fields",
-		})
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
 	}
 
 	update_time, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 	update := bson.M{
 		"$set": bson.M{
-			"address":    data["address"],
-			"city":       data["city"],
-			"province":   data["province"],
-			"postal":     data["postal"],
+			"address":    models.Encrypted(data.Address),
+			"city":       data.City,
+			"province":   data.Province,
+			"postal":     models.Encrypted(data.Postal),
 			"updated_at": update_time,
 		},
 	}
 
-	_, updateErr := ContactCollection.UpdateOne(
-		ctx,
-		bson.M{"_id": data["_id"]},
-		update,
-	)
+	updateErr := AuditedUpdate(c, ctx, Repos(c).Contacts, data.ID, update)
 	if updateErr != nil {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "the contact could not be updated",
-			"error":   updateErr,
-		})
+		return UpdateError("contact", updateErr)
 	}
 	defer cancel()
 
@@ -141,57 +111,39 @@ func UpdateContactAddress(c *fiber.Ctx) error {
 	})
 }
 
+// ContactPhoneRequest is the body of UpdateContactHomePhone and UpdateContactWorkPhone
+type ContactPhoneRequest struct {
+	ID        string `json:"_id" validate:"required,objectid"`
+	NewNumber string `json:"newnumber" validate:"required"`
+}
+
 func UpdateContactHomePhone(c *fiber.Ctx) error {
-	var data map[string]string
+	var data ContactPhoneRequest
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 
-	if err := c.BodyParser(&data); err != nil {
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to parse body",
-			"error":   err,
-		})
+		return InvalidRequest(fieldErrs)
 	}
 
 	// Ensure Authenticated admin sent request
 	if verified, _ := AuthenticateUser(c, 3); !verified {
 		cancel()
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"success": false,
-			"message": "Unauthorized: only an admin can perform this action",
-		})
-	}
-
-	// Check id of contact and new priority number is included
-	if data["_id"] == "" || data["newnumber"] == "" {
-		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "missing required fields",
-		})
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
 	}
 
 	update_time, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 	update := bson.M{
 		"$set": bson.M{
-			"homephone":  data["newnumber"],
+			"homephone":  models.Encrypted(data.NewNumber),
 			"updated_at": update_time,
 		},
 	}
 
-	_, updateErr := ContactCollection.UpdateOne(
-		ctx,
-		bson.M{"_id": data["_id"]},
-		update,
-	)
+	updateErr := AuditedUpdate(c, ctx, Repos(c).Contacts, data.ID, update)
 	if updateErr != nil {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "contact could not be updated",
-			"error":   updateErr,
-		})
+		return UpdateError("contact", updateErr)
 	}
 	defer cancel()
 
@@ -202,56 +154,32 @@ func UpdateContactHomePhone(c *fiber.Ctx) error {
 }
 
 func UpdateContactWorkPhone(c *fiber.Ctx) error {
-	var data map[string]string
+	var data ContactPhoneRequest
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 
-	if err := c.BodyParser(&data); err != nil {
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to parse body",
-			"error":   err,
-		})
+		return InvalidRequest(fieldErrs)
 	}
 
 	// Ensure Authenticated admin sent request
 	if verified, _ := AuthenticateUser(c, 3); !verified {
 		cancel()
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"success": false,
-			"message": "Unauthorized: only an admin can perform this action",
-		})
-	}
-
-	// Check id of contact and new priority number is included
-	if data["_id"] == "" || data["newnumber"] == "" {
-		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "missing required fields",
-		})
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
 	}
 
 	update_time, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 	update := bson.M{
 		"$set": bson.M{
-			"workphone":  data["newnumber"],
+			"workphone":  models.Encrypted(data.NewNumber),
 			"updated_at": update_time,
 		},
 	}
 
-	_, updateErr := ContactCollection.UpdateOne(
-		ctx,
-		bson.M{"_id": data["_id"]},
-		update,
-	)
+	updateErr := AuditedUpdate(c, ctx, Repos(c).Contacts, data.ID, update)
 	if updateErr != nil {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "contact could not be updated",
-			"error":   updateErr,
-		})
+		return UpdateError("contact", updateErr)
 	}
 	defer cancel()
 
@@ -261,57 +189,39 @@ func UpdateContactWorkPhone(c *fiber.Ctx) error {
 	})
 }
 
+// ContactEmailRequest is the body of UpdateContactEmail
+type ContactEmailRequest struct {
+	ID    string `json:"_id" validate:"required,objectid"`
+	Email string `json:"email" validate:"required"`
+}
+
 func UpdateContactEmail(c *fiber.Ctx) error {
-	var data map[string]string
+	var data ContactEmailRequest
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 
-	if err := c.BodyParser(&data); err != nil {
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to parse body",
-			"error":   err,
-		})
+		return InvalidRequest(fieldErrs)
 	}
 
 	// Ensure Authenticated admin sent request
 	if verified, _ := AuthenticateUser(c, 3); !verified {
 		cancel()
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"success": false,
-			"message": "Unauthorized: only an admin can perform this action",
-		})
-	}
-
-	// Check id of contact and new priority number is included
-	if data["_id"] == "" || data["email"] == "" {
-		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "missing required fields",
-		})
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
 	}
 
 	update_time, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 	update := bson.M{
 		"$set": bson.M{
-			"email":      data["email"],
+			"email":      models.Searchable(data.Email),
 			"updated_at": update_time,
 		},
 	}
 
-	_, updateErr := ContactCollection.UpdateOne(
-		ctx,
-		bson.M{"_id": data["_id"]},
-		update,
-	)
+	updateErr := AuditedUpdate(c, ctx, Repos(c).Contacts, data.ID, update)
 	if updateErr != nil {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "contact could not be updated",
-			"error":   updateErr,
-		})
+		return UpdateError("contact", updateErr)
 	}
 	defer cancel()
 
@@ -321,45 +231,32 @@ func UpdateContactEmail(c *fiber.Ctx) error {
 	})
 }
 
+// ContactPriorityRequest is the body of UpdateContactPriority
+type ContactPriorityRequest struct {
+	ID       string `json:"_id" validate:"required,objectid"`
+	Priority *int   `json:"priority" validate:"required"`
+}
+
 func UpdateContactPriority(c *fiber.Ctx) error {
-	var data map[string]interface{}
+	var data ContactPriorityRequest
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 
-	if err := c.BodyParser(&data); err != nil {
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to parse body",
-			"error":   err,
-		})
+		return InvalidRequest(fieldErrs)
 	}
 
 	// Ensure Authenticated admin sent request
 	if verified, _ := AuthenticateUser(c, 3); !verified {
 		cancel()
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"success": false,
-			"message": "Unauthorized: only an admin can perform this action",
-		})
-	}
-
-	// Check id of contact and new priority number is included
-	if data["_id"] == nil || data["priority"] == nil {
-		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "missing required fields",
-		})
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
 	}
 
-	var priority int = data["priority"].(int)
+	var priority int = *data.Priority
 
 	if priority > 10 || priority < 1 {
 		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "invalid priority",
-		})
+		return NewError(fiber.StatusBadRequest, CodeInvalidRequest, "invalid priority")
 	}
 
 	update_time, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
@@ -370,18 +267,10 @@ func UpdateContactPriority(c *fiber.Ctx) error {
 		},
 	}
 
-	_, updateErr := ContactCollection.UpdateOne(
-		ctx,
-		bson.M{"_id": data["_id"]},
-		update,
-	)
+	updateErr := AuditedUpdate(c, ctx, Repos(c).Contacts, data.ID, update)
 	if updateErr != nil {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "contact could not be updated",
-			"error":   updateErr,
-		})
+		return UpdateError("contact", updateErr)
 	}
 	defer cancel()
 
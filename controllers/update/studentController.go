@@ -10,6 +10,7 @@ import (
 
 	. "github.com/SowinskiBraeden/school-management-api/controllers"
 	"github.com/SowinskiBraeden/school-management-api/models"
+	"github.com/SowinskiBraeden/school-management-api/repository"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt"
@@ -21,40 +22,31 @@ func toBase64(b []byte) string {
 	return base64.StdEncoding.EncodeToString(b)
 }
 
+// NameRequest is the body of UpdateStudentName and UpdateTeacherName
+type NameRequest struct {
+	UID        string `json:"uid" validate:"required,uid"`
+	FirstName  string `json:"firstname" validate:"required"`
+	MiddleName string `json:"middlename"` // left as it is when empty
+	LastName   string `json:"lastname" validate:"required"`
+}
+
 func UpdateStudentName(c *fiber.Ctx) error {
-	var data map[string]string
+	var data NameRequest
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 
-	if err := c.BodyParser(&data); err != nil {
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to parse body",
-			"error":   err,
-		})
+		return InvalidRequest(fieldErrs)
 	}
 
 	// Ensure Authenticated admin sent request
 	if verified, _ := AuthenticateUser(c, 3); !verified {
 		cancel()
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"success": false,
-			"message": "Unauthorized: only an admin can perform this action",
-		})
-	}
-
-	// Check id and names are included
-	// Middle name is optional
-	if data["uid"] == "" || data["firstname"] == "" || data["lastname"] == "" {
-		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "missing required fields",
-		})
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
 	}
 
 	var updateMiddle bool = false
-	if data["middlename"] != "" {
+	if data.MiddleName != "" {
 		updateMiddle = true
 	}
 
@@ -63,95 +55,68 @@ func UpdateStudentName(c *fiber.Ctx) error {
 	if updateMiddle {
 		update = bson.M{
 			"$set": bson.M{
-				"personal.firstname":  data["firstname"],
-				"personal.middlename": data["middlename"],
-				"personal.lastname":   data["lastname"],
+				"personal.firstname":  data.FirstName,
+				"personal.middlename": data.MiddleName,
+				"personal.lastname":   data.LastName,
 				"updated_at":          update_time,
 			},
 		}
 	} else {
 		update = bson.M{
 			"$set": bson.M{
-				"personal.firstname": data["firstname"],
-				"personal.lastname":  data["lastname"],
+				"personal.firstname": data.FirstName,
+				"personal.lastname":  data.LastName,
 				"updated_at":         update_time,
 			},
 		}
 	}
 
-	result, updateErr := StudentCollection.UpdateOne(
-		ctx,
-		bson.M{"school.sid": data["uid"]},
-		update,
-	)
+	updateErr := AuditedUpdate(c, ctx, Repos(c).Students, data.UID, update)
 	if updateErr != nil {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "the student could not be updated",
-			"error":   updateErr,
-		})
+		return UpdateError("student", updateErr)
 	}
 	defer cancel()
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"success": true,
 		"message": "successfully updated student",
-		"result":  result,
 	})
 }
 
+// GradeLevelRequest is the body of UpdateStudentGradeLevel
+type GradeLevelRequest struct {
+	UID        string   `json:"uid" validate:"required,uid"`
+	GradeLevel *float64 `json:"gradelevel" validate:"required,min=0,max=12"`
+}
+
 func UpdateStudentGradeLevel(c *fiber.Ctx) error {
-	var data map[string]interface{}
+	var data GradeLevelRequest
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 
-	if err := c.BodyParser(&data); err != nil {
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to parse body",
-			"error":   err,
-		})
+		return InvalidRequest(fieldErrs)
 	}
 
 	// Ensure Authorized admin sent request
 	if verified, _ := AuthenticateUser(c, 3); !verified {
 		cancel()
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"success": false,
-			"message": "Unauthorized: only an admin can perform this action",
-		})
-	}
-
-	// Check required fields are included
-	if data["uid"] == nil || data["gradelevel"] == nil {
-		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "missing required fields",
-		})
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
 	}
 
 	update_time, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 	update := bson.M{
 		"$set": bson.M{
-			"school.gradelevel": data["gradelevel"].(float64),
+			"school.gradelevel": *data.GradeLevel,
 			"updated_at":        update_time,
 		},
 	}
 
-	_, updateErr := StudentCollection.UpdateOne(
-		ctx,
-		bson.M{"school.sid": data["uid"].(string)},
-		update,
-	)
+	updateErr := AuditedUpdate(c, ctx, Repos(c).Students, data.UID, update)
 	if updateErr != nil {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "the student could not be updated",
-			"error":   updateErr,
-		})
+		return UpdateError("student", updateErr)
 	}
 	defer cancel()
 
@@ -172,57 +137,39 @@ a student requests a course change, if its their block 2 the
 admin would have to alter their homeroom to be the new class
 number.
 */
+// HomeroomRequest is the body of UpdateStudentHomeroom and UpdateTeacherHomeroom
+type HomeroomRequest struct {
+	UID      string `json:"uid" validate:"required,uid"`
+	Homeroom string `json:"homeroom" validate:"required"`
+}
+
 func UpdateStudentHomeroom(c *fiber.Ctx) error {
-	var data map[string]string
+	var data HomeroomRequest
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 
-	if err := c.BodyParser(&data); err != nil {
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to parse body",
-			"error":   err,
-		})
+		return InvalidRequest(fieldErrs)
 	}
 
 	// Ensure Authenticated admin sent request
 	if verified, _ := AuthenticateUser(c, 3); !verified {
 		cancel()
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"success": false,
-			"message": "Unauthorized: only an admin can perform this action",
-		})
-	}
-
-	// Check required fields are included
-	if data["uid"] == "" || data["homeroom"] == "" {
-		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "missing required fields",
-		})
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
 	}
 
 	update_time, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 	update := bson.M{
 		"$set": bson.M{
-			"school.homeroom": data["homeroom"],
+			"school.homeroom": data.Homeroom,
 			"updated_at":      update_time,
 		},
 	}
 
-	_, updateErr := StudentCollection.UpdateOne(
-		ctx,
-		bson.M{"school.sid": data["uid"]},
-		update,
-	)
+	updateErr := AuditedUpdate(c, ctx, Repos(c).Students, data.UID, update)
 	if updateErr != nil {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "the student could not be updated",
-			"error":   updateErr,
-		})
+		return UpdateError("student", updateErr)
 	}
 	defer cancel()
 
@@ -232,17 +179,20 @@ func UpdateStudentHomeroom(c *fiber.Ctx) error {
 	})
 }
 
+// PasswordRequest is the body of the endpoints a signed in user changes their password with
+type PasswordRequest struct {
+	Password     string `json:"password" validate:"required"`
+	NewPassword1 string `json:"newpassword1" validate:"required"`
+	NewPassword2 string `json:"newpassword2" validate:"required"`
+}
+
 func UpdateStudentPassword(c *fiber.Ctx) error {
-	var data map[string]string
+	var data PasswordRequest
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 
-	if err := c.BodyParser(&data); err != nil {
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to parse body",
-			"error":   err,
-		})
+		return InvalidRequest(fieldErrs)
 	}
 
 	cookie := c.Cookies("jwt")
@@ -252,94 +202,68 @@ func UpdateStudentPassword(c *fiber.Ctx) error {
 	})
 	if err != nil {
 		cancel()
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"success": false,
-			"message": "not authorized",
-		})
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "not authorized")
 	}
 
 	claims := token.Claims.(*jwt.StandardClaims)
 
-	var student models.Student
-	findErr := StudentCollection.FindOne(ctx, bson.M{"school.sid": claims.Issuer}).Decode(&student)
+	student, findErr := Repos(c).Students.Get(ctx, claims.Issuer)
 	if findErr != nil {
 		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "student not found",
-		})
+		return FindError("student", findErr)
 	}
 
-	// Check required fields are included
-	if data["password"] == "" || data["newpassword1"] == "" || data["newpassword2"] == "" {
+	if !student.ComparePasswords(data.Password) {
 		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "missing required fields",
-		})
+		return NewError(fiber.StatusUnauthorized, CodeIncorrectPassword, "Your password is incorrect")
 	}
 
-	if !student.ComparePasswords(data["password"]) {
+	if data.NewPassword1 != data.NewPassword2 {
 		cancel()
-		return c.Status(fiber.StatusOK).JSON(fiber.Map{
-			"success": false,
-			"message": "Your password is incorrect",
-		})
+		return NewError(fiber.StatusBadRequest, CodePasswordMismatch, "Your new passwords must match")
 	}
 
-	if data["newpassword1"] != data["newpassword2"] {
+	policy := GetPasswordPolicy(ctx, Repos(c), "student")
+	if policyErrs := student.CheckPassword(policy, data.NewPassword1); len(policyErrs) > 0 {
 		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "Your new password must match",
-		})
+		return PasswordPolicyError("Your new password does not meet the password policy", "newpassword1", policyErrs)
 	}
 
-	if student.UsedPassword(data["newpassword1"]) {
+	newHash, hashErr := student.HashPassword(data.NewPassword1)
+	if hashErr != nil {
 		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "Your new password cannot be the same as a previous password",
-		})
+		return InternalError("the password could not be hashed", hashErr)
 	}
-
 	update_time, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 	update := bson.M{
 		"$set": bson.M{
-			"account.password":     student.HashPassword(data["newpassword1"]),
-			"account.temppassword": false, // If it were a temp password, its not now
-			"updated_at":           update_time,
+			"account.password":           newHash,
+			"account.temppassword":       false, // If it were a temp password, its not now
+			"account.passwordchanged_at": update_time,
+			"updated_at":                 update_time,
 		},
+		// Only as many passwords as the policy checks are kept
 		"$push": bson.M{
-			"account.hashhistory": student.HashPassword(data["newpassword1"]),
+			"account.hashhistory": bson.M{"$each": []string{newHash}, "$slice": -policy.HistoryDepth},
 		},
 	}
 
-	_, updateErr := StudentCollection.UpdateOne(
-		ctx,
-		bson.M{"school.sid": claims.Issuer},
-		update,
-	)
+	updateErr := AuditedUpdate(c, ctx, Repos(c).Students, claims.Issuer, update)
 	if updateErr != nil {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "the student password could not be updated",
-			"error":   updateErr,
-		})
+		return InternalError("the student password could not be updated", updateErr)
 	}
 	defer cancel()
 
-	// Alert email the password has changed
-	subject := "Password Changed"
-	receiver := student.Personal.Email
-	r := NewRequest([]string{receiver}, subject)
+	// Alert email the password has changed, only sent to a verified email
+	if student.Account.VerifiedEmail {
+		subject := "Password Changed"
+		receiver := string(student.Personal.Email)
+		r := NewRequest([]string{receiver}, subject)
 
-	if sent := r.Send("./templates/selfPasswordChanged.html", map[string]string{"username": student.Personal.FirstName}); !sent {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "failed to send email to student",
-		})
+		if sent := r.Send("./templates/selfPasswordChanged.html", map[string]string{"username": student.Personal.FirstName}); !sent {
+			return NewError(fiber.StatusBadGateway, CodeEmailNotSent, "failed to send email to student")
+		}
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
@@ -349,152 +273,114 @@ func UpdateStudentPassword(c *fiber.Ctx) error {
 }
 
 // This is for students to reset their password if they are unable to login
+// ResetPasswordRequest is the body of ResetStudentPassword and ResetTeacherPassword, email is the personal email of the account
+type ResetPasswordRequest struct {
+	UID   string `json:"uid" validate:"required,uid"`
+	Email string `json:"email" validate:"required"`
+}
+
 func ResetStudentPassword(c *fiber.Ctx) error {
-	var data map[string]string
+	var data ResetPasswordRequest
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 
-	if err := c.BodyParser(&data); err != nil {
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to parse body",
-			"error":   err,
-		})
+		return InvalidRequest(fieldErrs)
 	}
 
-	// Check required fields are included (email must be personal email)
-	if data["uid"] == "" || data["email"] == "" {
+	student, findErr := Repos(c).Students.Get(context.TODO(), data.UID)
+	if findErr != nil {
 		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "missing required fields",
-		})
+		return FindError("student", findErr)
 	}
 
-	var student models.Student
-	findErr := StudentCollection.FindOne(context.TODO(), bson.M{"school.sid": data["uid"]}).Decode(&student)
-	if findErr != nil {
+	if string(student.Personal.Email) != data.Email {
 		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "student not found",
-		})
+		return NewError(fiber.StatusBadRequest, CodeIncorrectEmail, "Your personal email is incorrect")
 	}
 
-	if student.Personal.Email != data["email"] {
+	// A temp password is only ever sent to a verified email
+	if !student.Account.VerifiedEmail {
 		cancel()
-		return c.Status(fiber.StatusOK).JSON(fiber.Map{
-			"success": false,
-			"message": "Your personal email is incorrect",
-		})
+		return NewError(fiber.StatusForbidden, CodeEmailNotVerified, "Your personal email has not been verified, contact an admin")
 	}
 
 	tempPass := student.GeneratePassword(12, 1, 1, 1)
+	tempHash, hashErr := student.HashPassword(tempPass)
+	if hashErr != nil {
+		cancel()
+		return InternalError("the password could not be hashed", hashErr)
+	}
 	update_time, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 	update := bson.M{
 		"$set": bson.M{
-			"account.password":     student.HashPassword(tempPass),
+			"account.password":     tempHash,
 			"account.temppassword": true,
 			"updated_at":           update_time,
 		},
 	}
 
-	result, updateErr := StudentCollection.UpdateOne(
-		ctx,
-		bson.M{"school.sid": data["uid"]},
-		update,
-	)
+	updateErr := AuditedUpdate(c, ctx, Repos(c).Students, data.UID, update)
 	if updateErr != nil {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "the student password could not be updated",
-			"error":   updateErr,
-		})
+		return InternalError("the student password could not be updated", updateErr)
 	}
 	defer cancel()
 
 	// Send student personal email temp password
 	subject := "Password Changed"
-	receiver := student.Personal.Email
+	receiver := string(student.Personal.Email)
 	r := NewRequest([]string{receiver}, subject)
 
 	if sent := r.Send("./templates/passwordChanged.html", map[string]string{"username": student.Personal.FirstName, "password": tempPass}); !sent {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Could not send password to students email",
-		})
+		return NewError(fiber.StatusBadGateway, CodeEmailNotSent, "Could not send password to students email")
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"success": true,
 		"message": "successfully updated student password",
-		"result":  result,
 	})
 }
 
+// LockerRequest is the body of UpdateStudentLocker
+type LockerRequest struct {
+	UID          string `json:"uid" validate:"required,uid"`
+	LockerNumber string `json:"lockernumber" validate:"required"`
+}
+
 func UpdateStudentLocker(c *fiber.Ctx) error {
-	var data map[string]string
+	var data LockerRequest
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 
-	if err := c.BodyParser(&data); err != nil {
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to parse body",
-			"error":   err,
-		})
+		return InvalidRequest(fieldErrs)
 	}
 
 	// Ensure Authenticated admin sent request
 	if verified, _ := AuthenticateUser(c, 3); !verified {
 		cancel()
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"success": false,
-			"message": "Unauthorized: only an admin can perform this action",
-		})
-	}
-
-	// Check id and locker are included
-	if data["uid"] == "" || data["lockernumber"] == "" {
-		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "missing required fields",
-		})
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
 	}
 
-	var locker models.Locker
-	err := LockerCollection.FindOne(ctx, bson.M{"lockernumber": data["lockernumber"]}).Decode(&locker)
+	locker, err := Repos(c).Lockers.FindByNumber(ctx, data.LockerNumber)
 	if err != nil {
 		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "locker not found",
-			"error":   err,
-		})
+		return FindError("locker", err)
 	}
 
 	update_time, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 	update := bson.M{
 		"$set": bson.M{
-			"school.locker": locker.ID,
+			"school.locker": locker.ID.Hex(),
 			"updated_at":    update_time,
 		},
 	}
 
-	_, updateErr := StudentCollection.UpdateOne(
-		ctx,
-		bson.M{"school.sid": data["uid"]},
-		update,
-	)
+	updateErr := AuditedUpdate(c, ctx, Repos(c).Students, data.UID, update)
 	if updateErr != nil {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "the student could not be updated",
-			"error":   updateErr,
-		})
+		return UpdateError("student", updateErr)
 	}
 	defer cancel()
 
@@ -504,60 +390,45 @@ func UpdateStudentLocker(c *fiber.Ctx) error {
 	})
 }
 
+// AddressRequest is the body of UpdateStudentAddress and UpdateTeacherAddress
+type AddressRequest struct {
+	UID      string `json:"uid" validate:"required,uid"`
+	Address  string `json:"address" validate:"required"`
+	City     string `json:"city" validate:"required"`
+	Province string `json:"province" validate:"required"`
+	Postal   string `json:"postal" validate:"required"`
+}
+
 func UpdateStudentAddress(c *fiber.Ctx) error {
-	var data map[string]string
+	var data AddressRequest
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 
-	if err := c.BodyParser(&data); err != nil {
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to parse body",
-			"error":   err,
-		})
+		return InvalidRequest(fieldErrs)
 	}
 
 	// Ensure Authenticated admin sent request
 	if verified, _ := AuthenticateUser(c, 3); !verified {
 		cancel()
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"success": false,
-			"message": "Unauthorized: only an admin can perform this action",
-		})
-	}
-
-	// Check required fields are included
-	if data["uid"] == "" || data["address"] == "" || data["city"] == "" || data["province"] == "" || data["postal"] == "" {
-		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "missing required fields",
-		})
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
 	}
 
 	update_time, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 	update := bson.M{
 		"$set": bson.M{
-			"personal.address":  data["address"],
-			"personal.city":     data["city"],
-			"personal.province": data["province"],
-			"personal.postal":   data["postal"],
+			"personal.address":  models.Encrypted(data.Address),
+			"personal.city":     data.City,
+			"personal.province": data.Province,
+			"personal.postal":   models.Encrypted(data.Postal),
 			"updated_at":        update_time,
 		},
 	}
 
-	_, updateErr := StudentCollection.UpdateOne(
-		ctx,
-		bson.M{"sid": data["uid"]},
-		update,
-	)
+	updateErr := AuditedUpdate(c, ctx, Repos(c).Students, data.UID, update)
 	if updateErr != nil {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "the student could not be updated",
-			"error":   updateErr,
-		})
+		return UpdateError("student", updateErr)
 	}
 	defer cancel()
 
@@ -568,117 +439,132 @@ func UpdateStudentAddress(c *fiber.Ctx) error {
 }
 
 // In the case a student gets held back a grade, we need to update their YOG (Year of Graduation)
+// YOGRequest is the body of UpdateStudentYOG
+type YOGRequest struct {
+	UID string `json:"uid" validate:"required,uid"`
+	YOG *int   `json:"yog" validate:"required,min=1900,max=2100"`
+}
+
 func UpdateStudentYOG(c *fiber.Ctx) error {
-	var data map[string]interface{}
+	var data YOGRequest
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 
-	if err := c.BodyParser(&data); err != nil {
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to parse body",
-			"error":   err,
-		})
+		return InvalidRequest(fieldErrs)
 	}
 
 	// Ensure Authenticated admin sent request
 	if verified, _ := AuthenticateUser(c, 3); !verified {
 		cancel()
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"success": false,
-			"message": "Unauthorized: only an admin can perform this action",
-		})
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
+	}
+
+	_, findErr := Repos(c).Students.Get(context.TODO(), data.UID)
+	if findErr != nil {
+		cancel()
+		return FindError("student", findErr)
 	}
 
-	// Check required fields are included
-	if data["uid"] == "" || data["yog"] == nil {
+	update_time, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	update := bson.M{
+		"$set": bson.M{
+			"school.yog": *data.YOG,
+			"updated_at": update_time,
+		},
+	}
+
+	updateErr := AuditedUpdate(c, ctx, Repos(c).Students, data.UID, update)
+	if updateErr != nil {
+		cancel()
+		return UpdateError("student", updateErr)
+	}
+	defer cancel()
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "successfully updated student",
+	})
+}
+
+// UpdateStudentPEN replaces a student's generated PEN with the one the ministry assigned
+// PENRequest is the body of UpdateStudentPEN
+type PENRequest struct {
+	UID string `json:"uid" validate:"required,uid"`
+	PEN string `json:"pen" validate:"required,pen"`
+}
+
+func UpdateStudentPEN(c *fiber.Ctx) error {
+	var data PENRequest
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
 		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "missing required fields",
-		})
+		return InvalidRequest(fieldErrs)
 	}
 
-	var student models.Student
-	findErr := StudentCollection.FindOne(context.TODO(), bson.M{"school.sid": data["uid"].(string)}).Decode(&student)
+	// Ensure Authenticated admin sent request
+	if verified, _ := AuthenticateUser(c, 3); !verified {
+		cancel()
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
+	}
+
+	_, findErr := Repos(c).Students.Get(ctx, data.UID)
 	if findErr != nil {
 		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "student not found",
-		})
+		return FindError("student", findErr)
 	}
 
 	update_time, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 	update := bson.M{
 		"$set": bson.M{
-			"school.yog": data["yog"].(int),
+			"school.pen": data.PEN,
 			"updated_at": update_time,
 		},
 	}
 
-	result, updateErr := StudentCollection.UpdateOne(
-		ctx,
-		bson.M{"school.sid": data["uid"].(string)},
-		update,
-	)
+	updateErr := AuditedUpdate(c, ctx, Repos(c).Students, data.UID, update)
+	if updateErr == repository.ErrDuplicateKey {
+		cancel()
+		return NewError(fiber.StatusConflict, CodePENTaken, "the pen is already assigned to another student")
+	}
 	if updateErr != nil {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "the student could not be updated",
-			"error":   updateErr,
-		})
+		return UpdateError("student", updateErr)
 	}
 	defer cancel()
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"success": true,
 		"message": "successfully updated student",
-		"result":  result,
 	})
 }
 
+// StudentContactRequest is the body of AddStudentContact and RemoveStudentContact
+type StudentContactRequest struct {
+	UID       string `json:"uid" validate:"required,uid"`
+	ContactID string `json:"contactid" validate:"required,objectid"`
+}
+
 func RemoveStudentContact(c *fiber.Ctx) error {
-	var data map[string]string
+	var data StudentContactRequest
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 
-	if err := c.BodyParser(&data); err != nil {
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to parse body",
-			"error":   err,
-		})
+		return InvalidRequest(fieldErrs)
 	}
 
 	// Ensure Authenticated admin sent request
 	if verified, _ := AuthenticateUser(c, 3); !verified {
 		cancel()
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"success": false,
-			"message": "Unauthorized: only an admin can perform this action",
-		})
-	}
-
-	// Check id and contact id are included
-	if data["uid"] == "" || data["contactid"] == "" {
-		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "missing required fields",
-		})
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
 	}
 
-	var contact models.Contact
-	err := ContactCollection.FindOne(ctx, bson.M{"_id": data["contactid"]}).Decode(&contact)
+	contact, err := Repos(c).Contacts.Get(ctx, data.ContactID)
 	if err != nil {
 		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "contact not found",
-			"error":   err,
-		})
+		return FindError("contact", err)
 	}
 
 	update_time, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
@@ -687,72 +573,42 @@ func RemoveStudentContact(c *fiber.Ctx) error {
 			"updated_at": update_time,
 		},
 		"$pull": bson.M{
-			"personal.contacts": contact.ID,
+			"personal.contacts": contact.ID.Hex(),
 		},
 	}
 
-	result, updateErr := StudentCollection.UpdateOne(
-		ctx,
-		bson.M{"school.sid": data["uid"]},
-		update,
-	)
+	updateErr := AuditedUpdate(c, ctx, Repos(c).Students, data.UID, update)
 	if updateErr != nil {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "the contact could not be added",
-			"error":   updateErr,
-		})
+		return InternalError("the contact could not be added", updateErr)
 	}
 	defer cancel()
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"success": true,
 		"message": "successfully added contact",
-		"result":  result,
 	})
 }
 
 func AddStudentContact(c *fiber.Ctx) error {
-	var data map[string]string
+	var data StudentContactRequest
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 
-	if err := c.BodyParser(&data); err != nil {
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to parse body",
-			"error":   err,
-		})
+		return InvalidRequest(fieldErrs)
 	}
 
 	// Ensure Authenticated admin sent request
 	if verified, _ := AuthenticateUser(c, 3); !verified {
 		cancel()
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"success": false,
-			"message": "Unauthorized: only an admin can perform this action",
-		})
-	}
-
-	// Check id and contact id are included
-	if data["uid"] == "" || data["contactid"] == "" {
-		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "missing required fields",
-		})
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
 	}
 
-	var contact models.Contact
-	err := ContactCollection.FindOne(ctx, bson.M{"_id": data["contactid"]}).Decode(&contact)
+	contact, err := Repos(c).Contacts.Get(ctx, data.ContactID)
 	if err != nil {
 		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "contact not found",
-			"error":   err,
-		})
+		return FindError("contact", err)
 	}
 
 	update_time, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
@@ -761,29 +617,20 @@ func AddStudentContact(c *fiber.Ctx) error {
 			"updated_at": update_time,
 		},
 		"$push": bson.M{
-			"personal.contacts": contact.ID,
+			"personal.contacts": contact.ID.Hex(),
 		},
 	}
 
-	result, updateErr := StudentCollection.UpdateOne(
-		ctx,
-		bson.M{"school.sid": data["uid"]},
-		update,
-	)
+	updateErr := AuditedUpdate(c, ctx, Repos(c).Students, data.UID, update)
 	if updateErr != nil {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "the contact could not be added",
-			"error":   updateErr,
-		})
+		return InternalError("the contact could not be added", updateErr)
 	}
 	defer cancel()
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"success": true,
 		"message": "successfully added contact",
-		"result":  result,
 	})
 }
 
@@ -793,54 +640,34 @@ func UpdateStudentPhoto(c *fiber.Ctx) error {
 	//Ensure Authenticated admin sent request
 	if verified, _ := AuthenticateUser(c, 3); !verified {
 		cancel()
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"success": false,
-			"message": "Unauthorized: only an admin can perform this action",
-		})
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
 	}
 
 	sid := c.FormValue("sid")
 	if sid == "" {
 		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "missing required fields",
-		})
+		return NewError(fiber.StatusBadRequest, CodeInvalidRequest, "missing required fields")
 	}
 
 	// Get student
-	var student models.Student
-	findErr := StudentCollection.FindOne(context.TODO(), bson.M{"school.sid": sid}).Decode(&student)
+	student, findErr := Repos(c).Students.Get(context.TODO(), sid)
 	if findErr != nil {
 		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "the student could not be found",
-			"error":   findErr,
-		})
+		return FindError("student", findErr)
 	}
 
 	// Collect image
 	file, err := c.FormFile("image")
 	if err != nil {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "the image could not be retrieved",
-			"error":   err,
-		})
+		return InternalError("the image could not be retrieved", err)
 	}
 
 	// Get student photo
-	var photo models.Photo
-	findErr = ImageCollection.FindOne(context.TODO(), bson.M{"name": student.School.PhotoName}).Decode(&photo)
+	photo, findErr := Repos(c).Photos.Get(context.TODO(), student.School.PhotoName)
 	if findErr != nil {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "the student image could not be found",
-			"error":   findErr,
-		})
+		return InternalError("the student image could not be found", findErr)
 	}
 
 	// Save image to local
@@ -851,22 +678,14 @@ func UpdateStudentPhoto(c *fiber.Ctx) error {
 	err = c.SaveFile(file, fmt.Sprintf("./database/images/%s", image))
 	if err != nil {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "the image could not be saved",
-			"error":   err,
-		})
+		return InternalError("the image could not be saved", err)
 	}
 
 	// Read the entire file into a byte slice
 	bytes, err := os.ReadFile(fmt.Sprintf("./database/images/%s", image))
 	if err != nil {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "the image could not be read",
-			"error":   err,
-		})
+		return InternalError("the image could not be read", err)
 	}
 
 	var base64Encoding string = toBase64(bytes)
@@ -879,18 +698,10 @@ func UpdateStudentPhoto(c *fiber.Ctx) error {
 			"updated_at": update_time,
 		},
 	}
-	_, updateErr := ImageCollection.UpdateOne(
-		ctx,
-		bson.M{"_id": photo.ID},
-		update,
-	)
+	updateErr := AuditedUpdate(c, ctx, Repos(c).Photos, photo.Name, update)
 	if updateErr != nil {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "the image could not be updated",
-			"error":   updateErr,
-		})
+		return InternalError("the image could not be updated", updateErr)
 	}
 	defer cancel()
 
@@ -903,17 +714,19 @@ func UpdateStudentPhoto(c *fiber.Ctx) error {
 	})
 }
 
+// EmailRequest is the body of UpdateStudentEmail and UpdateTeacherEmail, uid is only needed when an admin sends it
+type EmailRequest struct {
+	UID   string `json:"uid" validate:"omitempty,uid"`
+	Email string `json:"email" validate:"required,mailaddress"`
+}
+
 func UpdateStudentEmail(c *fiber.Ctx) error {
-	var data map[string]string
+	var data EmailRequest
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 
-	if err := c.BodyParser(&data); err != nil {
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to parse body",
-			"error":   err,
-		})
+		return InvalidRequest(fieldErrs)
 	}
 
 	var sid string
@@ -923,57 +736,44 @@ func UpdateStudentEmail(c *fiber.Ctx) error {
 	// Ensure Authenticated admin sent request
 	if !verifiedAdmin && !verifiedStudent {
 		cancel()
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"success": false,
-			"message": "Unauthorized: only an admin or teacher can perform this action",
-		})
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin or teacher can perform this action")
 	}
 
-	if verifiedAdmin && data["uid"] == "" {
+	if verifiedAdmin && data.UID == "" {
 		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "missing required fields",
-		})
+		return NewError(fiber.StatusBadRequest, CodeInvalidRequest, "missing required fields")
 	} else if verifiedAdmin {
-		sid = data["uid"]
+		sid = data.UID
 	}
 
-	// Check required fields are included
-	if data["email"] == "" {
+	student, findErr := Repos(c).Students.Get(ctx, sid)
+	if findErr != nil {
 		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "missing required fields",
-		})
+		return FindError("student", findErr)
 	}
 
+	// The new email is only used once it has been verified
 	update_time, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 	update := bson.M{
 		"$set": bson.M{
-			"personal.email": data["email"],
-			"updated_at":     update_time,
+			"account.pendingemail": models.Encrypted(data.Email),
+			"updated_at":           update_time,
 		},
 	}
 
-	result, updateErr := StudentCollection.UpdateOne(
-		ctx,
-		bson.M{"school.sid": sid},
-		update,
-	)
+	updateErr := AuditedUpdate(c, ctx, Repos(c).Students, sid, update)
 	if updateErr != nil {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "the student could not be updated",
-			"error":   updateErr,
-		})
+		return UpdateError("student", updateErr)
 	}
 	defer cancel()
 
+	if sent := SendVerification(Repos(c), sid, 1, student.Personal.FirstName, data.Email); !sent {
+		return NewError(fiber.StatusBadGateway, CodeEmailNotSent, "failed to send verification email")
+	}
+
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"success": true,
-		"message": "successfully updated student",
-		"result":  result,
+		"message": "successfully updated student, the new email must be verified before it is used",
 	})
 }
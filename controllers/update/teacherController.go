@@ -9,7 +9,6 @@ import (
 
 	. "github.com/SowinskiBraeden/school-management-api/controllers"
 
-	"github.com/SowinskiBraeden/school-management-api/models"
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt"
 	"github.com/google/uuid"
@@ -28,56 +27,32 @@ admin would have to alter their homeroom to be the new class
 number.
 */
 func UpdateTeacherHomeroom(c *fiber.Ctx) error {
-	var data map[string]string
+	var data HomeroomRequest
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 
-	if err := c.BodyParser(&data); err != nil {
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to parse body",
-			"error":   err,
-		})
+		return InvalidRequest(fieldErrs)
 	}
 
 	// Ensure Authenticated admin sent request
 	if verified, _ := AuthenticateUser(c, 3); !verified {
 		cancel()
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"success": false,
-			"message": "Unauthorized: only an admin can perform this action",
-		})
-	}
-
-	// Check required fields are included
-	if data["uid"] == "" || data["homeroom"] == "" {
-		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "missing required fields",
-		})
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
 	}
 
 	update_time, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 	update := bson.M{
 		"$set": bson.M{
-			"school.homeroom": data["homeroom"],
+			"school.homeroom": data.Homeroom,
 			"updated_at":      update_time,
 		},
 	}
 
-	_, updateErr := TeacherCollection.UpdateOne(
-		ctx,
-		bson.M{"school.tid": data["uid"]},
-		update,
-	)
+	updateErr := AuditedUpdate(c, ctx, Repos(c).Teachers, data.UID, update)
 	if updateErr != nil {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "the teacher could not be updated",
-			"error":   updateErr,
-		})
+		return UpdateError("teacher", updateErr)
 	}
 	defer cancel()
 
@@ -88,16 +63,12 @@ func UpdateTeacherHomeroom(c *fiber.Ctx) error {
 }
 
 func UpdateTeacherPassword(c *fiber.Ctx) error {
-	var data map[string]string
+	var data PasswordRequest
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 
-	if err := c.BodyParser(&data); err != nil {
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to parse body",
-			"error":   err,
-		})
+		return InvalidRequest(fieldErrs)
 	}
 
 	cookie := c.Cookies("jwt")
@@ -107,93 +78,68 @@ func UpdateTeacherPassword(c *fiber.Ctx) error {
 	})
 	if err != nil {
 		cancel()
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"success": false,
-			"message": "not authorized",
-		})
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "not authorized")
 	}
 
 	claims := token.Claims.(*jwt.StandardClaims)
 
-	var teacher models.Teacher
-	findErr := TeacherCollection.FindOne(ctx, bson.M{"school.tid": claims.Issuer}).Decode(&teacher)
+	teacher, findErr := Repos(c).Teachers.Get(ctx, claims.Issuer)
 	if findErr != nil {
 		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "teacher not found",
-		})
+		return FindError("teacher", findErr)
 	}
 
-	// Check required fields are included
-	if data["password"] == "" || data["newpassword1"] == "" || data["newpassword2"] == "" {
+	if !teacher.ComparePasswords(data.Password) {
 		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "missing required fields",
-		})
+		return NewError(fiber.StatusUnauthorized, CodeIncorrectPassword, "Your password is incorrect")
 	}
 
-	if !teacher.ComparePasswords(data["password"]) {
+	if data.NewPassword1 != data.NewPassword2 {
 		cancel()
-		return c.Status(fiber.StatusOK).JSON(fiber.Map{
-			"success": false,
-			"message": "Your password is incorrect",
-		})
+		return NewError(fiber.StatusBadRequest, CodePasswordMismatch, "Your new passwords must match")
 	}
 
-	if data["newpassword1"] != data["newpassword2"] {
+	policy := GetPasswordPolicy(ctx, Repos(c), "teacher")
+	if policyErrs := teacher.CheckPassword(policy, data.NewPassword1); len(policyErrs) > 0 {
 		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "Your new passwords must match",
-		})
+		return PasswordPolicyError("Your new password does not meet the password policy", "newpassword1", policyErrs)
 	}
 
-	if teacher.UsedPassword(data["newpassword1"]) {
+	newHash, hashErr := teacher.HashPassword(data.NewPassword1)
+	if hashErr != nil {
 		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "Your new password cannot be the same as a previous password",
-		})
+		return InternalError("the password could not be hashed", hashErr)
 	}
-
 	update_time, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 	update := bson.M{
 		"$set": bson.M{
-			"account.password":     teacher.HashPassword(data["newpassword1"]),
-			"account.temppassword": false, // If it were a temp password, its not now
-			"updated_at":           update_time,
+			"account.password":           newHash,
+			"account.temppassword":       false, // If it were a temp password, its not now
+			"account.passwordchanged_at": update_time,
+			"updated_at":                 update_time,
 		},
+		// Only as many passwords as the policy checks are kept
 		"$push": bson.M{
-			"account.hashhistory": teacher.HashPassword(data["newpassword1"]),
+			"account.hashhistory": bson.M{"$each": []string{newHash}, "$slice": -policy.HistoryDepth},
 		},
 	}
 
-	_, updateErr := TeacherCollection.UpdateOne(
-		ctx,
-		bson.M{"school.tid": claims.Issuer},
-		update,
-	)
+	updateErr := AuditedUpdate(c, ctx, Repos(c).Teachers, claims.Issuer, update)
 	if updateErr != nil {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "the teacher password could not be updated",
-			"error":   updateErr,
-		})
+		return InternalError("the teacher password could not be updated", updateErr)
 	}
 	defer cancel()
 
-	subject := "Password Changed"
-	receiver := teacher.Personal.Email
-	r := NewRequest([]string{receiver}, subject)
+	// Alert email the password has changed, only sent to a verified email
+	if teacher.Account.VerifiedEmail {
+		subject := "Password Changed"
+		receiver := teacher.Personal.Email
+		r := NewRequest([]string{receiver}, subject)
 
-	if sent := r.Send("./templates/selfPasswordChanged.html", map[string]string{"username": teacher.Personal.FirstName}); !sent {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Could not send password to teachers email",
-		})
+		if sent := r.Send("./templates/selfPasswordChanged.html", map[string]string{"username": teacher.Personal.FirstName}); !sent {
+			return NewError(fiber.StatusBadGateway, CodeEmailNotSent, "Could not send password to teachers email")
+		}
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
@@ -203,67 +149,50 @@ func UpdateTeacherPassword(c *fiber.Ctx) error {
 }
 
 func ResetTeacherPassword(c *fiber.Ctx) error {
-	var data map[string]string
+	var data ResetPasswordRequest
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 
-	if err := c.BodyParser(&data); err != nil {
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to parse body",
-			"error":   err,
-		})
+		return InvalidRequest(fieldErrs)
 	}
 
-	// Check required fields are included (email must be personal email)
-	if data["uid"] == "" || data["email"] == "" {
+	teacher, findErr := Repos(c).Teachers.Get(context.TODO(), data.UID)
+	if findErr != nil {
 		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "missing required fields",
-		})
+		return FindError("teacher", findErr)
 	}
 
-	var teacher models.Teacher
-	findErr := TeacherCollection.FindOne(context.TODO(), bson.M{"school.tid": data["uid"]}).Decode(&teacher)
-	if findErr != nil {
+	if teacher.Personal.Email != data.Email {
 		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "teacher not found",
-		})
+		return NewError(fiber.StatusBadRequest, CodeIncorrectEmail, "Your personal email is incorrect")
 	}
 
-	if teacher.Personal.Email != data["email"] {
+	// A temp password is only ever sent to a verified email
+	if !teacher.Account.VerifiedEmail {
 		cancel()
-		return c.Status(fiber.StatusOK).JSON(fiber.Map{
-			"success": false,
-			"message": "Your personal email is incorrect",
-		})
+		return NewError(fiber.StatusForbidden, CodeEmailNotVerified, "Your personal email has not been verified, contact an admin")
 	}
 
 	tempPass := teacher.GeneratePassword(12, 1, 1, 1)
+	tempHash, hashErr := teacher.HashPassword(tempPass)
+	if hashErr != nil {
+		cancel()
+		return InternalError("the password could not be hashed", hashErr)
+	}
 	update_time, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 	update := bson.M{
 		"$set": bson.M{
-			"account.password":     teacher.HashPassword(tempPass),
+			"account.password":     tempHash,
 			"account.temppassword": true,
 			"updated_at":           update_time,
 		},
 	}
 
-	result, updateErr := StudentCollection.UpdateOne(
-		ctx,
-		bson.M{"school.tid": data["uid"]},
-		update,
-	)
+	updateErr := AuditedUpdate(c, ctx, Repos(c).Teachers, data.UID, update)
 	if updateErr != nil {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "the teacher password could not be updated",
-			"error":   updateErr,
-		})
+		return InternalError("the teacher password could not be updated", updateErr)
 	}
 	defer cancel()
 
@@ -273,73 +202,45 @@ func ResetTeacherPassword(c *fiber.Ctx) error {
 	r := NewRequest([]string{receiver}, subject)
 
 	if sent := r.Send("./templates/passwordChanged.html", map[string]string{"username": teacher.Personal.FirstName, "password": tempPass}); !sent {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Could not send password to teachers email",
-		})
+		return NewError(fiber.StatusBadGateway, CodeEmailNotSent, "Could not send password to teachers email")
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"success": true,
 		"message": "successfully updated teacher password",
-		"result":  result,
 	})
 }
 
 func UpdateTeacherAddress(c *fiber.Ctx) error {
-	var data map[string]string
+	var data AddressRequest
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 
-	if err := c.BodyParser(&data); err != nil {
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to parse body",
-			"error":   err,
-		})
+		return InvalidRequest(fieldErrs)
 	}
 
 	// Ensure Authenticated admin sent request
 	if verified, _ := AuthenticateUser(c, 3); !verified {
 		cancel()
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"success": false,
-			"message": "Unauthorized: only an admin can perform this action",
-		})
-	}
-
-	// Check required fields are included
-	if data["uid"] == "" || data["address"] == "" || data["city"] == "" || data["province"] == "" || data["postal"] == "" {
-		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "missing required fields",
-		})
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
 	}
 
 	update_time, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 	update := bson.M{
 		"$set": bson.M{
-			"personal.address":  data["address"],
-			"personal.city":     data["city"],
-			"personal.province": data["province"],
-			"personal.postal":   data["postal"],
+			"personal.address":  data.Address,
+			"personal.city":     data.City,
+			"personal.province": data.Province,
+			"personal.postal":   data.Postal,
 			"updated_at":        update_time,
 		},
 	}
 
-	_, updateErr := TeacherCollection.UpdateOne(
-		ctx,
-		bson.M{"school.tid": data["uid"]},
-		update,
-	)
+	updateErr := AuditedUpdate(c, ctx, Repos(c).Teachers, data.UID, update)
 	if updateErr != nil {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "the teacher could not be updated",
-			"error":   updateErr,
-		})
+		return UpdateError("teacher", updateErr)
 	}
 	defer cancel()
 
@@ -355,54 +256,34 @@ func UpdateTeacherPhoto(c *fiber.Ctx) error {
 	//Ensure Authenticated admin sent request
 	if verified, _ := AuthenticateUser(c, 3); !verified {
 		cancel()
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"success": false,
-			"message": "Unauthorized: only an admin can perform this action",
-		})
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
 	}
 
 	tid := c.FormValue("tid")
 	if tid == "" {
 		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "missing required fields",
-		})
+		return NewError(fiber.StatusBadRequest, CodeInvalidRequest, "missing required fields")
 	}
 
 	// Get teacher
-	var teacher models.Teacher
-	findErr := TeacherCollection.FindOne(context.TODO(), bson.M{"school.tid": tid}).Decode(&teacher)
+	teacher, findErr := Repos(c).Teachers.Get(context.TODO(), tid)
 	if findErr != nil {
 		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "the teacher could not be found",
-			"error":   findErr,
-		})
+		return FindError("teacher", findErr)
 	}
 
 	// Collect image
 	file, err := c.FormFile("image")
 	if err != nil {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "the image could not be retrieved",
-			"error":   err,
-		})
+		return InternalError("the image could not be retrieved", err)
 	}
 
 	// Get student photo
-	var photo models.Photo
-	findErr = ImageCollection.FindOne(context.TODO(), bson.M{"name": teacher.School.PhotoName}).Decode(&photo)
+	photo, findErr := Repos(c).Photos.Get(context.TODO(), teacher.School.PhotoName)
 	if findErr != nil {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "the student image could not be found",
-			"error":   findErr,
-		})
+		return InternalError("the student image could not be found", findErr)
 	}
 
 	// Save image to local
@@ -413,22 +294,14 @@ func UpdateTeacherPhoto(c *fiber.Ctx) error {
 	err = c.SaveFile(file, fmt.Sprintf("./database/images/%s", image))
 	if err != nil {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "the image could not be saved",
-			"error":   err,
-		})
+		return InternalError("the image could not be saved", err)
 	}
 
 	// Read the entire file into a byte slice
 	bytes, err := os.ReadFile(fmt.Sprintf("./database/images/%s", image))
 	if err != nil {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "the image could not be read",
-			"error":   err,
-		})
+		return InternalError("the image could not be read", err)
 	}
 
 	var base64Encoding string = toBase64(bytes)
@@ -441,18 +314,10 @@ func UpdateTeacherPhoto(c *fiber.Ctx) error {
 			"updated_at": update_time,
 		},
 	}
-	_, updateErr := ImageCollection.UpdateOne(
-		ctx,
-		bson.M{"_id": photo.ID},
-		update,
-	)
+	updateErr := AuditedUpdate(c, ctx, Repos(c).Photos, photo.Name, update)
 	if updateErr != nil {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "the image could not be updated",
-			"error":   updateErr,
-		})
+		return InternalError("the image could not be updated", updateErr)
 	}
 	defer cancel()
 
@@ -466,16 +331,12 @@ func UpdateTeacherPhoto(c *fiber.Ctx) error {
 }
 
 func UpdateTeacherEmail(c *fiber.Ctx) error {
-	var data map[string]string
+	var data EmailRequest
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 
-	if err := c.BodyParser(&data); err != nil {
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to parse body",
-			"error":   err,
-		})
+		return InvalidRequest(fieldErrs)
 	}
 
 	var tid string
@@ -486,131 +347,90 @@ func UpdateTeacherEmail(c *fiber.Ctx) error {
 	// Ensure Authenticated admin sent request
 	if !verifiedAdmin && !verifiedTeacher {
 		cancel()
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"success": false,
-			"message": "Unauthorized: only an admin or teacher can perform this action",
-		})
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin or teacher can perform this action")
 	}
 
-	if verifiedAdmin && data["uid"] == "" {
+	if verifiedAdmin && data.UID == "" {
 		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "missing required fields",
-		})
+		return NewError(fiber.StatusBadRequest, CodeInvalidRequest, "missing required fields")
 	} else if verifiedAdmin {
-		tid = data["uid"]
+		tid = data.UID
 	}
 
-	// Check required fields are included
-	if data["email"] == "" {
+	teacher, findErr := Repos(c).Teachers.Get(ctx, tid)
+	if findErr != nil {
 		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "missing required fields",
-		})
+		return FindError("teacher", findErr)
 	}
 
+	// The new email is only used once it has been verified
 	update_time, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 	update := bson.M{
 		"$set": bson.M{
-			"personal.email": data["email"],
-			"updated_at":     update_time,
+			"account.pendingemail": data.Email,
+			"updated_at":           update_time,
 		},
 	}
 
-	_, updateErr := TeacherCollection.UpdateOne(
-		ctx,
-		bson.M{"school.tid": tid},
-		update,
-	)
+	updateErr := AuditedUpdate(c, ctx, Repos(c).Teachers, tid, update)
 	if updateErr != nil {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "the teacher could not be updated",
-			"error":   updateErr,
-		})
+		return UpdateError("teacher", updateErr)
 	}
 	defer cancel()
 
+	if sent := SendVerification(Repos(c), tid, 2, teacher.Personal.FirstName, data.Email); !sent {
+		return NewError(fiber.StatusBadGateway, CodeEmailNotSent, "failed to send verification email")
+	}
+
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"success": true,
-		"message": "successfully updated teacher",
+		"message": "successfully updated teacher, the new email must be verified before it is used",
 	})
 }
 
 func UpdateTeacherName(c *fiber.Ctx) error {
-	var data map[string]string
+	var data NameRequest
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 
-	if err := c.BodyParser(&data); err != nil {
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to parse body",
-			"error":   err,
-		})
+		return InvalidRequest(fieldErrs)
 	}
 
 	// Ensure Authenticated admin sent request
 	if verified, _ := AuthenticateUser(c, 3); !verified {
 		cancel()
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"success": false,
-			"message": "Unauthorized: only an admin can perform this action",
-		})
-	}
-
-	// Check id and names are included
-	if data["uid"] == "" || data["firstname"] == "" || data["lastname"] == "" {
-		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "missing required fields",
-		})
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
 	}
 
 	// Get teacher
-	var teacher models.Teacher
-	findErr := TeacherCollection.FindOne(ctx, bson.M{"school.tid": data["uid"]}).Decode(&teacher)
+	_, findErr := Repos(c).Teachers.Get(ctx, data.UID)
 	if findErr != nil {
 		cancel()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "the teacher could not be found",
-			"error":   findErr,
-		})
+		return FindError("teacher", findErr)
 	}
 
 	var middlename string = ""
 
-	if data["middlename"] != "" {
-		middlename = data["middlename"]
+	if data.MiddleName != "" {
+		middlename = data.MiddleName
 	}
 
 	update_time, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 	update := bson.M{
 		"$set": bson.M{
-			"personal.firstname":  data["firstname"],
+			"personal.firstname":  data.FirstName,
 			"personal.middlename": middlename,
-			"personal.lastname":   data["lastname"],
+			"personal.lastname":   data.LastName,
 			"updated_at":          update_time,
 		},
 	}
 
-	_, updateErr := TeacherCollection.UpdateOne(
-		ctx,
-		bson.M{"school.tid": data["uid"]},
-		update,
-	)
+	updateErr := AuditedUpdate(c, ctx, Repos(c).Teachers, data.UID, update)
 	if updateErr != nil {
 		cancel()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "the teacher could not be updated",
-			"error":   updateErr,
-		})
+		return UpdateError("teacher", updateErr)
 	}
 	defer cancel()
 
@@ -0,0 +1,216 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+	Every endpoint reads its body into a request struct, never
+	a map, so a field of the wrong type or left out can't reach
+	a type assertion. The validate tags on the struct say what
+	a field must hold, ParseRequest checks them and returns an
+	error for each field that is wrong, which handlers send
+	back with a 400. Fields that can be left out are pointers
+	when zero is a value they could be sent with.
+*/
+
+// FieldError is a field of a request body that is missing or invalid, Field is empty when the whole body is
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// UIDRequest is the body of the endpoints that only need to know which account
+type UIDRequest struct {
+	UID string `json:"uid" validate:"required,uid"`
+}
+
+// ContactIDRequest is the body of the endpoints that only need to know which contact
+type ContactIDRequest struct {
+	ID string `json:"_id" validate:"required,objectid"`
+}
+
+// DryRunRequest is the body of the endpoints that can report what they would change without changing it
+type DryRunRequest struct {
+	DryRun bool `json:"dryrun"`
+}
+
+var validate = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New()
+
+	// Errors name fields as they are sent, by their json names
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+
+	v.RegisterValidation("uid", func(fl validator.FieldLevel) bool {
+		return ValidUID(fl.Field().String())
+	})
+	v.RegisterValidation("pen", func(fl validator.FieldLevel) bool {
+		return ValidPEN(fl.Field().String())
+	})
+	v.RegisterValidation("mailaddress", func(fl validator.FieldLevel) bool {
+		_, valid := ValidMailAddress(fl.Field().String())
+		return valid
+	})
+	v.RegisterValidation("notblank", func(fl validator.FieldLevel) bool {
+		return strings.TrimSpace(fl.Field().String()) != ""
+	})
+	v.RegisterValidation("objectid", func(fl validator.FieldLevel) bool {
+		return primitive.IsValidObjectID(fl.Field().String())
+	})
+	return v
+}
+
+// fieldMessage describes what is wrong with a field in a sentence
+func fieldMessage(err validator.FieldError) string {
+	field := err.Field()
+	unit := ""
+	if err.Kind() == reflect.String || err.Kind() == reflect.Slice || err.Kind() == reflect.Map {
+		unit = " characters"
+		if err.Kind() != reflect.String {
+			unit = " items"
+		}
+	}
+
+	switch err.Tag() {
+	case "required", "notblank":
+		return field + " is required"
+	case "min", "gte":
+		return fmt.Sprintf("%s must be at least %s%s", field, err.Param(), unit)
+	case "max", "lte":
+		return fmt.Sprintf("%s must be at most %s%s", field, err.Param(), unit)
+	case "len":
+		return fmt.Sprintf("%s must be %s%s long", field, err.Param(), unit)
+	case "oneof":
+		return fmt.Sprintf("%s must be one of: %s", field, strings.ReplaceAll(err.Param(), " ", ", "))
+	case "numeric":
+		return field + " must only contain digits"
+	case "mailaddress":
+		return field + " must be a valid email address"
+	case "uid":
+		return field + " isn't a valid ID, check it was typed correctly"
+	case "pen":
+		return field + " isn't a valid PEN, check it was typed correctly"
+	case "objectid":
+		return field + " must be the id of a record"
+	}
+	return field + " is invalid"
+}
+
+// jsonType names a Go type the way a JSON body would hold it
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return jsonType(t.Elem())
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "a whole number"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "a list"
+	}
+	return "an object"
+}
+
+// ValidateRequest checks the validate tags of a request that has been read, and returns an error for each field that is wrong
+func ValidateRequest(request interface{}) []FieldError {
+	fieldErrs := []FieldError{}
+
+	err := validate.Struct(request)
+	var validationErrs validator.ValidationErrors
+	if !errors.As(err, &validationErrs) {
+		return fieldErrs
+	}
+	for _, validationErr := range validationErrs {
+		// The namespace starts with the name of the request struct, which the client never sees
+		field := validationErr.Namespace()
+		if dot := strings.Index(field, "."); dot != -1 {
+			field = field[dot+1:]
+		}
+		fieldErrs = append(fieldErrs, FieldError{Field: field, Rule: validationErr.Tag(), Message: fieldMessage(validationErr)})
+	}
+	return fieldErrs
+}
+
+// ReadRequest reads the body into request, a pointer to a request struct, without validating it
+func ReadRequest(c *fiber.Ctx, request interface{}) []FieldError {
+	err := c.BodyParser(request)
+
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		return []FieldError{{Field: typeErr.Field, Rule: "type", Message: typeErr.Field + " must be " + jsonType(typeErr.Type)}}
+	case errors.Is(err, fiber.ErrUnprocessableEntity):
+		return []FieldError{{Rule: "body", Message: "the body must be JSON, sent with the Content-Type application/json"}}
+	}
+	return []FieldError{{Rule: "body", Message: "the body must be a JSON object"}}
+}
+
+// ParseRequest reads the body into request, a pointer to a request struct, and validates it.
+// It returns an error for each field that is wrong, none if the request can be used
+func ParseRequest(c *fiber.Ctx, request interface{}) []FieldError {
+	if fieldErrs := ReadRequest(c, request); len(fieldErrs) > 0 {
+		return fieldErrs
+	}
+	return ValidateRequest(request)
+}
+
+// ParsePatch reads a body changing only the fields sent into patch, a pointer to a struct of pointers.
+// As well as what ParseRequest checks, a field patch doesn't have, or a body changing nothing, is an error
+func ParsePatch(c *fiber.Ctx, patch interface{}) []FieldError {
+	if fieldErrs := ParseRequest(c, patch); len(fieldErrs) > 0 {
+		return fieldErrs
+	}
+
+	patchType := reflect.TypeOf(patch).Elem()
+	known := map[string]bool{}
+	for i := 0; i < patchType.NumField(); i++ {
+		known[strings.SplitN(patchType.Field(i).Tag.Get("json"), ",", 2)[0]] = true
+	}
+
+	// A field that is misspelled would otherwise be left out without a word
+	var fields map[string]json.RawMessage
+	json.Unmarshal(c.Body(), &fields)
+	fieldErrs := []FieldError{}
+	for field := range fields {
+		if !known[field] {
+			fieldErrs = append(fieldErrs, FieldError{Field: field, Rule: "unknown", Message: field + " isn't a field that can be changed"})
+		}
+	}
+	if len(fieldErrs) > 0 {
+		sort.Slice(fieldErrs, func(i, j int) bool { return fieldErrs[i].Field < fieldErrs[j].Field })
+		return fieldErrs
+	}
+
+	patchValue := reflect.ValueOf(patch).Elem()
+	for i := 0; i < patchValue.NumField(); i++ {
+		if !patchValue.Field(i).IsNil() {
+			return nil
+		}
+	}
+	return []FieldError{{Rule: "body", Message: "the body must have a field to change"}}
+}
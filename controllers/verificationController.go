@@ -0,0 +1,271 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/SowinskiBraeden/school-management-api/models"
+	"github.com/SowinskiBraeden/school-management-api/repository"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+	A personal email only becomes active once its owner opens
+	the link sent to it. Until then a changed address is kept
+	as the account's pending email, and password resets and
+	notifications are only sent to verified addresses.
+*/
+
+const verificationLifetime = 24 * time.Hour
+
+// HashToken is used to store secrets sent to users without storing the secret itself
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewVerification stores a verification for the address and returns the link to verify it
+func NewVerification(repos *repository.Repositories, uid string, userType int, email string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+
+	var verification models.Verification
+	verification.ID = primitive.NewObjectID()
+	verification.Token = HashToken(token)
+	verification.UID = uid
+	verification.UserType = userType
+	verification.Email = models.Encrypted(email)
+	verification.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	verification.Expires_at = verification.Created_at.Add(verificationLifetime)
+
+	if insertErr := repos.Verifications.Insert(ctx, verification); insertErr != nil {
+		return "", insertErr
+	}
+
+	return os.Getenv("SYSTEM_URL") + "/api/v1/verifyEmail?token=" + token, nil
+}
+
+func SendVerification(repos *repository.Repositories, uid string, userType int, username string, email string) bool {
+	link, err := NewVerification(repos, uid, userType, email)
+	if err != nil {
+		return false
+	}
+
+	r := NewRequest([]string{email}, "Verify Email")
+	return r.Send("./templates/verifyEmail.html", map[string]string{"username": username, "link": link})
+}
+
+// errStaleVerification is returned by checkVerification when the link isn't for the address waiting to be verified
+var errStaleVerification = errors.New("the verification is for an address that is no longer waiting to be verified")
+
+// checkVerification returns errStaleVerification unless the account is still waiting to verify the address
+func checkVerification(ctx context.Context, repos *repository.Repositories, verification models.Verification) error {
+	var email, pending string
+	if verification.UserType == 2 {
+		teacher, err := repos.Teachers.Get(ctx, verification.UID)
+		if err != nil {
+			return err
+		}
+		email, pending = teacher.Personal.Email, teacher.Account.PendingEmail
+	} else {
+		student, err := repos.Students.Get(ctx, verification.UID)
+		if err != nil {
+			return err
+		}
+		email, pending = string(student.Personal.Email), string(student.Account.PendingEmail)
+	}
+
+	if pending == "" {
+		pending = email
+	}
+	if pending != string(verification.Email) {
+		return errStaleVerification
+	}
+	return nil
+}
+
+func VerifyEmail(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+
+	token := c.Query("token")
+	if token == "" {
+		cancel()
+		return NewError(fiber.StatusBadRequest, CodeInvalidRequest, "missing required fields")
+	}
+
+	var verification models.Verification
+	findErr := Repos(c).Verifications.FindOne(ctx, bson.M{
+		"token":      HashToken(token),
+		"expires_at": bson.M{"$gt": time.Now()},
+	}, nil, &verification)
+	if findErr != nil {
+		cancel()
+		return NewError(fiber.StatusBadRequest, CodeVerificationInvalid, "the verification link is invalid or has expired")
+	}
+
+	// Only a student's personal email is stored encrypted
+	var email interface{} = string(verification.Email)
+	if verification.UserType == 1 {
+		email = models.Searchable(verification.Email)
+	}
+
+	update_time, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	update := bson.M{
+		"$set": bson.M{
+			"personal.email":        email,
+			"account.verifiedemail": true,
+			"account.pendingemail":  "",
+			"updated_at":            update_time,
+		},
+	}
+
+	// The link must be for the address waiting to be verified, or the current one when none is waiting,
+	// so an old link can't replace a newer pending change. It is checked with the update, in one transaction
+	repos := Repos(c)
+	updateErr := repos.Atomic(ctx,
+		repository.Step{Do: func(ctx context.Context) error {
+			return checkVerification(ctx, repos, verification)
+		}},
+		repository.Step{Do: func(ctx context.Context) error {
+			return repos.Account(verification.UserType).Update(ctx, verification.UID, update)
+		}},
+	)
+	if updateErr == errStaleVerification || updateErr == repository.ErrNotFound {
+		repos.Verifications.DeleteMany(ctx, bson.M{"_id": verification.ID})
+		cancel()
+		return NewError(fiber.StatusBadRequest, CodeVerificationInvalid, "the verification link is invalid or has expired")
+	}
+	if updateErr != nil {
+		cancel()
+		return InternalError("the email could not be verified", updateErr)
+	}
+
+	// Any older links for the account are no longer needed
+	repos.Verifications.DeleteMany(ctx, bson.M{"uid": verification.UID, "usertype": verification.UserType})
+	defer cancel()
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "successfully verified email",
+	})
+}
+
+// SendVerificationRequest is the body of SendVerificationEmail
+type SendVerificationRequest struct {
+	UID      string `json:"uid" validate:"required,uid"`
+	UserType string `json:"usertype" validate:"oneof=student teacher"`
+}
+
+func SendVerificationEmail(c *fiber.Ctx) error {
+	var data SendVerificationRequest
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+
+	if fieldErrs := ParseRequest(c, &data); len(fieldErrs) > 0 {
+		cancel()
+		return InvalidRequest(fieldErrs)
+	}
+
+	// Ensure Authenticated admin sent request
+	if verified, _ := AuthenticateUser(c, 3); !verified {
+		cancel()
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
+	}
+
+	var userType int = 1
+	var firstname, email, pending string
+	if data.UserType == "teacher" {
+		userType = 2
+		teacher, findErr := Repos(c).Teachers.Get(ctx, data.UID)
+		if findErr != nil {
+			cancel()
+			return FindError("teacher", findErr)
+		}
+		firstname, email, pending = teacher.Personal.FirstName, teacher.Personal.Email, teacher.Account.PendingEmail
+	} else {
+		student, findErr := Repos(c).Students.Get(ctx, data.UID)
+		if findErr != nil {
+			cancel()
+			return FindError("student", findErr)
+		}
+		firstname, email, pending = student.Personal.FirstName, string(student.Personal.Email), string(student.Account.PendingEmail)
+	}
+	defer cancel()
+
+	// A pending address takes priority over the current unverified one
+	if pending != "" {
+		email = pending
+	}
+
+	if sent := SendVerification(Repos(c), data.UID, userType, firstname, email); !sent {
+		return NewError(fiber.StatusBadGateway, CodeEmailNotSent, "failed to send verification email")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "successfully sent verification email",
+	})
+}
+
+func UnverifiedAccounts(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Ensure Authenticated admin sent request
+	if verified, _ := AuthenticateUser(c, 3); !verified {
+		return NewError(fiber.StatusUnauthorized, CodeUnauthorized, "Unauthorized: only an admin can perform this action")
+	}
+
+	repos := Repos(c)
+	filter := repository.AccountFilter{Unverified: true}
+	accounts := []fiber.Map{}
+
+	students, err := repos.Students.Find(ctx, filter)
+	if err != nil {
+		return InternalError("failed to find unverified students", err)
+	}
+	for _, student := range students {
+		accounts = append(accounts, fiber.Map{
+			"usertype":     "student",
+			"uid":          student.School.SID,
+			"firstname":    student.Personal.FirstName,
+			"lastname":     student.Personal.LastName,
+			"email":        student.Personal.Email,
+			"pendingemail": student.Account.PendingEmail,
+		})
+	}
+
+	teachers, err := repos.Teachers.Find(ctx, filter)
+	if err != nil {
+		return InternalError("failed to find unverified teachers", err)
+	}
+	for _, teacher := range teachers {
+		accounts = append(accounts, fiber.Map{
+			"usertype":     "teacher",
+			"uid":          teacher.School.TID,
+			"firstname":    teacher.Personal.FirstName,
+			"lastname":     teacher.Personal.LastName,
+			"email":        teacher.Personal.Email,
+			"pendingemail": teacher.Account.PendingEmail,
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success":  true,
+		"message":  "successfully retrieved unverified accounts",
+		"accounts": accounts,
+	})
+}
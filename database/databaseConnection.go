@@ -3,7 +3,6 @@ package database
 import (
 	"context"
 	"fmt"
-	"log"
 	"os"
 	"time"
 
@@ -12,35 +11,44 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+/*
+	The client is created when the package loads but doesn't
+	connect until Connect is called, so the packages using it
+	can be loaded, and run against the in-memory repositories,
+	without a reachable MongoDB.
+*/
+
+var clientErr error
+
 func DBinstance() *mongo.Client {
 	godotenv.Load(".env")
 	mongoURI := os.Getenv("mongoURI")
 
-	fmt.Printf("Connecting to mongodb: %v\n", mongoURI)
-
 	client, err := mongo.NewClient(options.Client().ApplyURI(mongoURI))
 	if err != nil {
-		log.Fatal(err)
-	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-
-	defer cancel()
-
-	err = client.Connect(ctx)
-	if err != nil {
-		log.Fatal(err)
+		// Connect reports the error, the client stays unconnected until then
+		clientErr = err
+		client, _ = mongo.NewClient()
 	}
-	fmt.Println("connected to mongodb")
 	return client
 }
 
 var Client *mongo.Client = DBinstance()
 
-func OpenCollection(client *mongo.Client, collectionName string) *mongo.Collection {
-	godotenv.Load(".env")
-	dbo := os.Getenv("dbo")
-	var collection *mongo.Collection = client.Database(dbo).Collection(collectionName)
+// Connect connects the client to MongoDB, it must be called before the server starts
+func Connect() error {
+	if clientErr != nil {
+		return clientErr
+	}
 
-	return collection
+	fmt.Printf("Connecting to mongodb: %v\n", os.Getenv("mongoURI"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := Client.Connect(ctx); err != nil {
+		return err
+	}
+	fmt.Println("connected to mongodb")
+	return nil
 }
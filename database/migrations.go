@@ -0,0 +1,454 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+	Changes to the shape of the stored documents are made by
+	migrations, run in order of their version with
+
+		go run main.go -migrate up
+
+	Each applied migration is recorded in schema_migrations, and
+	the server won't start until every migration has been
+	applied. A migration must never be edited once released, add
+	a new one to the end of Migrations instead. Up and Down must
+	be safe to run again if they fail part way.
+*/
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context, db *mongo.Database) error
+	Down    func(ctx context.Context, db *mongo.Database) error // nil if the migration can't be rolled back
+}
+
+type MigrationStatus struct {
+	Version    int        `json:"version"`
+	Name       string     `json:"name"`
+	Applied_at *time.Time `json:"applied_at"` // nil while the migration is pending
+}
+
+// appliedMigration is the record of a migration in schema_migrations
+type appliedMigration struct {
+	Version    int       `bson:"_id"`
+	Name       string    `bson:"name"`
+	Applied_at time.Time `bson:"applied_at"`
+}
+
+var ErrMigrationRunning = errors.New("another migration is running, if none is remove the lock document from schema_migrations")
+
+// migrationIndexes are the indexes made by the first migration, by collection
+var migrationIndexes = map[string][]mongo.IndexModel{
+	"students": {
+		{Keys: bson.D{{Key: "school.sid", Value: 1}}},
+		{Keys: bson.D{{Key: "school.pen", Value: 1}}},
+		{Keys: bson.D{{Key: "account.schoolemail", Value: 1}}},
+	},
+	"teachers": {
+		{Keys: bson.D{{Key: "school.tid", Value: 1}}},
+		{Keys: bson.D{{Key: "account.schoolemail", Value: 1}}},
+	},
+	"admins": {
+		{Keys: bson.D{{Key: "aid", Value: 1}}},
+		{Keys: bson.D{{Key: "schoolemail", Value: 1}}},
+	},
+	"lockers": {
+		{Keys: bson.D{{Key: "lockernumber", Value: 1}}},
+	},
+	"images": {
+		{Keys: bson.D{{Key: "name", Value: 1}}},
+	},
+	"cids": {
+		{Keys: bson.D{{Key: "cid", Value: 1}}},
+	},
+	"loginattempts": {
+		{Keys: bson.D{{Key: "uid", Value: 1}, {Key: "usertype", Value: 1}, {Key: "created_at", Value: -1}}},
+	},
+	"accesslog": {
+		{Keys: bson.D{{Key: "sid", Value: 1}, {Key: "accessed_at", Value: -1}}},
+	},
+	// A unique seq stops two servers appending to the same point of the audit chain
+	"auditlog": {
+		{Keys: bson.D{{Key: "seq", Value: 1}}, Options: options.Index().SetUnique(true)},
+	},
+}
+
+var Migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create indexes",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			for collection, indexes := range migrationIndexes {
+				if _, err := db.Collection(collection).Indexes().CreateMany(ctx, indexes); err != nil {
+					return fmt.Errorf("%s: %w", collection, err)
+				}
+			}
+			return nil
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			for collection, indexes := range migrationIndexes {
+				for _, index := range indexes {
+					_, err := db.Collection(collection).Indexes().DropOne(ctx, indexName(index.Keys.(bson.D)))
+					if err != nil && !isIndexNotFound(err) {
+						return fmt.Errorf("%s: %w", collection, err)
+					}
+				}
+			}
+			return nil
+		},
+	},
+	{
+		// Contacts were once pushed to contacts, and stored as ObjectIDs, they belong in personal.contacts as hex IDs
+		Version: 2,
+		Name:    "move student contacts to personal.contacts",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			return convertContacts(ctx, db, bson.M{"$or": bson.A{
+				bson.M{"contacts": bson.M{"$exists": true}},
+				bson.M{"personal.contacts": bson.M{"$type": "objectId"}},
+			}}, func(id primitive.ObjectID) interface{} { return id.Hex() })
+		},
+		// Contacts moved from contacts stay in personal.contacts, where they were read from
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return convertContacts(ctx, db, bson.M{
+				"personal.contacts": bson.M{"$type": "string"},
+			}, func(id primitive.ObjectID) interface{} { return id })
+		},
+	},
+	{
+		// A PEN imported with the JSON field name was stored at school.ped
+		Version: 3,
+		Name:    "move school.ped to school.pen",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			students := db.Collection("students")
+			_, err := students.UpdateMany(ctx, bson.M{
+				"school.ped": bson.M{"$exists": true},
+				"$or": bson.A{
+					bson.M{"school.pen": bson.M{"$exists": false}},
+					bson.M{"school.pen": ""},
+				},
+			}, bson.M{"$rename": bson.M{"school.ped": "school.pen"}})
+			if err != nil {
+				return err
+			}
+			// Where both were set school.pen was the one in use
+			_, err = students.UpdateMany(ctx, bson.M{"school.ped": bson.M{"$exists": true}}, bson.M{"$unset": bson.M{"school.ped": ""}})
+			return err
+		},
+		// school.pen was always the field read, so nothing needs to be put back
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return nil
+		},
+	},
+	{
+		// IDs were reserved in cids, unique indexes on the accounts now stop two accounts getting the same one
+		Version: 4,
+		Name:    "unique account IDs and PENs",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			for _, index := range uniqueIndexes {
+				if err := replaceIndex(ctx, db.Collection(index.collection), index.model(true)); err != nil {
+					return fmt.Errorf("%s: %w", index.collection, err)
+				}
+			}
+			return db.Collection("cids").Drop(ctx)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			for _, index := range uniqueIndexes {
+				if err := replaceIndex(ctx, db.Collection(index.collection), index.model(false)); err != nil {
+					return fmt.Errorf("%s: %w", index.collection, err)
+				}
+			}
+			return rebuildIDs(ctx, db)
+		},
+	},
+}
+
+// uniqueIndex is an index made unique by migration 4
+type uniqueIndex struct {
+	collection string
+	field      string
+}
+
+var uniqueIndexes = []uniqueIndex{
+	{"students", "school.sid"},
+	{"students", "school.pen"},
+	{"teachers", "school.tid"},
+	{"admins", "aid"},
+}
+
+func (i uniqueIndex) model(unique bool) mongo.IndexModel {
+	model := mongo.IndexModel{Keys: bson.D{{Key: i.field, Value: 1}}}
+	if unique {
+		// Accounts without the field, like students enrolled before PENs, don't clash with each other
+		model.Options = options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{i.field: bson.M{"$gt": ""}})
+	}
+	return model
+}
+
+// replaceIndex drops the index on the same keys, if there is one, and creates model in its place
+func replaceIndex(ctx context.Context, collection *mongo.Collection, model mongo.IndexModel) error {
+	_, err := collection.Indexes().DropOne(ctx, indexName(model.Keys.(bson.D)))
+	if err != nil && !isIndexNotFound(err) {
+		return err
+	}
+	if _, err := collection.Indexes().CreateOne(ctx, model); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("two records share a value of %s, give one a new value and migrate again: %w", indexName(model.Keys.(bson.D)), err)
+		}
+		return err
+	}
+	return nil
+}
+
+// rebuildIDs fills cids with the ID of every account, as it was before migration 4
+func rebuildIDs(ctx context.Context, db *mongo.Database) error {
+	cids := db.Collection("cids")
+	if _, err := cids.Indexes().CreateMany(ctx, migrationIndexes["cids"]); err != nil {
+		return err
+	}
+
+	accounts := []struct {
+		collection string
+		field      string
+		parentType int
+	}{
+		{"students", "$school.sid", 1},
+		{"teachers", "$school.tid", 2},
+		{"admins", "$aid", 3},
+	}
+	for _, account := range accounts {
+		cursor, err := db.Collection(account.collection).Aggregate(ctx, mongo.Pipeline{
+			{{Key: "$project", Value: bson.M{"cid": account.field, "removed": bson.M{"$ne": bson.A{bson.M{"$ifNull": bson.A{"$removed", nil}}, nil}}}}},
+		})
+		if err != nil {
+			return err
+		}
+		var ids []struct {
+			CID     string `bson:"cid"`
+			Removed bool   `bson:"removed"`
+		}
+		if err := cursor.All(ctx, &ids); err != nil {
+			return err
+		}
+		for _, id := range ids {
+			_, err := cids.UpdateOne(ctx, bson.M{"cid": id.CID}, bson.M{"$setOnInsert": bson.M{
+				"_id":        primitive.NewObjectID(),
+				"parenttype": account.parentType,
+				"removed":    id.Removed,
+			}}, options.Update().SetUpsert(true))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// indexName is the name MongoDB gives an index on keys
+func indexName(keys bson.D) string {
+	name := ""
+	for i, key := range keys {
+		if i > 0 {
+			name += "_"
+		}
+		name += fmt.Sprintf("%s_%v", key.Key, key.Value)
+	}
+	return name
+}
+
+func isIndexNotFound(err error) bool {
+	var commandErr mongo.CommandError
+	return errors.As(err, &commandErr) && (commandErr.Name == "IndexNotFound" || commandErr.Name == "NamespaceNotFound")
+}
+
+// convertContacts rewrites the contacts of the students matching filter, converting each ID with convert
+func convertContacts(ctx context.Context, db *mongo.Database, filter bson.M, convert func(id primitive.ObjectID) interface{}) error {
+	students := db.Collection("students")
+	cursor, err := students.Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var student struct {
+			ID       primitive.ObjectID `bson:"_id"`
+			Contacts bson.A             `bson:"contacts"`
+			Personal struct {
+				Contacts bson.A `bson:"contacts"`
+			} `bson:"personal"`
+		}
+		if err := cursor.Decode(&student); err != nil {
+			return err
+		}
+
+		contacts := bson.A{}
+		seen := map[string]bool{}
+		for _, contact := range append(student.Personal.Contacts, student.Contacts...) {
+			var id primitive.ObjectID
+			switch value := contact.(type) {
+			case primitive.ObjectID:
+				id = value
+			case string:
+				if id, err = primitive.ObjectIDFromHex(value); err != nil {
+					return fmt.Errorf("student %s has the invalid contact ID %q", student.ID.Hex(), value)
+				}
+			default:
+				return fmt.Errorf("student %s has a contact ID of type %T", student.ID.Hex(), contact)
+			}
+			if !seen[id.Hex()] {
+				seen[id.Hex()] = true
+				contacts = append(contacts, convert(id))
+			}
+		}
+
+		_, err := students.UpdateOne(ctx, bson.M{"_id": student.ID}, bson.M{
+			"$set":   bson.M{"personal.contacts": contacts},
+			"$unset": bson.M{"contacts": ""},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func appliedMigrations(ctx context.Context, db *mongo.Database) (map[int]appliedMigration, error) {
+	cursor, err := db.Collection("schema_migrations").Find(ctx, bson.M{"_id": bson.M{"$type": "number"}})
+	if err != nil {
+		return nil, err
+	}
+	var records []appliedMigration
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+
+	applied := map[int]appliedMigration{}
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
+
+// MigrationsStatus returns every migration and when it was applied
+func MigrationsStatus(ctx context.Context, db *mongo.Database) ([]MigrationStatus, error) {
+	applied, err := appliedMigrations(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	status := []MigrationStatus{}
+	for _, migration := range Migrations {
+		entry := MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if record, ok := applied[migration.Version]; ok {
+			entry.Applied_at = &record.Applied_at
+		}
+		status = append(status, entry)
+	}
+	return status, nil
+}
+
+// CheckMigrations returns an error unless every migration has been applied, and no unknown one has
+func CheckMigrations(ctx context.Context, db *mongo.Database) error {
+	applied, err := appliedMigrations(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	pending := 0
+	for _, migration := range Migrations {
+		if _, ok := applied[migration.Version]; !ok {
+			pending++
+		}
+		delete(applied, migration.Version)
+	}
+	for version := range applied {
+		return fmt.Errorf("the database has migration %d, which this build doesn't know, upgrade the server", version)
+	}
+	if pending > 0 {
+		return fmt.Errorf("the database has %d pending migrations, run the server with -migrate up", pending)
+	}
+	return nil
+}
+
+// withMigrationLock runs fn while holding the lock, so only one server migrates at a time
+func withMigrationLock(ctx context.Context, db *mongo.Database, fn func() error) error {
+	migrations := db.Collection("schema_migrations")
+	_, err := migrations.InsertOne(ctx, bson.M{"_id": "lock", "locked_at": time.Now()})
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrMigrationRunning
+	}
+	if err != nil {
+		return err
+	}
+	defer migrations.DeleteOne(context.Background(), bson.M{"_id": "lock"})
+
+	return fn()
+}
+
+// MigrateUp applies every pending migration in order, and returns the versions applied
+func MigrateUp(ctx context.Context, db *mongo.Database) ([]int, error) {
+	ran := []int{}
+	err := withMigrationLock(ctx, db, func() error {
+		applied, err := appliedMigrations(ctx, db)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range Migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			if err := migration.Up(ctx, db); err != nil {
+				return fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, err)
+			}
+			record := appliedMigration{Version: migration.Version, Name: migration.Name, Applied_at: time.Now()}
+			if _, err := db.Collection("schema_migrations").InsertOne(ctx, record); err != nil {
+				return err
+			}
+			ran = append(ran, migration.Version)
+		}
+		return nil
+	})
+	return ran, err
+}
+
+// MigrateDown rolls back the latest applied migration, and returns its version, or 0 if none was applied
+func MigrateDown(ctx context.Context, db *mongo.Database) (int, error) {
+	version := 0
+	err := withMigrationLock(ctx, db, func() error {
+		applied, err := appliedMigrations(ctx, db)
+		if err != nil {
+			return err
+		}
+
+		for i := len(Migrations) - 1; i >= 0; i-- {
+			migration := Migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+			if migration.Down == nil {
+				return fmt.Errorf("migration %d %s can't be rolled back", migration.Version, migration.Name)
+			}
+			if err := migration.Down(ctx, db); err != nil {
+				return fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, err)
+			}
+			if _, err := db.Collection("schema_migrations").DeleteOne(ctx, bson.M{"_id": migration.Version}); err != nil {
+				return err
+			}
+			version = migration.Version
+			return nil
+		}
+		return nil
+	})
+	return version, err
+}
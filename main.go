@@ -1,9 +1,19 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"log"
 	"os"
+	"strings"
+	"time"
 
+	"github.com/SowinskiBraeden/school-management-api/controllers"
+	"github.com/SowinskiBraeden/school-management-api/database"
+	"github.com/SowinskiBraeden/school-management-api/models"
+	"github.com/SowinskiBraeden/school-management-api/repository"
 	"github.com/SowinskiBraeden/school-management-api/routes"
 	"github.com/joho/godotenv"
 
@@ -14,16 +24,160 @@ import (
 const version string = "\nv1.1.3-Beta"
 
 func main() {
+	benchmarkHash := flag.Bool("benchmark-hash", false, "time the password hashing settings on this machine and exit")
+	ldapSync := flag.Bool("ldap-sync", false, "sync teachers and admins with the LDAP directory, print the report and exit")
+	dryRun := flag.Bool("dry-run", false, "with -ldap-sync, report the changes without making them")
+	newKey := flag.String("new-encryption-key", "", "add a key with this id to ENCRYPTION_KEY_FILE, make it current and exit")
+	migrate := flag.String("migrate", "", "up applies every pending MongoDB migration, down rolls back the latest, status lists them, then exit")
+	checkOpenAPI := flag.Bool("check-openapi", false, "check every route is documented in the OpenAPI document, print any that aren't and exit")
+	flag.Parse()
+
 	fmt.Println(version)
-	app := fiber.New()
+
+	// Settings are read once the .env is loaded, never when their package is, which can be before it
+	godotenv.Load(".env")
+	models.Hashing = models.LoadHashConfig()
+	controllers.SecretKey = os.Getenv("secret")
+
+	if *benchmarkHash {
+		fmt.Println("Time to hash one password, pick the strongest setting that stays under your target login time (around 250ms):")
+		for _, result := range models.BenchmarkHashing() {
+			fmt.Println(result)
+		}
+		return
+	}
+
+	if *newKey != "" {
+		path := os.Getenv("ENCRYPTION_KEY_FILE")
+		if path == "" {
+			log.Fatal("ENCRYPTION_KEY_FILE must be set")
+		}
+		if err := models.AddKey(path, *newKey); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Added key %s to %s, restart the server to re-encrypt records with it\n", *newKey, path)
+		return
+	}
+
+	if *checkOpenAPI {
+		if !openAPIContract() {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *migrate != "" {
+		if err := runMigrations(*migrate); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	repos, err := openStorage()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *ldapSync {
+		report, err := controllers.SyncDirectory(repos, *dryRun)
+		output, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(output))
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	app := fiber.New(fiber.Config{
+		ErrorHandler: controllers.ErrorHandler,
+	})
 
 	app.Use(cors.New(cors.Config{
 		AllowCredentials: true,
 	}))
 
-	routes.Setup(app)
+	routes.Version = strings.TrimSpace(version)
+	routes.Setup(app, repos)
 
-	godotenv.Load(".env")
 	port := os.Getenv("PORT")
 	app.Listen(":" + port)
 }
+
+// openStorage opens the backend chosen by STORAGE, every record is kept in it
+func openStorage() (*repository.Repositories, error) {
+	switch os.Getenv("STORAGE") {
+	case "", "mongodb":
+		if err := database.Connect(); err != nil {
+			return nil, err
+		}
+		db := database.Client.Database(os.Getenv("dbo"))
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := database.CheckMigrations(ctx, db); err != nil {
+			return nil, err
+		}
+		return repository.NewMongo(db), nil
+	case "sqlite":
+		path := os.Getenv("SQLITE_PATH")
+		if path == "" {
+			path = "school.db"
+		}
+		return repository.NewSQLite(path)
+	}
+	return nil, fmt.Errorf("unknown STORAGE %q, use mongodb or sqlite", os.Getenv("STORAGE"))
+}
+
+// runMigrations runs the migrate command on the MongoDB database
+func runMigrations(command string) error {
+	if err := database.Connect(); err != nil {
+		return err
+	}
+	db := database.Client.Database(os.Getenv("dbo"))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+
+	switch command {
+	case "up":
+		applied, err := database.MigrateUp(ctx, db)
+		for _, version := range applied {
+			fmt.Printf("applied migration %d\n", version)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("the database is up to date")
+		}
+		return err
+	case "down":
+		version, err := database.MigrateDown(ctx, db)
+		if err == nil && version == 0 {
+			fmt.Println("no migration has been applied")
+		} else if err == nil {
+			fmt.Printf("rolled back migration %d\n", version)
+		}
+		return err
+	case "status":
+		status, err := database.MigrationsStatus(ctx, db)
+		for _, migration := range status {
+			applied := "pending"
+			if migration.Applied_at != nil {
+				applied = "applied " + migration.Applied_at.Format(time.RFC3339)
+			}
+			fmt.Printf("%3d  %-45s %s\n", migration.Version, migration.Name, applied)
+		}
+		return err
+	}
+	return fmt.Errorf("unknown migrate command %q, use up, down or status", command)
+}
+
+// openAPIContract runs the OpenAPI check and reports whether every route and operation match
+func openAPIContract() bool {
+	problems := routes.OpenAPIProblems()
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	if len(problems) > 0 {
+		fmt.Printf("openapi: %d problems\n", len(problems))
+		return false
+	}
+	fmt.Println("openapi: ok")
+	return true
+}
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AccessEvent records one read of a student's personal information
+type AccessEvent struct {
+	ID            primitive.ObjectID `bson:"_id"`
+	SID           string             `json:"sid"`           // student whose record was read
+	Accessor      string             `json:"accessor"`      // uid of the user or "apikey:<prefix>"
+	AccessorType  int                `json:"accessortype"`  // A number representing the user (0: API key, 1: student, 2: teacher, 3: admin)
+	Impersonation string             `json:"impersonation"` // id of the impersonation session the read was made in, if any
+	Action        string             `json:"action"`        // route the record was read through
+	Fields        []string           `json:"fields"`        // parts of the record returned, e.g. student, contacts, locker
+	IP            string             `json:"ip"`
+	Created_at    time.Time          `json:"created_at"`
+}
+
+// AccessAlert is raised when an account reads an unusual number of student records in an hour
+type AccessAlert struct {
+	ID           primitive.ObjectID `bson:"_id"`
+	Accessor     string             `json:"accessor"`
+	AccessorType int                `json:"accessortype"`
+	Records      int                `json:"records"` // distinct students read in the window
+	Window_start time.Time          `json:"window_start"`
+	Created_at   time.Time          `json:"created_at"`
+}
+
+// AccessSummary is one account that read a student's record, as shown to the student
+type AccessSummary struct {
+	Accessor     string    `json:"accessor"`
+	AccessorType int       `json:"accessortype"`
+	Name         string    `json:"name"`
+	Views        int       `json:"views"`
+	Last_viewed  time.Time `json:"last_viewed"`
+}
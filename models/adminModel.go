@@ -1,41 +1,39 @@
 package models
 
 import (
-	"context"
 	"math/rand"
 	"os"
 	"strconv"
 	"strings"
 	"time"
-	"unicode"
 
-	"github.com/SowinskiBraeden/school-management-api/database"
-	"golang.org/x/crypto/bcrypt"
-
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
-var AdminCollection *mongo.Collection = database.OpenCollection(database.Client, "admins")
-
 type Admin struct {
-	ID           primitive.ObjectID `bson:"_id"`
-	FirstName    string             `json:"firstname" validate:"required"`
-	LastName     string             `json:"lastname" validate:"required"`
-	Email        string             `json:"email" validate:"required"`
-	SchoolEmail  string             `json:"schoolemail"`
-	Password     string             `json:"-" validate:"min=10,max=32"`
-	TempPassword bool               `json:"temppassword"`
-	AID          string             `json:"aid"`
-	Created_at   time.Time          `json:"created_at"`
-	Updated_at   time.Time          `json:"updated_at"`
+	ID                 primitive.ObjectID `bson:"_id"`
+	FirstName          string             `json:"firstname" validate:"required"`
+	LastName           string             `json:"lastname" validate:"required"`
+	Email              string             `json:"email" validate:"required"`
+	SchoolEmail        string             `json:"schoolemail"`
+	Password           string             `json:"-" validate:"min=10,max=32"`
+	TempPassword       bool               `json:"temppassword"`
+	AccountDisabled    bool               `bson:"accountdisabled"`
+	Attempts           int                `json:"attempts"`           // login attempts max 5
+	LockedUntil        time.Time          `json:"lockeduntil"`        // login is refused until this time after too many attempts
+	Lockouts           int                `json:"lockouts"`           // consecutive lockouts, each one doubles the cooldown
+	HashHistory        []string           `json:"-"`                  // Hashes of the passwords chosen by the user, newest last and the current one included, up to the policy's history depth (auto generated passwords aren't kept)
+	PasswordChanged_at time.Time          `json:"passwordchanged_at"` // when the password was last chosen by the admin
+	AID                string             `json:"aid"`
+	DirectoryDN        string             `json:"directorydn"`       // DN of the LDAP entry the account is synced from, empty if created here
+	Removed            *Removal           `json:"removed,omitempty"` // set while the account is soft deleted
+	Created_at         time.Time          `json:"created_at"`
+	Updated_at         time.Time          `json:"updated_at"`
 }
 
-func (a *Admin) EmailExists(email string) bool {
-	var admin Admin
-	findErr := AdminCollection.FindOne(context.TODO(), bson.M{"account.schoolemail": email}).Decode(&admin)
-	return findErr == nil
+// Locked reports whether the account is still in a lockout cooldown
+func (a *Admin) Locked() bool {
+	return a.LockedUntil.After(time.Now())
 }
 
 func (a *Admin) GenerateSchoolEmail(offset int, lastEmail string) string {
@@ -53,37 +51,25 @@ func (a *Admin) GenerateSchoolEmail(offset int, lastEmail string) string {
 	return email
 }
 
-func (s *Admin) HashPassword(password string) string {
-	hash, _ := bcrypt.GenerateFromPassword([]byte(password), 14)
-	return string(hash)
+func (s *Admin) HashPassword(password string) (string, error) {
+	return HashPassword(password)
 }
 
 func (a *Admin) ComparePasswords(password string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(a.Password), []byte(password))
-	return err == nil
+	return VerifyPassword(a.Password, password)
 }
 
-func (a *Admin) CheckPasswordStrength(password string) bool {
-
-	var hasUpper bool = false
-	for _, r := range password {
-		if unicode.IsUpper(r) && unicode.IsLetter(r) {
-			hasUpper = true
-		}
-	}
-
-	var hasLower bool = false
-	for _, r := range password {
-		if !unicode.IsLower(r) && unicode.IsLetter(r) {
-			hasLower = true
-		}
-	}
+// CheckPassword returns every rule of the admin password policy the password fails
+func (a *Admin) CheckPassword(policy PasswordPolicy, password string) []PolicyError {
+	return policy.Check(password, a.HashHistory)
+}
 
-	if strings.ContainsAny(password, specialCharSet) && hasLower && hasUpper && len(password) >= 8 {
-		return true
-	} else {
-		return false
+func (a *Admin) PasswordExpired(policy PasswordPolicy) bool {
+	changed := a.PasswordChanged_at
+	if changed.IsZero() {
+		changed = a.Created_at
 	}
+	return policy.Expired(changed)
 }
 
 func (a *Admin) GeneratePassword(passwordLength, minSpecialChar, minNum, minUpperCase int) string {
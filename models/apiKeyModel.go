@@ -0,0 +1,60 @@
+package models
+
+import (
+	"net"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// APIKeyScopes are the scopes an admin can give an API key
+var APIKeyScopes = []string{"students:read", "contacts:read", "lockers:read"}
+
+type APIKey struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	Name        string             `json:"name" validate:"required"` // the integration the key was made for
+	Prefix      string             `json:"prefix"`                   // start of the key, used to identify it
+	Hash        string             `json:"-"`                        // sha256 of the key, the key itself is never stored
+	Scopes      []string           `json:"scopes"`
+	AllowedIPs  []string           `json:"allowedips"` // IPs or CIDR ranges the key can be used from, empty allows any
+	CreatedBy   string             `json:"createdby"`  // aid of the admin who created the key
+	Revoked     bool               `json:"revoked"`
+	Revoked_at  time.Time          `json:"revoked_at"`
+	Expires_at  time.Time          `json:"expires_at"`
+	LastUsed_at time.Time          `json:"lastused_at"`
+	Created_at  time.Time          `json:"created_at"`
+}
+
+func ValidAPIKeyScope(scope string) bool {
+	for _, s := range APIKeyScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (k *APIKey) AllowsIP(ip string) bool {
+	if len(k.AllowedIPs) == 0 {
+		return true
+	}
+	addr := net.ParseIP(ip)
+	for _, allowed := range k.AllowedIPs {
+		if allowed == ip {
+			return true
+		}
+		if _, network, err := net.ParseCIDR(allowed); err == nil && addr != nil && network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AuditChange is one field changed by a write, values are JSON encoded
+type AuditChange struct {
+	Field  string `json:"field"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// AuditEntry records one write, each entry holds the hash of the one before it
+type AuditEntry struct {
+	ID            primitive.ObjectID `bson:"_id"`
+	Seq           int64              `json:"seq"`           // position in the chain, starting at 1
+	Actor         string             `json:"actor"`         // uid of the user, "apikey:<prefix>" or "system"
+	ActorType     int                `json:"actortype"`     // A number representing the user (0: API key or system, 1: student, 2: teacher, 3: admin)
+	Impersonation string             `json:"impersonation"` // id of the impersonation session the write was made in, if any
+	Action        string             `json:"action"`        // route the write was made through
+	TargetType    string             `json:"targettype"`    // collection written to
+	Target        string             `json:"target"`        // sid, tid, aid or _id of the written document
+	Changes       []AuditChange      `json:"changes"`
+	IP            string             `json:"ip"`
+	Created_at    time.Time          `json:"created_at"`
+	PrevHash      string             `json:"prevhash"`
+	Hash          string             `json:"hash"`
+}
+
+// ComputeHash hashes every field of the entry except its own hash
+func (e *AuditEntry) ComputeHash() string {
+	changes, _ := json.Marshal(e.Changes)
+	sum := sha256.Sum256([]byte(fmt.Sprintf(
+		"%d|%s|%s|%d|%s|%s|%s|%s|%s|%s|%s",
+		e.Seq, e.PrevHash, e.Actor, e.ActorType, e.Impersonation, e.Action,
+		e.TargetType, e.Target, e.IP, e.Created_at.UTC().Format(time.RFC3339), changes,
+	)))
+	return hex.EncodeToString(sum[:])
+}
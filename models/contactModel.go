@@ -13,11 +13,11 @@ type Contact struct {
 	LastName   string             `json:"lastname" validate:"required"`
 	Province   string             `json:"province"`
 	City       string             `json:"city"`
-	Address    string             `json:"address" validate:"required"`
-	Postal     string             `json:"postal"`
-	HomePhone  float64            `json:"homephone" validate:"required"`
-	WorkPhone  float64            `json:"workphone"`
-	Email      string             `json:"email" validate:"required"`
+	Address    Encrypted          `json:"address" validate:"required"`
+	Postal     Encrypted          `json:"postal"`
+	HomePhone  EncryptedNumber    `json:"homephone" validate:"required"`
+	WorkPhone  EncryptedNumber    `json:"workphone"`
+	Email      Searchable         `json:"email" validate:"required"`
 	Relation   string             `json:"relation" validate:"required"`
 	Priotrity  float64            `json:"priority" validate:"required"`
 	Created_at time.Time          `json:"created_at"`
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DirectoryChange is one account created, updated or disabled by a directory sync
+type DirectoryChange struct {
+	UserType int      `json:"usertype"` // A number representing the user (2: teacher, 3: admin)
+	UID      string   `json:"uid"`      // tid or aid, empty for accounts a dry run would create
+	DN       string   `json:"dn"`
+	Email    string   `json:"email"`
+	Fields   []string `json:"fields"` // fields an update changed
+}
+
+// DirectorySync is the diff report of one run of the LDAP sync
+type DirectorySync struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	DryRun      bool               `json:"dryrun"` // nothing was changed, the report shows what would have been
+	Created     []DirectoryChange  `json:"created"`
+	Updated     []DirectoryChange  `json:"updated"`
+	Disabled    []DirectoryChange  `json:"disabled"`
+	Errors      []string           `json:"errors"`
+	Started_at  time.Time          `json:"started_at"`
+	Finished_at time.Time          `json:"finished_at"`
+}
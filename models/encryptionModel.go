@@ -0,0 +1,512 @@
+package models
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+	Sensitive personal fields are encrypted before they reach the
+	database with envelope encryption. Each value is sealed with
+	AES-256-GCM under a data key, and the data key is stored next
+	to it wrapped by a key encryption key that never leaves the
+	key provider. The provider is chosen by ENCRYPTION_PROVIDER,
+	"file" reads the keys from ENCRYPTION_KEY_FILE, and others can
+	be added with RegisterKeyProvider. With neither set, the
+	fields are stored in plain text as before.
+
+	A field is encrypted by giving it one of the types below, the
+	value is sealed when it is marshalled to BSON and opened when
+	it is decoded, so handlers use it like the string or number
+	it was. Plain values written before encryption was enabled
+	still decode, and are sealed by the next key rotation.
+
+	Searchable fields also store a blind index, an HMAC of the
+	normalised value under a key that never rotates, so they can
+	still be looked up by equality with SearchFilter.
+
+	Stored as {k: key id, d: wrapped data key, n: nonce, c: ciphertext, i: blind index, t: "number"}
+*/
+
+// Encrypted is a string stored encrypted
+type Encrypted string
+
+// Searchable is a string stored encrypted with a blind index for equality lookups
+type Searchable string
+
+// EncryptedNumber is a number stored encrypted
+type EncryptedNumber float64
+
+// KeyProvider holds the key encryption keys, e.g. a key file or a cloud KMS
+type KeyProvider interface {
+	CurrentKeyID() string                                   // key new data keys are wrapped with
+	WrapKey(keyID string, dataKey []byte) ([]byte, error)   // encrypts a data key
+	UnwrapKey(keyID string, wrapped []byte) ([]byte, error) // decrypts a data key wrapped by any key still held
+	IndexKey() []byte                                       // key for blind indexes, it must never change
+}
+
+var keyProviders = map[string]func() (KeyProvider, error){
+	"file": openFileKeyProvider,
+}
+
+// RegisterKeyProvider adds a provider that can be chosen with ENCRYPTION_PROVIDER
+func RegisterKeyProvider(name string, open func() (KeyProvider, error)) {
+	keyProviders[name] = open
+}
+
+type dataKey struct {
+	plain   []byte
+	wrapped []byte
+}
+
+// Keyring seals and opens values with data keys wrapped by its provider
+type Keyring struct {
+	provider KeyProvider
+	lock     sync.Mutex
+	current  map[string]dataKey // data key of this process for each key id
+	opened   map[string][]byte  // unwrapped data keys by key id and wrapped key
+}
+
+var (
+	keyring     *Keyring
+	keyringErr  error
+	keyringOnce sync.Once
+)
+
+// Encryption returns the keyring, or nil if encryption isn't configured
+func Encryption() (*Keyring, error) {
+	keyringOnce.Do(func() {
+		name := os.Getenv("ENCRYPTION_PROVIDER")
+		if name == "" && os.Getenv("ENCRYPTION_KEY_FILE") != "" {
+			name = "file"
+		}
+		if name == "" {
+			return
+		}
+
+		open, ok := keyProviders[name]
+		if !ok {
+			keyringErr = fmt.Errorf("unknown encryption provider %q", name)
+			return
+		}
+		provider, err := open()
+		if err != nil {
+			keyringErr = err
+			return
+		}
+		if len(provider.IndexKey()) < 32 {
+			keyringErr = errors.New("the blind index key must be at least 32 bytes")
+			return
+		}
+		keyring = &Keyring{provider: provider, current: map[string]dataKey{}, opened: map[string][]byte{}}
+	})
+	return keyring, keyringErr
+}
+
+// CurrentKeyID is the key new values are sealed with
+func (k *Keyring) CurrentKeyID() string {
+	return k.provider.CurrentKeyID()
+}
+
+func (k *Keyring) dataKey() (string, dataKey, error) {
+	keyID := k.provider.CurrentKeyID()
+
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	if key, ok := k.current[keyID]; ok {
+		return keyID, key, nil
+	}
+
+	key := dataKey{plain: make([]byte, 32)}
+	if _, err := rand.Read(key.plain); err != nil {
+		return "", key, err
+	}
+	wrapped, err := k.provider.WrapKey(keyID, key.plain)
+	if err != nil {
+		return "", key, err
+	}
+	key.wrapped = wrapped
+	k.current[keyID] = key
+	return keyID, key, nil
+}
+
+func (k *Keyring) openDataKey(keyID string, wrapped []byte) ([]byte, error) {
+	cacheKey := keyID + "/" + base64.StdEncoding.EncodeToString(wrapped)
+
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	if plain, ok := k.opened[cacheKey]; ok {
+		return plain, nil
+	}
+	plain, err := k.provider.UnwrapKey(keyID, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	k.opened[cacheKey] = plain
+	return plain, nil
+}
+
+// BlindIndex is the lookup value of a Searchable field
+func (k *Keyring) BlindIndex(value string) string {
+	mac := hmac.New(sha256.New, k.provider.IndexKey())
+	mac.Write([]byte(strings.ToLower(strings.TrimSpace(value))))
+	return base64.RawStdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (k *Keyring) seal(plaintext []byte, indexed bool, number bool) (bson.D, error) {
+	keyID, key, err := k.dataKey()
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key.plain)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	sealed := bson.D{
+		{Key: "k", Value: keyID},
+		{Key: "d", Value: primitive.Binary{Data: key.wrapped}},
+		{Key: "n", Value: primitive.Binary{Data: nonce}},
+		{Key: "c", Value: primitive.Binary{Data: gcm.Seal(nil, nonce, plaintext, nil)}},
+	}
+	if indexed {
+		sealed = append(sealed, bson.E{Key: "i", Value: k.BlindIndex(string(plaintext))})
+	}
+	if number {
+		sealed = append(sealed, bson.E{Key: "t", Value: "number"})
+	}
+	return sealed, nil
+}
+
+type sealedValue struct {
+	K string           `bson:"k"`
+	D primitive.Binary `bson:"d"`
+	N primitive.Binary `bson:"n"`
+	C primitive.Binary `bson:"c"`
+	I string           `bson:"i,omitempty"`
+	T string           `bson:"t,omitempty"`
+}
+
+func (k *Keyring) open(sealed sealedValue) ([]byte, error) {
+	key, err := k.openDataKey(sealed.K, sealed.D.Data)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Open(nil, sealed.N.Data, sealed.C.Data, nil)
+}
+
+// marshalSealed seals a value, an empty value isn't worth sealing and is stored as is
+func marshalSealed(plain interface{}, plaintext string, indexed bool, number bool) (bsontype.Type, []byte, error) {
+	k, err := Encryption()
+	if err != nil {
+		return 0, nil, err
+	}
+	if k == nil || plaintext == "" || (number && plaintext == "0") {
+		return bson.MarshalValue(plain)
+	}
+	sealed, err := k.seal([]byte(plaintext), indexed, number)
+	if err != nil {
+		return 0, nil, err
+	}
+	return bson.MarshalValue(sealed)
+}
+
+// unmarshalSealed returns the plain text of a stored value, sealed or not
+func unmarshalSealed(t bsontype.Type, data []byte) (string, error) {
+	raw := bson.RawValue{Type: t, Value: data}
+	switch t {
+	case bsontype.String:
+		return raw.StringValue(), nil
+	case bsontype.Double:
+		return strconv.FormatFloat(raw.Double(), 'f', -1, 64), nil
+	case bsontype.Int32:
+		return strconv.Itoa(int(raw.Int32())), nil
+	case bsontype.Int64:
+		return strconv.FormatInt(raw.Int64(), 10), nil
+	case bsontype.Null, bsontype.Undefined:
+		return "", nil
+	case bsontype.EmbeddedDocument:
+		var sealed sealedValue
+		if err := raw.Unmarshal(&sealed); err != nil {
+			return "", err
+		}
+		k, err := Encryption()
+		if err != nil {
+			return "", err
+		}
+		if k == nil {
+			return "", errors.New("an encrypted field was read without encryption configured")
+		}
+		plaintext, err := k.open(sealed)
+		return string(plaintext), err
+	}
+	return "", fmt.Errorf("cannot decode %v into an encrypted field", t)
+}
+
+func (e Encrypted) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	return marshalSealed(string(e), string(e), false, false)
+}
+
+func (e *Encrypted) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	plaintext, err := unmarshalSealed(t, data)
+	*e = Encrypted(plaintext)
+	return err
+}
+
+func (s Searchable) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	return marshalSealed(string(s), string(s), true, false)
+}
+
+func (s *Searchable) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	plaintext, err := unmarshalSealed(t, data)
+	*s = Searchable(plaintext)
+	return err
+}
+
+func (n EncryptedNumber) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	return marshalSealed(float64(n), strconv.FormatFloat(float64(n), 'f', -1, 64), false, true)
+}
+
+func (n *EncryptedNumber) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	plaintext, err := unmarshalSealed(t, data)
+	if err != nil || plaintext == "" {
+		*n = 0
+		return err
+	}
+	number, err := strconv.ParseFloat(plaintext, 64)
+	*n = EncryptedNumber(number)
+	return err
+}
+
+// SearchFilter matches a Searchable field by value, whether it has been sealed yet or not
+func SearchFilter(field string, value string) bson.M {
+	k, _ := Encryption()
+	if k == nil {
+		return bson.M{field: value}
+	}
+	return bson.M{"$or": bson.A{
+		bson.M{field: value},
+		bson.M{field + ".i": k.BlindIndex(value)},
+	}}
+}
+
+type EncryptedField struct {
+	Name       string
+	Searchable bool
+}
+
+// EncryptedFields are the fields of each collection that are stored encrypted
+var EncryptedFields = map[string][]EncryptedField{
+	"students": {
+		{"personal.email", true},
+		{"personal.address", false},
+		{"personal.postal", false},
+		{"personal.dob", false},
+		{"account.pendingemail", false},
+	},
+	"contacts": {
+		{"email", true},
+		{"address", false},
+		{"postal", false},
+		{"homephone", false},
+		{"workphone", false},
+	},
+}
+
+// StaleFilter matches documents with a field in plain text or sealed with an old key
+func (k *Keyring) StaleFilter(collection string) bson.M {
+	var stale bson.A
+	for _, field := range EncryptedFields[collection] {
+		stale = append(stale,
+			bson.M{field.Name + ".k": bson.M{"$exists": true, "$ne": k.CurrentKeyID()}},
+			bson.M{field.Name: bson.M{"$type": "string", "$ne": ""}},
+			bson.M{field.Name: bson.M{"$type": "number", "$ne": 0}},
+		)
+	}
+	return bson.M{"$or": stale}
+}
+
+type resealed struct {
+	sealed bson.D
+}
+
+func (r resealed) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	return bson.MarshalValue(r.sealed)
+}
+
+// Reseal returns the stored value of a field sealed with the current key, and false if it already was
+func (k *Keyring) Reseal(field EncryptedField, value bson.RawValue) (interface{}, bool, error) {
+	var sealed sealedValue
+	number := value.Type != bsontype.String && value.Type != bsontype.EmbeddedDocument
+	if value.Type == bsontype.EmbeddedDocument {
+		if err := value.Unmarshal(&sealed); err != nil {
+			return nil, false, err
+		}
+		if sealed.K == k.CurrentKeyID() {
+			return nil, false, nil
+		}
+		number = sealed.T == "number"
+	}
+
+	plaintext, err := unmarshalSealed(value.Type, value.Value)
+	if err != nil || plaintext == "" || (number && plaintext == "0") {
+		return nil, false, err
+	}
+	resealedValue, err := k.seal([]byte(plaintext), field.Searchable, number)
+	if err != nil {
+		return nil, false, err
+	}
+	return resealed{resealedValue}, true, nil
+}
+
+/*
+	The key file is JSON, each key is 32 bytes of base64
+	{
+		"current": "2022-09",
+		"keys": { "2022-01": "...", "2022-09": "..." },
+		"index": "..."
+	}
+	Old keys must stay in the file until every record has been
+	rotated onto the current one.
+*/
+
+type keyFile struct {
+	Current string            `json:"current"`
+	Keys    map[string]string `json:"keys"`
+	Index   string            `json:"index"`
+}
+
+type fileKeyProvider struct {
+	current string
+	keys    map[string]cipher.AEAD
+	index   []byte
+}
+
+func openFileKeyProvider() (KeyProvider, error) {
+	path := os.Getenv("ENCRYPTION_KEY_FILE")
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file keyFile
+	if err := json.Unmarshal(contents, &file); err != nil {
+		return nil, fmt.Errorf("invalid key file %s: %v", path, err)
+	}
+
+	provider := &fileKeyProvider{current: file.Current, keys: map[string]cipher.AEAD{}}
+	for keyID, encoded := range file.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("key %q in %s must be 32 bytes of base64", keyID, path)
+		}
+		block, _ := aes.NewCipher(key)
+		provider.keys[keyID], _ = cipher.NewGCM(block)
+	}
+	if _, ok := provider.keys[file.Current]; !ok {
+		return nil, fmt.Errorf("the current key %q is not in %s", file.Current, path)
+	}
+	if provider.index, err = base64.StdEncoding.DecodeString(file.Index); err != nil {
+		return nil, fmt.Errorf("invalid index key in %s", path)
+	}
+	return provider, nil
+}
+
+func (f *fileKeyProvider) CurrentKeyID() string {
+	return f.current
+}
+
+func (f *fileKeyProvider) WrapKey(keyID string, dataKey []byte) ([]byte, error) {
+	aead, ok := f.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", keyID)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, dataKey, []byte(keyID)), nil
+}
+
+func (f *fileKeyProvider) UnwrapKey(keyID string, wrapped []byte) ([]byte, error) {
+	aead, ok := f.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q, it may have been removed from the key file too early", keyID)
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errors.New("invalid wrapped key")
+	}
+	return aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], []byte(keyID))
+}
+
+func (f *fileKeyProvider) IndexKey() []byte {
+	return f.index
+}
+
+func randomKey() string {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+// AddKey adds a new key to the key file and makes it current, creating the file if needed
+func AddKey(path string, keyID string) error {
+	file := keyFile{Keys: map[string]string{}, Index: randomKey()}
+	if contents, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(contents, &file); err != nil {
+			return fmt.Errorf("invalid key file %s: %v", path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if _, exists := file.Keys[keyID]; exists {
+		return fmt.Errorf("key %q is already in %s", keyID, path)
+	}
+	file.Keys[keyID] = randomKey()
+	file.Current = keyID
+
+	contents, _ := json.MarshalIndent(file, "", "  ")
+	return os.WriteFile(path, contents, 0600)
+}
+
+// KeyRotation is the report of one pass re-encrypting records onto the current key
+type KeyRotation struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	KeyID       string             `json:"keyid"`    // key the records were sealed with
+	Resealed    map[string]int     `json:"resealed"` // records re-encrypted in each collection
+	Errors      []string           `json:"errors"`
+	Started_at  time.Time          `json:"started_at"`
+	Finished_at time.Time          `json:"finished_at"`
+}
@@ -0,0 +1,220 @@
+package models
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type sealedRecord struct {
+	Email  Searchable      `bson:"email"`
+	Postal Encrypted       `bson:"postal"`
+	Dob    EncryptedNumber `bson:"dob"`
+}
+
+// useKeyring makes the key file at path the one values are sealed and opened with for the rest of the test
+func useKeyring(t *testing.T, path string) *Keyring {
+	t.Setenv("ENCRYPTION_KEY_FILE", path)
+	provider, err := openFileKeyProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyringOnce.Do(func() {})
+	previous := keyring
+	keyring = &Keyring{provider: provider, current: map[string]dataKey{}, opened: map[string][]byte{}}
+	t.Cleanup(func() { keyring = previous })
+	return keyring
+}
+
+func addKey(t *testing.T, path string, keyID string) {
+	if err := AddKey(path, keyID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func sealRecord(t *testing.T, record sealedRecord) bson.Raw {
+	raw, err := bson.Marshal(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestEncryptedRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	addKey(t, path, "2022-01")
+	k := useKeyring(t, path)
+
+	record := sealedRecord{"Jane.Doe@example.com", "V5K 0A1", 20050412}
+	raw := sealRecord(t, record)
+	for _, plain := range []string{"Jane.Doe@example.com", "V5K 0A1", "20050412"} {
+		if strings.Contains(string(raw), plain) {
+			t.Errorf("%q is stored in plain text", plain)
+		}
+	}
+
+	email := raw.Lookup("email")
+	if email.Type != bsontype.EmbeddedDocument {
+		t.Fatalf("the email is stored as a %v, not sealed", email.Type)
+	}
+	if index := email.Document().Lookup("i").StringValue(); index != k.BlindIndex("  jane.doe@EXAMPLE.com") {
+		t.Errorf("the blind index doesn't match the value it was given with other case and spacing")
+	}
+	if raw.Lookup("postal").Document().Lookup("i").Type != 0 {
+		t.Errorf("a field that isn't searchable has a blind index")
+	}
+
+	var opened sealedRecord
+	if err := bson.Unmarshal(raw, &opened); err != nil {
+		t.Fatal(err)
+	}
+	if opened != record {
+		t.Errorf("got %+v back, want %+v", opened, record)
+	}
+
+	// Values written before encryption was enabled still decode
+	var plain sealedRecord
+	raw, _ = bson.Marshal(bson.M{"email": "jane.doe@example.com", "postal": "V5K 0A1", "dob": 20050412})
+	if err := bson.Unmarshal(raw, &plain); err != nil {
+		t.Fatal(err)
+	}
+	if plain != (sealedRecord{"jane.doe@example.com", "V5K 0A1", 20050412}) {
+		t.Errorf("got %+v back from plain text", plain)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	addKey(t, path, "2022-01")
+	old := useKeyring(t, path)
+
+	record := sealedRecord{"jane.doe@example.com", "V5K 0A1", 20050412}
+	sealed := sealRecord(t, record)
+
+	addKey(t, path, "2022-09")
+	current := useKeyring(t, path)
+	if current.CurrentKeyID() != "2022-09" {
+		t.Fatalf("the current key is %q after adding 2022-09", current.CurrentKeyID())
+	}
+	if current.BlindIndex(string(record.Email)) != old.BlindIndex(string(record.Email)) {
+		t.Errorf("the blind index changed with the key, searches for records sealed before would miss")
+	}
+
+	// Records sealed under the old key are read until they are rotated
+	var opened sealedRecord
+	if err := bson.Unmarshal(sealed, &opened); err != nil {
+		t.Fatal(err)
+	}
+	if opened != record {
+		t.Errorf("got %+v back from the old key, want %+v", opened, record)
+	}
+
+	for _, field := range []EncryptedField{{"email", true}, {"postal", false}, {"dob", false}} {
+		value, changed, err := current.Reseal(field, sealed.Lookup(field.Name))
+		if err != nil || !changed {
+			t.Fatalf("resealing %s: changed %v, %v", field.Name, changed, err)
+		}
+		bsonType, data, err := bson.MarshalValue(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resealed := bson.RawValue{Type: bsonType, Value: data}
+		if keyID := resealed.Document().Lookup("k").StringValue(); keyID != "2022-09" {
+			t.Errorf("%s was resealed with %q", field.Name, keyID)
+		}
+		if _, changed, _ := current.Reseal(field, resealed); changed {
+			t.Errorf("%s was resealed again once on the current key", field.Name)
+		}
+
+		plaintext, err := unmarshalSealed(bsonType, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, _ := unmarshalSealed(sealed.Lookup(field.Name).Type, sealed.Lookup(field.Name).Value); plaintext != want {
+			t.Errorf("%s is %q once resealed, want %q", field.Name, plaintext, want)
+		}
+	}
+
+	// Plain values are sealed by a rotation too, and empty ones left as they are
+	if _, changed, err := current.Reseal(EncryptedField{"postal", false}, plainValue("V5K 0A1")); err != nil || !changed {
+		t.Errorf("a plain value wasn't sealed: changed %v, %v", changed, err)
+	}
+	if _, changed, err := current.Reseal(EncryptedField{"postal", false}, plainValue("")); err != nil || changed {
+		t.Errorf("an empty value was sealed: changed %v, %v", changed, err)
+	}
+}
+
+func plainValue(value string) bson.RawValue {
+	bsonType, data, _ := bson.MarshalValue(value)
+	return bson.RawValue{Type: bsonType, Value: data}
+}
+
+func TestEncryptedOpenFails(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "keys.json")
+	addKey(t, path, "2022-01")
+	useKeyring(t, path)
+	sealed := sealRecord(t, sealedRecord{"jane.doe@example.com", "V5K 0A1", 20050412})
+
+	for _, test := range []struct {
+		name   string
+		record func() bson.Raw
+		keys   func() string
+		opens  bool
+	}{
+		{"intact", func() bson.Raw { return sealed }, func() string { return path }, true},
+		{"ciphertext tampered", func() bson.Raw {
+			return tamper(t, sealed, "c")
+		}, func() string { return path }, false},
+		{"nonce tampered", func() bson.Raw {
+			return tamper(t, sealed, "n")
+		}, func() string { return path }, false},
+		{"data key tampered", func() bson.Raw {
+			return tamper(t, sealed, "d")
+		}, func() string { return path }, false},
+		{"other key file", func() bson.Raw { return sealed }, func() string {
+			other := filepath.Join(dir, "other.json")
+			addKey(t, other, "2022-01")
+			return other
+		}, false},
+		{"old key removed", func() bson.Raw { return sealed }, func() string {
+			other := filepath.Join(dir, "removed.json")
+			addKey(t, other, "2022-09")
+			return other
+		}, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			raw := test.record()
+			useKeyring(t, test.keys())
+
+			var opened sealedRecord
+			if err := bson.Unmarshal(raw, &opened); (err == nil) != test.opens {
+				t.Errorf("opened %+v, %v", opened, err)
+			}
+		})
+	}
+}
+
+// tamper flips a bit of one part of the sealed postal code
+func tamper(t *testing.T, record bson.Raw, part string) bson.Raw {
+	var doc bson.M
+	if err := bson.Unmarshal(record, &doc); err != nil {
+		t.Fatal(err)
+	}
+	postal := doc["postal"].(bson.M)
+	binary := postal[part].(primitive.Binary)
+	data := append([]byte(nil), binary.Data...)
+	data[len(data)-1] ^= 1
+	postal[part] = primitive.Binary{Data: data}
+
+	raw, err := bson.Marshal(bson.M{"postal": postal})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
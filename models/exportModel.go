@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ExportRequest tracks a request for everything held about a student, until it is answered
+type ExportRequest struct {
+	ID           primitive.ObjectID `bson:"_id"`
+	SID          string             `json:"sid"`
+	Requester    string             `json:"requester"` // who asked, e.g. the student or a named guardian
+	Notes        string             `json:"notes"`
+	Created_by   string             `json:"created_by"` // aid of the admin who logged the request
+	Received_at  time.Time          `json:"received_at"`
+	Due_at       time.Time          `json:"due_at"`       // the request must be answered by this time
+	Completed_at time.Time          `json:"completed_at"` // zero until the bundle is first downloaded
+	Completed_by string             `json:"completed_by"`
+}
+
+// Overdue reports whether the request is still open past its deadline
+func (e *ExportRequest) Overdue() bool {
+	return e.Completed_at.IsZero() && time.Now().After(e.Due_at)
+}
+
+// StudentExport is everything held about one student
+type StudentExport struct {
+	Request        ExportRequest   `json:"request"`
+	Generated_at   time.Time       `json:"generated_at"`
+	Student        Student         `json:"student"`
+	Photo          Photo           `json:"photo"`
+	Contacts       []Contact       `json:"contacts"`
+	Locker         *Locker         `json:"locker"`
+	LoginAttempts  []LoginAttempt  `json:"loginattempts"`
+	Verifications  []Verification  `json:"verifications"`
+	Impersonations []Impersonation `json:"impersonations"`
+	AuditEntries   []AuditEntry    `json:"auditentries"`
+	AccessEvents   []AccessEvent   `json:"accessevents"`
+}
@@ -0,0 +1,198 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+/*
+	Passwords are hashed with bcrypt or argon2id, chosen by
+	HASH_ALGORITHM in the .env along with the cost of each
+	algorithm. A hash made with an outdated algorithm or cost
+	still verifies, and is re-encoded with the current settings
+	the next time its owner logs in successfully.
+
+	argon2id hashes are stored in the PHC string format
+	$argon2id$v=19$m=65536,t=1,p=4$<salt>$<key>
+*/
+
+type HashConfig struct {
+	Algorithm    string // bcrypt or argon2id
+	BcryptCost   int
+	ArgonTime    uint32 // iterations
+	ArgonMemory  uint32 // memory in KiB
+	ArgonThreads uint8
+}
+
+const (
+	argonSaltLength = 16
+	argonKeyLength  = 32
+)
+
+var Hashing HashConfig // set by main with LoadHashConfig once the .env is loaded
+
+func envInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// envRange reads an int from the .env, a value that isn't a number uses fallback and one out of range is clamped to it
+func envRange(key string, fallback int, min int, max int) int {
+	value := envInt(key, fallback)
+	if value < min {
+		log.Printf("%s=%d is below the minimum of %d, using %d", key, value, min, min)
+		return min
+	}
+	if value > max {
+		log.Printf("%s=%d is above the maximum of %d, using %d", key, value, max, max)
+		return max
+	}
+	return value
+}
+
+func LoadHashConfig() HashConfig {
+	config := HashConfig{
+		Algorithm: strings.ToLower(os.Getenv("HASH_ALGORITHM")),
+		// Memory is between the 19 MiB OWASP recommends at least and 4 GiB, threads fit in a uint8
+		ArgonTime:    uint32(envRange("ARGON2_TIME", 1, 1, 100)),
+		ArgonMemory:  uint32(envRange("ARGON2_MEMORY", 64*1024, 19*1024, 4*1024*1024)),
+		ArgonThreads: uint8(envRange("ARGON2_THREADS", 4, 1, 255)),
+	}
+	if config.Algorithm != "argon2id" {
+		config.Algorithm = "bcrypt"
+	}
+	config.BcryptCost = envInt("BCRYPT_COST", 12)
+	if config.BcryptCost < bcrypt.MinCost || config.BcryptCost > bcrypt.MaxCost {
+		log.Printf("BCRYPT_COST=%d must be between %d and %d, using 12", config.BcryptCost, bcrypt.MinCost, bcrypt.MaxCost)
+		config.BcryptCost = 12
+	}
+	return config
+}
+
+// Hash encodes a password with the algorithm and cost of the config
+func (h HashConfig) Hash(password string) (string, error) {
+	if h.Algorithm == "argon2id" {
+		salt := make([]byte, argonSaltLength)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(password), salt, h.ArgonTime, h.ArgonMemory, h.ArgonThreads, argonKeyLength)
+		return fmt.Sprintf(
+			"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+			argon2.Version, h.ArgonMemory, h.ArgonTime, h.ArgonThreads,
+			base64.RawStdEncoding.EncodeToString(salt),
+			base64.RawStdEncoding.EncodeToString(key),
+		), nil
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.BcryptCost)
+	return string(hash), err
+}
+
+// NeedsRehash reports whether a hash was made with different settings than the config
+func (h HashConfig) NeedsRehash(hash string) bool {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		params, _, _, err := decodeArgon2id(hash)
+		return err != nil || h.Algorithm != "argon2id" ||
+			params.ArgonTime != h.ArgonTime || params.ArgonMemory != h.ArgonMemory || params.ArgonThreads != h.ArgonThreads
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || h.Algorithm != "bcrypt" || cost != h.BcryptCost
+}
+
+func HashPassword(password string) (string, error) {
+	return Hashing.Hash(password)
+}
+
+// VerifyPassword compares a password with a bcrypt or argon2id hash
+func VerifyPassword(hash string, password string) bool {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		params, salt, key, err := decodeArgon2id(hash)
+		if err != nil {
+			return false
+		}
+		other := argon2.IDKey([]byte(password), salt, params.ArgonTime, params.ArgonMemory, params.ArgonThreads, uint32(len(key)))
+		return subtle.ConstantTimeCompare(key, other) == 1
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+func decodeArgon2id(hash string) (HashConfig, []byte, []byte, error) {
+	var params HashConfig
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, errors.New("invalid argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, errors.New("unsupported argon2id version")
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.ArgonMemory, &params.ArgonTime, &params.ArgonThreads); err != nil {
+		return params, nil, nil, err
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, err
+	}
+	params.Algorithm = "argon2id"
+	return params, salt, key, nil
+}
+
+type HashBenchmark struct {
+	Config   HashConfig
+	Duration time.Duration
+}
+
+// BenchmarkHashing times a range of bcrypt costs and argon2id memory sizes on this machine
+func BenchmarkHashing() []HashBenchmark {
+	var results []HashBenchmark
+	const password = "Benchmark-Password-123!"
+
+	for cost := 10; cost <= 14; cost++ {
+		config := HashConfig{Algorithm: "bcrypt", BcryptCost: cost}
+		start := time.Now()
+		if _, err := config.Hash(password); err != nil {
+			continue
+		}
+		results = append(results, HashBenchmark{config, time.Since(start)})
+	}
+
+	for _, memory := range []uint32{19 * 1024, 46 * 1024, 64 * 1024, 128 * 1024} {
+		for _, iterations := range []uint32{1, 2, 3} {
+			config := HashConfig{Algorithm: "argon2id", ArgonTime: iterations, ArgonMemory: memory, ArgonThreads: Hashing.ArgonThreads}
+			start := time.Now()
+			if _, err := config.Hash(password); err != nil {
+				continue
+			}
+			results = append(results, HashBenchmark{config, time.Since(start)})
+		}
+	}
+
+	return results
+}
+
+func (b HashBenchmark) String() string {
+	if b.Config.Algorithm == "argon2id" {
+		return fmt.Sprintf("argon2id ARGON2_MEMORY=%-6d ARGON2_TIME=%d ARGON2_THREADS=%d  %v", b.Config.ArgonMemory, b.Config.ArgonTime, b.Config.ArgonThreads, b.Duration.Round(time.Millisecond))
+	}
+	return fmt.Sprintf("bcrypt   BCRYPT_COST=%-2d  %v", b.Config.BcryptCost, b.Duration.Round(time.Millisecond))
+}
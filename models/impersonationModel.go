@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Impersonation is an admin viewing the system as a student or teacher, kept as the audit trail of the session
+type Impersonation struct {
+	ID         primitive.ObjectID `bson:"_id"`
+	AID        string             `json:"aid"`      // admin who started the session
+	UID        string             `json:"uid"`      // sid or tid being impersonated
+	UserType   int                `json:"usertype"` // A number representing the user (1: student, 2: teacher)
+	Reason     string             `json:"reason" validate:"required"`
+	ReadOnly   bool               `json:"readonly"`
+	IP         string             `json:"ip"`
+	Started_at time.Time          `json:"started_at"`
+	Expires_at time.Time          `json:"expires_at"`
+	Ended_at   time.Time          `json:"ended_at"` // zero while the session is active
+}
+
+func (i *Impersonation) Active() bool {
+	return i.Ended_at.IsZero() && i.Expires_at.After(time.Now())
+}
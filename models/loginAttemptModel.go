@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type LoginAttempt struct {
+	ID         primitive.ObjectID `bson:"_id"`
+	UID        string             `json:"uid"`      // sid, tid or aid the login was attempted for
+	UserType   int                `json:"usertype"` // A number representing the user (1: student, 2: teacher, 3: admin)
+	IP         string             `json:"ip"`
+	Device     string             `json:"device"` // hash of the device cookie a successful login was made from
+	Success    bool               `json:"success"`
+	Created_at time.Time          `json:"created_at"`
+}
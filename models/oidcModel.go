@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// OIDCState is kept between sending a user to the identity provider and their return
+type OIDCState struct {
+	ID         primitive.ObjectID `bson:"_id"`
+	State      string             `json:"-"`        // sha256 of the state sent to the provider
+	Nonce      string             `json:"-"`        // must match the nonce in the returned id token
+	Verifier   string             `json:"-"`        // PKCE code verifier, only its challenge is sent to the provider
+	UserType   int                `json:"usertype"` // A number representing the user (1: student, 2: teacher, 3: admin)
+	Expires_at time.Time          `json:"expires_at"`
+	Created_at time.Time          `json:"created_at"`
+}
@@ -0,0 +1,168 @@
+package models
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+	One password policy is stored per role (student, teacher
+	and admin) and is configured by admins. Every place a user
+	chooses a password checks it against the policy for their
+	role, so the rules can't drift apart between user types.
+*/
+
+var PasswordPolicyRoles = []string{"student", "teacher", "admin"}
+
+type PasswordPolicy struct {
+	ID             primitive.ObjectID `bson:"_id"`
+	Role           string             `json:"role" validate:"required"` // student, teacher or admin
+	MinLength      int                `json:"minlength"`
+	RequireUpper   bool               `json:"requireupper"`
+	RequireLower   bool               `json:"requirelower"`
+	RequireNumber  bool               `json:"requirenumber"`
+	RequireSpecial bool               `json:"requirespecial"`
+	HistoryDepth   int                `json:"historydepth"` // amount of previous passwords that can't be reused
+	BlockCommon    bool               `json:"blockcommon"`  // refuse passwords found in the common password list
+	MaxAgeDays     int                `json:"maxagedays"`   // days before a password expires, 0 never expires
+	Updated_at     time.Time          `json:"updated_at"`
+}
+
+// PolicyError names the rule of the password policy a password failed
+type PolicyError struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+func (e PolicyError) Error() string {
+	return e.Message
+}
+
+func ValidPasswordPolicyRole(role string) bool {
+	for _, r := range PasswordPolicyRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultPasswordPolicy is used for a role until an admin configures one
+func DefaultPasswordPolicy(role string) PasswordPolicy {
+	return PasswordPolicy{
+		Role:           role,
+		MinLength:      8,
+		RequireUpper:   true,
+		RequireLower:   true,
+		RequireNumber:  false,
+		RequireSpecial: true,
+		HistoryDepth:   5,
+		BlockCommon:    true,
+		MaxAgeDays:     0,
+	}
+}
+
+// Check returns every rule of the policy the password fails, history is the list of previous hashes newest last
+func (p *PasswordPolicy) Check(password string, history []string) []PolicyError {
+	var errs []PolicyError
+
+	if len([]rune(password)) < p.MinLength {
+		errs = append(errs, PolicyError{"minlength", fmt.Sprintf("password must be at least %d characters", p.MinLength)})
+	}
+
+	var hasUpper, hasLower, hasNumber bool = false, false, false
+	for _, r := range password {
+		if unicode.IsUpper(r) && unicode.IsLetter(r) {
+			hasUpper = true
+		}
+		if unicode.IsLower(r) && unicode.IsLetter(r) {
+			hasLower = true
+		}
+		if unicode.IsDigit(r) {
+			hasNumber = true
+		}
+	}
+
+	if p.RequireUpper && !hasUpper {
+		errs = append(errs, PolicyError{"requireupper", "password must contain an uppercase letter"})
+	}
+	if p.RequireLower && !hasLower {
+		errs = append(errs, PolicyError{"requirelower", "password must contain a lowercase letter"})
+	}
+	if p.RequireNumber && !hasNumber {
+		errs = append(errs, PolicyError{"requirenumber", "password must contain a number"})
+	}
+	if p.RequireSpecial && !strings.ContainsAny(password, specialCharSet) {
+		errs = append(errs, PolicyError{"requirespecial", "password must contain one of " + specialCharSet})
+	}
+	if p.BlockCommon && CommonPassword(password) {
+		errs = append(errs, PolicyError{"blockcommon", "password is too common"})
+	}
+	if PasswordInHistory(password, history, p.HistoryDepth) {
+		errs = append(errs, PolicyError{"historydepth", fmt.Sprintf("password cannot be the same as any of your last %d passwords", p.HistoryDepth)})
+	}
+
+	return errs
+}
+
+// Expired reports whether a password changed at the given time is past the maximum age
+func (p *PasswordPolicy) Expired(changed time.Time) bool {
+	if p.MaxAgeDays <= 0 {
+		return false
+	}
+	return time.Since(changed) > time.Duration(p.MaxAgeDays)*24*time.Hour
+}
+
+// PasswordInHistory compares the password against the last depth hashes
+func PasswordInHistory(password string, history []string, depth int) bool {
+	if depth <= 0 {
+		return false
+	}
+	start := len(history) - depth
+	if start < 0 {
+		start = 0
+	}
+	for _, oldHash := range history[start:] {
+		if VerifyPassword(oldHash, password) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	commonPasswords     map[string]bool
+	commonPasswordsOnce sync.Once
+)
+
+// CommonPassword checks the local list of breached or common passwords, one per line
+func CommonPassword(password string) bool {
+	commonPasswordsOnce.Do(func() {
+		commonPasswords = make(map[string]bool)
+
+		path := os.Getenv("COMMON_PASSWORDS_FILE")
+		if path == "" {
+			path = "./database/commonPasswords.txt"
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return
+		}
+		defer file.Close()
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				commonPasswords[strings.ToLower(line)] = true
+			}
+		}
+	})
+	return commonPasswords[strings.ToLower(password)]
+}
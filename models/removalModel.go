@@ -0,0 +1,13 @@
+package models
+
+import (
+	"time"
+)
+
+// Removal marks a soft deleted account, it can be restored until it is purged at Purge_at
+type Removal struct {
+	Reason     string    `json:"reason"`
+	Removed_by string    `json:"removed_by"` // aid of the admin who removed the account
+	Removed_at time.Time `json:"removed_at"`
+	Purge_at   time.Time `json:"purge_at"`
+}
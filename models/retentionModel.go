@@ -0,0 +1,92 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+	Each type of record has its own retention rule, set by
+	admins to match how long the school must legally keep it.
+	A rule of 0 days keeps records forever, which is the
+	default, so nothing is removed until a rule is configured.
+*/
+
+var RetentionDataTypes = []string{
+	"graduated_students",
+	"attendance",
+	"login_attempts",
+	"audit_log",
+	"access_log",
+	"access_alerts",
+	"impersonations",
+	"export_requests",
+	"directory_syncs",
+	"key_rotations",
+}
+
+type RetentionRule struct {
+	ID         primitive.ObjectID `bson:"_id"`
+	DataType   string             `json:"datatype" validate:"required"`
+	Days       int                `json:"days"`   // days a record is kept, 0 keeps it forever
+	Action     string             `json:"action"` // purge deletes records, archive moves them to <collection>_archive first
+	Updated_by string             `json:"updated_by"`
+	Updated_at time.Time          `json:"updated_at"`
+}
+
+func ValidRetentionDataType(dataType string) bool {
+	for _, t := range RetentionDataTypes {
+		if t == dataType {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultRetentionRule is used for a type of record until an admin configures one
+func DefaultRetentionRule(dataType string) RetentionRule {
+	return RetentionRule{
+		DataType: dataType,
+		Days:     0,
+		Action:   "purge",
+	}
+}
+
+// LegalHold stops every record linked to a user from being purged, archived or removed until it is released
+type LegalHold struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	UID         string             `json:"uid"` // sid, tid or aid under hold
+	Reason      string             `json:"reason" validate:"required"`
+	Placed_by   string             `json:"placed_by"` // aid of the admin who placed the hold
+	Placed_at   time.Time          `json:"placed_at"`
+	Released_by string             `json:"released_by"`
+	Released_at time.Time          `json:"released_at"` // zero while the hold is in place
+}
+
+// RetentionResult is what a retention run did with one type of record
+type RetentionResult struct {
+	DataType string    `json:"datatype"`
+	Action   string    `json:"action"`
+	Cutoff   time.Time `json:"cutoff"`         // records older than this were past retention
+	Removed  int64     `json:"removed"`        // records purged or archived
+	Held     int64     `json:"held"`           // records past retention kept for a legal hold
+	UIDs     []string  `json:"uids,omitempty"` // accounts removed, for graduated students
+}
+
+// AuditAnchor is the last audit entry purged, the remaining log chains on from its hash
+type AuditAnchor struct {
+	Seq  int64  `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+// RetentionRun is the report of one run of the retention job
+type RetentionRun struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	DryRun      bool               `json:"dryrun"` // nothing was removed, the report shows what would have been
+	Results     []RetentionResult  `json:"results"`
+	AuditAnchor *AuditAnchor       `json:"auditanchor,omitempty"`
+	Errors      []string           `json:"errors"`
+	Started_at  time.Time          `json:"started_at"`
+	Finished_at time.Time          `json:"finished_at"`
+}
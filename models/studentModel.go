@@ -1,40 +1,29 @@
 package models
 
 import (
-	"context"
 	"math/rand"
 	"os"
 	"strconv"
 	"strings"
 	"time"
-	"unicode"
 
-	"github.com/SowinskiBraeden/school-management-api/database"
-
-	"golang.org/x/crypto/bcrypt"
-
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
-var LockerCollection *mongo.Collection = database.OpenCollection(database.Client, "lockers")
-var StudentCollection *mongo.Collection = database.OpenCollection(database.Client, "students")
-
 type Student struct {
 	ID       primitive.ObjectID `bson:"_id"`
 	Personal struct {
-		FirstName  string   `json:"firstname" validate:"required"`
-		MiddleName string   `json:"middlename"`
-		LastName   string   `json:"lastname" validate:"required"`
-		Age        float64  `json:"age" validate:"required"`
-		Email      string   `json:"email" validate:"required"`
-		Address    string   `json:"address"`
-		City       string   `json:"city"`
-		Province   string   `json:"province"`
-		Postal     string   `json:"postal"`
-		DOB        string   `json:"dob" validate:"required"`
-		Contacts   []string `json:"contacts"` // List of contact ID's rather than contact object
+		FirstName  string     `json:"firstname" validate:"required"`
+		MiddleName string     `json:"middlename"`
+		LastName   string     `json:"lastname" validate:"required"`
+		Age        float64    `json:"age" validate:"required"`
+		Email      Searchable `json:"email" validate:"required"`
+		Address    Encrypted  `json:"address"`
+		City       string     `json:"city"`
+		Province   string     `json:"province"`
+		Postal     Encrypted  `json:"postal"`
+		DOB        Encrypted  `json:"dob" validate:"required"`
+		Contacts   []string   `json:"contacts"` // List of contact ID's rather than contact object
 	} `json:"personal"`
 	School struct {
 		GradeLevel float64 `json:"gradelevel" validate:"required"`
@@ -46,37 +35,31 @@ type Student struct {
 		PhotoName  string  `json:"photoname"` // name of photo in db
 	} `json:"School"`
 	Account struct {
-		VerifiedEmail   bool     `json:"verifiedemail"`
-		SchoolEmail     string   `json:"schoolemail"`
-		Password        string   `json:"-" validate:"min=10,max=32"`
-		AccountDisabled bool     `bson:"accountdisabled"`
-		Alerted         bool     `bson:"alerted"`
-		TempPassword    bool     `json:"temppassword"`
-		Attempts        int      `json:"attempts"` // login attempts max 5
-		HashHistory     []string `json:"-"`        // List of old hashed passwords (not including auto generated passwords)
+		VerifiedEmail      bool      `json:"verifiedemail"`
+		PendingEmail       Encrypted `json:"pendingemail"` // new personal email waiting to be verified
+		SchoolEmail        string    `json:"schoolemail"`
+		Password           string    `json:"-" validate:"min=10,max=32"`
+		AccountDisabled    bool      `bson:"accountdisabled"`
+		Alerted            bool      `bson:"alerted"`
+		TempPassword       bool      `json:"temppassword"`
+		Attempts           int       `json:"attempts"`           // login attempts max 5
+		LockedUntil        time.Time `json:"lockeduntil"`        // login is refused until this time after too many attempts
+		Lockouts           int       `json:"lockouts"`           // consecutive lockouts, each one doubles the cooldown
+		HashHistory        []string  `json:"-"`                  // Hashes of the passwords chosen by the user, newest last and the current one included, up to the policy's history depth (auto generated passwords aren't kept)
+		PasswordChanged_at time.Time `json:"passwordchanged_at"` // when the password was last chosen by the user
 	} `json:"Account"`
+	Removed    *Removal  `json:"removed,omitempty"` // set while the account is soft deleted
 	Created_at time.Time `json:"created_at"`
 	Updated_at time.Time `json:"updated_at"`
 }
 
-func (s *Student) UsedPassword(password string) bool {
-	for _, oldHash := range s.Account.HashHistory {
-		if oldHash == s.HashPassword(password) {
-			return true
-		}
-	}
-	return false
-}
-
-func (s *Student) HashPassword(password string) string {
-	hash, _ := bcrypt.GenerateFromPassword([]byte(password), 14)
-	return string(hash)
+// Locked reports whether the account is still in a lockout cooldown
+func (s *Student) Locked() bool {
+	return s.Account.LockedUntil.After(time.Now())
 }
 
-func (s *Student) EmailExists(email string) bool {
-	var student Student
-	findErr := StudentCollection.FindOne(context.TODO(), bson.M{"account.schoolemail": email}).Decode(&student)
-	return findErr == nil
+func (s *Student) HashPassword(password string) (string, error) {
+	return HashPassword(password)
 }
 
 func (s *Student) GenerateSchoolEmail(offset int, lastEmail string) string {
@@ -95,31 +78,20 @@ func (s *Student) GenerateSchoolEmail(offset int, lastEmail string) string {
 }
 
 func (s *Student) ComparePasswords(password string) bool { //True: passwords match, False: no match
-	valid := bcrypt.CompareHashAndPassword([]byte(s.Account.Password), []byte(password))
-	return valid == nil
+	return VerifyPassword(s.Account.Password, password)
 }
 
-func (s *Student) CheckPasswordStrength(password string) bool {
-
-	var hasUpper bool = false
-	for _, r := range password {
-		if unicode.IsUpper(r) && unicode.IsLetter(r) {
-			hasUpper = true
-		}
-	}
-
-	var hasLower bool = false
-	for _, r := range password {
-		if unicode.IsLower(r) && unicode.IsLetter(r) {
-			hasLower = true
-		}
-	}
+// CheckPassword returns every rule of the student password policy the password fails
+func (s *Student) CheckPassword(policy PasswordPolicy, password string) []PolicyError {
+	return policy.Check(password, s.Account.HashHistory)
+}
 
-	if strings.ContainsAny(password, specialCharSet) && hasLower && hasUpper && len(password) >= 8 {
-		return true
-	} else {
-		return false
+func (s *Student) PasswordExpired(policy PasswordPolicy) bool {
+	changed := s.Account.PasswordChanged_at
+	if changed.IsZero() {
+		changed = s.Created_at
 	}
+	return policy.Expired(changed)
 }
 
 func (s *Student) GeneratePassword(passwordLength, minSpecialChar, minNum, minUpperCase int) string {
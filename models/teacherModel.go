@@ -1,19 +1,13 @@
 package models
 
 import (
-	"context"
 	"math/rand"
 	"os"
 	"strconv"
 	"strings"
 	"time"
-	"unicode"
 
-	"github.com/SowinskiBraeden/school-management-api/database"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"golang.org/x/crypto/bcrypt"
 )
 
 var (
@@ -24,8 +18,6 @@ var (
 	allCharSet     = lowerCharSet + upperCharSet + specialCharSet + numberSet
 )
 
-var TeacherCollection *mongo.Collection = database.OpenCollection(database.Client, "teachers")
-
 type Teacher struct {
 	ID       primitive.ObjectID `bson:"_id"`
 	Personal struct {
@@ -45,36 +37,32 @@ type Teacher struct {
 		PhotoName string `json:"photoname"` // name of photo in db
 	} `json:"School"`
 	Account struct {
-		VerifiedEmail   bool     `json:"verifiedemail"`
-		SchoolEmail     string   `json:"schoolemail"`
-		Password        string   `json:"-" validate:"min=10,max=32"`
-		AccountDisabled bool     `bson:"accountdisabled"`
-		TempPassword    bool     `json:"temppassword"`
-		Attempts        int      `json:"attempts"` // login attempts max 5
-		HashHistory     []string `json:"-"`        // List of old hashed passwords (not including auto generated passwords)
+		VerifiedEmail      bool      `json:"verifiedemail"`
+		PendingEmail       string    `json:"pendingemail"` // new personal email waiting to be verified
+		SchoolEmail        string    `json:"schoolemail"`
+		Password           string    `json:"-" validate:"min=10,max=32"`
+		AccountDisabled    bool      `bson:"accountdisabled"`
+		Alerted            bool      `bson:"alerted"`
+		TempPassword       bool      `json:"temppassword"`
+		Attempts           int       `json:"attempts"`           // login attempts max 5
+		LockedUntil        time.Time `json:"lockeduntil"`        // login is refused until this time after too many attempts
+		Lockouts           int       `json:"lockouts"`           // consecutive lockouts, each one doubles the cooldown
+		HashHistory        []string  `json:"-"`                  // Hashes of the passwords chosen by the user, newest last and the current one included, up to the policy's history depth (auto generated passwords aren't kept)
+		PasswordChanged_at time.Time `json:"passwordchanged_at"` // when the password was last chosen by the user
+		DirectoryDN        string    `json:"directorydn"`        // DN of the LDAP entry the account is synced from, empty if created here
 	} `json:"Account"`
+	Removed    *Removal  `json:"removed,omitempty"` // set while the account is soft deleted
 	Created_at time.Time `json:"created_at"`
 	Updated_at time.Time `json:"updated_at"`
 }
 
-func (t *Teacher) UsedPassword(password string) bool {
-	for _, oldHash := range t.Account.HashHistory {
-		if oldHash == t.HashPassword(password) {
-			return true
-		}
-	}
-	return false
+// Locked reports whether the account is still in a lockout cooldown
+func (t *Teacher) Locked() bool {
+	return t.Account.LockedUntil.After(time.Now())
 }
 
-func (t *Teacher) HashPassword(password string) string {
-	hash, _ := bcrypt.GenerateFromPassword([]byte(password), 14)
-	return string(hash)
-}
-
-func (t *Teacher) EmailExists(email string) bool {
-	var teacher Teacher
-	findErr := TeacherCollection.FindOne(context.TODO(), bson.M{"account.schoolemail": email}).Decode(&teacher)
-	return findErr == nil
+func (t *Teacher) HashPassword(password string) (string, error) {
+	return HashPassword(password)
 }
 
 func (t *Teacher) GenerateSchoolEmail(offset int, lastEmail string) string {
@@ -93,31 +81,20 @@ func (t *Teacher) GenerateSchoolEmail(offset int, lastEmail string) string {
 }
 
 func (t *Teacher) ComparePasswords(password string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(t.Account.Password), []byte(password))
-	return err == nil
+	return VerifyPassword(t.Account.Password, password)
 }
 
-func (t *Teacher) CheckPasswordStrength(password string) bool {
-
-	var hasUpper bool = false
-	for _, r := range password {
-		if unicode.IsUpper(r) && unicode.IsLetter(r) {
-			hasUpper = true
-		}
-	}
-
-	var hasLower bool = false
-	for _, r := range password {
-		if unicode.IsLower(r) && unicode.IsLetter(r) {
-			hasLower = true
-		}
-	}
+// CheckPassword returns every rule of the teacher password policy the password fails
+func (t *Teacher) CheckPassword(policy PasswordPolicy, password string) []PolicyError {
+	return policy.Check(password, t.Account.HashHistory)
+}
 
-	if strings.ContainsAny(password, specialCharSet) && hasLower && hasUpper && len(password) >= 8 {
-		return true
-	} else {
-		return false
+func (t *Teacher) PasswordExpired(policy PasswordPolicy) bool {
+	changed := t.Account.PasswordChanged_at
+	if changed.IsZero() {
+		changed = t.Created_at
 	}
+	return policy.Expired(changed)
 }
 
 func (t *Teacher) GeneratePassword(passwordLength, minSpecialChar, minNum, minUpperCase int) string {
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Verification struct {
+	ID         primitive.ObjectID `bson:"_id"`
+	Token      string             `json:"-"`        // sha256 of the token sent in the verification link
+	UID        string             `json:"uid"`      // sid or tid of the account being verified
+	UserType   int                `json:"usertype"` // A number representing the user (1: student, 2: teacher)
+	Email      Encrypted          `json:"email"`    // the address that becomes active once verified
+	Expires_at time.Time          `json:"expires_at"`
+	Created_at time.Time          `json:"created_at"`
+}
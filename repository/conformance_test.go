@@ -0,0 +1,763 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/SowinskiBraeden/school-management-api/database"
+	"github.com/SowinskiBraeden/school-management-api/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+/*
+	Every backend must behave the same for the handlers to work
+	on it. TestConformance runs the same checks of every operation
+	against each backend and reports each way it differs. The
+	in-memory and SQLite backends are always checked, MongoDB
+	only when mongoURI is set, in a <dbo>_conformance database
+	migrated for the run and dropped after it, so the school's
+	records are never touched.
+
+	The checks write accounts with keys starting "conformance-",
+	and records marked "conformance", and remove them again.
+*/
+
+// conformanceCheck is one behaviour every backend must have
+type conformanceCheck struct {
+	name string
+	run  func(ctx context.Context, repos *Repositories) error
+}
+
+func studentHashHistory(ctx context.Context, repos *Repositories, sid string) []string {
+	student, _ := repos.Students.Get(ctx, sid)
+	return student.Account.HashHistory
+}
+
+// expect returns an error describing the difference when got isn't want
+func expect(what string, got interface{}, want interface{}) error {
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		return fmt.Errorf("%s: got %v, want %v", what, got, want)
+	}
+	return nil
+}
+
+func conformanceStudent(sid string) models.Student {
+	var student models.Student
+	student.ID = primitive.NewObjectID()
+	student.Personal.FirstName = "Conformance"
+	student.Personal.LastName = sid
+	student.Personal.Email = models.Searchable(sid + "@example.com")
+	student.Personal.Address = models.Encrypted("1 Test Street")
+	student.School.SID = sid
+	student.School.PEN = "pen-" + sid
+	student.School.YOG = 2020
+	student.Account.SchoolEmail = sid + "@school.example.com"
+	student.Created_at = time.Now().UTC().Truncate(time.Millisecond)
+	return student
+}
+
+// The records without a key of their own are written with these IDs, so they can be found to remove
+var (
+	conformanceContactID, _ = primitive.ObjectIDFromHex("636f6e666f726d616e636531")
+	conformanceLockerID, _  = primitive.ObjectIDFromHex("636f6e666f726d616e636532")
+)
+
+var conformanceChecks = []conformanceCheck{
+	{"insert and get", func(ctx context.Context, repos *Repositories) error {
+		student := conformanceStudent("conformance-1")
+		if err := repos.Students.Insert(ctx, student); err != nil {
+			return err
+		}
+		got, err := repos.Students.Get(ctx, student.School.SID)
+		if err != nil {
+			return err
+		}
+		return errors.Join(
+			expect("sid", got.School.SID, student.School.SID),
+			expect("email", got.Personal.Email, student.Personal.Email),
+			expect("address", got.Personal.Address, student.Personal.Address),
+			expect("created_at", got.Created_at.UTC(), student.Created_at),
+		)
+	}},
+	{"get missing", func(ctx context.Context, repos *Repositories) error {
+		_, err := repos.Students.Get(ctx, "conformance-missing")
+		return expect("error", err, ErrNotFound)
+	}},
+	{"insert duplicate", func(ctx context.Context, repos *Repositories) error {
+		student := conformanceStudent("conformance-2")
+		if err := repos.Students.Insert(ctx, student); err != nil {
+			return err
+		}
+		return expect("error", repos.Students.Insert(ctx, student), ErrDuplicateKey)
+	}},
+	{"unique fields", func(ctx context.Context, repos *Repositories) error {
+		student := conformanceStudent("conformance-11")
+		if err := repos.Students.Insert(ctx, student); err != nil {
+			return err
+		}
+		sameSID := conformanceStudent(student.School.SID)
+		samePEN := conformanceStudent("conformance-12")
+		samePEN.School.PEN = student.School.PEN
+		noPEN := conformanceStudent("conformance-12")
+		noPEN.School.PEN = ""
+		return errors.Join(
+			expect("same sid", repos.Students.Insert(ctx, sameSID), ErrDuplicateKey),
+			expect("same pen", repos.Students.Insert(ctx, samePEN), ErrDuplicateKey),
+			expect("no pen", repos.Students.Insert(ctx, noPEN), nil),
+			expect("update to same pen", repos.Students.Update(ctx, noPEN.School.SID, bson.M{"$set": bson.M{"school.pen": student.School.PEN}}), ErrDuplicateKey),
+		)
+	}},
+	{"concurrent inserts", func(ctx context.Context, repos *Repositories) error {
+		// However many accounts are created at once, only one can have an ID
+		results := make(chan error, 20)
+		for i := 0; i < cap(results); i++ {
+			go func() {
+				results <- repos.Students.Insert(ctx, conformanceStudent("conformance-13"))
+			}()
+		}
+		inserted, duplicates := 0, 0
+		for i := 0; i < cap(results); i++ {
+			switch err := <-results; err {
+			case nil:
+				inserted++
+			case ErrDuplicateKey:
+				duplicates++
+			default:
+				return err
+			}
+		}
+		return errors.Join(
+			expect("inserted", inserted, 1),
+			expect("duplicates", duplicates, cap(results)-1),
+		)
+	}},
+	{"find by field", func(ctx context.Context, repos *Repositories) error {
+		student := conformanceStudent("conformance-3")
+		if err := repos.Students.Insert(ctx, student); err != nil {
+			return err
+		}
+		byPEN, err := repos.Students.FindByPEN(ctx, student.School.PEN)
+		if err != nil {
+			return err
+		}
+		byEmail, err := repos.Students.FindBySchoolEmail(ctx, student.Account.SchoolEmail)
+		if err != nil {
+			return err
+		}
+		_, missing := repos.Students.FindByPEN(ctx, "conformance-missing")
+		return errors.Join(
+			expect("by pen", byPEN.School.SID, student.School.SID),
+			expect("by school email", byEmail.School.SID, student.School.SID),
+			expect("missing", missing, ErrNotFound),
+		)
+	}},
+	{"update operators", func(ctx context.Context, repos *Repositories) error {
+		student := conformanceStudent("conformance-4")
+		student.Personal.Contacts = []string{"a", "b"}
+		if err := repos.Students.Insert(ctx, student); err != nil {
+			return err
+		}
+		err := repos.Students.Update(ctx, student.School.SID, bson.M{
+			"$set":   bson.M{"personal.firstname": "Updated", "account.lockouts": 2, "personal.email": models.Searchable("new@example.com")},
+			"$unset": bson.M{"school.homeroom": ""},
+			"$push":  bson.M{"account.hashhistory": "hash"},
+			"$pull":  bson.M{"personal.contacts": "a"},
+		})
+		if err != nil {
+			return err
+		}
+		got, err := repos.Students.Get(ctx, student.School.SID)
+		if err != nil {
+			return err
+		}
+		return errors.Join(
+			expect("set", got.Personal.FirstName, "Updated"),
+			expect("set number", got.Account.Lockouts, 2),
+			expect("set encrypted", got.Personal.Email, "new@example.com"),
+			expect("push", got.Account.HashHistory, []string{"hash"}),
+			expect("pull", got.Personal.Contacts, []string{"b"}),
+			expect("push slice", repos.Students.Update(ctx, student.School.SID, bson.M{
+				"$push": bson.M{"account.hashhistory": bson.M{"$each": []string{"hash2", "hash3"}, "$slice": -2}},
+			}), nil),
+			expect("pushed", studentHashHistory(ctx, repos, student.School.SID), []string{"hash2", "hash3"}),
+			expect("update missing", repos.Students.Update(ctx, "conformance-missing", bson.M{"$set": bson.M{"personal.age": 1}}), ErrNotFound),
+		)
+	}},
+	{"delete", func(ctx context.Context, repos *Repositories) error {
+		student := conformanceStudent("conformance-5")
+		if err := repos.Students.Insert(ctx, student); err != nil {
+			return err
+		}
+		if err := repos.Students.Delete(ctx, student.School.SID); err != nil {
+			return err
+		}
+		_, err := repos.Students.Get(ctx, student.School.SID)
+		return errors.Join(
+			expect("get deleted", err, ErrNotFound),
+			expect("delete missing", repos.Students.Delete(ctx, student.School.SID), ErrNotFound),
+		)
+	}},
+	{"document", func(ctx context.Context, repos *Repositories) error {
+		student := conformanceStudent("conformance-6")
+		if err := repos.Students.Insert(ctx, student); err != nil {
+			return err
+		}
+		doc, err := repos.Students.Document(ctx, student.School.SID)
+		if err != nil {
+			return err
+		}
+		sid, _ := lookup(toBSONM(doc), "school.sid")
+		return expect("school.sid", sid, student.School.SID)
+	}},
+	{"account filters", func(ctx context.Context, repos *Repositories) error {
+		removed := conformanceStudent("conformance-7")
+		removed.Removed = &models.Removal{Purge_at: time.Now().Add(-time.Hour)}
+		locked := conformanceStudent("conformance-8")
+		locked.Account.LockedUntil = time.Now().Add(time.Hour)
+		locked.Account.VerifiedEmail = true
+		locked.School.YOG = 2040
+		locked.School.Locker = "conformance-locker"
+		removed.Personal.Contacts = []string{"conformance-contact-1", "conformance-contact-2"}
+		for _, student := range []models.Student{removed, locked} {
+			if err := repos.Students.Insert(ctx, student); err != nil {
+				return err
+			}
+		}
+
+		keys := []string{removed.School.SID, locked.School.SID}
+		count := func(filter AccountFilter) int64 {
+			filter.Keys = keys
+			found, err := repos.Students.Count(ctx, filter)
+			if err != nil {
+				return -1
+			}
+			return found
+		}
+		found, err := repos.Students.Find(ctx, AccountFilter{State: RemovedAccounts, Keys: keys})
+		if err != nil {
+			return err
+		}
+		return errors.Join(
+			expect("find removed", len(found), 1),
+			expect("active", count(AccountFilter{}), 1),
+			expect("removed", count(AccountFilter{State: RemovedAccounts}), 1),
+			expect("all", count(AccountFilter{State: AllAccounts}), 2),
+			expect("purge due", count(AccountFilter{State: RemovedAccounts, PurgeDue: time.Now()}), 1),
+			expect("locked since", count(AccountFilter{State: AllAccounts, LockedSince: time.Now()}), 1),
+			expect("unverified", count(AccountFilter{State: AllAccounts, Unverified: true}), 1),
+			expect("graduated by", count(AccountFilter{State: AllAccounts, GraduatedBy: 2030}), 1),
+			expect("exclude keys", count(AccountFilter{State: AllAccounts, ExcludeKeys: []string{removed.School.SID}}), 1),
+			expect("contacts", count(AccountFilter{State: AllAccounts, Contacts: []string{"conformance-contact-2", "other"}}), 1),
+			expect("no contacts", count(AccountFilter{State: AllAccounts, Contacts: []string{}}), 0),
+			expect("lockers", count(AccountFilter{State: AllAccounts, Lockers: []string{"conformance-locker"}}), 1),
+			expect("holds locker", count(AccountFilter{State: AllAccounts, HoldsLocker: true}), 1),
+		)
+	}},
+	{"list pages", func(ctx context.Context, repos *Repositories) error {
+		lastNames := map[string]string{"conformance-15": "Page-B", "conformance-16": "Page-A", "conformance-17": "Page-A"}
+		for _, sid := range []string{"conformance-15", "conformance-16", "conformance-17"} {
+			student := conformanceStudent(sid)
+			student.Personal.LastName = lastNames[sid]
+			student.School.Homeroom = "conformance-list"
+			if err := repos.Students.Insert(ctx, student); err != nil {
+				return err
+			}
+		}
+
+		// sids lists a page, ending with the cursor of the page after it
+		sids := func(query ListQuery) string {
+			query.Equal = bson.M{"school.homeroom": "conformance-list"}
+			students, next, err := repos.Students.List(ctx, query)
+			if err != nil {
+				return err.Error()
+			}
+			found := []string{}
+			for _, student := range students {
+				found = append(found, student.School.SID)
+			}
+			return fmt.Sprint(found, next != "")
+		}
+		byName := ListQuery{Sort: "personal.lastname", Limit: 2}
+		first, next, err := repos.Students.List(ctx, ListQuery{Equal: bson.M{"school.homeroom": "conformance-list"}, Sort: byName.Sort, Limit: byName.Limit})
+		if err != nil {
+			return err
+		}
+		byName.After = next
+		_, _, badCursorErr := repos.Students.List(ctx, ListQuery{After: "not a cursor"})
+		return errors.Join(
+			expect("first page", len(first), 2),
+			expect("next page", sids(byName), "[conformance-15] false"),
+			expect("sorted", sids(ListQuery{Sort: "personal.lastname"}), "[conformance-16 conformance-17 conformance-15] false"),
+			expect("descending", sids(ListQuery{Sort: "personal.lastname", Descending: true, Limit: 1}), "[conformance-15] true"),
+			expect("prefix", sids(ListQuery{Prefixes: []Prefix{{Fields: []string{"personal.firstname", "personal.lastname"}, Text: "page-b"}}}), "[conformance-15] false"),
+			expect("not equal", sids(ListQuery{NotEqual: bson.M{"personal.lastname": "Page-A"}}), "[conformance-15] false"),
+			expect("keys", sids(ListQuery{Keys: []string{"conformance-17"}}), "[conformance-17] false"),
+			expect("bad cursor", badCursorErr, ErrInvalidCursor),
+		)
+	}},
+	{"staff and records", func(ctx context.Context, repos *Repositories) error {
+		var teacher models.Teacher
+		teacher.ID = primitive.NewObjectID()
+		teacher.School.TID = "conformance-teacher"
+		teacher.Account.SchoolEmail = "conformance-teacher@school.example.com"
+		admin := models.Admin{ID: primitive.NewObjectID(), AID: "conformance-admin", SchoolEmail: "conformance-admin@school.example.com"}
+		contact := models.Contact{ID: conformanceContactID, FirstName: "Conformance"}
+		locker := models.Locker{ID: conformanceLockerID, LockerNumber: "conformance-locker"}
+		photo := models.Photo{ID: primitive.NewObjectID(), Name: "conformance-photo"}
+
+		err := errors.Join(
+			repos.Teachers.Insert(ctx, teacher),
+			repos.Admins.Insert(ctx, admin),
+			repos.Contacts.Insert(ctx, contact),
+			repos.Lockers.Insert(ctx, locker),
+			repos.Photos.Insert(ctx, photo),
+		)
+		if err != nil {
+			return err
+		}
+
+		gotTeacher, teacherErr := repos.Teachers.FindBySchoolEmail(ctx, teacher.Account.SchoolEmail)
+		gotAdmin, adminErr := repos.Admins.FindBySchoolEmail(ctx, admin.SchoolEmail)
+		gotContact, contactErr := repos.Contacts.Get(ctx, contact.ID.Hex())
+		gotLocker, lockerErr := repos.Lockers.FindByNumber(ctx, locker.LockerNumber)
+		gotPhoto, photoErr := repos.Photos.Get(ctx, photo.Name)
+		_, badHexErr := repos.Contacts.Get(ctx, "not a hex id")
+		return errors.Join(
+			teacherErr, adminErr, contactErr, lockerErr, photoErr,
+			expect("teacher", gotTeacher.School.TID, teacher.School.TID),
+			expect("admin", gotAdmin.AID, admin.AID),
+			expect("contact", gotContact.ID, contact.ID),
+			expect("locker by number", gotLocker.ID.Hex(), locker.ID.Hex()),
+			expect("locker update", repos.Lockers.Update(ctx, locker.ID.Hex(), bson.M{"$set": bson.M{"lockercombo": "1-2-3"}}), nil),
+			expect("photo", gotPhoto.ID, photo.ID),
+			expect("invalid contact id", badHexErr, ErrNotFound),
+		)
+	}},
+	{"transaction rollback", func(ctx context.Context, repos *Repositories) error {
+		student := conformanceStudent("conformance-9")
+		failed := errors.New("rolled back")
+		err := repos.Transaction(ctx, func(ctx context.Context) error {
+			if err := repos.Students.Insert(ctx, student); err != nil {
+				return err
+			}
+			return failed
+		})
+		if err != failed {
+			return expect("transaction error", err, failed)
+		}
+		_, err = repos.Students.Get(ctx, student.School.SID)
+		return expect("after rollback", err, ErrNotFound)
+	}},
+	{"transaction commit", func(ctx context.Context, repos *Repositories) error {
+		student := conformanceStudent("conformance-10")
+		err := repos.Transaction(ctx, func(ctx context.Context) error {
+			if err := repos.Students.Insert(ctx, student); err != nil {
+				return err
+			}
+			return repos.Students.Update(ctx, student.School.SID, bson.M{"$set": bson.M{"school.homeroom": "B12"}})
+		})
+		if err != nil {
+			return err
+		}
+		got, err := repos.Students.Get(ctx, student.School.SID)
+		if err != nil {
+			return err
+		}
+		return expect("homeroom", got.School.Homeroom, "B12")
+	}},
+	{"atomic steps undone", func(ctx context.Context, repos *Repositories) error {
+		student := conformanceStudent("conformance-14")
+		failed := errors.New("undone")
+		err := repos.Atomic(ctx,
+			Step{
+				Do:   func(ctx context.Context) error { return repos.Students.Insert(ctx, student) },
+				Undo: func(ctx context.Context) error { return repos.Students.Delete(ctx, student.School.SID) },
+			},
+			Step{Do: func(ctx context.Context) error { return failed }},
+		)
+		if err != failed {
+			return expect("atomic error", err, failed)
+		}
+		_, err = repos.Students.Get(ctx, student.School.SID)
+		return expect("after undoing", err, ErrNotFound)
+	}},
+	{"nested transaction", func(ctx context.Context, repos *Repositories) error {
+		student := conformanceStudent("conformance-18")
+		failed := errors.New("rolled back")
+		err := repos.Transaction(ctx, func(ctx context.Context) error {
+			if err := repos.Students.Insert(ctx, student); err != nil {
+				return err
+			}
+			// The inner transaction is part of the outer one, it is rolled back with it
+			if err := repos.Transaction(ctx, func(ctx context.Context) error {
+				return repos.Students.Update(ctx, student.School.SID, bson.M{"$set": bson.M{"school.homeroom": "B12"}})
+			}); err != nil {
+				return err
+			}
+			return failed
+		})
+		if err != failed {
+			return expect("transaction error", err, failed)
+		}
+		_, err = repos.Students.Get(ctx, student.School.SID)
+		return expect("after rollback", err, ErrNotFound)
+	}},
+	{"restore", func(ctx context.Context, repos *Repositories) error {
+		student := conformanceStudent("conformance-19")
+		if err := repos.Students.Insert(ctx, student); err != nil {
+			return err
+		}
+		before, err := repos.Students.Document(ctx, student.School.SID)
+		if err != nil {
+			return err
+		}
+
+		if err := repos.Students.Update(ctx, student.School.SID, bson.M{"$set": bson.M{"school.homeroom": "B12"}}); err != nil {
+			return err
+		}
+		if err := repos.Students.Restore(ctx, student.School.SID, before); err != nil {
+			return err
+		}
+		updated, err := repos.Students.Get(ctx, student.School.SID)
+		if err != nil {
+			return err
+		}
+
+		if err := repos.Students.Delete(ctx, student.School.SID); err != nil {
+			return err
+		}
+		if err := repos.Students.Restore(ctx, student.School.SID, before); err != nil {
+			return err
+		}
+		deleted, err := repos.Students.Get(ctx, student.School.SID)
+		return errors.Join(
+			expect("homeroom after update", updated.School.Homeroom, ""),
+			err,
+			expect("pen after delete", deleted.School.PEN, student.School.PEN),
+		)
+	}},
+	{"records find", func(ctx context.Context, repos *Repositories) error {
+		if err := insertConformanceRecords(ctx, repos.LoginAttempts); err != nil {
+			return err
+		}
+		var all, newest, limited []bson.M
+		if err := repos.LoginAttempts.Find(ctx, bson.M{"conformance": true}, bson.D{{Key: "n", Value: 1}}, 0, &all); err != nil {
+			return err
+		}
+		if err := repos.LoginAttempts.Find(ctx, bson.M{"conformance": true, "n": bson.M{"$gte": 2}}, bson.D{{Key: "n", Value: -1}}, 0, &newest); err != nil {
+			return err
+		}
+		if err := repos.LoginAttempts.Find(ctx, bson.M{"conformance": true}, bson.D{{Key: "n", Value: -1}}, 1, &limited); err != nil {
+			return err
+		}
+		var first bson.M
+		if err := repos.LoginAttempts.FindOne(ctx, bson.M{"conformance": true}, bson.D{{Key: "n", Value: 1}}, &first); err != nil {
+			return err
+		}
+		var none []bson.M
+		if err := repos.LoginAttempts.Find(ctx, bson.M{"conformance": true, "n": 4}, nil, 0, &none); err != nil {
+			return err
+		}
+		return errors.Join(
+			expect("all", recordNumbers(all), []int{1, 2, 3}),
+			expect("sorted descending", recordNumbers(newest), []int{3, 2}),
+			expect("limited", recordNumbers(limited), []int{3}),
+			expect("first", first["n"], 1),
+			expect("none", none != nil && len(none) == 0, true),
+			expect("find one missing", repos.LoginAttempts.FindOne(ctx, bson.M{"conformance": true, "n": 4}, nil, &first), ErrNotFound),
+		)
+	}},
+	{"records filters", func(ctx context.Context, repos *Repositories) error {
+		if err := insertConformanceRecords(ctx, repos.LoginAttempts); err != nil {
+			return err
+		}
+		cutoff := time.Date(2020, time.January, 2, 12, 0, 0, 0, time.UTC)
+		filters := []struct {
+			name   string
+			filter bson.M
+			want   int64
+		}{
+			{"equal", bson.M{"uid": "conformance-b"}, 1},
+			{"ne", bson.M{"uid": bson.M{"$ne": "conformance-b"}}, 2},
+			{"gt", bson.M{"n": bson.M{"$gt": 1}}, 2},
+			{"lte", bson.M{"n": bson.M{"$lte": 2}}, 2},
+			{"time lt", bson.M{"created_at": bson.M{"$lt": cutoff}}, 2},
+			{"in", bson.M{"uid": bson.M{"$in": bson.A{"conformance-a", "conformance-c"}}}, 2},
+			{"nin", bson.M{"uid": bson.M{"$nin": bson.A{"conformance-a"}}}, 2},
+			{"array member", bson.M{"tags": "even"}, 1},
+			{"nested", bson.M{"detail.ip": "10.0.0.1"}, 3},
+			{"exists", bson.M{"released_at": bson.M{"$exists": true}}, 1},
+			{"not exists", bson.M{"released_at": bson.M{"$exists": false}}, 2},
+			{"type", bson.M{"uid": bson.M{"$type": "string", "$ne": ""}}, 3},
+			{"or", bson.M{"$or": bson.A{bson.M{"n": 1}, bson.M{"n": 3}}}, 2},
+			{"and", bson.M{"$and": bson.A{bson.M{"n": bson.M{"$gt": 1}}, bson.M{"n": bson.M{"$lt": 3}}}}, 1},
+			{"nor", bson.M{"$nor": bson.A{bson.M{"n": 1}}}, 2},
+		}
+		errs := []error{}
+		for _, f := range filters {
+			f.filter["conformance"] = true
+			count, err := repos.LoginAttempts.Count(ctx, f.filter)
+			if err != nil {
+				return fmt.Errorf("%s: %v", f.name, err)
+			}
+			errs = append(errs, expect(f.name, count, f.want))
+		}
+		return errors.Join(errs...)
+	}},
+	{"records update", func(ctx context.Context, repos *Repositories) error {
+		if err := insertConformanceRecords(ctx, repos.LoginAttempts); err != nil {
+			return err
+		}
+		matched, err := repos.LoginAttempts.UpdateOne(ctx, bson.M{"conformance": true, "n": 1}, bson.M{"$set": bson.M{"success": true}}, false)
+		if err != nil {
+			return err
+		}
+		missing, err := repos.LoginAttempts.UpdateOne(ctx, bson.M{"conformance": true, "n": 4}, bson.M{"$set": bson.M{"success": true}}, false)
+		if err != nil {
+			return err
+		}
+		updated, err := repos.LoginAttempts.Count(ctx, bson.M{"conformance": true, "success": true})
+		if err != nil {
+			return err
+		}
+		return errors.Join(
+			expect("matched", matched, 1),
+			expect("missing", missing, 0),
+			expect("updated", updated, 1),
+		)
+	}},
+	{"records upsert", func(ctx context.Context, repos *Repositories) error {
+		id := primitive.NewObjectID()
+		filter := bson.M{"conformance": true, "datatype": "conformance"}
+		upsert := func(days int) (int64, error) {
+			return repos.RetentionRules.UpdateOne(ctx, filter, bson.M{
+				"$set":         bson.M{"days": days},
+				"$setOnInsert": bson.M{"_id": id},
+			}, true)
+		}
+		inserted, err := upsert(5)
+		if err != nil {
+			return err
+		}
+		updated, err := upsert(6)
+		if err != nil {
+			return err
+		}
+		var rules []bson.M
+		if err := repos.RetentionRules.Find(ctx, filter, nil, 0, &rules); err != nil {
+			return err
+		}
+		if len(rules) != 1 {
+			return expect("rules", len(rules), 1)
+		}
+		return errors.Join(
+			expect("inserted", inserted, 1),
+			expect("updated", updated, 1),
+			expect("id", rules[0]["_id"], id),
+			expect("filter fields", rules[0]["datatype"], "conformance"),
+			expect("days", rules[0]["days"], 6),
+		)
+	}},
+	{"records delete", func(ctx context.Context, repos *Repositories) error {
+		if err := insertConformanceRecords(ctx, repos.LoginAttempts); err != nil {
+			return err
+		}
+		deleted, err := repos.LoginAttempts.DeleteMany(ctx, bson.M{"conformance": true, "n": bson.M{"$lt": 3}})
+		if err != nil {
+			return err
+		}
+		left, err := repos.LoginAttempts.Count(ctx, bson.M{"conformance": true})
+		if err != nil {
+			return err
+		}
+		return errors.Join(
+			expect("deleted", deleted, 2),
+			expect("left", left, 1),
+		)
+	}},
+	{"records unique", func(ctx context.Context, repos *Repositories) error {
+		// The audit log is chained by seq, two entries can never take the same place
+		entry := bson.M{"_id": primitive.NewObjectID(), "conformance": true, "seq": int64(1)}
+		if err := repos.Audit.Insert(ctx, entry); err != nil {
+			return err
+		}
+		sameSeq := bson.M{"_id": primitive.NewObjectID(), "conformance": true, "seq": int64(1)}
+		sameID := bson.M{"_id": entry["_id"], "conformance": true, "seq": int64(2)}
+		many := []interface{}{
+			bson.M{"_id": primitive.NewObjectID(), "conformance": true, "seq": int64(3)},
+			bson.M{"_id": primitive.NewObjectID(), "conformance": true, "seq": int64(3)},
+		}
+		return errors.Join(
+			expect("same seq", repos.Audit.Insert(ctx, sameSeq), ErrDuplicateKey),
+			expect("same id", repos.Audit.Insert(ctx, sameID), ErrDuplicateKey),
+			expect("insert many", repos.Audit.InsertMany(ctx, many), ErrDuplicateKey),
+		)
+	}},
+	{"records archive", func(ctx context.Context, repos *Repositories) error {
+		if err := insertConformanceRecords(ctx, repos.LoginAttempts); err != nil {
+			return err
+		}
+		archive := repos.Records("loginattempts_archive")
+		if archive == nil {
+			return errors.New("no loginattempts_archive")
+		}
+		err := repos.Transaction(ctx, func(ctx context.Context) error {
+			var records []bson.M
+			if err := repos.LoginAttempts.Find(ctx, bson.M{"conformance": true}, nil, 0, &records); err != nil {
+				return err
+			}
+			docs := []interface{}{}
+			for _, record := range records {
+				docs = append(docs, record)
+			}
+			if err := archive.InsertMany(ctx, docs); err != nil {
+				return err
+			}
+			_, err := repos.LoginAttempts.DeleteMany(ctx, bson.M{"conformance": true})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		archived, err := archive.Count(ctx, bson.M{"conformance": true})
+		if err != nil {
+			return err
+		}
+		left, err := repos.LoginAttempts.Count(ctx, bson.M{"conformance": true})
+		if err != nil {
+			return err
+		}
+		return errors.Join(
+			expect("archived", archived, 3),
+			expect("left", left, 0),
+		)
+	}},
+}
+
+// toBSONM converts a document read from any backend into a bson.M with bson.M subdocuments
+func toBSONM(doc bson.M) bson.M {
+	normalized, _ := toDocument(doc)
+	return normalized
+}
+
+// insertConformanceRecords writes the records the checks of record repositories look for
+func insertConformanceRecords(ctx context.Context, records RecordRepository) error {
+	if _, err := records.DeleteMany(ctx, bson.M{"conformance": true}); err != nil {
+		return err
+	}
+	docs := []interface{}{}
+	for i, uid := range []string{"conformance-a", "conformance-b", "conformance-c"} {
+		doc := bson.M{
+			"_id":         primitive.NewObjectID(),
+			"conformance": true,
+			"uid":         uid,
+			"n":           i + 1,
+			"tags":        bson.A{[]string{"odd", "even"}[i%2]},
+			"detail":      bson.M{"ip": "10.0.0.1"},
+			"created_at":  time.Date(2020, time.January, i+1, 0, 0, 0, 0, time.UTC),
+		}
+		if i == 0 {
+			doc["released_at"] = time.Date(2020, time.February, 1, 0, 0, 0, 0, time.UTC)
+		}
+		docs = append(docs, doc)
+	}
+	return records.InsertMany(ctx, docs)
+}
+
+// recordNumbers lists the n of each record, in order
+func recordNumbers(records []bson.M) []int {
+	numbers := []int{}
+	for _, record := range records {
+		numbers = append(numbers, int(toBSONM(bson.M{"n": record["n"]})["n"].(int32)))
+	}
+	return numbers
+}
+
+// cleanConformance removes the records the checks write
+func cleanConformance(ctx context.Context, repos *Repositories) {
+	for i := 1; i <= 19; i++ {
+		repos.Students.Delete(ctx, fmt.Sprintf("conformance-%d", i))
+	}
+	repos.Teachers.Delete(ctx, "conformance-teacher")
+	repos.Admins.Delete(ctx, "conformance-admin")
+	repos.Photos.Delete(ctx, "conformance-photo")
+	repos.Contacts.Delete(ctx, conformanceContactID.Hex())
+	repos.Lockers.Delete(ctx, conformanceLockerID.Hex())
+	for _, records := range []RecordRepository{repos.LoginAttempts, repos.Records("loginattempts_archive"), repos.Audit, repos.RetentionRules} {
+		records.DeleteMany(ctx, bson.M{"conformance": true})
+	}
+}
+
+// conformanceBackends opens each backend to check, MongoDB only when mongoURI is set
+var conformanceBackends = []struct {
+	name string
+	open func(t *testing.T) *Repositories
+}{
+	{"memory", func(t *testing.T) *Repositories {
+		return NewMemory()
+	}},
+	{"sqlite", func(t *testing.T) *Repositories {
+		repos, err := NewSQLite(filepath.Join(t.TempDir(), "conformance.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return repos
+	}},
+	{"mongodb", func(t *testing.T) *Repositories {
+		if os.Getenv("mongoURI") == "" {
+			t.Skip("mongoURI is not set")
+		}
+		if err := database.Connect(); err != nil {
+			t.Fatal(err)
+		}
+		return NewMongo(migratedMongo(t, os.Getenv("dbo")+"_conformance"))
+	}},
+}
+
+// migratedMongo gives the test a new database with every migration applied, as the server's would have, dropped once it ends
+func migratedMongo(t *testing.T, name string) *mongo.Database {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	db := database.Client.Database(name)
+	if err := db.Drop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Drop(context.Background()) })
+	if _, err := database.MigrateUp(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestConformance(t *testing.T) {
+	for _, backend := range conformanceBackends {
+		backend := backend
+		t.Run(backend.name, func(t *testing.T) {
+			repos := backend.open(t)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+			defer cancel()
+
+			cleanConformance(ctx, repos)
+			defer cleanConformance(ctx, repos)
+
+			for _, check := range conformanceChecks {
+				check := check
+				t.Run(check.name, func(t *testing.T) {
+					if err := check.run(ctx, repos); err != nil {
+						t.Error(err)
+					}
+				})
+			}
+		})
+	}
+}
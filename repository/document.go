@@ -0,0 +1,465 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/SowinskiBraeden/school-management-api/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+/*
+	Stores that don't run on MongoDB keep each record as the
+	document MongoDB would store, so encrypted fields are sealed
+	the same way and an update changes the same fields. These
+	are the document operations they share, and the repositories
+	built on any store of documents.
+*/
+
+// documentStore keeps records as documents found by a key, in the order they were inserted
+type documentStore interface {
+	Store
+	insert(ctx context.Context, record interface{}) error
+	get(ctx context.Context, key string, record interface{}) error
+	// findOne decodes the first record where the field at a dotted path has value
+	findOne(ctx context.Context, field string, value string, record interface{}) error
+	// each calls fn with every document in the order they were inserted
+	each(ctx context.Context, fn func(doc bson.M) error) error
+	// keyPath is the dotted path of the field records are found by
+	keyPath() string
+	// insertMany inserts every record or, if one can't be, none of them
+	insertMany(ctx context.Context, records []interface{}) error
+	// updateFirst replaces the first document match picks with what update returns for it, or inserts what update
+	// returns for nil when none is picked and it returns a document, with no other write coming in between
+	updateFirst(ctx context.Context, match func(doc bson.M) (bool, error), update func(doc bson.M) (bson.M, error)) error
+	// deleteWhere deletes every document match picks and returns how many it deleted
+	deleteWhere(ctx context.Context, match func(doc bson.M) (bool, error)) (int64, error)
+}
+
+// toDocument encodes a record the way MongoDB stores it, the result shares nothing with value
+func toDocument(value interface{}) (bson.M, error) {
+	data, err := bson.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.M
+	err = bson.Unmarshal(data, &doc)
+	return doc, err
+}
+
+// fromDocument decodes a stored document into a record
+func fromDocument(doc bson.M, record interface{}) error {
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(data, record)
+}
+
+// normalize encodes a single value the way it would be stored in a document
+func normalize(value interface{}) (interface{}, error) {
+	doc, err := toDocument(bson.M{"v": value})
+	if err != nil {
+		return nil, err
+	}
+	return doc["v"], nil
+}
+
+// lookup finds the value at a dotted path
+func lookup(doc bson.M, path string) (interface{}, bool) {
+	var value interface{} = doc
+	for _, key := range strings.Split(path, ".") {
+		parent, ok := value.(bson.M)
+		if !ok {
+			return nil, false
+		}
+		if value, ok = parent[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// setPath sets the value at a dotted path, creating the documents on the way
+func setPath(doc bson.M, path string, value interface{}) error {
+	keys := strings.Split(path, ".")
+	for _, key := range keys[:len(keys)-1] {
+		child, ok := doc[key]
+		if !ok || child == nil {
+			child = bson.M{}
+			doc[key] = child
+		}
+		if doc, ok = child.(bson.M); !ok {
+			return fmt.Errorf("cannot set %s, %s is not a document", path, key)
+		}
+	}
+	doc[keys[len(keys)-1]] = value
+	return nil
+}
+
+// unsetPath removes the value at a dotted path, if there is one
+func unsetPath(doc bson.M, path string) {
+	keys := strings.Split(path, ".")
+	for _, key := range keys[:len(keys)-1] {
+		child, ok := doc[key].(bson.M)
+		if !ok {
+			return
+		}
+		doc = child
+	}
+	delete(doc, keys[len(keys)-1])
+}
+
+// applyUpdate applies a MongoDB update document to a stored document
+func applyUpdate(doc bson.M, update bson.M) error {
+	for operator, fields := range update {
+		fields, err := toDocument(fields)
+		if err != nil {
+			return err
+		}
+
+		for path, value := range fields {
+			switch operator {
+			case "$set":
+				if err := setPath(doc, path, value); err != nil {
+					return err
+				}
+			case "$unset":
+				unsetPath(doc, path)
+			case "$push":
+				current, _ := lookup(doc, path)
+				array, ok := current.(bson.A)
+				if current != nil && !ok {
+					return fmt.Errorf("cannot push to %s, it is not an array", path)
+				}
+				pushed, err := pushValues(append(bson.A{}, array...), value)
+				if err != nil {
+					return err
+				}
+				if err := setPath(doc, path, pushed); err != nil {
+					return err
+				}
+			case "$pull":
+				current, _ := lookup(doc, path)
+				array, ok := current.(bson.A)
+				if !ok {
+					continue
+				}
+				kept := bson.A{}
+				for _, item := range array {
+					if !reflect.DeepEqual(item, value) {
+						kept = append(kept, item)
+					}
+				}
+				setPath(doc, path, kept)
+			default:
+				return fmt.Errorf("the %s update operator is not supported", operator)
+			}
+		}
+	}
+	return nil
+}
+
+// pushValues appends a $push value to an array, either one item or the items of $each kept to $slice
+func pushValues(array bson.A, value interface{}) (bson.A, error) {
+	modifiers, ok := value.(bson.M)
+	if !ok || modifiers["$each"] == nil {
+		return append(array, value), nil
+	}
+	each, ok := modifiers["$each"].(bson.A)
+	if !ok {
+		return nil, fmt.Errorf("$each must be an array")
+	}
+	array = append(array, each...)
+
+	slice, ok := modifiers["$slice"]
+	if !ok {
+		return array, nil
+	}
+	var n int
+	switch value := slice.(type) {
+	case int32:
+		n = int(value)
+	case int64:
+		n = int(value)
+	default:
+		return nil, fmt.Errorf("$slice must be a number")
+	}
+	// A negative $slice keeps the last items, a positive one the first
+	if n < 0 && -n < len(array) {
+		return array[len(array)+n:], nil
+	}
+	if n >= 0 && n < len(array) {
+		return array[:n], nil
+	}
+	return array, nil
+}
+
+// matches reports whether an account with these fields is picked by the filter
+func (f AccountFilter) matches(key string, removed *models.Removal, lockedUntil time.Time, verified bool, yog int) bool {
+	switch {
+	case f.State == ActiveAccounts && removed != nil,
+		f.State == RemovedAccounts && removed == nil,
+		!f.PurgeDue.IsZero() && (removed == nil || removed.Purge_at.After(f.PurgeDue)),
+		!f.LockedSince.IsZero() && lockedUntil.Before(f.LockedSince),
+		f.Unverified && verified,
+		f.GraduatedBy != 0 && (yog <= 0 || yog > f.GraduatedBy):
+		return false
+	}
+	if f.Keys != nil && !contains(f.Keys, key) {
+		return false
+	}
+	return !contains(f.ExcludeKeys, key)
+}
+
+// references reports whether a student with these contacts and locker is picked by the filter
+func (f AccountFilter) references(contacts []string, locker string) bool {
+	if f.Lockers != nil && !contains(f.Lockers, locker) || f.HoldsLocker && locker == "" {
+		return false
+	}
+	if f.Contacts == nil {
+		return true
+	}
+	for _, contact := range contacts {
+		if contains(f.Contacts, contact) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+type documentStudents struct{ documentStore }
+
+func (r documentStudents) Get(ctx context.Context, sid string) (student models.Student, err error) {
+	err = r.get(ctx, sid, &student)
+	return student, err
+}
+
+func (r documentStudents) FindByPEN(ctx context.Context, pen string) (student models.Student, err error) {
+	err = r.findOne(ctx, "school.pen", pen, &student)
+	return student, err
+}
+
+func (r documentStudents) FindBySchoolEmail(ctx context.Context, email string) (student models.Student, err error) {
+	err = r.findOne(ctx, "account.schoolemail", email, &student)
+	return student, err
+}
+
+func (r documentStudents) Find(ctx context.Context, filter AccountFilter) ([]models.Student, error) {
+	students := []models.Student{}
+	err := r.each(ctx, func(doc bson.M) error {
+		var student models.Student
+		if err := fromDocument(doc, &student); err != nil {
+			return err
+		}
+		if filter.matches(student.School.SID, student.Removed, student.Account.LockedUntil, student.Account.VerifiedEmail, student.School.YOG) &&
+			filter.references(student.Personal.Contacts, student.School.Locker) {
+			students = append(students, student)
+		}
+		return nil
+	})
+	return students, err
+}
+
+func (r documentStudents) Count(ctx context.Context, filter AccountFilter) (int64, error) {
+	students, err := r.Find(ctx, filter)
+	return int64(len(students)), err
+}
+
+func (r documentStudents) List(ctx context.Context, query ListQuery) ([]models.Student, string, error) {
+	docs, next, err := listDocuments(ctx, r.documentStore, query)
+	if err != nil {
+		return nil, "", err
+	}
+	students := make([]models.Student, len(docs))
+	for i, doc := range docs {
+		if err := fromDocument(doc, &students[i]); err != nil {
+			return nil, "", err
+		}
+	}
+	return students, next, nil
+}
+
+func (r documentStudents) Insert(ctx context.Context, student models.Student) error {
+	return r.insert(ctx, student)
+}
+
+type documentTeachers struct{ documentStore }
+
+func (r documentTeachers) Get(ctx context.Context, tid string) (teacher models.Teacher, err error) {
+	err = r.get(ctx, tid, &teacher)
+	return teacher, err
+}
+
+func (r documentTeachers) FindBySchoolEmail(ctx context.Context, email string) (teacher models.Teacher, err error) {
+	err = r.findOne(ctx, "account.schoolemail", email, &teacher)
+	return teacher, err
+}
+
+func (r documentTeachers) Find(ctx context.Context, filter AccountFilter) ([]models.Teacher, error) {
+	teachers := []models.Teacher{}
+	err := r.each(ctx, func(doc bson.M) error {
+		var teacher models.Teacher
+		if err := fromDocument(doc, &teacher); err != nil {
+			return err
+		}
+		if filter.matches(teacher.School.TID, teacher.Removed, teacher.Account.LockedUntil, teacher.Account.VerifiedEmail, 0) {
+			teachers = append(teachers, teacher)
+		}
+		return nil
+	})
+	return teachers, err
+}
+
+func (r documentTeachers) Count(ctx context.Context, filter AccountFilter) (int64, error) {
+	teachers, err := r.Find(ctx, filter)
+	return int64(len(teachers)), err
+}
+
+func (r documentTeachers) List(ctx context.Context, query ListQuery) ([]models.Teacher, string, error) {
+	docs, next, err := listDocuments(ctx, r.documentStore, query)
+	if err != nil {
+		return nil, "", err
+	}
+	teachers := make([]models.Teacher, len(docs))
+	for i, doc := range docs {
+		if err := fromDocument(doc, &teachers[i]); err != nil {
+			return nil, "", err
+		}
+	}
+	return teachers, next, nil
+}
+
+func (r documentTeachers) Insert(ctx context.Context, teacher models.Teacher) error {
+	return r.insert(ctx, teacher)
+}
+
+type documentAdmins struct{ documentStore }
+
+func (r documentAdmins) Get(ctx context.Context, aid string) (admin models.Admin, err error) {
+	err = r.get(ctx, aid, &admin)
+	return admin, err
+}
+
+func (r documentAdmins) FindBySchoolEmail(ctx context.Context, email string) (admin models.Admin, err error) {
+	err = r.findOne(ctx, "schoolemail", email, &admin)
+	return admin, err
+}
+
+func (r documentAdmins) Find(ctx context.Context, filter AccountFilter) ([]models.Admin, error) {
+	admins := []models.Admin{}
+	err := r.each(ctx, func(doc bson.M) error {
+		var admin models.Admin
+		if err := fromDocument(doc, &admin); err != nil {
+			return err
+		}
+		// Admins have no personal email to verify
+		if filter.matches(admin.AID, admin.Removed, admin.LockedUntil, false, 0) {
+			admins = append(admins, admin)
+		}
+		return nil
+	})
+	return admins, err
+}
+
+func (r documentAdmins) Count(ctx context.Context, filter AccountFilter) (int64, error) {
+	admins, err := r.Find(ctx, filter)
+	return int64(len(admins)), err
+}
+
+func (r documentAdmins) List(ctx context.Context, query ListQuery) ([]models.Admin, string, error) {
+	docs, next, err := listDocuments(ctx, r.documentStore, query)
+	if err != nil {
+		return nil, "", err
+	}
+	admins := make([]models.Admin, len(docs))
+	for i, doc := range docs {
+		if err := fromDocument(doc, &admins[i]); err != nil {
+			return nil, "", err
+		}
+	}
+	return admins, next, nil
+}
+
+func (r documentAdmins) Insert(ctx context.Context, admin models.Admin) error {
+	return r.insert(ctx, admin)
+}
+
+type documentContacts struct{ documentStore }
+
+func (r documentContacts) Get(ctx context.Context, id string) (contact models.Contact, err error) {
+	err = r.get(ctx, id, &contact)
+	return contact, err
+}
+
+func (r documentContacts) List(ctx context.Context, query ListQuery) ([]models.Contact, string, error) {
+	docs, next, err := listDocuments(ctx, r.documentStore, query)
+	if err != nil {
+		return nil, "", err
+	}
+	contacts := make([]models.Contact, len(docs))
+	for i, doc := range docs {
+		if err := fromDocument(doc, &contacts[i]); err != nil {
+			return nil, "", err
+		}
+	}
+	return contacts, next, nil
+}
+
+func (r documentContacts) Insert(ctx context.Context, contact models.Contact) error {
+	return r.insert(ctx, contact)
+}
+
+type documentLockers struct{ documentStore }
+
+func (r documentLockers) Get(ctx context.Context, id string) (locker models.Locker, err error) {
+	err = r.get(ctx, id, &locker)
+	return locker, err
+}
+
+func (r documentLockers) FindByNumber(ctx context.Context, number string) (locker models.Locker, err error) {
+	err = r.findOne(ctx, "lockernumber", number, &locker)
+	return locker, err
+}
+
+func (r documentLockers) List(ctx context.Context, query ListQuery) ([]models.Locker, string, error) {
+	docs, next, err := listDocuments(ctx, r.documentStore, query)
+	if err != nil {
+		return nil, "", err
+	}
+	lockers := make([]models.Locker, len(docs))
+	for i, doc := range docs {
+		if err := fromDocument(doc, &lockers[i]); err != nil {
+			return nil, "", err
+		}
+	}
+	return lockers, next, nil
+}
+
+func (r documentLockers) Insert(ctx context.Context, locker models.Locker) error {
+	return r.insert(ctx, locker)
+}
+
+type documentPhotos struct{ documentStore }
+
+func (r documentPhotos) Get(ctx context.Context, name string) (photo models.Photo, err error) {
+	err = r.get(ctx, name, &photo)
+	return photo, err
+}
+
+func (r documentPhotos) Insert(ctx context.Context, photo models.Photo) error {
+	return r.insert(ctx, photo)
+}
@@ -0,0 +1,267 @@
+package repository
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+	The list endpoints read records a page at a time. A page is
+	sorted by one field and then by the key, so records with the
+	same value keep their order, and the cursor of a page holds
+	the sort value and key of its last record. The next page
+	starts after that record, so records written between pages
+	don't shift or repeat the ones already read.
+
+	Fields are named by their dotted path in the stored document.
+	Encrypted fields can't be filtered, searched or sorted by.
+*/
+
+// ErrInvalidCursor is returned when a list is given a cursor it didn't return
+var ErrInvalidCursor = errors.New("the cursor is invalid")
+
+// ListQuery picks a page of records, fields left empty match every record
+type ListQuery struct {
+	Equal       bson.M   // the field at each path has the value, nil matches a missing field
+	NotEqual    bson.M   // the field at each path doesn't have the value
+	Prefixes    []Prefix // every prefix starts one of its fields
+	Keys        []string // only these records, when not nil
+	ExcludeKeys []string // leave out these records
+	Sort        string   // path of the field to sort by, by the key alone when empty
+	Descending  bool
+	After       string // cursor of the page before, the first page when empty
+	Limit       int    // records in a page, every record when 0
+}
+
+// Prefix matches when one of the fields starts with Text, in any case
+type Prefix struct {
+	Fields []string
+	Text   string
+}
+
+type listCursor struct {
+	Value interface{} `bson:"v"`
+	Key   interface{} `bson:"k"`
+}
+
+// encodeCursor returns the cursor of a page ending with a record with these sort value and key
+func encodeCursor(value interface{}, key interface{}) (string, error) {
+	data, err := bson.Marshal(listCursor{value, key})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeCursor(cursor string) (listCursor, error) {
+	var decoded listCursor
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return decoded, ErrInvalidCursor
+	}
+	if err := bson.Unmarshal(data, &decoded); err != nil {
+		return decoded, ErrInvalidCursor
+	}
+	return decoded, nil
+}
+
+// sortRank is the place of a value's type in the order MongoDB sorts mixed types in
+func sortRank(value interface{}) int {
+	switch value.(type) {
+	case nil, primitive.Null:
+		return 0
+	case int32, int64, float64:
+		return 1
+	case string:
+		return 2
+	case bson.M, bson.D:
+		return 3
+	case bson.A:
+		return 4
+	case primitive.ObjectID:
+		return 5
+	case bool:
+		return 6
+	case primitive.DateTime:
+		return 7
+	}
+	return 8
+}
+
+func toFloat(value interface{}) float64 {
+	switch number := value.(type) {
+	case int32:
+		return float64(number)
+	case int64:
+		return float64(number)
+	case float64:
+		return number
+	}
+	return 0
+}
+
+// compareValues orders two stored values the way MongoDB sorts them, numbers of any type together
+func compareValues(a interface{}, b interface{}) int {
+	rankA, rankB := sortRank(a), sortRank(b)
+	switch {
+	case rankA < rankB:
+		return -1
+	case rankA > rankB:
+		return 1
+	}
+
+	switch a := a.(type) {
+	case int32, int64, float64:
+		x, y := toFloat(a), toFloat(b)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	case string:
+		return strings.Compare(a, b.(string))
+	case primitive.ObjectID:
+		return strings.Compare(a.Hex(), b.(primitive.ObjectID).Hex())
+	case bool:
+		switch {
+		case a == b.(bool):
+			return 0
+		case !a:
+			return -1
+		}
+		return 1
+	case primitive.DateTime:
+		switch other := b.(primitive.DateTime); {
+		case a < other:
+			return -1
+		case a > other:
+			return 1
+		}
+		return 0
+	case nil, primitive.Null:
+		return 0
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+// documentKey is the key of a document, object ids as hex
+func documentKey(doc bson.M, keyField string) string {
+	value, _ := lookup(doc, keyField)
+	if id, ok := value.(primitive.ObjectID); ok {
+		return id.Hex()
+	}
+	key, _ := value.(string)
+	return key
+}
+
+// picks reports whether a stored document is picked by the filters of the query
+func (q ListQuery) picks(doc bson.M, keyField string) (bool, error) {
+	for path, want := range q.Equal {
+		want, err := normalize(want)
+		if err != nil {
+			return false, err
+		}
+		if value, _ := lookup(doc, path); compareValues(value, want) != 0 {
+			return false, nil
+		}
+	}
+	for path, unwanted := range q.NotEqual {
+		unwanted, err := normalize(unwanted)
+		if err != nil {
+			return false, err
+		}
+		if value, _ := lookup(doc, path); compareValues(value, unwanted) == 0 {
+			return false, nil
+		}
+	}
+
+	for _, prefix := range q.Prefixes {
+		found := false
+		for _, path := range prefix.Fields {
+			value, _ := lookup(doc, path)
+			text, _ := value.(string)
+			if strings.HasPrefix(strings.ToLower(text), strings.ToLower(prefix.Text)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false, nil
+		}
+	}
+
+	key := documentKey(doc, keyField)
+	if q.Keys != nil && !contains(q.Keys, key) {
+		return false, nil
+	}
+	return !contains(q.ExcludeKeys, key), nil
+}
+
+// listDocuments returns the page of documents picked by the query and the cursor of the page after it,
+// empty when it is the last
+func listDocuments(ctx context.Context, s documentStore, query ListQuery) ([]bson.M, string, error) {
+	keyField := s.keyPath()
+	picked := []bson.M{}
+	err := s.each(ctx, func(doc bson.M) error {
+		ok, err := query.picks(doc, keyField)
+		if ok {
+			picked = append(picked, doc)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	// order compares two documents by the sort field then the key, reversed when descending
+	order := func(value interface{}, key interface{}, doc bson.M) int {
+		sortValue, _ := lookup(doc, query.Sort)
+		docKey, _ := lookup(doc, keyField)
+		result := 0
+		if query.Sort != "" {
+			result = compareValues(value, sortValue)
+		}
+		if result == 0 {
+			result = compareValues(key, docKey)
+		}
+		if query.Descending {
+			return -result
+		}
+		return result
+	}
+	sort.SliceStable(picked, func(i, j int) bool {
+		value, _ := lookup(picked[i], query.Sort)
+		key, _ := lookup(picked[i], keyField)
+		return order(value, key, picked[j]) < 0
+	})
+
+	if query.After != "" {
+		after, err := decodeCursor(query.After)
+		if err != nil {
+			return nil, "", err
+		}
+		start := sort.Search(len(picked), func(i int) bool {
+			return order(after.Value, after.Key, picked[i]) < 0
+		})
+		picked = picked[start:]
+	}
+
+	if query.Limit <= 0 || len(picked) <= query.Limit {
+		return picked, "", nil
+	}
+	page := picked[:query.Limit]
+	last := page[len(page)-1]
+	value, _ := lookup(last, query.Sort)
+	key, _ := lookup(last, keyField)
+	next, err := encodeCursor(value, key)
+	return page, next, err
+}
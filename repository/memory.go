@@ -0,0 +1,359 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// memoryStore keeps records in memory as the documents MongoDB would store, in the order they were inserted
+type memoryStore struct {
+	name     string
+	keyField string
+	unique   []string // fields no two records can share a value of, empty values aside
+
+	lock  sync.Mutex
+	docs  map[string]bson.M
+	order []string
+}
+
+func newMemoryStore(name string, keyField string, unique ...string) *memoryStore {
+	return &memoryStore{name: name, keyField: keyField, unique: unique, docs: map[string]bson.M{}}
+}
+
+func (s *memoryStore) Name() string {
+	return s.name
+}
+
+func (s *memoryStore) key(doc bson.M) string {
+	return documentKey(doc, s.keyField)
+}
+
+func (s *memoryStore) keyPath() string {
+	return s.keyField
+}
+
+func (s *memoryStore) insert(ctx context.Context, record interface{}) error {
+	doc, err := toDocument(record)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	key := s.key(doc)
+	if _, exists := s.docs[key]; exists || s.conflicts(key, doc) {
+		return ErrDuplicateKey
+	}
+	s.docs[key] = doc
+	s.order = append(s.order, key)
+	return nil
+}
+
+// conflicts reports whether a record other than the one with key shares a unique value with doc
+func (s *memoryStore) conflicts(key string, doc bson.M) bool {
+	for _, field := range s.unique {
+		value, _ := lookup(doc, field)
+		if value == nil || value == "" {
+			continue
+		}
+		for other, otherDoc := range s.docs {
+			if found, _ := lookup(otherDoc, field); other != key && found == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *memoryStore) get(ctx context.Context, key string, record interface{}) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	doc, ok := s.docs[key]
+	if !ok {
+		return ErrNotFound
+	}
+	return fromDocument(doc, record)
+}
+
+// findOne decodes the first record where field has value
+func (s *memoryStore) findOne(ctx context.Context, field string, value string, record interface{}) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, key := range s.order {
+		if found, _ := lookup(s.docs[key], field); found == value {
+			return fromDocument(s.docs[key], record)
+		}
+	}
+	return ErrNotFound
+}
+
+// each calls fn with every record in the order they were inserted
+func (s *memoryStore) each(ctx context.Context, fn func(doc bson.M) error) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, key := range s.order {
+		if err := fn(s.docs[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) Document(ctx context.Context, key string) (bson.M, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	doc, ok := s.docs[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return toDocument(doc)
+}
+
+func (s *memoryStore) Update(ctx context.Context, key string, update bson.M) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	doc, ok := s.docs[key]
+	if !ok {
+		return ErrNotFound
+	}
+
+	// Work on a copy so a failed update leaves the record as it was
+	updated, err := toDocument(doc)
+	if err != nil {
+		return err
+	}
+	if err := applyUpdate(updated, update); err != nil {
+		return err
+	}
+	if s.conflicts(key, updated) {
+		return ErrDuplicateKey
+	}
+	s.docs[key] = updated
+	return nil
+}
+
+func (s *memoryStore) Delete(ctx context.Context, key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.docs[key]; !ok {
+		return ErrNotFound
+	}
+	delete(s.docs, key)
+	for i, k := range s.order {
+		if k == key {
+			s.order = append(s.order[:i:i], s.order[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) Restore(ctx context.Context, key string, doc bson.M) error {
+	restored, err := toDocument(doc)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.key(restored) != key {
+		return fmt.Errorf("cannot change %s, the record is found by it", s.keyField)
+	}
+	if s.conflicts(key, restored) {
+		return ErrDuplicateKey
+	}
+	if _, ok := s.docs[key]; !ok {
+		s.order = append(s.order, key)
+	}
+	s.docs[key] = restored
+	return nil
+}
+
+func (s *memoryStore) insertMany(ctx context.Context, records []interface{}) error {
+	docs := make([]bson.M, len(records))
+	for i, record := range records {
+		doc, err := toDocument(record)
+		if err != nil {
+			return err
+		}
+		docs[i] = doc
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// Each record is checked against the ones before it in the batch too, if any clashes none are kept
+	order := len(s.order)
+	for _, doc := range docs {
+		key := s.key(doc)
+		if _, exists := s.docs[key]; exists || s.conflicts(key, doc) {
+			for _, added := range s.order[order:] {
+				delete(s.docs, added)
+			}
+			s.order = s.order[:order]
+			return ErrDuplicateKey
+		}
+		s.docs[key] = doc
+		s.order = append(s.order, key)
+	}
+	return nil
+}
+
+func (s *memoryStore) updateFirst(ctx context.Context, match func(doc bson.M) (bool, error), update func(doc bson.M) (bson.M, error)) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, key := range s.order {
+		ok, err := match(s.docs[key])
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		// Work on a copy so a failed update leaves the record as it was
+		doc, err := toDocument(s.docs[key])
+		if err != nil {
+			return err
+		}
+		if doc, err = update(doc); err != nil {
+			return err
+		}
+		if s.key(doc) != key {
+			return fmt.Errorf("cannot change %s, the record is found by it", s.keyField)
+		}
+		if s.conflicts(key, doc) {
+			return ErrDuplicateKey
+		}
+		s.docs[key] = doc
+		return nil
+	}
+
+	doc, err := update(nil)
+	if err != nil || doc == nil {
+		return err
+	}
+	key := s.key(doc)
+	if _, exists := s.docs[key]; exists || s.conflicts(key, doc) {
+		return ErrDuplicateKey
+	}
+	s.docs[key] = doc
+	s.order = append(s.order, key)
+	return nil
+}
+
+func (s *memoryStore) deleteWhere(ctx context.Context, match func(doc bson.M) (bool, error)) (int64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	kept := []string{}
+	deleted := []string{}
+	for _, key := range s.order {
+		ok, err := match(s.docs[key])
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			deleted = append(deleted, key)
+		} else {
+			kept = append(kept, key)
+		}
+	}
+	for _, key := range deleted {
+		delete(s.docs, key)
+	}
+	s.order = kept
+	return int64(len(deleted)), nil
+}
+
+// snapshot copies every record so they can be put back by restore
+func (s *memoryStore) snapshot() (map[string]bson.M, []string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	docs := map[string]bson.M{}
+	for key, doc := range s.docs {
+		docs[key], _ = toDocument(doc)
+	}
+	return docs, append([]string{}, s.order...)
+}
+
+func (s *memoryStore) restore(docs map[string]bson.M, order []string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.docs, s.order = docs, order
+}
+
+// NewMemory returns empty repositories kept in memory, for running the API without a database
+func NewMemory() *Repositories {
+	stores := []*memoryStore{
+		newMemoryStore("students", "school.sid", "school.pen"),
+		newMemoryStore("teachers", "school.tid"),
+		newMemoryStore("admins", "aid"),
+		newMemoryStore("contacts", "_id"),
+		newMemoryStore("lockers", "_id"),
+		newMemoryStore("images", "name"),
+	}
+	records := map[string]RecordRepository{
+		"students": documentRecords{stores[0]},
+		"contacts": documentRecords{stores[3]},
+	}
+	for _, collection := range recordCollections {
+		store := newMemoryStore(collection.name, "_id", collection.unique...)
+		stores = append(stores, store)
+		records[collection.name] = documentRecords{store}
+	}
+	for _, name := range archivedCollections {
+		store := newMemoryStore(name+"_archive", "_id")
+		stores = append(stores, store)
+		records[store.name] = documentRecords{store}
+	}
+
+	// Transactions run one at a time, if one fails every store is put back as it was before it started
+	var transactions sync.Mutex
+	transaction := func(ctx context.Context, fn func(ctx context.Context) error) error {
+		transactions.Lock()
+		defer transactions.Unlock()
+
+		docs := make([]map[string]bson.M, len(stores))
+		orders := make([][]string, len(stores))
+		for i, store := range stores {
+			docs[i], orders[i] = store.snapshot()
+		}
+
+		err := fn(ctx)
+		if err != nil {
+			for i, store := range stores {
+				store.restore(docs[i], orders[i])
+			}
+		}
+		return err
+	}
+
+	repos := &Repositories{
+		Students: documentStudents{stores[0]},
+		Teachers: documentTeachers{stores[1]},
+		Admins:   documentAdmins{stores[2]},
+		Contacts: documentContacts{stores[3]},
+		Lockers:  documentLockers{stores[4]},
+		Photos:   documentPhotos{stores[5]},
+
+		transaction:   transaction,
+		transactional: func(ctx context.Context) bool { return true },
+	}
+	repos.setRecords(records)
+	return repos
+}
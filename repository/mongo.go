@@ -0,0 +1,595 @@
+package repository
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/SowinskiBraeden/school-management-api/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mongoStore is a collection whose records are found by keyField, or by _id when objectID is set
+type mongoStore struct {
+	collection *mongo.Collection
+	keyField   string
+	objectID   bool
+}
+
+func (s mongoStore) Name() string {
+	return s.collection.Name()
+}
+
+// filter matches the record with the key, ok is false if the key can't match any record
+func (s mongoStore) filter(key string) (bson.M, bool) {
+	if !s.objectID {
+		return bson.M{s.keyField: key}, true
+	}
+	id, err := primitive.ObjectIDFromHex(key)
+	if err != nil {
+		return nil, false
+	}
+	return bson.M{"_id": id}, true
+}
+
+func (s mongoStore) findOne(ctx context.Context, filter bson.M, record interface{}) error {
+	err := s.collection.FindOne(ctx, filter).Decode(record)
+	if err == mongo.ErrNoDocuments {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s mongoStore) get(ctx context.Context, key string, record interface{}) error {
+	filter, ok := s.filter(key)
+	if !ok {
+		return ErrNotFound
+	}
+	return s.findOne(ctx, filter, record)
+}
+
+func (s mongoStore) find(ctx context.Context, filter bson.M, records interface{}) error {
+	cursor, err := s.collection.Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	return cursor.All(ctx, records)
+}
+
+func (s mongoStore) insert(ctx context.Context, record interface{}) error {
+	_, err := s.collection.InsertOne(ctx, record)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicateKey
+	}
+	return err
+}
+
+func (s mongoStore) Document(ctx context.Context, key string) (bson.M, error) {
+	var doc bson.M
+	err := s.get(ctx, key, &doc)
+	return doc, err
+}
+
+func (s mongoStore) Update(ctx context.Context, key string, update bson.M) error {
+	filter, ok := s.filter(key)
+	if !ok {
+		return ErrNotFound
+	}
+	result, err := s.collection.UpdateOne(ctx, filter, update)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicateKey
+	}
+	if err == nil && result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s mongoStore) Delete(ctx context.Context, key string) error {
+	filter, ok := s.filter(key)
+	if !ok {
+		return ErrNotFound
+	}
+	result, err := s.collection.DeleteOne(ctx, filter)
+	if err == nil && result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s mongoStore) Restore(ctx context.Context, key string, doc bson.M) error {
+	filter, ok := s.filter(key)
+	if !ok {
+		return ErrNotFound
+	}
+	_, err := s.collection.ReplaceOne(ctx, filter, doc, options.Replace().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicateKey
+	}
+	return err
+}
+
+func (s mongoStore) count(ctx context.Context, filter bson.M) (int64, error) {
+	return s.collection.CountDocuments(ctx, filter)
+}
+
+// accountFilter turns an AccountFilter into a query, admins keep their account fields at the top level
+func (s mongoStore) accountFilter(f AccountFilter, accountPrefix string) bson.M {
+	filter := bson.M{}
+	switch f.State {
+	case ActiveAccounts:
+		filter["removed"] = nil
+	case RemovedAccounts:
+		filter["removed"] = bson.M{"$ne": nil}
+	}
+	if !f.PurgeDue.IsZero() {
+		filter["removed.purge_at"] = bson.M{"$lte": f.PurgeDue}
+	}
+	if !f.LockedSince.IsZero() {
+		filter[accountPrefix+"lockeduntil"] = bson.M{"$gte": f.LockedSince}
+	}
+	if f.Unverified {
+		filter[accountPrefix+"verifiedemail"] = bson.M{"$ne": true}
+	}
+	if f.GraduatedBy != 0 {
+		filter["school.yog"] = bson.M{"$gt": 0, "$lte": f.GraduatedBy}
+	}
+	if f.Contacts != nil {
+		filter["personal.contacts"] = bson.M{"$in": f.Contacts}
+	}
+	if f.Lockers != nil {
+		filter["school.locker"] = bson.M{"$in": f.Lockers}
+	} else if f.HoldsLocker {
+		filter["school.locker"] = bson.M{"$nin": bson.A{"", nil}}
+	}
+
+	keys := bson.M{}
+	if f.Keys != nil {
+		keys["$in"] = f.Keys
+	}
+	if f.ExcludeKeys != nil {
+		keys["$nin"] = f.ExcludeKeys
+	}
+	if len(keys) > 0 {
+		filter[s.keyField] = keys
+	}
+	return filter
+}
+
+// listFilter turns the filters of a ListQuery into a query
+func (s mongoStore) listFilter(q ListQuery) bson.M {
+	conditions := bson.A{}
+	for path, value := range q.Equal {
+		conditions = append(conditions, bson.M{path: value})
+	}
+	for path, value := range q.NotEqual {
+		conditions = append(conditions, bson.M{path: bson.M{"$ne": value}})
+	}
+	for _, prefix := range q.Prefixes {
+		either := bson.A{}
+		for _, path := range prefix.Fields {
+			either = append(either, bson.M{path: primitive.Regex{Pattern: "^" + regexp.QuoteMeta(prefix.Text), Options: "i"}})
+		}
+		conditions = append(conditions, bson.M{"$or": either})
+	}
+
+	keys := bson.M{}
+	if q.Keys != nil {
+		keys["$in"] = s.keyValues(q.Keys)
+	}
+	if q.ExcludeKeys != nil {
+		keys["$nin"] = s.keyValues(q.ExcludeKeys)
+	}
+	if len(keys) > 0 {
+		conditions = append(conditions, bson.M{s.keyField: keys})
+	}
+
+	if len(conditions) == 0 {
+		return bson.M{}
+	}
+	return bson.M{"$and": conditions}
+}
+
+// keyValues returns keys as they are stored, object ids that aren't valid can't match and are left out
+func (s mongoStore) keyValues(keys []string) bson.A {
+	values := bson.A{}
+	for _, key := range keys {
+		if !s.objectID {
+			values = append(values, key)
+		} else if id, err := primitive.ObjectIDFromHex(key); err == nil {
+			values = append(values, id)
+		}
+	}
+	return values
+}
+
+// list returns the page of records picked by the query, undecoded, and the cursor of the page after it
+func (s mongoStore) list(ctx context.Context, q ListQuery) ([]bson.Raw, string, error) {
+	filter := s.listFilter(q)
+	direction, after := 1, "$gt"
+	if q.Descending {
+		direction, after = -1, "$lt"
+	}
+
+	if q.After != "" {
+		cursor, err := decodeCursor(q.After)
+		if err != nil {
+			return nil, "", err
+		}
+		// Past the last record, records with the same sort value go by their key
+		page := bson.M{s.keyField: bson.M{after: cursor.Key}}
+		if q.Sort != "" {
+			page = bson.M{"$or": bson.A{
+				bson.M{q.Sort: bson.M{after: cursor.Value}},
+				bson.M{q.Sort: cursor.Value, s.keyField: bson.M{after: cursor.Key}},
+			}}
+		}
+		filter = bson.M{"$and": bson.A{filter, page}}
+	}
+
+	order := bson.D{{Key: s.keyField, Value: direction}}
+	if q.Sort != "" {
+		order = append(bson.D{{Key: q.Sort, Value: direction}}, order...)
+	}
+	opts := options.Find().SetSort(order)
+	if q.Limit > 0 {
+		// One more than the page shows whether there is a page after it
+		opts.SetLimit(int64(q.Limit) + 1)
+	}
+
+	cursor, err := s.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	records := []bson.Raw{}
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, "", err
+	}
+	if q.Limit <= 0 || len(records) <= q.Limit {
+		return records, "", nil
+	}
+
+	records = records[:q.Limit]
+	last := records[len(records)-1]
+	var value interface{}
+	if q.Sort != "" {
+		if found, err := last.LookupErr(strings.Split(q.Sort, ".")...); err == nil {
+			value = found
+		}
+	}
+	next, err := encodeCursor(value, last.Lookup(strings.Split(s.keyField, ".")...))
+	return records, next, err
+}
+
+type mongoStudents struct{ mongoStore }
+
+func (r mongoStudents) Get(ctx context.Context, sid string) (student models.Student, err error) {
+	err = r.get(ctx, sid, &student)
+	return student, err
+}
+
+func (r mongoStudents) FindByPEN(ctx context.Context, pen string) (student models.Student, err error) {
+	err = r.findOne(ctx, bson.M{"school.pen": pen}, &student)
+	return student, err
+}
+
+func (r mongoStudents) FindBySchoolEmail(ctx context.Context, email string) (student models.Student, err error) {
+	err = r.findOne(ctx, bson.M{"account.schoolemail": email}, &student)
+	return student, err
+}
+
+func (r mongoStudents) Find(ctx context.Context, filter AccountFilter) ([]models.Student, error) {
+	students := []models.Student{}
+	err := r.find(ctx, r.accountFilter(filter, "account."), &students)
+	return students, err
+}
+
+func (r mongoStudents) Count(ctx context.Context, filter AccountFilter) (int64, error) {
+	return r.count(ctx, r.accountFilter(filter, "account."))
+}
+
+func (r mongoStudents) List(ctx context.Context, query ListQuery) ([]models.Student, string, error) {
+	records, next, err := r.list(ctx, query)
+	if err != nil {
+		return nil, "", err
+	}
+	students := make([]models.Student, len(records))
+	for i, record := range records {
+		if err := bson.Unmarshal(record, &students[i]); err != nil {
+			return nil, "", err
+		}
+	}
+	return students, next, nil
+}
+
+func (r mongoStudents) Insert(ctx context.Context, student models.Student) error {
+	return r.insert(ctx, student)
+}
+
+type mongoTeachers struct{ mongoStore }
+
+func (r mongoTeachers) Get(ctx context.Context, tid string) (teacher models.Teacher, err error) {
+	err = r.get(ctx, tid, &teacher)
+	return teacher, err
+}
+
+func (r mongoTeachers) FindBySchoolEmail(ctx context.Context, email string) (teacher models.Teacher, err error) {
+	err = r.findOne(ctx, bson.M{"account.schoolemail": email}, &teacher)
+	return teacher, err
+}
+
+func (r mongoTeachers) Find(ctx context.Context, filter AccountFilter) ([]models.Teacher, error) {
+	teachers := []models.Teacher{}
+	err := r.find(ctx, r.accountFilter(filter, "account."), &teachers)
+	return teachers, err
+}
+
+func (r mongoTeachers) Count(ctx context.Context, filter AccountFilter) (int64, error) {
+	return r.count(ctx, r.accountFilter(filter, "account."))
+}
+
+func (r mongoTeachers) List(ctx context.Context, query ListQuery) ([]models.Teacher, string, error) {
+	records, next, err := r.list(ctx, query)
+	if err != nil {
+		return nil, "", err
+	}
+	teachers := make([]models.Teacher, len(records))
+	for i, record := range records {
+		if err := bson.Unmarshal(record, &teachers[i]); err != nil {
+			return nil, "", err
+		}
+	}
+	return teachers, next, nil
+}
+
+func (r mongoTeachers) Insert(ctx context.Context, teacher models.Teacher) error {
+	return r.insert(ctx, teacher)
+}
+
+type mongoAdmins struct{ mongoStore }
+
+func (r mongoAdmins) Get(ctx context.Context, aid string) (admin models.Admin, err error) {
+	err = r.get(ctx, aid, &admin)
+	return admin, err
+}
+
+func (r mongoAdmins) FindBySchoolEmail(ctx context.Context, email string) (admin models.Admin, err error) {
+	err = r.findOne(ctx, bson.M{"schoolemail": email}, &admin)
+	return admin, err
+}
+
+func (r mongoAdmins) Find(ctx context.Context, filter AccountFilter) ([]models.Admin, error) {
+	admins := []models.Admin{}
+	err := r.find(ctx, r.accountFilter(filter, ""), &admins)
+	return admins, err
+}
+
+func (r mongoAdmins) Count(ctx context.Context, filter AccountFilter) (int64, error) {
+	return r.count(ctx, r.accountFilter(filter, ""))
+}
+
+func (r mongoAdmins) List(ctx context.Context, query ListQuery) ([]models.Admin, string, error) {
+	records, next, err := r.list(ctx, query)
+	if err != nil {
+		return nil, "", err
+	}
+	admins := make([]models.Admin, len(records))
+	for i, record := range records {
+		if err := bson.Unmarshal(record, &admins[i]); err != nil {
+			return nil, "", err
+		}
+	}
+	return admins, next, nil
+}
+
+func (r mongoAdmins) Insert(ctx context.Context, admin models.Admin) error {
+	return r.insert(ctx, admin)
+}
+
+type mongoContacts struct{ mongoStore }
+
+func (r mongoContacts) Get(ctx context.Context, id string) (contact models.Contact, err error) {
+	err = r.get(ctx, id, &contact)
+	return contact, err
+}
+
+func (r mongoContacts) List(ctx context.Context, query ListQuery) ([]models.Contact, string, error) {
+	records, next, err := r.list(ctx, query)
+	if err != nil {
+		return nil, "", err
+	}
+	contacts := make([]models.Contact, len(records))
+	for i, record := range records {
+		if err := bson.Unmarshal(record, &contacts[i]); err != nil {
+			return nil, "", err
+		}
+	}
+	return contacts, next, nil
+}
+
+func (r mongoContacts) Insert(ctx context.Context, contact models.Contact) error {
+	return r.insert(ctx, contact)
+}
+
+type mongoLockers struct{ mongoStore }
+
+func (r mongoLockers) Get(ctx context.Context, id string) (locker models.Locker, err error) {
+	err = r.get(ctx, id, &locker)
+	return locker, err
+}
+
+func (r mongoLockers) FindByNumber(ctx context.Context, number string) (locker models.Locker, err error) {
+	err = r.findOne(ctx, bson.M{"lockernumber": number}, &locker)
+	return locker, err
+}
+
+func (r mongoLockers) List(ctx context.Context, query ListQuery) ([]models.Locker, string, error) {
+	records, next, err := r.list(ctx, query)
+	if err != nil {
+		return nil, "", err
+	}
+	lockers := make([]models.Locker, len(records))
+	for i, record := range records {
+		if err := bson.Unmarshal(record, &lockers[i]); err != nil {
+			return nil, "", err
+		}
+	}
+	return lockers, next, nil
+}
+
+func (r mongoLockers) Insert(ctx context.Context, locker models.Locker) error {
+	return r.insert(ctx, locker)
+}
+
+type mongoPhotos struct{ mongoStore }
+
+func (r mongoPhotos) Get(ctx context.Context, name string) (photo models.Photo, err error) {
+	err = r.get(ctx, name, &photo)
+	return photo, err
+}
+
+func (r mongoPhotos) Insert(ctx context.Context, photo models.Photo) error {
+	return r.insert(ctx, photo)
+}
+
+// mongoRecords are records kept in a collection, MongoDB runs the filters itself
+type mongoRecords struct{ collection *mongo.Collection }
+
+func (r mongoRecords) Name() string {
+	return r.collection.Name()
+}
+
+func (r mongoRecords) Insert(ctx context.Context, record interface{}) error {
+	_, err := r.collection.InsertOne(ctx, record)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicateKey
+	}
+	return err
+}
+
+func (r mongoRecords) InsertMany(ctx context.Context, records []interface{}) error {
+	_, err := r.collection.InsertMany(ctx, records)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicateKey
+	}
+	return err
+}
+
+func (r mongoRecords) FindOne(ctx context.Context, filter bson.M, sort bson.D, record interface{}) error {
+	opts := options.FindOne()
+	if sort != nil {
+		opts.SetSort(sort)
+	}
+	err := r.collection.FindOne(ctx, filter, opts).Decode(record)
+	if err == mongo.ErrNoDocuments {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (r mongoRecords) Find(ctx context.Context, filter bson.M, sort bson.D, limit int64, records interface{}) error {
+	opts := options.Find()
+	if sort != nil {
+		opts.SetSort(sort)
+	}
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+	return cursor.All(ctx, records)
+}
+
+func (r mongoRecords) Count(ctx context.Context, filter bson.M) (int64, error) {
+	return r.collection.CountDocuments(ctx, filter)
+}
+
+func (r mongoRecords) UpdateOne(ctx context.Context, filter bson.M, update bson.M, upsert bool) (int64, error) {
+	result, err := r.collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(upsert))
+	if mongo.IsDuplicateKeyError(err) {
+		return 0, ErrDuplicateKey
+	}
+	if err != nil {
+		return 0, err
+	}
+	return result.MatchedCount + result.UpsertedCount, nil
+}
+
+func (r mongoRecords) DeleteMany(ctx context.Context, filter bson.M) (int64, error) {
+	result, err := r.collection.DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+// mongoTransactions asks the server whether it has transactions, and remembers once it has an answer
+type mongoTransactions struct {
+	db        *mongo.Database
+	lock      sync.Mutex
+	known     bool
+	supported bool
+}
+
+func (t *mongoTransactions) check(ctx context.Context) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.known {
+		return t.supported
+	}
+
+	// Only replica set members and mongos routers have transactions
+	var reply struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	if err := t.db.RunCommand(ctx, bson.D{{Key: "isMaster", Value: 1}}).Decode(&reply); err != nil {
+		return false
+	}
+	t.known = true
+	t.supported = reply.SetName != "" || reply.Msg == "isdbgrid"
+	return t.supported
+}
+
+// NewMongo returns repositories kept in the collections of db
+func NewMongo(db *mongo.Database) *Repositories {
+	transactions := &mongoTransactions{db: db}
+	records := map[string]RecordRepository{
+		"students": mongoRecords{db.Collection("students")},
+		"contacts": mongoRecords{db.Collection("contacts")},
+	}
+	for _, collection := range recordCollections {
+		records[collection.name] = mongoRecords{db.Collection(collection.name)}
+	}
+	for _, name := range archivedCollections {
+		records[name+"_archive"] = mongoRecords{db.Collection(name + "_archive")}
+	}
+
+	repos := &Repositories{
+		Students: mongoStudents{mongoStore{db.Collection("students"), "school.sid", false}},
+		Teachers: mongoTeachers{mongoStore{db.Collection("teachers"), "school.tid", false}},
+		Admins:   mongoAdmins{mongoStore{db.Collection("admins"), "aid", false}},
+		Contacts: mongoContacts{mongoStore{db.Collection("contacts"), "_id", true}},
+		Lockers:  mongoLockers{mongoStore{db.Collection("lockers"), "_id", true}},
+		Photos:   mongoPhotos{mongoStore{db.Collection("images"), "name", false}},
+
+		transaction: func(ctx context.Context, fn func(ctx context.Context) error) error {
+			return db.Client().UseSession(ctx, func(session mongo.SessionContext) error {
+				_, err := session.WithTransaction(session, func(session mongo.SessionContext) (interface{}, error) {
+					return nil, fn(session)
+				})
+				return err
+			})
+		},
+		transactional: transactions.check,
+	}
+	repos.setRecords(records)
+	return repos
+}
@@ -0,0 +1,467 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+	Besides the accounts and what hangs off them, the system
+	keeps its own records: the login attempts, audit and access
+	logs, API keys, policies, reports of its jobs and the like.
+	They have no key of their own and are picked by filters
+	written as in MongoDB, so each backend that isn't MongoDB
+	matches them itself. Only equality and the $eq, $ne, $gt,
+	$gte, $lt, $lte, $in, $nin, $exists and $type operators are
+	supported, combined with $and, $or and $nor.
+
+	Records are kept in the collection they always were, and
+	every collection the retention job can archive has an
+	<collection>_archive collection beside it.
+*/
+
+// RecordRepository keeps one collection of records, picked by filters written as in MongoDB
+type RecordRepository interface {
+	// Name of the collection, as it appears in the audit log
+	Name() string
+	Insert(ctx context.Context, record interface{}) error
+	InsertMany(ctx context.Context, records []interface{}) error
+	// FindOne decodes the first record the filter picks in the order of sort, in the order they were inserted when nil
+	FindOne(ctx context.Context, filter bson.M, sort bson.D, record interface{}) error
+	// Find decodes the records the filter picks into the slice records points to, every record when limit is 0
+	Find(ctx context.Context, filter bson.M, sort bson.D, limit int64, records interface{}) error
+	Count(ctx context.Context, filter bson.M) (int64, error)
+	// UpdateOne applies an update to the first record the filter picks and returns how many it matched,
+	// with upsert a record is inserted when none is, from the fields the filter is equal to and the $setOnInsert ones
+	UpdateOne(ctx context.Context, filter bson.M, update bson.M, upsert bool) (int64, error)
+	DeleteMany(ctx context.Context, filter bson.M) (int64, error)
+}
+
+// recordCollection is a collection of records and the fields no two of its records can share a value of
+type recordCollection struct {
+	name   string
+	unique []string
+}
+
+// recordCollections are every collection of records, they are created on SQLite by its migrations
+var recordCollections = []recordCollection{
+	{"loginattempts", nil},
+	{"auditlog", []string{"seq"}},
+	{"accesslog", nil},
+	{"accessalerts", nil},
+	{"apikeys", nil},
+	{"verifications", nil},
+	{"oidcstates", nil},
+	{"impersonations", nil},
+	{"exports", nil},
+	{"legalholds", nil},
+	{"retentionrules", nil},
+	{"retentionruns", nil},
+	{"passwordpolicies", nil},
+	{"directorysyncs", nil},
+	{"keyrotations", nil},
+	{"attendance", nil},
+	{"ids", nil},
+}
+
+// archivedCollections are the collections the retention job can archive records of
+var archivedCollections = []string{
+	"attendance", "loginattempts", "auditlog", "accesslog", "accessalerts",
+	"impersonations", "exports", "directorysyncs", "keyrotations",
+}
+
+// setRecords gives the repositories the record repositories of every collection, by name
+func (r *Repositories) setRecords(records map[string]RecordRepository) {
+	r.records = records
+	r.LoginAttempts = records["loginattempts"]
+	r.Audit = records["auditlog"]
+	r.Accesses = records["accesslog"]
+	r.AccessAlerts = records["accessalerts"]
+	r.APIKeys = records["apikeys"]
+	r.Verifications = records["verifications"]
+	r.OIDCStates = records["oidcstates"]
+	r.Impersonations = records["impersonations"]
+	r.Exports = records["exports"]
+	r.LegalHolds = records["legalholds"]
+	r.RetentionRules = records["retentionrules"]
+	r.RetentionRuns = records["retentionruns"]
+	r.PasswordPolicies = records["passwordpolicies"]
+	r.DirectorySyncs = records["directorysyncs"]
+	r.KeyRotations = records["keyrotations"]
+	r.IDs = records["ids"]
+}
+
+// Records returns the records of a collection by its name, archives and the students and contacts included,
+// or nil if there is no such collection
+func (r *Repositories) Records(name string) RecordRepository {
+	return r.records[name]
+}
+
+// errUnsupportedQuery is wrapped by the errors for filters only MongoDB can run
+var errUnsupportedQuery = errors.New("the filter is not supported")
+
+// matchDocument reports whether a stored document is picked by a filter, encoded the way it would be stored
+func matchDocument(doc bson.M, filter bson.M) (bool, error) {
+	for field, condition := range filter {
+		var ok bool
+		var err error
+		switch field {
+		case "$and", "$or", "$nor":
+			ok, err = matchLogical(doc, field, condition)
+		default:
+			value, exists := lookup(doc, field)
+			ok, err = matchCondition(value, exists, condition)
+		}
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchLogical(doc bson.M, operator string, condition interface{}) (bool, error) {
+	filters, ok := condition.(bson.A)
+	if !ok {
+		return false, fmt.Errorf("%w: %s takes an array", errUnsupportedQuery, operator)
+	}
+	for _, filter := range filters {
+		filter, ok := filter.(bson.M)
+		if !ok {
+			return false, fmt.Errorf("%w: %s takes an array of filters", errUnsupportedQuery, operator)
+		}
+		matched, err := matchDocument(doc, filter)
+		if err != nil {
+			return false, err
+		}
+		switch {
+		case operator == "$and" && !matched:
+			return false, nil
+		case operator == "$or" && matched:
+			return true, nil
+		case operator == "$nor" && matched:
+			return false, nil
+		}
+	}
+	return operator != "$or", nil
+}
+
+// matchCondition matches a field's value against an equality or a document of operators
+func matchCondition(value interface{}, exists bool, condition interface{}) (bool, error) {
+	operators, ok := condition.(bson.M)
+	if !ok || !isOperators(operators) {
+		return equalValue(value, condition), nil
+	}
+
+	for operator, operand := range operators {
+		var ok bool
+		switch operator {
+		case "$eq":
+			ok = equalValue(value, operand)
+		case "$ne":
+			ok = !equalValue(value, operand)
+		case "$gt", "$gte", "$lt", "$lte":
+			ok = compareOrdered(value, exists, operator, operand)
+		case "$in", "$nin":
+			list, isList := operand.(bson.A)
+			if !isList {
+				return false, fmt.Errorf("%w: %s takes an array", errUnsupportedQuery, operator)
+			}
+			for _, item := range list {
+				if equalValue(value, item) {
+					ok = true
+					break
+				}
+			}
+			if operator == "$nin" {
+				ok = !ok
+			}
+		case "$exists":
+			want, _ := operand.(bool)
+			ok = exists == want
+		case "$type":
+			name, _ := operand.(string)
+			ok = exists && typeName(value) == name
+			if array, isArray := value.(bson.A); isArray && !ok {
+				for _, item := range array {
+					if typeName(item) == name {
+						ok = true
+						break
+					}
+				}
+			}
+		default:
+			return false, fmt.Errorf("%w: the %s query operator", errUnsupportedQuery, operator)
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// isOperators reports whether a document is a set of query operators rather than a value to equal
+func isOperators(doc bson.M) bool {
+	for key := range doc {
+		if len(key) == 0 || key[0] != '$' {
+			return false
+		}
+	}
+	return len(doc) > 0
+}
+
+// equalValue compares as MongoDB does, an array equals a value one of its items equals, a missing field equals nil
+func equalValue(value interface{}, want interface{}) bool {
+	if array, ok := value.(bson.A); ok {
+		if _, wantArray := want.(bson.A); !wantArray {
+			for _, item := range array {
+				if equalValue(item, want) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	if sortRank(value) != sortRank(want) {
+		return false
+	}
+	switch value.(type) {
+	case bson.M, bson.A, primitive.Binary:
+		return reflect.DeepEqual(value, want)
+	}
+	return compareValues(value, want) == 0
+}
+
+// compareOrdered matches $gt, $gte, $lt and $lte, which only compare values of the same type
+func compareOrdered(value interface{}, exists bool, operator string, operand interface{}) bool {
+	if array, ok := value.(bson.A); ok {
+		for _, item := range array {
+			if compareOrdered(item, true, operator, operand) {
+				return true
+			}
+		}
+		return false
+	}
+	if !exists || sortRank(value) != sortRank(operand) {
+		return false
+	}
+	result := compareValues(value, operand)
+	switch operator {
+	case "$gt":
+		return result > 0
+	case "$gte":
+		return result >= 0
+	case "$lt":
+		return result < 0
+	}
+	return result <= 0
+}
+
+// typeName is the alias $type knows a stored value's type by
+func typeName(value interface{}) string {
+	switch value.(type) {
+	case nil, primitive.Null:
+		return "null"
+	case int32, int64, float64:
+		return "number"
+	case string:
+		return "string"
+	case bson.M:
+		return "object"
+	case bson.A:
+		return "array"
+	case primitive.ObjectID:
+		return "objectId"
+	case bool:
+		return "bool"
+	case primitive.DateTime:
+		return "date"
+	case primitive.Binary:
+		return "binData"
+	}
+	return ""
+}
+
+// sortDocuments orders documents by the fields of sort, keeping the order they were in where they are equal
+func sortDocuments(docs []bson.M, order bson.D) {
+	if len(order) == 0 {
+		return
+	}
+	sort.SliceStable(docs, func(i, j int) bool {
+		for _, field := range order {
+			a, _ := lookup(docs[i], field.Key)
+			b, _ := lookup(docs[j], field.Key)
+			result := compareValues(a, b)
+			if direction, _ := normalize(field.Value); toFloat(direction) < 0 {
+				result = -result
+			}
+			if result != 0 {
+				return result < 0
+			}
+		}
+		return false
+	})
+}
+
+// decodeAll decodes documents into the slice records points to
+func decodeAll(docs []bson.M, records interface{}) error {
+	slice := reflect.ValueOf(records)
+	if slice.Kind() != reflect.Ptr || slice.Elem().Kind() != reflect.Slice {
+		return errors.New("records must be a pointer to a slice")
+	}
+	elemType := slice.Elem().Type().Elem()
+	decoded := reflect.MakeSlice(slice.Elem().Type(), 0, len(docs))
+	for _, doc := range docs {
+		elem := reflect.New(elemType)
+		if err := fromDocument(doc, elem.Interface()); err != nil {
+			return err
+		}
+		decoded = reflect.Append(decoded, elem.Elem())
+	}
+	slice.Elem().Set(decoded)
+	return nil
+}
+
+// upsertDocument is the document an upsert inserts: the fields the filter is equal to, then the update
+func upsertDocument(filter bson.M, update bson.M) (bson.M, error) {
+	doc := bson.M{}
+	for field, condition := range filter {
+		if field[0] == '$' {
+			continue
+		}
+		if operators, ok := condition.(bson.M); ok && isOperators(operators) {
+			if equal, ok := operators["$eq"]; ok {
+				setPath(doc, field, equal)
+			}
+			continue
+		}
+		if err := setPath(doc, field, condition); err != nil {
+			return nil, err
+		}
+	}
+
+	if onInsert, ok := update["$setOnInsert"]; ok {
+		if err := applyUpdate(doc, bson.M{"$set": onInsert}); err != nil {
+			return nil, err
+		}
+	}
+	if err := applyUpdate(doc, withoutSetOnInsert(update)); err != nil {
+		return nil, err
+	}
+	if _, ok := doc["_id"]; !ok {
+		doc["_id"] = primitive.NewObjectID()
+	}
+	return doc, nil
+}
+
+// withoutSetOnInsert leaves out the part of an update only made when a record is inserted, by an upsert
+func withoutSetOnInsert(update bson.M) bson.M {
+	rest := bson.M{}
+	for operator, fields := range update {
+		if operator != "$setOnInsert" {
+			rest[operator] = fields
+		}
+	}
+	return rest
+}
+
+// documentRecords are records kept in a store of documents, filters are matched one document at a time
+type documentRecords struct{ documentStore }
+
+// matching returns the documents the filter picks, sorted
+func (r documentRecords) matching(ctx context.Context, filter bson.M, order bson.D) ([]bson.M, error) {
+	encoded, err := toDocument(filter)
+	if err != nil {
+		return nil, err
+	}
+	picked := []bson.M{}
+	err = r.each(ctx, func(doc bson.M) error {
+		ok, err := matchDocument(doc, encoded)
+		if ok {
+			copied, copyErr := toDocument(doc)
+			if copyErr != nil {
+				return copyErr
+			}
+			picked = append(picked, copied)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortDocuments(picked, order)
+	return picked, nil
+}
+
+func (r documentRecords) Insert(ctx context.Context, record interface{}) error {
+	return r.insert(ctx, record)
+}
+
+func (r documentRecords) InsertMany(ctx context.Context, records []interface{}) error {
+	return r.insertMany(ctx, records)
+}
+
+func (r documentRecords) FindOne(ctx context.Context, filter bson.M, sort bson.D, record interface{}) error {
+	docs, err := r.matching(ctx, filter, sort)
+	if err != nil {
+		return err
+	}
+	if len(docs) == 0 {
+		return ErrNotFound
+	}
+	return fromDocument(docs[0], record)
+}
+
+func (r documentRecords) Find(ctx context.Context, filter bson.M, sort bson.D, limit int64, records interface{}) error {
+	docs, err := r.matching(ctx, filter, sort)
+	if err != nil {
+		return err
+	}
+	if limit > 0 && int64(len(docs)) > limit {
+		docs = docs[:limit]
+	}
+	return decodeAll(docs, records)
+}
+
+func (r documentRecords) Count(ctx context.Context, filter bson.M) (int64, error) {
+	docs, err := r.matching(ctx, filter, nil)
+	return int64(len(docs)), err
+}
+
+func (r documentRecords) UpdateOne(ctx context.Context, filter bson.M, update bson.M, upsert bool) (int64, error) {
+	encoded, err := toDocument(filter)
+	if err != nil {
+		return 0, err
+	}
+	var matched int64
+	err = r.updateFirst(ctx, func(doc bson.M) (bool, error) {
+		return matchDocument(doc, encoded)
+	}, func(doc bson.M) (bson.M, error) {
+		if doc == nil {
+			if !upsert {
+				return nil, nil
+			}
+			inserted, err := upsertDocument(filter, update)
+			matched = 1
+			return inserted, err
+		}
+		matched = 1
+		return doc, applyUpdate(doc, withoutSetOnInsert(update))
+	})
+	return matched, err
+}
+
+func (r documentRecords) DeleteMany(ctx context.Context, filter bson.M) (int64, error) {
+	encoded, err := toDocument(filter)
+	if err != nil {
+		return 0, err
+	}
+	return r.deleteWhere(ctx, func(doc bson.M) (bool, error) {
+		return matchDocument(doc, encoded)
+	})
+}
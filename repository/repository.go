@@ -0,0 +1,186 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/SowinskiBraeden/school-management-api/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+/*
+	The repositories are the only way handlers reach students,
+	teachers, admins, contacts, lockers and photos. Each one has
+	a MongoDB and a SQLite implementation, chosen by STORAGE,
+	and an in-memory one, so every endpoint can be run without
+	a database. Handlers are given the repositories through the
+	request, see controllers.Inject.
+
+	Records are found by their key: the sid, tid or aid of an
+	account, the object id (as hex) of a contact or locker and
+	the name of a photo. Keys, and the PENs of students, are
+	unique: an insert or update that would reuse one fails with
+	ErrDuplicateKey, however many are made at once. Updates are written
+	the same way as in MongoDB, but only $set, $unset, $push
+	(with $each and $slice) and $pull are supported, with dotted
+	paths to nested fields.
+
+	The logs, policies and other records kept by the system
+	have repositories of their own, see RecordRepository, kept
+	in the same database.
+*/
+
+// ErrNotFound is returned when no record has the key or matches the lookup
+var ErrNotFound = errors.New("record not found")
+
+// ErrDuplicateKey is returned when a record is written with a key, or PEN, already in use
+var ErrDuplicateKey = errors.New("a record with that key already exists")
+
+// Store is what every repository has in common
+type Store interface {
+	// Name of the collection the records are kept in, as it appears in the audit log
+	Name() string
+	// Document returns a record as it is stored, encrypted fields stay sealed
+	Document(ctx context.Context, key string) (bson.M, error)
+	// Update applies an update document to a record
+	Update(ctx context.Context, key string, update bson.M) error
+	Delete(ctx context.Context, key string) error
+	// Restore puts a record back as Document returned it, in place of the one with the key or as a new one if it was deleted
+	Restore(ctx context.Context, key string, doc bson.M) error
+}
+
+// AccountState picks accounts by whether they are soft deleted
+type AccountState int
+
+const (
+	ActiveAccounts AccountState = iota
+	RemovedAccounts
+	AllAccounts
+)
+
+// AccountFilter narrows a list of students, teachers or admins, fields left empty match every account
+type AccountFilter struct {
+	State       AccountState
+	PurgeDue    time.Time // only removed accounts due to be purged by this time
+	LockedSince time.Time // only accounts locked out since this time
+	Unverified  bool      // only accounts whose personal email isn't verified
+	GraduatedBy int       // only students with a year of graduation up to this one
+	Keys        []string  // only these accounts, when not nil
+	ExcludeKeys []string  // leave out these accounts
+	Contacts    []string  // only students with one of these contacts, when not nil
+	Lockers     []string  // only students holding one of these lockers, when not nil
+	HoldsLocker bool      // only students holding a locker
+}
+
+type StudentRepository interface {
+	Store
+	Get(ctx context.Context, sid string) (models.Student, error)
+	FindByPEN(ctx context.Context, pen string) (models.Student, error)
+	FindBySchoolEmail(ctx context.Context, email string) (models.Student, error)
+	Find(ctx context.Context, filter AccountFilter) ([]models.Student, error)
+	Count(ctx context.Context, filter AccountFilter) (int64, error)
+	// List returns a page of students and the cursor of the next page, empty on the last page
+	List(ctx context.Context, query ListQuery) ([]models.Student, string, error)
+	Insert(ctx context.Context, student models.Student) error
+}
+
+type TeacherRepository interface {
+	Store
+	Get(ctx context.Context, tid string) (models.Teacher, error)
+	FindBySchoolEmail(ctx context.Context, email string) (models.Teacher, error)
+	Find(ctx context.Context, filter AccountFilter) ([]models.Teacher, error)
+	Count(ctx context.Context, filter AccountFilter) (int64, error)
+	// List returns a page of teachers and the cursor of the next page, empty on the last page
+	List(ctx context.Context, query ListQuery) ([]models.Teacher, string, error)
+	Insert(ctx context.Context, teacher models.Teacher) error
+}
+
+type AdminRepository interface {
+	Store
+	Get(ctx context.Context, aid string) (models.Admin, error)
+	FindBySchoolEmail(ctx context.Context, email string) (models.Admin, error)
+	Find(ctx context.Context, filter AccountFilter) ([]models.Admin, error)
+	Count(ctx context.Context, filter AccountFilter) (int64, error)
+	// List returns a page of admins and the cursor of the next page, empty on the last page
+	List(ctx context.Context, query ListQuery) ([]models.Admin, string, error)
+	Insert(ctx context.Context, admin models.Admin) error
+}
+
+type ContactRepository interface {
+	Store
+	Get(ctx context.Context, id string) (models.Contact, error)
+	List(ctx context.Context, query ListQuery) ([]models.Contact, string, error)
+	Insert(ctx context.Context, contact models.Contact) error
+}
+
+type LockerRepository interface {
+	Store
+	Get(ctx context.Context, id string) (models.Locker, error)
+	FindByNumber(ctx context.Context, number string) (models.Locker, error)
+	List(ctx context.Context, query ListQuery) ([]models.Locker, string, error)
+	Insert(ctx context.Context, locker models.Locker) error
+}
+
+type PhotoRepository interface {
+	Store
+	Get(ctx context.Context, name string) (models.Photo, error)
+	Insert(ctx context.Context, photo models.Photo) error
+}
+
+// Repositories holds one of each repository, all kept in the same database
+type Repositories struct {
+	Students StudentRepository
+	Teachers TeacherRepository
+	Admins   AdminRepository
+	Contacts ContactRepository
+	Lockers  LockerRepository
+	Photos   PhotoRepository
+
+	LoginAttempts    RecordRepository
+	Audit            RecordRepository
+	Accesses         RecordRepository
+	AccessAlerts     RecordRepository
+	APIKeys          RecordRepository
+	Verifications    RecordRepository
+	OIDCStates       RecordRepository
+	Impersonations   RecordRepository
+	Exports          RecordRepository
+	LegalHolds       RecordRepository
+	RetentionRules   RecordRepository
+	RetentionRuns    RecordRepository
+	PasswordPolicies RecordRepository
+	DirectorySyncs   RecordRepository
+	KeyRotations     RecordRepository
+	IDs              RecordRepository // every account ID ever given, see models.AllocatedID
+
+	records     map[string]RecordRepository
+	transaction func(ctx context.Context, fn func(ctx context.Context) error) error
+	// transactional reports whether the database has transactions, see Atomic
+	transactional func(ctx context.Context) bool
+}
+
+type transactionKey struct{}
+
+// Transaction runs fn so either every write it makes through the repositories is kept or none are,
+// fn must use the context it is given. A transaction started inside another is part of it
+func (r *Repositories) Transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if ctx.Value(transactionKey{}) != nil {
+		return fn(ctx)
+	}
+	return r.transaction(ctx, func(ctx context.Context) error {
+		return fn(context.WithValue(ctx, transactionKey{}, true))
+	})
+}
+
+// Account returns the store for a type of user (1: student, 2: teacher, 3: admin)
+func (r *Repositories) Account(userType int) Store {
+	switch userType {
+	case 3:
+		return r.Admins
+	case 2:
+		return r.Teachers
+	}
+	return r.Students
+}
@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"context"
+	"log"
+)
+
+/*
+	Writes that only make sense together, an account and its
+	photo or a contact and the student it belongs to, are made
+	as steps. Where the database has transactions the steps are
+	made in one, so either all of them are kept or none are.
+	MongoDB only has transactions on a replica set or sharded
+	cluster, on a standalone server the steps run as a saga:
+	one after another, and when one fails the steps already
+	made are undone, latest first.
+*/
+
+// Step is one write made by Atomic, Undo reverses it and can be nil for a write that needs no undoing
+type Step struct {
+	Do   func(ctx context.Context) error
+	Undo func(ctx context.Context) error
+}
+
+// Atomic makes every step, or if one fails, leaves the records as they were and returns its error
+func (r *Repositories) Atomic(ctx context.Context, steps ...Step) error {
+	if r.transactional(ctx) {
+		return r.Transaction(ctx, func(ctx context.Context) error {
+			for _, step := range steps {
+				if err := step.Do(ctx); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+
+	for i, step := range steps {
+		err := step.Do(ctx)
+		if err == nil {
+			continue
+		}
+		for j := i - 1; j >= 0; j-- {
+			if steps[j].Undo == nil {
+				continue
+			}
+			if undoErr := steps[j].Undo(ctx); undoErr != nil {
+				log.Printf("a step could not be undone after another failed: %v", undoErr)
+			}
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,496 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+/*
+	The SQLite store keeps each record as the BSON document
+	MongoDB would store, in a table named after the collection.
+	Fields that records are looked up by are copied into their
+	own indexed columns when the record is written. The tables
+	are created and changed by the migrations below, which run
+	in order when the database is opened and are recorded in
+	schema_migrations.
+*/
+
+// sqliteMigrations change the schema, a migration must never be edited once released, add a new one instead
+var sqliteMigrations = []string{
+	// 1: a table for each collection
+	`CREATE TABLE students (id TEXT PRIMARY KEY, pen TEXT, schoolemail TEXT, document BLOB NOT NULL);
+	CREATE INDEX students_pen ON students (pen);
+	CREATE INDEX students_schoolemail ON students (schoolemail);
+	CREATE TABLE teachers (id TEXT PRIMARY KEY, schoolemail TEXT, document BLOB NOT NULL);
+	CREATE INDEX teachers_schoolemail ON teachers (schoolemail);
+	CREATE TABLE admins (id TEXT PRIMARY KEY, schoolemail TEXT, document BLOB NOT NULL);
+	CREATE INDEX admins_schoolemail ON admins (schoolemail);
+	CREATE TABLE contacts (id TEXT PRIMARY KEY, document BLOB NOT NULL);
+	CREATE TABLE lockers (id TEXT PRIMARY KEY, lockernumber TEXT, document BLOB NOT NULL);
+	CREATE INDEX lockers_lockernumber ON lockers (lockernumber);
+	CREATE TABLE images (id TEXT PRIMARY KEY, document BLOB NOT NULL);
+	CREATE TABLE cids (id TEXT PRIMARY KEY, document BLOB NOT NULL);`,
+	// 2: IDs are kept unique by the account tables, and no two students share a PEN
+	`DROP TABLE cids;
+	DROP INDEX students_pen;
+	CREATE UNIQUE INDEX students_pen ON students (pen) WHERE pen <> '';`,
+	// 3: the records kept by the system and the archives of those retention can archive
+	`CREATE TABLE loginattempts (id TEXT PRIMARY KEY, document BLOB NOT NULL);
+	CREATE TABLE auditlog (id TEXT PRIMARY KEY, seq INTEGER, document BLOB NOT NULL);
+	CREATE UNIQUE INDEX auditlog_seq ON auditlog (seq) WHERE seq <> '';
+	CREATE TABLE accesslog (id TEXT PRIMARY KEY, document BLOB NOT NULL);
+	CREATE TABLE accessalerts (id TEXT PRIMARY KEY, document BLOB NOT NULL);
+	CREATE TABLE apikeys (id TEXT PRIMARY KEY, document BLOB NOT NULL);
+	CREATE TABLE verifications (id TEXT PRIMARY KEY, document BLOB NOT NULL);
+	CREATE TABLE oidcstates (id TEXT PRIMARY KEY, document BLOB NOT NULL);
+	CREATE TABLE impersonations (id TEXT PRIMARY KEY, document BLOB NOT NULL);
+	CREATE TABLE exports (id TEXT PRIMARY KEY, document BLOB NOT NULL);
+	CREATE TABLE legalholds (id TEXT PRIMARY KEY, document BLOB NOT NULL);
+	CREATE TABLE retentionrules (id TEXT PRIMARY KEY, document BLOB NOT NULL);
+	CREATE TABLE retentionruns (id TEXT PRIMARY KEY, document BLOB NOT NULL);
+	CREATE TABLE passwordpolicies (id TEXT PRIMARY KEY, document BLOB NOT NULL);
+	CREATE TABLE directorysyncs (id TEXT PRIMARY KEY, document BLOB NOT NULL);
+	CREATE TABLE keyrotations (id TEXT PRIMARY KEY, document BLOB NOT NULL);
+	CREATE TABLE attendance (id TEXT PRIMARY KEY, document BLOB NOT NULL);
+	CREATE TABLE attendance_archive (id TEXT PRIMARY KEY, document BLOB NOT NULL);
+	CREATE TABLE loginattempts_archive (id TEXT PRIMARY KEY, document BLOB NOT NULL);
+	CREATE TABLE auditlog_archive (id TEXT PRIMARY KEY, document BLOB NOT NULL);
+	CREATE TABLE accesslog_archive (id TEXT PRIMARY KEY, document BLOB NOT NULL);
+	CREATE TABLE accessalerts_archive (id TEXT PRIMARY KEY, document BLOB NOT NULL);
+	CREATE TABLE impersonations_archive (id TEXT PRIMARY KEY, document BLOB NOT NULL);
+	CREATE TABLE exports_archive (id TEXT PRIMARY KEY, document BLOB NOT NULL);
+	CREATE TABLE directorysyncs_archive (id TEXT PRIMARY KEY, document BLOB NOT NULL);
+	CREATE TABLE keyrotations_archive (id TEXT PRIMARY KEY, document BLOB NOT NULL);`,
+	// 4: the ledger of every account ID ever given
+	`CREATE TABLE ids (id TEXT PRIMARY KEY, document BLOB NOT NULL);`,
+}
+
+// duplicate returns ErrDuplicateKey in place of the error for a write breaking a unique constraint
+func duplicate(err error) error {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) && (sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY || sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE) {
+		return ErrDuplicateKey
+	}
+	return err
+}
+
+// migrateSQLite runs the migrations the database hasn't had yet
+func migrateSQLite(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY, applied_at TEXT NOT NULL)`)
+	if err != nil {
+		return err
+	}
+
+	var version int
+	if err := db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return err
+	}
+	if version > len(sqliteMigrations) {
+		return fmt.Errorf("the database is at migration %d, this build only knows %d", version, len(sqliteMigrations))
+	}
+
+	for i := version; i < len(sqliteMigrations); i++ {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err = tx.ExecContext(ctx, sqliteMigrations[i]); err == nil {
+			_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`, i+1, time.Now().UTC().Format(time.RFC3339))
+		}
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// querier runs statements on the database, or on the transaction in progress
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+type sqliteTxKey struct{}
+
+// sqliteStore is a table of documents, found by keyField, with columns copied from the document at their path
+type sqliteStore struct {
+	db       *sql.DB
+	table    string
+	keyField string
+	columns  map[string]string // column name by the dotted path it is copied from
+}
+
+func (s sqliteStore) Name() string {
+	return s.table
+}
+
+func (s sqliteStore) conn(ctx context.Context) querier {
+	if tx, ok := ctx.Value(sqliteTxKey{}).(*sql.Tx); ok {
+		return tx
+	}
+	return s.db
+}
+
+// inTransaction runs fn in the transaction in progress, or in a new one
+func (s sqliteStore) inTransaction(ctx context.Context, fn func(q querier) error) error {
+	if tx, ok := ctx.Value(sqliteTxKey{}).(*sql.Tx); ok {
+		return fn(tx)
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s sqliteStore) key(doc bson.M) string {
+	return documentKey(doc, s.keyField)
+}
+
+func (s sqliteStore) keyPath() string {
+	return s.keyField
+}
+
+// row returns the names and values of the columns written for a document
+func (s sqliteStore) row(doc bson.M) ([]string, []interface{}, error) {
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, nil, err
+	}
+	names := []string{"id", "document"}
+	values := []interface{}{s.key(doc), data}
+	for path, column := range s.columns {
+		// Columns hold text or whole numbers, anything else is left empty
+		value, _ := lookup(doc, path)
+		switch value.(type) {
+		case string, int32, int64:
+		default:
+			value = ""
+		}
+		names = append(names, column)
+		values = append(values, value)
+	}
+	return names, values, nil
+}
+
+func (s sqliteStore) insert(ctx context.Context, record interface{}) error {
+	doc, err := toDocument(record)
+	if err != nil {
+		return err
+	}
+	return s.insertRow(ctx, s.conn(ctx), doc)
+}
+
+// document reads the stored document where column has value
+func (s sqliteStore) document(ctx context.Context, q querier, column string, value string) (bson.M, error) {
+	var data []byte
+	err := q.QueryRowContext(ctx, fmt.Sprintf(`SELECT document FROM %s WHERE %s = ? ORDER BY rowid LIMIT 1`, s.table, column), value).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.M
+	err = bson.Unmarshal(data, &doc)
+	return doc, err
+}
+
+func (s sqliteStore) get(ctx context.Context, key string, record interface{}) error {
+	doc, err := s.document(ctx, s.conn(ctx), "id", key)
+	if err != nil {
+		return err
+	}
+	return fromDocument(doc, record)
+}
+
+func (s sqliteStore) findOne(ctx context.Context, field string, value string, record interface{}) error {
+	if column, ok := s.columns[field]; ok {
+		doc, err := s.document(ctx, s.conn(ctx), column, value)
+		if err != nil {
+			return err
+		}
+		return fromDocument(doc, record)
+	}
+
+	// Fields without a column are searched for one document at a time
+	errFound := errors.New("found")
+	err := s.each(ctx, func(doc bson.M) error {
+		if found, _ := lookup(doc, field); found == value {
+			if err := fromDocument(doc, record); err != nil {
+				return err
+			}
+			return errFound
+		}
+		return nil
+	})
+	switch err {
+	case errFound:
+		return nil
+	case nil:
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s sqliteStore) each(ctx context.Context, fn func(doc bson.M) error) error {
+	docs, err := s.documents(ctx, s.conn(ctx))
+	if err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		if err := fn(doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// documents reads every stored document in the order they were inserted
+func (s sqliteStore) documents(ctx context.Context, q querier) ([]bson.M, error) {
+	rows, err := q.QueryContext(ctx, fmt.Sprintf(`SELECT document FROM %s ORDER BY rowid`, s.table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Every row is read before any is used, so the caller can use the database
+	docs := []bson.M{}
+	for rows.Next() {
+		var data []byte
+		var doc bson.M
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		if err := bson.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	return docs, rows.Err()
+}
+
+// insertRow writes a new row for a document
+func (s sqliteStore) insertRow(ctx context.Context, q querier, doc bson.M) error {
+	names, values, err := s.row(doc)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf(`INSERT INTO %s (%s) VALUES (?%s)`, s.table, strings.Join(names, ", "), strings.Repeat(", ?", len(names)-1))
+	_, err = q.ExecContext(ctx, query, values...)
+	return duplicate(err)
+}
+
+// updateRow rewrites the row of the document with the key
+func (s sqliteStore) updateRow(ctx context.Context, q querier, key string, doc bson.M) error {
+	if s.key(doc) != key {
+		return fmt.Errorf("cannot change %s, the record is found by it", s.keyField)
+	}
+	names, values, err := s.row(doc)
+	if err != nil {
+		return err
+	}
+	// The key can't be changed, it is where the record is found
+	assignments := []string{}
+	for _, name := range names[1:] {
+		assignments = append(assignments, name+" = ?")
+	}
+	query := fmt.Sprintf(`UPDATE %s SET %s WHERE id = ?`, s.table, strings.Join(assignments, ", "))
+	_, err = q.ExecContext(ctx, query, append(values[1:], key)...)
+	return duplicate(err)
+}
+
+func (s sqliteStore) insertMany(ctx context.Context, records []interface{}) error {
+	return s.inTransaction(ctx, func(q querier) error {
+		for _, record := range records {
+			doc, err := toDocument(record)
+			if err != nil {
+				return err
+			}
+			if err := s.insertRow(ctx, q, doc); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s sqliteStore) updateFirst(ctx context.Context, match func(doc bson.M) (bool, error), update func(doc bson.M) (bson.M, error)) error {
+	return s.inTransaction(ctx, func(q querier) error {
+		docs, err := s.documents(ctx, q)
+		if err != nil {
+			return err
+		}
+		for _, doc := range docs {
+			ok, err := match(doc)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			key := s.key(doc)
+			if doc, err = update(doc); err != nil {
+				return err
+			}
+			return s.updateRow(ctx, q, key, doc)
+		}
+
+		doc, err := update(nil)
+		if err != nil || doc == nil {
+			return err
+		}
+		return s.insertRow(ctx, q, doc)
+	})
+}
+
+func (s sqliteStore) deleteWhere(ctx context.Context, match func(doc bson.M) (bool, error)) (int64, error) {
+	var deleted int64
+	err := s.inTransaction(ctx, func(q querier) error {
+		docs, err := s.documents(ctx, q)
+		if err != nil {
+			return err
+		}
+		for _, doc := range docs {
+			ok, err := match(doc)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			if _, err := q.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE id = ?`, s.table), s.key(doc)); err != nil {
+				return err
+			}
+			deleted++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
+
+func (s sqliteStore) Document(ctx context.Context, key string) (bson.M, error) {
+	return s.document(ctx, s.conn(ctx), "id", key)
+}
+
+func (s sqliteStore) Update(ctx context.Context, key string, update bson.M) error {
+	return s.inTransaction(ctx, func(q querier) error {
+		doc, err := s.document(ctx, q, "id", key)
+		if err != nil {
+			return err
+		}
+		if err := applyUpdate(doc, update); err != nil {
+			return err
+		}
+		return s.updateRow(ctx, q, key, doc)
+	})
+}
+
+func (s sqliteStore) Delete(ctx context.Context, key string) error {
+	result, err := s.conn(ctx).ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE id = ?`, s.table), key)
+	if err != nil {
+		return err
+	}
+	if deleted, _ := result.RowsAffected(); deleted == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s sqliteStore) Restore(ctx context.Context, key string, doc bson.M) error {
+	return s.inTransaction(ctx, func(q querier) error {
+		_, err := s.document(ctx, q, "id", key)
+		if err == ErrNotFound {
+			if s.key(doc) != key {
+				return fmt.Errorf("cannot change %s, the record is found by it", s.keyField)
+			}
+			return s.insertRow(ctx, q, doc)
+		}
+		if err != nil {
+			return err
+		}
+		return s.updateRow(ctx, q, key, doc)
+	})
+}
+
+// NewSQLite opens, or creates, the SQLite database at path and brings its schema up to date
+func NewSQLite(path string) (*Repositories, error) {
+	// Writers wait for each other instead of failing, and transactions take the write lock when they begin
+	dsn := "file:" + (&url.URL{Path: path}).EscapedPath() + "?_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)&_txlock=immediate"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := migrateSQLite(ctx, db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	students := sqliteStore{db, "students", "school.sid", map[string]string{"school.pen": "pen", "account.schoolemail": "schoolemail"}}
+	contacts := sqliteStore{db, "contacts", "_id", nil}
+	records := map[string]RecordRepository{
+		"students": documentRecords{students},
+		"contacts": documentRecords{contacts},
+	}
+	for _, collection := range recordCollections {
+		// The unique fields of records each have a column of the same name
+		columns := map[string]string{}
+		for _, field := range collection.unique {
+			columns[field] = field
+		}
+		records[collection.name] = documentRecords{sqliteStore{db, collection.name, "_id", columns}}
+	}
+	for _, name := range archivedCollections {
+		records[name+"_archive"] = documentRecords{sqliteStore{db, name + "_archive", "_id", nil}}
+	}
+
+	repos := &Repositories{
+		Students: documentStudents{students},
+		Teachers: documentTeachers{sqliteStore{db, "teachers", "school.tid", map[string]string{"account.schoolemail": "schoolemail"}}},
+		Admins:   documentAdmins{sqliteStore{db, "admins", "aid", map[string]string{"schoolemail": "schoolemail"}}},
+		Contacts: documentContacts{contacts},
+		Lockers:  documentLockers{sqliteStore{db, "lockers", "_id", map[string]string{"lockernumber": "lockernumber"}}},
+		Photos:   documentPhotos{sqliteStore{db, "images", "name", nil}},
+
+		transaction: func(ctx context.Context, fn func(ctx context.Context) error) error {
+			tx, err := db.BeginTx(ctx, nil)
+			if err != nil {
+				return err
+			}
+			if err := fn(context.WithValue(ctx, sqliteTxKey{}, tx)); err != nil {
+				tx.Rollback()
+				return err
+			}
+			return tx.Commit()
+		},
+		transactional: func(ctx context.Context) bool { return true },
+	}
+	repos.setRecords(records)
+	return repos, nil
+}
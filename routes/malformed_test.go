@@ -0,0 +1,214 @@
+package routes
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SowinskiBraeden/school-management-api/controllers"
+	"github.com/SowinskiBraeden/school-management-api/models"
+	"github.com/SowinskiBraeden/school-management-api/repository"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+	TestMalformedRequests sends every route bodies that are broken
+	in each way a client could break them: not JSON, JSON that
+	isn't an object, and objects with every field left out or
+	of the wrong type. It sends them with no session and as a
+	student, a teacher and an admin, so handlers past the login
+	check are reached too. A handler should answer all of them
+	with an error, never panic. The records are kept in memory,
+	so the test can't touch the school's database.
+*/
+
+// requestFields are the fields read from request bodies, each is sent with every type of value
+var requestFields = []string{
+	"_id", "action", "address", "age", "allowedips", "blockcommon", "city", "contactid",
+	"datatype", "days", "dob", "dryrun", "email", "expiresindays", "firstname", "gradelevel",
+	"historydepth", "homephone", "homeroom", "id", "lastname", "lockernumber", "maxagedays",
+	"middlename", "minlength", "minutes", "name", "newlockercombo", "newnumber", "newpassword1",
+	"newpassword2", "notes", "password", "password1", "password2", "pen", "postal", "prefix",
+	"priority", "province", "readonly", "reason", "relation", "requester", "requirelower",
+	"requirenumber", "requirespecial", "requireupper", "role", "scopes", "uid", "usertype",
+	"workphone", "yog",
+}
+
+// malformedBody is a body sent to every route
+type malformedBody struct {
+	name string
+	body string
+	json bool // sent with the JSON content type
+}
+
+// malformedBodies returns the bodies sent to every route, uid is a real account so lookups succeed
+func malformedBodies(uid string) []malformedBody {
+	bodies := []malformedBody{
+		// The empty body is sent without a content type, as a client sending nothing would
+		{"no body", "", false},
+		{"empty JSON", "", true},
+		{"truncated", "{", true},
+		{"null", "null", true},
+		{"array", "[]", true},
+		{"string", `"text"`, true},
+		{"number", "12", true},
+		{"empty object", "{}", true},
+	}
+	values := []struct{ name, value string }{
+		{"objects", `{"a":1}`},
+		{"strings", `"text"`},
+		{"numbers", "12"},
+		{"nulls", "null"},
+		{"booleans", "true"},
+		{"arrays", "[1]"},
+	}
+	for _, value := range values {
+		fields := []string{}
+		for _, field := range requestFields {
+			fieldValue := value.value
+			if field == "uid" && value.name == "strings" {
+				fieldValue = `"` + uid + `"`
+			}
+			fields = append(fields, fmt.Sprintf("%q:%s", field, fieldValue))
+		}
+		bodies = append(bodies, malformedBody{"fields of " + value.name, "{" + strings.Join(fields, ",") + "}", true})
+	}
+	return bodies
+}
+
+// sessionCookie returns a jwt cookie logging in as uid, the same as the login endpoints set
+func sessionCookie(t *testing.T, uid string) string {
+	claims := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{
+		Issuer:    uid,
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+	})
+	cookie, err := claims.SignedString([]byte(controllers.SecretKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cookie
+}
+
+func TestMalformedRequests(t *testing.T) {
+	// Handlers log what they can't record, which would bury the failures
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	repos := repository.NewMemory()
+
+	var student models.Student
+	student.School.SID = controllers.GenerateUID()
+	student.School.PEN = controllers.GeneratePEN()
+	var teacher models.Teacher
+	teacher.School.TID = controllers.GenerateUID()
+	var admin models.Admin
+	admin.AID = controllers.GenerateUID()
+	var contact models.Contact
+	contact.ID = primitive.NewObjectID()
+	student.Personal.Contacts = []string{contact.ID.Hex()}
+	var locker models.Locker
+	locker.ID = primitive.NewObjectID()
+	locker.LockerNumber = "B123"
+
+	if err := repos.Students.Insert(ctx, student); err != nil {
+		t.Fatal(err)
+	}
+	if err := repos.Teachers.Insert(ctx, teacher); err != nil {
+		t.Fatal(err)
+	}
+	if err := repos.Admins.Insert(ctx, admin); err != nil {
+		t.Fatal(err)
+	}
+	if err := repos.Contacts.Insert(ctx, contact); err != nil {
+		t.Fatal(err)
+	}
+	if err := repos.Lockers.Insert(ctx, locker); err != nil {
+		t.Fatal(err)
+	}
+
+	// Path parameters name the records above, so handlers get past looking them up
+	params := map[string]string{
+		":sid":    student.School.SID,
+		":tid":    teacher.School.TID,
+		":id":     contact.ID.Hex(),
+		":number": locker.LockerNumber,
+		"*":       "",
+	}
+
+	sessions := []struct {
+		name   string
+		cookie string
+	}{
+		{"no session", ""},
+		{"student", sessionCookie(t, student.School.SID)},
+		{"teacher", sessionCookie(t, teacher.School.TID)},
+		{"admin", sessionCookie(t, admin.AID)},
+	}
+
+	app := fiber.New(fiber.Config{
+		ErrorHandler: controllers.ErrorHandler,
+	})
+	// current is the test of the route being sent requests, panics are reported to it
+	current := t
+	app.Use(func(c *fiber.Ctx) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				current.Errorf("%s as %s panicked: %v", c.Get("X-Check-Body"), c.Get("X-Check-Session"), r)
+				err = c.SendStatus(fiber.StatusInternalServerError)
+			}
+		}()
+		return c.Next()
+	})
+	Register(app, repos)
+
+	routes := []*fiber.Route{}
+	for _, stack := range app.Stack() {
+		for _, route := range stack {
+			// Middleware is registered for every method under the path "/", it is reached through the routes
+			if route.Path != "/" && route.Method != fiber.MethodHead {
+				routes = append(routes, route)
+			}
+		}
+	}
+
+	bodies := malformedBodies(student.School.SID)
+	for _, route := range routes {
+		route := route
+		t.Run(route.Method+" "+route.Path, func(t *testing.T) {
+			current = t
+			path := route.Path
+			for param, value := range params {
+				path = strings.ReplaceAll(path, param, value)
+			}
+			for _, session := range sessions {
+				for _, body := range bodies {
+					req := httptest.NewRequest(route.Method, path, strings.NewReader(body.body))
+					if body.json {
+						req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+					}
+					if session.cookie != "" {
+						req.AddCookie(&http.Cookie{Name: "jwt", Value: session.cookie})
+					}
+					req.Header.Set("X-Check-Session", session.name)
+					req.Header.Set("X-Check-Body", body.name)
+					if _, err := app.Test(req, -1); err != nil {
+						t.Fatalf("%s as %s: %v", body.name, session.name, err)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,516 @@
+package routes
+
+import (
+	"mime/multipart"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SowinskiBraeden/school-management-api/controllers"
+	"github.com/SowinskiBraeden/school-management-api/repository"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+	The API describes itself with an OpenAPI 3 document, built
+	from the routes registered on the app and the operation of
+	each one in operations. An operation names the Go types its
+	handler reads and sends, and their schemas are made from the
+	json, query and validate tags on them, so the document can't
+	drift from the structs the way hand-written docs did. A route
+	with no operation, or an operation with no route, is reported
+	by OpenAPIProblems, which -check-openapi runs.
+*/
+
+// Version is the version of the API given in the OpenAPI document
+var Version = "dev"
+
+// operation documents a route, by the types its handler reads and sends
+type operation struct {
+	Summary  string
+	Auth     string        // who has to be logged in, like "admin" or "the student or an admin", empty when anyone can call it
+	Query    []interface{} // structs read from the query, by their query tags
+	Body     interface{}   // struct read from a JSON body
+	Form     interface{}   // struct read from a multipart form, by its form tags, files are *multipart.FileHeader
+	Status   int           // of a success, 200 when 0, a redirect sends no body
+	Response fiber.Map     // what a success sends besides success and message, each an example of its type
+	Content  string        // type of a success that isn't JSON
+}
+
+// failure is what ErrorHandler sends for every failure
+var failure = fiber.Map{
+	"success":    false,
+	"code":       "",
+	"message":    "",
+	"request_id": "",
+	"errors":     []controllers.FieldError{},
+	"result":     nil,
+}
+
+// pathParameters are the schemas of the path parameters, any other is a string
+var pathParameters = map[string]fiber.Map{
+	"sid": {"type": "string", "pattern": "^[0-9]{6,7}$"},
+	"tid": {"type": "string", "pattern": "^[0-9]{6,7}$"},
+	"id":  {"type": "string", "pattern": "^[0-9a-fA-F]{24}$"},
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	objectIDType = reflect.TypeOf(primitive.ObjectID{})
+	fileType     = reflect.TypeOf(multipart.FileHeader{})
+)
+
+// schemas makes the schemas of Go types, each named struct is kept once in components and referred to
+type schemas struct {
+	components fiber.Map
+	names      map[reflect.Type]string
+	problems   []string
+}
+
+func (s *schemas) of(t reflect.Type) fiber.Map {
+	if t == nil {
+		return fiber.Map{}
+	}
+	switch t {
+	case timeType:
+		return fiber.Map{"type": "string", "format": "date-time"}
+	case objectIDType:
+		return fiber.Map{"type": "string", "pattern": "^[0-9a-fA-F]{24}$"}
+	case fileType:
+		return fiber.Map{"type": "string", "format": "binary"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		// Request fields are pointers so zero can be told from left out, they are sent as what they point to
+		return s.of(t.Elem())
+	case reflect.Bool:
+		return fiber.Map{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return fiber.Map{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return fiber.Map{"type": "number"}
+	case reflect.String:
+		return fiber.Map{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		// encoding/json sends bytes as base64
+		if t.Elem().Kind() == reflect.Uint8 {
+			return fiber.Map{"type": "string", "format": "byte"}
+		}
+		return fiber.Map{"type": "array", "items": s.of(t.Elem())}
+	case reflect.Map:
+		return fiber.Map{"type": "object", "additionalProperties": s.of(t.Elem())}
+	case reflect.Interface:
+		return fiber.Map{}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.object(t, "json")
+		}
+		return s.ref(t)
+	}
+	s.problems = append(s.problems, "there is no schema for the type "+t.String())
+	return fiber.Map{}
+}
+
+// ref returns a reference to the schema of a named struct, adding it to components the first time
+func (s *schemas) ref(t reflect.Type) fiber.Map {
+	name, ok := s.names[t]
+	if !ok {
+		name = t.Name()
+		// Two packages can have a type of the same name, the second is named with its package
+		if _, taken := s.components[name]; taken {
+			name = path.Base(t.PkgPath()) + "." + name
+		}
+		s.names[t] = name
+		// Added before its fields, so a type that holds itself refers to it instead of recursing
+		s.components[name] = fiber.Map{}
+		s.components[name] = s.object(t, "json")
+	}
+	return fiber.Map{"$ref": "#/components/schemas/" + name}
+}
+
+// object returns the schema of a struct, its fields named by tag
+func (s *schemas) object(t reflect.Type, tag string) fiber.Map {
+	properties := fiber.Map{}
+	required := []string{}
+	for _, field := range fieldsOf(t, tag) {
+		properties[field.name] = s.field(field.StructField)
+		if field.required {
+			required = append(required, field.name)
+		}
+	}
+
+	schema := fiber.Map{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// field returns the schema of a struct field, with the limits its validate tag puts on it
+func (s *schemas) field(field reflect.StructField) fiber.Map {
+	schema := s.of(field.Type)
+	if _, ok := schema["type"]; !ok {
+		return schema
+	}
+
+	kind := field.Type.Kind()
+	if kind == reflect.Ptr {
+		kind = field.Type.Elem().Kind()
+	}
+	for _, rule := range strings.Split(field.Tag.Get("validate"), ",") {
+		name, param := rule, ""
+		if equals := strings.Index(rule, "="); equals != -1 {
+			name, param = rule[:equals], rule[equals+1:]
+		}
+
+		switch name {
+		case "dive":
+			// The rules after dive are for the items
+			return schema
+		case "min", "max", "len":
+			limit, err := strconv.ParseFloat(param, 64)
+			if err != nil {
+				continue
+			}
+			for _, bound := range []string{"min", "max"} {
+				if name != bound && name != "len" {
+					continue
+				}
+				switch kind {
+				case reflect.String:
+					schema[bound+"Length"] = int(limit)
+				case reflect.Slice, reflect.Array:
+					schema[bound+"Items"] = int(limit)
+				case reflect.Map:
+					schema[bound+"Properties"] = int(limit)
+				default:
+					schema[bound+"imum"] = limit
+				}
+			}
+		case "oneof":
+			enum := []interface{}{}
+			for _, value := range strings.Fields(param) {
+				if number, err := strconv.ParseFloat(value, 64); err == nil && kind != reflect.String {
+					enum = append(enum, number)
+				} else {
+					enum = append(enum, value)
+				}
+			}
+			schema["enum"] = enum
+		case "mailaddress":
+			schema["format"] = "email"
+		case "numeric":
+			schema["pattern"] = "^[0-9]+$"
+		case "uid":
+			schema["pattern"] = "^[0-9]{6,7}$"
+		case "pen":
+			schema["pattern"] = "^[0-9]{9}$"
+		case "objectid":
+			schema["pattern"] = "^[0-9a-fA-F]{24}$"
+		case "notblank":
+			schema["pattern"] = `\S`
+		}
+	}
+	return schema
+}
+
+// example returns the schema of an example value, maps of examples are objects with a property for each key
+func (s *schemas) example(value interface{}) fiber.Map {
+	switch value := value.(type) {
+	case fiber.Map:
+		properties := fiber.Map{}
+		for key, example := range value {
+			properties[key] = s.example(example)
+		}
+		return fiber.Map{"type": "object", "properties": properties}
+	case []fiber.Map:
+		items := fiber.Map{}
+		if len(value) > 0 {
+			items = s.example(value[0])
+		}
+		return fiber.Map{"type": "array", "items": items}
+	}
+	return s.of(reflect.TypeOf(value))
+}
+
+// sentField is a struct field as it is sent, by the name it is sent with
+type sentField struct {
+	reflect.StructField
+	name     string
+	required bool
+}
+
+// fieldsOf returns the fields of a struct named by tag, with the fields of structs embedded in it
+func fieldsOf(t reflect.Type, tag string) []sentField {
+	fields := []sentField{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get(tag), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			fields = append(fields, fieldsOf(field.Type, tag)...)
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		// A field with omitempty is only checked when it is sent, without it an empty value fails notblank and oneof
+		required, optional := false, false
+		for _, rule := range strings.Split(field.Tag.Get("validate"), ",") {
+			if rule == "dive" {
+				break
+			}
+			optional = optional || rule == "omitempty"
+			required = required || rule == "required" || rule == "notblank" || strings.HasPrefix(rule, "oneof=")
+		}
+		fields = append(fields, sentField{field, name, required && !optional})
+	}
+	return fields
+}
+
+// structType returns the struct type of a value or a pointer to one
+func structType(value interface{}) (reflect.Type, bool) {
+	t := reflect.TypeOf(value)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t, t != nil && t.Kind() == reflect.Struct
+}
+
+// openAPIPath writes a fiber path in OpenAPI's form, /students/:sid is /students/{sid}
+func openAPIPath(route *fiber.Route) string {
+	parts := strings.Split(route.Path, "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, ":") {
+			parts[i] = "{" + strings.TrimSuffix(strings.TrimPrefix(part, ":"), "?") + "}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// operationID names a route in camel case, PATCH /api/v2/students/:sid is patchApiV2StudentsSid
+func operationID(route *fiber.Route) string {
+	id := strings.ToLower(route.Method)
+	for _, word := range strings.FieldsFunc(route.Path, func(r rune) bool { return r == '/' || r == ':' || r == '.' || r == '?' }) {
+		id += strings.ToUpper(word[:1]) + word[1:]
+	}
+	return id
+}
+
+// routeTag groups a route with the others on the same record, /api/v2/students/:sid is "v2 students"
+func routeTag(route *fiber.Route) string {
+	parts := strings.Split(strings.TrimPrefix(route.Path, "/api/"), "/")
+	if len(parts) < 2 {
+		return parts[0]
+	}
+	return parts[0] + " " + parts[1]
+}
+
+// apiRoutes returns the routes registered on app, without middleware
+func apiRoutes(app *fiber.App) []*fiber.Route {
+	routes := []*fiber.Route{}
+	for _, stack := range app.Stack() {
+		for _, route := range stack {
+			// Middleware is registered for every method under the path "/", fiber adds a HEAD route for every GET
+			if strings.HasPrefix(route.Path, "/api/") && route.Method != fiber.MethodHead {
+				routes = append(routes, route)
+			}
+		}
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		return routes[i].Method < routes[j].Method
+	})
+	return routes
+}
+
+// describe returns the OpenAPI operation of a route
+func (s *schemas) describe(route *fiber.Route, op operation) fiber.Map {
+	key := route.Method + " " + route.Path
+	parameters := []fiber.Map{}
+	for _, param := range route.Params {
+		schema, ok := pathParameters[param]
+		if !ok {
+			schema = fiber.Map{"type": "string"}
+		}
+		parameters = append(parameters, fiber.Map{"name": param, "in": "path", "required": true, "schema": schema})
+	}
+	for _, query := range op.Query {
+		t, ok := structType(query)
+		if !ok {
+			s.problems = append(s.problems, key+": the query isn't a struct")
+			continue
+		}
+		for _, field := range fieldsOf(t, "query") {
+			parameters = append(parameters, fiber.Map{"name": field.name, "in": "query", "required": field.required, "schema": s.field(field.StructField)})
+		}
+	}
+
+	description := "Anyone can call this route."
+	if op.Auth != "" {
+		description = "Requires the session cookie of: " + op.Auth + "."
+	}
+	security := []fiber.Map{}
+	if op.Auth != "" {
+		security = append(security, fiber.Map{"session": []string{}})
+	}
+	if scope, ok := controllers.APIKeyRoutes[key]; ok {
+		description += " Accepts an API key with the " + scope + " scope in place of an admin session."
+		security = append(security, fiber.Map{"apiKey": []string{}})
+	}
+
+	described := fiber.Map{
+		"operationId": operationID(route),
+		"summary":     op.Summary,
+		"description": description,
+		"tags":        []string{routeTag(route)},
+		"parameters":  parameters,
+		"security":    security,
+	}
+
+	switch {
+	case op.Body != nil:
+		t, ok := structType(op.Body)
+		if !ok {
+			s.problems = append(s.problems, key+": the body isn't a struct")
+			break
+		}
+		described["requestBody"] = fiber.Map{
+			"required": true,
+			"content":  fiber.Map{fiber.MIMEApplicationJSON: fiber.Map{"schema": s.of(t)}},
+		}
+	case op.Form != nil:
+		t, ok := structType(op.Form)
+		if !ok {
+			s.problems = append(s.problems, key+": the form isn't a struct")
+			break
+		}
+		described["requestBody"] = fiber.Map{
+			"required": true,
+			"content":  fiber.Map{fiber.MIMEMultipartForm: fiber.Map{"schema": s.object(t, "form")}},
+		}
+	}
+
+	status := op.Status
+	if status == 0 {
+		status = fiber.StatusOK
+	}
+	success := fiber.Map{"description": op.Summary}
+	switch {
+	case status >= 300 && status < 400:
+		success["headers"] = fiber.Map{"Location": fiber.Map{"schema": fiber.Map{"type": "string"}}}
+	case op.Content != "":
+		schema := fiber.Map{"type": "string"}
+		if op.Content == fiber.MIMEApplicationJSON {
+			schema = fiber.Map{"type": "object"}
+		}
+		success["content"] = fiber.Map{op.Content: fiber.Map{"schema": schema}}
+	case op.Response != nil:
+		envelope := fiber.Map{"success": true, "message": ""}
+		for field, example := range op.Response {
+			envelope[field] = example
+		}
+		schema := s.example(envelope)
+		schema["required"] = []string{"success"}
+		success["content"] = fiber.Map{fiber.MIMEApplicationJSON: fiber.Map{"schema": schema}}
+	default:
+		s.problems = append(s.problems, key+" has no documented response")
+	}
+
+	described["responses"] = fiber.Map{
+		strconv.Itoa(status): success,
+		"default": fiber.Map{
+			"description": "The failure, with a code that names it",
+			"content":     fiber.Map{fiber.MIMEApplicationJSON: fiber.Map{"schema": fiber.Map{"$ref": "#/components/schemas/Error"}}},
+		},
+	}
+	return described
+}
+
+// Document returns the OpenAPI document of the routes registered on app, and each route or operation that doesn't match
+func Document(app *fiber.App) (fiber.Map, []string) {
+	s := &schemas{components: fiber.Map{}, names: map[reflect.Type]string{}}
+	errorSchema := s.example(failure)
+	errorSchema["required"] = []string{"success", "code", "message", "request_id"}
+	s.components["Error"] = errorSchema
+
+	paths := fiber.Map{}
+	documented := map[string]bool{}
+	for _, route := range apiRoutes(app) {
+		key := route.Method + " " + route.Path
+		op, ok := operations[key]
+		if !ok {
+			s.problems = append(s.problems, key+" has no documented schema, add it to operations")
+			continue
+		}
+		documented[key] = true
+
+		item, ok := paths[openAPIPath(route)].(fiber.Map)
+		if !ok {
+			item = fiber.Map{}
+			paths[openAPIPath(route)] = item
+		}
+		item[strings.ToLower(route.Method)] = s.describe(route, op)
+	}
+
+	for key := range operations {
+		if !documented[key] {
+			s.problems = append(s.problems, key+" is documented but isn't a registered route")
+		}
+	}
+	sort.Strings(s.problems)
+
+	return fiber.Map{
+		"openapi": "3.0.3",
+		"info": fiber.Map{
+			"title":       "School Management API",
+			"version":     Version,
+			"description": "Generated from the registered routes and the types their handlers read and send.",
+		},
+		"paths": paths,
+		"components": fiber.Map{
+			"schemas": s.components,
+			"securitySchemes": fiber.Map{
+				"session": fiber.Map{"type": "apiKey", "in": "cookie", "name": "jwt"},
+				"apiKey":  fiber.Map{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+			},
+		},
+	}, s.problems
+}
+
+// OpenAPI sends the OpenAPI document of the API
+func OpenAPI(c *fiber.Ctx) error {
+	document, _ := Document(c.App())
+	return c.Status(fiber.StatusOK).JSON(document)
+}
+
+// APIDocs sends the page that renders the OpenAPI document, it loads nothing from other sites
+func APIDocs(c *fiber.Ctx) error {
+	return c.SendFile("./templates/apiDocs.html")
+}
+
+// OpenAPIProblems registers every route and returns each one without a documented schema,
+// each operation without a route and each type that can't be described
+func OpenAPIProblems() []string {
+	app := fiber.New(fiber.Config{
+		ErrorHandler: controllers.ErrorHandler,
+	})
+	Register(app, repository.NewMemory())
+	_, problems := Document(app)
+	return problems
+}
@@ -0,0 +1,49 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/SowinskiBraeden/school-management-api/controllers"
+	"github.com/SowinskiBraeden/school-management-api/repository"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// TestOpenAPIContract fails when a route and its entry in operations no longer match
+func TestOpenAPIContract(t *testing.T) {
+	for _, problem := range OpenAPIProblems() {
+		t.Error(problem)
+	}
+}
+
+// TestOpenAPIServed checks the document the API serves is the one the contract is checked against
+func TestOpenAPIServed(t *testing.T) {
+	app := fiber.New(fiber.Config{
+		ErrorHandler: controllers.ErrorHandler,
+	})
+	Register(app, repository.NewMemory())
+
+	res, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/api/v1/openapi.json", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != fiber.StatusOK {
+		t.Fatalf("GET /api/v1/openapi.json returned %d", res.StatusCode)
+	}
+
+	var document struct {
+		OpenAPI string                            `json:"openapi"`
+		Paths   map[string]map[string]interface{} `json:"paths"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&document); err != nil {
+		t.Fatal(err)
+	}
+	if document.OpenAPI == "" {
+		t.Error("the document has no openapi version")
+	}
+	if _, ok := document.Paths["/api/v2/students/{sid}"]["patch"]; !ok {
+		t.Error("the document is missing PATCH /api/v2/students/{sid}")
+	}
+}
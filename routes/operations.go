@@ -0,0 +1,195 @@
+package routes
+
+import (
+	"mime/multipart"
+
+	"github.com/SowinskiBraeden/school-management-api/controllers"
+	"github.com/SowinskiBraeden/school-management-api/controllers/rest"
+	"github.com/SowinskiBraeden/school-management-api/controllers/update"
+	"github.com/SowinskiBraeden/school-management-api/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// The queries and forms version 1 handlers read a field at a time, as the structs they would be read into
+type (
+	uidQuery struct {
+		UID string `query:"uid" validate:"omitempty,uid"`
+	}
+	tokenQuery struct {
+		Token string `query:"token" validate:"required"`
+	}
+	oidcLoginQuery struct {
+		UserType string `query:"usertype" validate:"oneof=student teacher admin"`
+		Email    string `query:"email"` // suggested to the identity provider
+	}
+	oidcCallbackQuery struct {
+		Code  string `query:"code"`
+		State string `query:"state"`
+		Error string `query:"error"` // set by the identity provider when it refused the login
+	}
+	dateRangeQuery struct {
+		From  string `query:"from"` // YYYY-MM-DD or RFC3339
+		To    string `query:"to"`
+		Limit int    `query:"limit" validate:"omitempty,min=1,max=1000"`
+	}
+	auditQuery struct {
+		Actor  string `query:"actor"`
+		Target string `query:"target"`
+	}
+	accessesQuery struct {
+		Accessor string `query:"accessor"`
+		UID      string `query:"uid" validate:"omitempty,uid"`
+	}
+	impersonationsQuery struct {
+		AID string `query:"aid"`
+		UID string `query:"uid" validate:"omitempty,uid"`
+	}
+	exportsQuery struct {
+		Status string `query:"status" validate:"omitempty,oneof=open overdue completed"`
+		UID    string `query:"uid" validate:"omitempty,uid"`
+	}
+	exportQuery struct {
+		ID string `query:"id" validate:"required,objectid"`
+	}
+	legalHoldsQuery struct {
+		Active string `query:"active" validate:"omitempty,oneof=true false"`
+		UID    string `query:"uid" validate:"omitempty,uid"`
+	}
+	studentPhotoForm struct {
+		SID   string                `form:"sid" validate:"required,uid"`
+		Image *multipart.FileHeader `form:"image" validate:"required"`
+	}
+	teacherPhotoForm struct {
+		TID   string                `form:"tid" validate:"required,uid"`
+		Image *multipart.FileHeader `form:"image" validate:"required"`
+	}
+)
+
+// Response fields sent by more than one route
+var (
+	message       = fiber.Map{}
+	login         = fiber.Map{"passwordexpired": false}
+	studentRecord = fiber.Map{"student": models.Student{}, "locker": models.Locker{}, "contacts": []models.Contact{}, "photo": models.Photo{}, "error": ""}
+	teacherRecord = fiber.Map{"teacher": models.Teacher{}, "photo": models.Photo{}}
+)
+
+// operations documents every route, keyed like APIKeyRoutes by method and fiber path
+var operations = map[string]operation{
+	"GET /api/v1/status":        {Summary: "Check the API is running", Response: message},
+	"GET /api/v1/openapi.json":  {Summary: "This OpenAPI document", Content: fiber.MIMEApplicationJSON},
+	"GET /api/v1/docs":          {Summary: "A page to browse and try this document, it needs no network", Content: fiber.MIMETextHTML},
+	"GET /api/v1/verifyEmail":   {Summary: "Verify an email from the link sent to it", Query: []interface{}{tokenQuery{}}, Response: message},
+	"GET /api/v1/oidc/login":    {Summary: "Start a single sign-on login with the identity provider", Query: []interface{}{oidcLoginQuery{}}, Status: fiber.StatusFound},
+	"GET /api/v1/oidc/callback": {Summary: "Finish a single sign-on login, redirecting to OIDC_SUCCESS_URL when it is set", Query: []interface{}{oidcCallbackQuery{}}, Response: fiber.Map{"uid": ""}},
+	"POST /api/v1/logout":       {Summary: "Log out, ending the session", Response: message},
+
+	"GET /api/v1/student":                   {Summary: "A student with their locker, contacts and photo, an admin names the student in the body", Auth: "the student or an admin", Body: controllers.UIDRequest{}, Status: fiber.StatusAccepted, Response: fiber.Map{"response": studentRecord}},
+	"POST /api/v1/student/enroll":           {Summary: "Enroll a student", Auth: "admin", Body: controllers.EnrollRequest{}, Response: message},
+	"POST /api/v1/student/login":            {Summary: "Log in as a student", Body: controllers.LoginRequest{}, Response: login},
+	"GET /api/v1/student/accesses":          {Summary: "Who has seen a student's record", Auth: "the student or an admin", Query: []interface{}{uidQuery{}}, Response: fiber.Map{"result": []models.AccessSummary{}}},
+	"POST /api/v1/student/updateName":       {Summary: "Change a student's name", Auth: "admin", Body: update.NameRequest{}, Response: message},
+	"POST /api/v1/student/updateGradeLevel": {Summary: "Change a student's grade", Auth: "admin", Body: update.GradeLevelRequest{}, Response: message},
+	"POST /api/v1/student/updateHomeroom":   {Summary: "Change a student's homeroom", Auth: "admin", Body: update.HomeroomRequest{}, Response: message},
+	"POST /api/v1/student/updateLocker":     {Summary: "Assign a student a locker", Auth: "admin", Body: update.LockerRequest{}, Response: message},
+	"POST /api/v1/studnet/updateYOG":        {Summary: "Misspelled alias of /api/v1/student/updateYOG, kept for clients already using it", Auth: "admin", Body: update.YOGRequest{}, Response: message},
+	"POST /api/v1/student/updateYOG":        {Summary: "Change a student's year of graduation", Auth: "admin", Body: update.YOGRequest{}, Response: message},
+	"POST /api/v1/student/updatePEN":        {Summary: "Change a student's Personal Education Number", Auth: "admin", Body: update.PENRequest{}, Response: message},
+	"POST /api/v1/studnet/addContact":       {Summary: "Misspelled alias of /api/v1/student/addContact, kept for clients already using it", Auth: "admin", Body: update.StudentContactRequest{}, Response: message},
+	"POST /api/v1/student/addContact":       {Summary: "Add an existing contact to a student", Auth: "admin", Body: update.StudentContactRequest{}, Response: message},
+	"POST /api/v1/student/removeContact":    {Summary: "Remove a contact from a student", Auth: "admin", Body: update.StudentContactRequest{}, Response: message},
+	"POST /api/v1/student/updatePassword":   {Summary: "Change the logged in student's password", Auth: "the student", Body: update.PasswordRequest{}, Response: message},
+	"POST /api/v1/student/resetPassword":    {Summary: "Email a student a temporary password", Body: update.ResetPasswordRequest{}, Response: message},
+	"POST /api/v1/student/updateAddress":    {Summary: "Change a student's address", Auth: "admin", Body: update.AddressRequest{}, Response: message},
+	"POST /api/v1/student/updatePhoto":      {Summary: "Replace a student's photo", Auth: "admin", Form: studentPhotoForm{}, Response: message},
+	"POST /api/v1/student/updateEmail":      {Summary: "Change a student's personal email, it is verified before it is used", Auth: "the student or an admin", Body: update.EmailRequest{}, Response: message},
+
+	"POST /api/v1/contact/createContact":   {Summary: "Create a contact for a student", Auth: "admin", Body: controllers.CreateContactRequest{}, Response: message},
+	"POST /api/v1/contact/updateName":      {Summary: "Change a contact's name", Auth: "admin", Body: update.ContactNameRequest{}, Response: message},
+	"POST /api/v1/contact/updateAddress":   {Summary: "Change a contact's address", Auth: "admin", Body: update.ContactAddressRequest{}, Response: message},
+	"POST /api/v1/contact/updateHomePhone": {Summary: "Change a contact's home phone", Auth: "admin", Body: update.ContactPhoneRequest{}, Response: message},
+	"POST /api/v1/contact/updateWorkPhone": {Summary: "Change a contact's work phone", Auth: "admin", Body: update.ContactPhoneRequest{}, Response: message},
+	"POST /api/v1/contact/updateEmail":     {Summary: "Change a contact's email", Auth: "admin", Body: update.ContactEmailRequest{}, Response: message},
+	"POST /api/v1/contact/updatePriority":  {Summary: "Change a contact's priority", Auth: "admin", Body: update.ContactPriorityRequest{}, Response: message},
+	"POST /api/v1/contact/deleteContact":   {Summary: "Delete a contact", Auth: "admin", Body: controllers.ContactIDRequest{}, Response: message},
+
+	"GET /api/v1/teacher":                 {Summary: "The logged in teacher with their photo", Auth: "the teacher", Response: fiber.Map{"response": teacherRecord}},
+	"POST /api/v1/teacher/register":       {Summary: "Register a teacher", Auth: "admin", Body: controllers.RegisterTeacherRequest{}, Response: message},
+	"POST /api/v1/teacher/login":          {Summary: "Log in as a teacher", Body: controllers.LoginRequest{}, Response: login},
+	"POST /api/v1/teacher/updatePassword": {Summary: "Change the logged in teacher's password", Auth: "the teacher", Body: update.PasswordRequest{}, Response: message},
+	"POST /api/v1/teacher/updateAddress":  {Summary: "Change a teacher's address", Auth: "admin", Body: update.AddressRequest{}, Response: message},
+	"POST /api/v1/teacher/updatePhoto":    {Summary: "Replace a teacher's photo", Auth: "admin", Form: teacherPhotoForm{}, Response: message},
+	"POST /api/v1/teacher/updateName":     {Summary: "Change a teacher's name", Auth: "admin", Body: update.NameRequest{}, Response: message},
+	"POST /api/v1/teacher/updateHomeroom": {Summary: "Change a teacher's homeroom", Auth: "admin", Body: update.HomeroomRequest{}, Response: message},
+	"POST /api/v1/teacher/updateEmail":    {Summary: "Change a teacher's personal email, it is verified before it is used", Auth: "the teacher or an admin", Body: update.EmailRequest{}, Response: message},
+	"POST /api/v1/teacher/resetPassword":  {Summary: "Email a teacher a temporary password", Body: update.ResetPasswordRequest{}, Response: message},
+
+	"POST /api/v1/impersonation/end": {Summary: "End the impersonation this session is in", Auth: "an impersonating admin", Response: fiber.Map{"impersonation": models.Impersonation{}}},
+
+	"GET /api/v1/admin":                 {Summary: "The logged in admin", Auth: "the admin", Response: fiber.Map{"result": models.Admin{}}},
+	"POST /api/v1/admin/create":         {Summary: "Create an admin", Auth: "admin", Body: controllers.CreateAdminRequest{}, Response: message},
+	"POST /api/v1/admin/login":          {Summary: "Log in as an admin", Body: controllers.LoginRequest{}, Response: login},
+	"POST /api/v1/admin/updateName":     {Summary: "Change the logged in admin's name", Auth: "the admin", Body: update.AdminNameRequest{}, Response: message},
+	"POST /api/v1/admin/updateEmail":    {Summary: "Change the logged in admin's email", Auth: "the admin", Body: update.AdminEmailRequest{}, Response: message},
+	"POST /api/v1/admin/updatePassword": {Summary: "Change the logged in admin's password", Auth: "the admin", Body: update.PasswordRequest{}, Response: message},
+
+	"POST /api/v1/admin/updateLockerCombo":    {Summary: "Change a locker's combination", Auth: "admin", Body: update.LockerComboRequest{}, Response: message},
+	"POST /api/v1/admin/enableStudent":        {Summary: "Enable a disabled student", Auth: "admin", Body: controllers.UIDRequest{}, Response: message},
+	"POST /api/v1/admin/enableTeacher":        {Summary: "Enable a disabled teacher", Auth: "admin", Body: controllers.UIDRequest{}, Response: message},
+	"GET /api/v1/admin/passwordPolicy":        {Summary: "The password policy of each account type", Auth: "admin", Response: fiber.Map{"policies": []models.PasswordPolicy{}}},
+	"POST /api/v1/admin/updatePasswordPolicy": {Summary: "Change the password policy of an account type", Auth: "admin", Body: models.PasswordPolicy{}, Response: message},
+	"GET /api/v1/admin/unverified":            {Summary: "Accounts with an email waiting to be verified", Auth: "admin", Response: fiber.Map{"accounts": []fiber.Map{{"usertype": "", "uid": "", "firstname": "", "lastname": "", "email": "", "pendingemail": ""}}}},
+	"POST /api/v1/admin/sendVerification":     {Summary: "Send an account a new verification email", Auth: "admin", Body: controllers.SendVerificationRequest{}, Response: message},
+	"GET /api/v1/admin/apikeys":               {Summary: "Every API key, without the keys themselves", Auth: "admin", Response: fiber.Map{"result": []models.APIKey{}}},
+	"POST /api/v1/admin/apikeys/create":       {Summary: "Create an API key, the key is only ever sent in this response", Auth: "admin", Body: controllers.APIKeyRequest{}, Response: fiber.Map{"key": "", "result": models.APIKey{}}},
+	"POST /api/v1/admin/apikeys/revoke":       {Summary: "Revoke an API key", Auth: "admin", Body: controllers.RevokeAPIKeyRequest{}, Response: message},
+	"POST /api/v1/admin/directory/sync":       {Summary: "Sync teachers and admins with the LDAP directory", Auth: "admin", Body: controllers.DryRunRequest{}, Response: fiber.Map{"result": models.DirectorySync{}}},
+	"GET /api/v1/admin/directory/syncs":       {Summary: "Reports of past directory syncs", Auth: "admin", Response: fiber.Map{"result": []models.DirectorySync{}}},
+	"POST /api/v1/admin/impersonate":          {Summary: "Start impersonating a student or teacher", Auth: "admin", Body: controllers.ImpersonationRequest{}, Response: fiber.Map{"impersonation": models.Impersonation{}}},
+	"GET /api/v1/admin/impersonations":        {Summary: "Past and current impersonations", Auth: "admin", Query: []interface{}{impersonationsQuery{}}, Response: fiber.Map{"result": []models.Impersonation{}}},
+	"GET /api/v1/admin/audit":                 {Summary: "Entries of the audit log, newest first", Auth: "admin", Query: []interface{}{auditQuery{}, dateRangeQuery{}}, Response: fiber.Map{"result": []models.AuditEntry{}}},
+	"GET /api/v1/admin/audit/verify":          {Summary: "Check the audit log's hash chain hasn't been tampered with", Auth: "admin", Response: fiber.Map{"valid": false, "entries": 0, "brokenat": int64(0)}},
+	"GET /api/v1/admin/accesses":              {Summary: "Reads of student records, newest first", Auth: "admin", Query: []interface{}{accessesQuery{}, dateRangeQuery{}}, Response: fiber.Map{"result": []models.AccessEvent{}}},
+	"GET /api/v1/admin/accessAlerts":          {Summary: "Alerts raised by unusual reads of student records", Auth: "admin", Response: fiber.Map{"result": []models.AccessAlert{}}},
+	"POST /api/v1/admin/encryption/rotate":    {Summary: "Start re-encrypting records sealed with an old key", Auth: "admin", Status: fiber.StatusAccepted, Response: message},
+	"GET /api/v1/admin/encryption/rotations":  {Summary: "The current key, records waiting to be re-encrypted and past rotations", Auth: "admin", Response: fiber.Map{"keyid": "", "stale": map[string]int64{}, "result": []models.KeyRotation{}}},
+	"POST /api/v1/admin/exports/create":       {Summary: "Record a request for a student's data", Auth: "admin", Body: controllers.ExportRequestRequest{}, Response: fiber.Map{"result": models.ExportRequest{}}},
+	"GET /api/v1/admin/exports":               {Summary: "Requests for student data, soonest due first", Auth: "admin", Query: []interface{}{exportsQuery{}}, Response: fiber.Map{"result": []fiber.Map{{"request": models.ExportRequest{}, "overdue": false}}}},
+	"GET /api/v1/admin/exports/download":      {Summary: "Download the data of a request as a zip", Auth: "admin", Query: []interface{}{exportQuery{}}, Content: "application/zip"},
+	"GET /api/v1/admin/removed":               {Summary: "Removed accounts waiting to be purged", Auth: "admin", Response: fiber.Map{"result": []fiber.Map{{"usertype": "", "uid": "", "firstname": "", "lastname": "", "removed": models.Removal{}}}}},
+	"GET /api/v1/admin/retention":             {Summary: "The retention rule of each kind of record", Auth: "admin", Response: fiber.Map{"result": []models.RetentionRule{}}},
+	"POST /api/v1/admin/retention/update":     {Summary: "Change a retention rule", Auth: "admin", Body: models.RetentionRule{}, Response: message},
+	"POST /api/v1/admin/retention/run":        {Summary: "Remove records past their retention rule", Auth: "admin", Body: controllers.DryRunRequest{}, Response: fiber.Map{"result": models.RetentionRun{}}},
+	"GET /api/v1/admin/retention/runs":        {Summary: "Reports of past retention runs", Auth: "admin", Response: fiber.Map{"result": []models.RetentionRun{}}},
+	"GET /api/v1/admin/legalHolds":            {Summary: "Legal holds, which keep records from retention", Auth: "admin", Query: []interface{}{legalHoldsQuery{}}, Response: fiber.Map{"result": []models.LegalHold{}}},
+	"POST /api/v1/admin/legalHolds/create":    {Summary: "Place a legal hold on an account's records", Auth: "admin", Body: controllers.LegalHoldRequest{}, Response: fiber.Map{"result": models.LegalHold{}}},
+	"POST /api/v1/admin/legalHolds/release":   {Summary: "Release a legal hold", Auth: "admin", Body: controllers.ReleaseLegalHoldRequest{}, Response: message},
+
+	"POST /api/v1/remove/student":  {Summary: "Remove a student until they are purged", Auth: "admin", Body: controllers.RemovalRequest{}, Response: fiber.Map{"removed": models.Removal{}}},
+	"POST /api/v1/remove/teacher":  {Summary: "Remove a teacher until they are purged", Auth: "admin", Body: controllers.RemovalRequest{}, Response: fiber.Map{"removed": models.Removal{}}},
+	"POST /api/v1/remove/admin":    {Summary: "Remove an admin until they are purged", Auth: "admin", Body: controllers.RemovalRequest{}, Response: fiber.Map{"removed": models.Removal{}}},
+	"POST /api/v1/restore/student": {Summary: "Restore a removed student", Auth: "admin", Body: controllers.UIDRequest{}, Response: message},
+	"POST /api/v1/restore/teacher": {Summary: "Restore a removed teacher", Auth: "admin", Body: controllers.UIDRequest{}, Response: message},
+	"POST /api/v1/restore/admin":   {Summary: "Restore a removed admin", Auth: "admin", Body: controllers.UIDRequest{}, Response: message},
+
+	"GET /api/v2/students":                      {Summary: "A page of students", Auth: "admin", Query: []interface{}{rest.ListRequest{}, rest.StudentFilter{}}, Response: fiber.Map{"result": []models.Student{}, "next": ""}},
+	"GET /api/v2/students/:sid":                 {Summary: "A student with their locker, contacts and photo", Auth: "an admin or the student", Response: fiber.Map{"result": studentRecord}},
+	"PATCH /api/v2/students/:sid":               {Summary: "Change the fields sent of a student", Auth: "admin", Body: rest.StudentPatch{}, Response: fiber.Map{"result": models.Student{}}},
+	"DELETE /api/v2/students/:sid":              {Summary: "Remove a student until they are purged", Auth: "admin", Query: []interface{}{rest.RemovalQuery{}}, Response: fiber.Map{"result": models.Removal{}}},
+	"GET /api/v2/students/:sid/contacts":        {Summary: "A student's contacts", Auth: "an admin or the student", Response: fiber.Map{"result": []models.Contact{}}},
+	"POST /api/v2/students/:sid/contacts":       {Summary: "Create a contact for a student", Auth: "admin", Body: controllers.CreateContactRequest{}, Status: fiber.StatusCreated, Response: fiber.Map{"result": models.Contact{}}},
+	"PATCH /api/v2/students/:sid/contacts/:id":  {Summary: "Change the fields sent of a student's contact", Auth: "admin", Body: rest.ContactPatch{}, Response: fiber.Map{"result": models.Contact{}}},
+	"DELETE /api/v2/students/:sid/contacts/:id": {Summary: "Delete a student's contact", Auth: "admin", Response: message},
+
+	"GET /api/v2/teachers":         {Summary: "A page of teachers", Auth: "admin", Query: []interface{}{rest.ListRequest{}, rest.TeacherFilter{}}, Response: fiber.Map{"result": []models.Teacher{}, "next": ""}},
+	"GET /api/v2/teachers/:tid":    {Summary: "A teacher with their photo", Auth: "an admin or the teacher", Response: fiber.Map{"result": teacherRecord}},
+	"PATCH /api/v2/teachers/:tid":  {Summary: "Change the fields sent of a teacher", Auth: "admin", Body: rest.TeacherPatch{}, Response: fiber.Map{"result": models.Teacher{}}},
+	"DELETE /api/v2/teachers/:tid": {Summary: "Remove a teacher until they are purged", Auth: "admin", Query: []interface{}{rest.RemovalQuery{}}, Response: fiber.Map{"result": models.Removal{}}},
+
+	"GET /api/v2/admins":   {Summary: "A page of admins", Auth: "admin", Query: []interface{}{rest.ListRequest{}, rest.AdminFilter{}}, Response: fiber.Map{"result": []models.Admin{}, "next": ""}},
+	"GET /api/v2/contacts": {Summary: "A page of contacts, each with the sid of its student", Auth: "admin", Query: []interface{}{rest.ListRequest{}, rest.ContactFilter{}}, Response: fiber.Map{"result": []models.Contact{}, "next": ""}},
+
+	"GET /api/v2/lockers":           {Summary: "A page of lockers, each with the sid of its student", Auth: "admin", Query: []interface{}{rest.ListRequest{}, rest.LockerFilter{}}, Response: fiber.Map{"result": []models.Locker{}, "next": ""}},
+	"GET /api/v2/lockers/:number":   {Summary: "A locker", Auth: "admin", Response: fiber.Map{"result": models.Locker{}}},
+	"PATCH /api/v2/lockers/:number": {Summary: "Change the fields sent of a locker", Auth: "admin", Body: rest.LockerPatch{}, Response: fiber.Map{"result": models.Locker{}}},
+}
@@ -2,18 +2,53 @@ package routes
 
 import (
 	"github.com/SowinskiBraeden/school-management-api/controllers"
+	"github.com/SowinskiBraeden/school-management-api/controllers/rest"
 	"github.com/SowinskiBraeden/school-management-api/controllers/update"
+	"github.com/SowinskiBraeden/school-management-api/repository"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
 )
 
-func Setup(app *fiber.App) {
+func Setup(app *fiber.App, repos *repository.Repositories) {
 	// Detect if system is new and needs default admin
-	controllers.NewSystem()
+	controllers.NewSystem(repos)
 
+	// Delete removed accounts once their retention period ends
+	controllers.StartRemovalPurge(repos)
+
+	// Remove records past their retention rule every day
+	controllers.StartRetention(repos)
+
+	// Email admins a daily digest of locked accounts
+	controllers.StartLockoutDigest(repos)
+
+	// Sync staff accounts with the LDAP directory every LDAP_SYNC_HOURS
+	controllers.StartDirectorySync(repos)
+
+	// Re-encrypt records in plain text or sealed with an old key
+	controllers.StartKeyRotation(repos)
+
+	Register(app, repos)
+}
+
+// Register adds the middleware and every route to app, without starting the background jobs
+func Register(app *fiber.App, repos *repository.Repositories) {
 	// API Handling
 	var routerPrefix string = "/api/v1"
 
+	// Give every request an ID, sent back in the X-Request-ID header and with every failure
+	app.Use(requestid.New())
+
+	// Give every handler the repositories
+	app.Use(controllers.Inject(repos))
+
+	// Reject mistyped account IDs before they reach a handler
+	app.Use(controllers.CheckUID)
+
+	// Mark and restrict requests made while an admin impersonates a user
+	app.Use(controllers.ImpersonationGuard)
+
 	// API check
 	app.Get(routerPrefix+"/status", func(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusOK).JSON(fiber.Map{
@@ -22,18 +57,26 @@ func Setup(app *fiber.App) {
 		})
 	})
 
+	// OpenAPI document generated from these routes, and a page to browse it
+	app.Get(routerPrefix+"/openapi.json", OpenAPI)
+	app.Get(routerPrefix+"/docs", APIDocs)
+
 	// Student Authentication Handler
 	app.Get(routerPrefix+"/student", controllers.Student)
 	app.Post(routerPrefix+"/student/enroll", controllers.Enroll)
 	app.Post(routerPrefix+"/student/login", controllers.StudentLogin)
+	app.Get(routerPrefix+"/student/accesses", controllers.StudentAccesses)
 
 	// Update Student Handler
 	app.Post(routerPrefix+"/student/updateName", update.UpdateStudentName)
 	app.Post(routerPrefix+"/student/updateGradeLevel", update.UpdateStudentGradeLevel)
 	app.Post(routerPrefix+"/student/updateHomeroom", update.UpdateStudentHomeroom)
 	app.Post(routerPrefix+"/student/updateLocker", update.UpdateStudentLocker)
-	app.Post(routerPrefix+"/studnet/updateYOG", update.UpdateStudentYOG)
-	app.Post(routerPrefix+"/studnet/addContact", update.AddStudentContact)
+	app.Post(routerPrefix+"/studnet/updateYOG", update.UpdateStudentYOG) // misspelled, kept for clients already using it
+	app.Post(routerPrefix+"/student/updateYOG", update.UpdateStudentYOG)
+	app.Post(routerPrefix+"/student/updatePEN", update.UpdateStudentPEN)
+	app.Post(routerPrefix+"/studnet/addContact", update.AddStudentContact) // misspelled, kept for clients already using it
+	app.Post(routerPrefix+"/student/addContact", update.AddStudentContact)
 	app.Post(routerPrefix+"/student/removeContact", update.RemoveStudentContact)
 	app.Post(routerPrefix+"/student/updatePassword", update.UpdateStudentPassword)
 	app.Post(routerPrefix+"/student/resetPassword", update.ResetStudentPassword)
@@ -67,6 +110,10 @@ func Setup(app *fiber.App) {
 
 	// General Routes
 	app.Post(routerPrefix+"/logout", controllers.Logout)
+	app.Get(routerPrefix+"/verifyEmail", controllers.VerifyEmail)
+	app.Get(routerPrefix+"/oidc/login", controllers.OIDCLogin)
+	app.Get(routerPrefix+"/oidc/callback", controllers.OIDCCallback)
+	app.Post(routerPrefix+"/impersonation/end", controllers.EndImpersonation)
 
 	// Admin Login Handling
 	app.Get(routerPrefix+"/admin", controllers.Admin)
@@ -82,14 +129,72 @@ func Setup(app *fiber.App) {
 	app.Post(routerPrefix+"/admin/updateLockerCombo", update.UpdateLockerCombo)
 	app.Post(routerPrefix+"/admin/enableStudent", update.RemoveStudentsDisabled)
 	app.Post(routerPrefix+"/admin/enableTeacher", update.RemoveTeachersDisabled)
+	app.Get(routerPrefix+"/admin/passwordPolicy", controllers.PasswordPolicies)
+	app.Post(routerPrefix+"/admin/updatePasswordPolicy", controllers.UpdatePasswordPolicy)
+	app.Get(routerPrefix+"/admin/unverified", controllers.UnverifiedAccounts)
+	app.Post(routerPrefix+"/admin/sendVerification", controllers.SendVerificationEmail)
+	app.Get(routerPrefix+"/admin/apikeys", controllers.APIKeys)
+	app.Post(routerPrefix+"/admin/apikeys/create", controllers.CreateAPIKey)
+	app.Post(routerPrefix+"/admin/apikeys/revoke", controllers.RevokeAPIKey)
+	app.Post(routerPrefix+"/admin/directory/sync", controllers.RunDirectorySync)
+	app.Get(routerPrefix+"/admin/directory/syncs", controllers.DirectorySyncs)
+	app.Post(routerPrefix+"/admin/impersonate", controllers.StartImpersonation)
+	app.Get(routerPrefix+"/admin/impersonations", controllers.Impersonations)
+	app.Get(routerPrefix+"/admin/audit", controllers.AuditLog)
+	app.Get(routerPrefix+"/admin/audit/verify", controllers.VerifyAudit)
+	app.Get(routerPrefix+"/admin/accesses", controllers.Accesses)
+	app.Get(routerPrefix+"/admin/accessAlerts", controllers.AccessAlerts)
+	app.Post(routerPrefix+"/admin/encryption/rotate", controllers.RunKeyRotation)
+	app.Get(routerPrefix+"/admin/encryption/rotations", controllers.KeyRotations)
+	app.Post(routerPrefix+"/admin/exports/create", controllers.CreateExportRequest)
+	app.Get(routerPrefix+"/admin/exports", controllers.ExportRequests)
+	app.Get(routerPrefix+"/admin/exports/download", controllers.DownloadExport)
+	app.Get(routerPrefix+"/admin/removed", controllers.RemovedUsers)
+	app.Get(routerPrefix+"/admin/retention", controllers.RetentionRules)
+	app.Post(routerPrefix+"/admin/retention/update", controllers.UpdateRetentionRule)
+	app.Post(routerPrefix+"/admin/retention/run", controllers.RunRetention)
+	app.Get(routerPrefix+"/admin/retention/runs", controllers.RetentionRuns)
+	app.Get(routerPrefix+"/admin/legalHolds", controllers.LegalHolds)
+	app.Post(routerPrefix+"/admin/legalHolds/create", controllers.PlaceLegalHold)
+	app.Post(routerPrefix+"/admin/legalHolds/release", controllers.ReleaseLegalHold)
 
 	// Delete Handler
 	app.Post(routerPrefix+"/remove/student", controllers.RemoveStudent)
 	app.Post(routerPrefix+"/remove/teacher", controllers.RemoveTeacher)
 	app.Post(routerPrefix+"/remove/admin", controllers.RemoveAdmin)
 
+	// Restore Handler
+	app.Post(routerPrefix+"/restore/student", controllers.RestoreStudent)
+	app.Post(routerPrefix+"/restore/teacher", controllers.RestoreTeacher)
+	app.Post(routerPrefix+"/restore/admin", controllers.RestoreAdmin)
+
+	// Version 2, routes named by the record they act on
+	var v2Prefix string = "/api/v2"
+
+	app.Get(v2Prefix+"/students", rest.ListStudents)
+	app.Get(v2Prefix+"/students/:sid", rest.GetStudent)
+	app.Patch(v2Prefix+"/students/:sid", rest.PatchStudent)
+	app.Delete(v2Prefix+"/students/:sid", rest.DeleteStudent)
+	app.Get(v2Prefix+"/students/:sid/contacts", rest.StudentContacts)
+	app.Post(v2Prefix+"/students/:sid/contacts", rest.AddStudentContact)
+	app.Patch(v2Prefix+"/students/:sid/contacts/:id", rest.PatchStudentContact)
+	app.Delete(v2Prefix+"/students/:sid/contacts/:id", rest.DeleteStudentContact)
+
+	app.Get(v2Prefix+"/teachers", rest.ListTeachers)
+	app.Get(v2Prefix+"/teachers/:tid", rest.GetTeacher)
+	app.Patch(v2Prefix+"/teachers/:tid", rest.PatchTeacher)
+	app.Delete(v2Prefix+"/teachers/:tid", rest.DeleteTeacher)
+
+	app.Get(v2Prefix+"/admins", rest.ListAdmins)
+
+	app.Get(v2Prefix+"/contacts", rest.ListContacts)
+
+	app.Get(v2Prefix+"/lockers", rest.ListLockers)
+	app.Get(v2Prefix+"/lockers/:number", rest.GetLocker)
+	app.Patch(v2Prefix+"/lockers/:number", rest.PatchLocker)
+
 	// 404 Handler
 	app.Use(func(c *fiber.Ctx) error {
-		return c.SendStatus(404) // => 404 "Not Found"
+		return controllers.NewError(fiber.StatusNotFound, controllers.CodeNotFound, "there is no "+c.Method()+" "+c.Path()+" endpoint")
 	})
 }